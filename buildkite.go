@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// buildkiteAnnotationContext identifies the annotation of the build, so
+// later annotations replace it instead of being added.
+const buildkiteAnnotationContext = "go-cache-prune"
+
+// annotateBuildkite adds markdown to the annotation of the running
+// Buildkite build using buildkite-agent.
+func annotateBuildkite(markdown string) error {
+	cmd := exec.Command("buildkite-agent", "annotate", "--style", "info", "--context", buildkiteAnnotationContext)
+	cmd.Stdin = strings.NewReader(markdown)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %s: %w: %s", cmd, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Package cache describes the layouts of Go's module and build caches
+// and records which of their entries are used.
+package cache
+
+import (
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+var (
+	buildShardRe = regexp.MustCompile(`^[0-9a-f]{2}$`)
+	buildEntryRe = regexp.MustCompile(`^[0-9a-f]{64}-[ad]$`)
+)
+
+// IsVersionedDir returns true if the dir name contains a valid module
+// version.
+func IsVersionedDir(name string) bool {
+	_, ver, ok := strings.Cut(name, "@")
+	if !ok {
+		return false
+	}
+	return strings.HasSuffix(ver, "+incompatible") || semver.IsValid(ver) || module.IsPseudoVersion(ver)
+}
+
+// IsWithinDir returns true if path is a descendant of dir.
+func IsWithinDir(dir, path string) bool {
+	return dir != "" && strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// IsModTempDir returns true if name is a temporary directory the go
+// command extracts modules to before renaming them into place.
+func IsModTempDir(name string) bool {
+	return strings.Contains(name, ".tmp-")
+}
+
+// ContainingDependencyDir returns the dependency dir of the module
+// cache modCache that path is in.
+func ContainingDependencyDir(modCache, path string) (string, bool) {
+	relPath, err := filepath.Rel(modCache, path)
+	if err != nil {
+		return "", false
+	}
+
+	elems := strings.Split(relPath, string(filepath.Separator))
+	for i, elem := range elems {
+		if IsModTempDir(elem) {
+			return "", false
+		}
+		if IsVersionedDir(elem) {
+			return filepath.Join(modCache, filepath.Join(elems[:i+1]...)), true
+		}
+	}
+
+	return "", false
+}
+
+// DependencyDir returns the dependency dir of the module cache that
+// the walked path is, or that contains it if path is a 'go.mod' file.
+func DependencyDir(path string, d fs.DirEntry) (string, bool) {
+	if d.IsDir() && strings.Contains(d.Name(), "@") {
+		if IsVersionedDir(d.Name()) {
+			return path, true
+		}
+	} else if !d.IsDir() && d.Name() == "go.mod" {
+		// If the dir contains 'go.mod', this is a dep dir
+		return filepath.Dir(path), true
+	}
+
+	return "", false
+}
+
+// ToolchainModule is the module path Go toolchains are downloaded to
+// the module cache as when GOTOOLCHAIN selects a newer toolchain.
+const ToolchainModule = "golang.org/toolchain"
+
+// IsToolchainDir returns true if the dependency dir depDir is a
+// downloaded Go toolchain.
+func IsToolchainDir(depDir string) bool {
+	return strings.HasPrefix(filepath.Base(depDir), "toolchain@") && filepath.Base(filepath.Dir(depDir)) == "golang.org"
+}
+
+// IsBuildShard returns true if name is the name of a build cache dir
+// entries are stored in.
+func IsBuildShard(name string) bool {
+	return buildShardRe.MatchString(name)
+}
+
+// IsBuildEntry returns true if name is the name of a build cache entry.
+func IsBuildEntry(name string) bool {
+	return buildEntryRe.MatchString(name)
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultPhase is the phase usage is recorded in if no other
+	// phases are started.
+	DefaultPhase = "default"
+	// DefaultPhaseMask is the mask of DefaultPhase.
+	DefaultPhaseMask = PhaseMask(1)
+	// MaxPhases is the maximum number of phases that can be started.
+	MaxPhases = 64
+)
+
+// PhaseMask is the set of phases a cache entry was used in, bit i is
+// set if the entry was used during the ith phase.
+type PhaseMask uint64
+
+// Phases tracks the named phases of a watch session. Usage is recorded
+// as part of the current phase so policies can consider which phases
+// entries were used in.
+type Phases struct {
+	mtx     sync.Mutex
+	names   []string
+	current atomic.Uint32
+}
+
+// NewPhases returns phases with only the default phase started.
+func NewPhases() *Phases {
+	return &Phases{
+		names: []string{DefaultPhase},
+	}
+}
+
+// Start makes name the current phase. Phases that were already started
+// before can be started again.
+func (p *Phases) Start(name string) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	idx := slices.Index(p.names, name)
+	if idx == -1 {
+		if len(p.names) == MaxPhases {
+			return fmt.Errorf("at most %d phases can be started", MaxPhases)
+		}
+		p.names = append(p.names, name)
+		idx = len(p.names) - 1
+	}
+	p.current.Store(uint32(idx))
+
+	return nil
+}
+
+// CurrentMask returns the mask of the current phase. If p is nil the
+// mask of the default phase is returned.
+func (p *Phases) CurrentMask() PhaseMask {
+	if p == nil {
+		return DefaultPhaseMask
+	}
+	return 1 << p.current.Load()
+}
+
+// Mask returns the mask of the named phases, names of phases that were
+// never started are ignored.
+func (p *Phases) Mask(names []string) PhaseMask {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var mask PhaseMask
+	for _, name := range names {
+		if idx := slices.Index(p.names, name); idx != -1 {
+			mask |= 1 << idx
+		}
+	}
+
+	return mask
+}
+
+// List returns the names of all phases that have been started.
+func (p *Phases) List() []string {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return slices.Clone(p.names)
+}
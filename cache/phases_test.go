@@ -0,0 +1,42 @@
+package cache
+
+import "testing"
+
+func TestPhases(t *testing.T) {
+	p := NewPhases()
+	if mask := p.CurrentMask(); mask != 1 {
+		t.Fatalf("expected default phase mask to be 1, got %b", mask)
+	}
+
+	used := NewUsedFiles()
+	used.Add("default", p.CurrentMask())
+
+	if err := p.Start("deps"); err != nil {
+		t.Fatalf("starting phase: %v", err)
+	}
+	used.Add("deps", p.CurrentMask())
+	used.Add("both", p.CurrentMask())
+
+	if err := p.Start("build"); err != nil {
+		t.Fatalf("starting phase: %v", err)
+	}
+	used.Add("both", p.CurrentMask())
+
+	// starting a phase again should reuse the same mask
+	if err := p.Start("deps"); err != nil {
+		t.Fatalf("starting phase: %v", err)
+	}
+	if mask := p.CurrentMask(); mask != 0b10 {
+		t.Fatalf("expected restarted phase mask to be 10, got %b", mask)
+	}
+
+	used.RemovePhases(p.Mask([]string{"deps", "unknown"}))
+	for _, path := range []string{"default", "both"} {
+		if !used.Has(path) {
+			t.Errorf("expected %q to be kept", path)
+		}
+	}
+	if used.Has("deps") {
+		t.Error(`expected "deps" to be removed`)
+	}
+}
@@ -0,0 +1,31 @@
+package cache
+
+import (
+	"io/fs"
+	"time"
+)
+
+// LastUsed returns the latest of the modification, access and change
+// times of a file.
+func LastUsed(info fs.FileInfo) time.Time {
+	latest := info.ModTime()
+	for _, t := range []time.Time{accessTime(info), changeTime(info)} {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+
+	return latest
+}
+
+// LastAccessed returns the latest of the modification and access times
+// of a file. Unlike LastUsed the change time is ignored, as extracting
+// or changing the permissions of a file updates it.
+func LastAccessed(info fs.FileInfo) time.Time {
+	latest := info.ModTime()
+	if t := accessTime(info); t.After(latest) {
+		latest = t
+	}
+
+	return latest
+}
@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+func accessTime(info fs.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atimespec.Unix())
+	}
+	return time.Time{}
+}
+
+// changeTime returns the later of the change and birth times, so newly
+// created files are always considered changed.
+func changeTime(info fs.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		ctime := time.Unix(stat.Ctimespec.Unix())
+		if btime := time.Unix(stat.Birthtimespec.Unix()); btime.After(ctime) {
+			return btime
+		}
+		return ctime
+	}
+	return time.Time{}
+}
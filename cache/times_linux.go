@@ -0,0 +1,21 @@
+package cache
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+func accessTime(info fs.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atim.Unix())
+	}
+	return time.Time{}
+}
+
+func changeTime(info fs.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Ctim.Unix())
+	}
+	return time.Time{}
+}
@@ -0,0 +1,23 @@
+package cache
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+func accessTime(info fs.FileInfo) time.Time {
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, data.LastAccessTime.Nanoseconds())
+	}
+	return time.Time{}
+}
+
+// changeTime returns the creation time as Windows doesn't track change
+// times, so newly created files are always considered changed.
+func changeTime(info fs.FileInfo) time.Time {
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, data.CreationTime.Nanoseconds())
+	}
+	return time.Time{}
+}
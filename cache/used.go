@@ -0,0 +1,253 @@
+package cache
+
+import (
+	"encoding/hex"
+	"os"
+	"unsafe"
+)
+
+// UsedFiles records the used entries of a cache by path, and the
+// phases they were used in. Like a map it refers to shared state, so
+// copies of it record the same entries, and the zero value is empty
+// but entries can't be added to it. It is not safe to use
+// concurrently.
+//
+// Caches can have millions of entries, so paths are stored compactly:
+// the directories of paths are stored once, and names of build cache
+// entries, which are hex encoded hashes, are stored decoded.
+type UsedFiles struct {
+	s *usedSet
+}
+
+type usedSet struct {
+	// dirIDs maps directories, including a trailing separator, to
+	// their index in dirs
+	dirIDs map[string]uint32
+	dirs   []string
+	hashed map[hashedEntry]PhaseMask
+	named  map[namedEntry]PhaseMask
+	// size is an estimate of how many bytes are used
+	size int64
+}
+
+// hashedEntry is a used build cache entry, its name is the hex encoded
+// hash followed by '-' and kind.
+type hashedEntry struct {
+	dir  uint32
+	kind byte
+	hash [hashSize]byte
+}
+
+// namedEntry is any other used entry.
+type namedEntry struct {
+	dir  uint32
+	name string
+}
+
+// hashSize is the size of the hashes build cache entries are named by.
+const hashSize = 32
+
+// mapEntryOverhead is roughly how many bytes a map entry uses besides
+// its key and value.
+const mapEntryOverhead = 16
+
+// NewUsedFiles returns a set of used entries containing paths, used in
+// the default phase.
+func NewUsedFiles(paths ...string) UsedFiles {
+	u := UsedFiles{s: &usedSet{
+		dirIDs: make(map[string]uint32),
+		hashed: make(map[hashedEntry]PhaseMask),
+		named:  make(map[namedEntry]PhaseMask),
+	}}
+	for _, path := range paths {
+		u.Add(path, DefaultPhaseMask)
+	}
+	return u
+}
+
+// splitPath splits path after its last separator.
+func splitPath(path string) (dir, name string) {
+	i := len(path) - 1
+	for i >= 0 && !os.IsPathSeparator(path[i]) {
+		i--
+	}
+	return path[:i+1], path[i+1:]
+}
+
+// parseHashedName returns the decoded hash and kind of a build cache
+// entry name.
+func parseHashedName(name string) (hash [hashSize]byte, kind byte, ok bool) {
+	if len(name) != 2*hashSize+2 || name[2*hashSize] != '-' {
+		return hash, 0, false
+	}
+	for i := range hash {
+		hi, ok1 := fromHex(name[2*i])
+		lo, ok2 := fromHex(name[2*i+1])
+		if !ok1 || !ok2 {
+			return hash, 0, false
+		}
+		hash[i] = hi<<4 | lo
+	}
+	return hash, name[2*hashSize+1], true
+}
+
+// fromHex decodes a lowercase hex digit, only lowercase hex is decoded
+// so names are encoded back the same way.
+func fromHex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	}
+	return 0, false
+}
+
+// lookupDir returns the index of dir, and false if it wasn't added.
+func (s *usedSet) lookupDir(dir string) (uint32, bool) {
+	id, ok := s.dirIDs[dir]
+	return id, ok
+}
+
+// addDir returns the index of dir, adding it if needed.
+func (s *usedSet) addDir(dir string) uint32 {
+	if id, ok := s.dirIDs[dir]; ok {
+		return id
+	}
+	id := uint32(len(s.dirs))
+	// don't keep the whole path dir was sliced from alive
+	dir = string([]byte(dir))
+	s.dirIDs[dir] = id
+	s.dirs = append(s.dirs, dir)
+	s.size += int64(len(dir)) + 2*int64(unsafe.Sizeof(dir)) + 4 + mapEntryOverhead
+	return id
+}
+
+// Add records that path was used during the phases in mask, in addition
+// to the phases it was already recorded as used in.
+func (u UsedFiles) Add(path string, mask PhaseMask) {
+	s := u.s
+	dirPath, name := splitPath(path)
+	dir := s.addDir(dirPath)
+	if hash, kind, ok := parseHashedName(name); ok {
+		key := hashedEntry{dir: dir, kind: kind, hash: hash}
+		if _, ok := s.hashed[key]; !ok {
+			s.size += int64(unsafe.Sizeof(key)+unsafe.Sizeof(mask)) + mapEntryOverhead
+		}
+		s.hashed[key] |= mask
+		return
+	}
+
+	key := namedEntry{dir: dir, name: name}
+	if _, ok := s.named[key]; !ok {
+		key.name = string([]byte(name))
+		s.size += int64(unsafe.Sizeof(key)+unsafe.Sizeof(mask)) + int64(len(name)) + mapEntryOverhead
+	}
+	s.named[key] |= mask
+}
+
+// Get returns the phases path was used in, and whether it was used.
+func (u UsedFiles) Get(path string) (PhaseMask, bool) {
+	if u.s == nil {
+		return 0, false
+	}
+	dirPath, name := splitPath(path)
+	dir, ok := u.s.lookupDir(dirPath)
+	if !ok {
+		return 0, false
+	}
+	if hash, kind, ok := parseHashedName(name); ok {
+		mask, ok := u.s.hashed[hashedEntry{dir: dir, kind: kind, hash: hash}]
+		return mask, ok
+	}
+	mask, ok := u.s.named[namedEntry{dir: dir, name: name}]
+	return mask, ok
+}
+
+// Has returns true if path was used.
+func (u UsedFiles) Has(path string) bool {
+	_, ok := u.Get(path)
+	return ok
+}
+
+// Delete removes path from the used entries.
+func (u UsedFiles) Delete(path string) {
+	if u.s == nil {
+		return
+	}
+	dirPath, name := splitPath(path)
+	dir, ok := u.s.lookupDir(dirPath)
+	if !ok {
+		return
+	}
+	if hash, kind, ok := parseHashedName(name); ok {
+		delete(u.s.hashed, hashedEntry{dir: dir, kind: kind, hash: hash})
+		return
+	}
+	delete(u.s.named, namedEntry{dir: dir, name: name})
+}
+
+// Len returns how many entries were used.
+func (u UsedFiles) Len() int {
+	if u.s == nil {
+		return 0
+	}
+	return len(u.s.hashed) + len(u.s.named)
+}
+
+// Range calls f with every used entry and the phases it was used in
+// until f returns false. Entries are visited in no particular order,
+// and entries may be deleted by f.
+func (u UsedFiles) Range(f func(path string, mask PhaseMask) bool) {
+	if u.s == nil {
+		return
+	}
+	for key, mask := range u.s.hashed {
+		name := hex.EncodeToString(key.hash[:]) + "-" + string(key.kind)
+		if !f(u.s.dirs[key.dir]+name, mask) {
+			return
+		}
+	}
+	for key, mask := range u.s.named {
+		if !f(u.s.dirs[key.dir]+key.name, mask) {
+			return
+		}
+	}
+}
+
+// Size returns roughly how many bytes of memory are used to record the
+// used entries. Memory isn't reclaimed when entries are deleted.
+func (u UsedFiles) Size() int64 {
+	if u.s == nil {
+		return 0
+	}
+	return u.s.size
+}
+
+// RemovePhases removes entries that were only used during phases in
+// mask.
+func (u UsedFiles) RemovePhases(mask PhaseMask) {
+	if u.s == nil {
+		return
+	}
+	for key, used := range u.s.hashed {
+		if used&^mask == 0 {
+			delete(u.s.hashed, key)
+		}
+	}
+	for key, used := range u.s.named {
+		if used&^mask == 0 {
+			delete(u.s.named, key)
+		}
+	}
+}
+
+// Clone returns a copy of u that doesn't share entries with it.
+func (u UsedFiles) Clone() UsedFiles {
+	c := NewUsedFiles()
+	u.Range(func(path string, mask PhaseMask) bool {
+		c.Add(path, mask)
+		return true
+	})
+	return c
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUsedFiles(t *testing.T) {
+	hash := strings.Repeat("0123456789abcdef", 4)
+	paths := []string{
+		filepath.Join("/build", "01", hash+"-a"),
+		filepath.Join("/build", "01", hash+"-d"),
+		// uppercase hex wouldn't be encoded back the same way
+		filepath.Join("/build", "01", strings.ToUpper(hash)+"-d"),
+		filepath.Join("/build", "01"),
+		filepath.Join("/mod", "example.com", "a@v1.0.0"),
+		"relative",
+	}
+
+	var zero UsedFiles
+	if zero.Has(paths[0]) || zero.Len() != 0 || zero.Size() != 0 {
+		t.Fatal("expected the zero value to be empty")
+	}
+
+	u := NewUsedFiles(paths...)
+	u.Add(paths[0], 0b10)
+	if u.Len() != len(paths) {
+		t.Fatalf("expected %d entries, got %d", len(paths), u.Len())
+	}
+	for _, path := range paths {
+		if !u.Has(path) {
+			t.Errorf("expected %q to be used", path)
+		}
+	}
+	if mask, _ := u.Get(paths[0]); mask != 0b11 {
+		t.Errorf("expected %q to be used in phases 0b11, got %b", paths[0], mask)
+	}
+	if u.Has(filepath.Join("/build", "02", hash+"-a")) {
+		t.Error("expected entry in another dir to not be used")
+	}
+
+	var got []string
+	u.Clone().Range(func(path string, _ PhaseMask) bool {
+		got = append(got, path)
+		return true
+	})
+	want := slices.Clone(paths)
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("expected entries %q, got %q", want, got)
+	}
+
+	size := u.Size()
+	u.Delete(paths[1])
+	u.RemovePhases(DefaultPhaseMask)
+	if u.Len() != 1 || !u.Has(paths[0]) {
+		t.Errorf("expected only %q to be left", paths[0])
+	}
+	if u.Size() != size {
+		t.Errorf("expected size to stay %d, got %d", size, u.Size())
+	}
+}
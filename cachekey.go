@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"golang.org/x/mod/module"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+// writeCacheKey writes a checksum of the modules left in the module
+// cache modCache to path, ignoring the dependency dirs in deleted that
+// were only reported as deleted by a dry run. It changes exactly when
+// the modules kept by pruning do, so CI systems like CircleCI can use
+// it as the key of the saved cache.
+func writeCacheKey(path, modCache string, deleted []string) error {
+	mods, err := keptModules(modCache, deleted)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	for _, mod := range mods {
+		fmt.Fprintln(h, mod.String())
+	}
+	key := hex.EncodeToString(h.Sum(nil)) + "\n"
+	if err := writeFileAtomic(path, []byte(key)); err != nil {
+		return fmt.Errorf("writing cache key file: %w", err)
+	}
+	log.Infof("wrote cache key of %d kept modules to %q", len(mods), path)
+
+	return nil
+}
+
+// keptModules returns the module versions of the dependency dirs of the
+// module cache modCache that aren't in deleted.
+func keptModules(modCache string, deleted []string) ([]module.Version, error) {
+	deletedDirs := make(map[string]bool, len(deleted))
+	for _, dir := range deleted {
+		deletedDirs[dir] = true
+	}
+
+	downloadDir := filepath.Join(modCache, "cache")
+	var mods []module.Version
+	err := filepath.WalkDir(modCache, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path == downloadDir || cache.IsModTempDir(d.Name()) {
+			return filepath.SkipDir
+		}
+		if !cache.IsVersionedDir(d.Name()) {
+			return nil
+		}
+
+		if mod, ok := dirModule(modCache, path); ok && !deletedDirs[path] {
+			mods = append(mods, mod)
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing kept modules: %w", err)
+	}
+	module.Sort(mods)
+
+	return mods, nil
+}
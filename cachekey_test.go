@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+func TestWriteCacheKey(t *testing.T) {
+	modCache := t.TempDir()
+	for _, dir := range []string{
+		"github.com/!burnt!sushi/toml@v1.3.2",
+		"golang.org/x/mod@v0.12.0",
+		"golang.org/x/sys@v0.11.0",
+		"golang.org/x/tools@v0.1.0.tmp-1234",
+		"cache/download/golang.org/x/mod/@v",
+	} {
+		if err := os.MkdirAll(filepath.Join(modCache, dir), 0o755); err != nil {
+			t.Fatalf("creating module cache dir: %v", err)
+		}
+	}
+
+	deleted := []string{filepath.Join(modCache, "golang.org/x/sys@v0.11.0")}
+	mods, err := keptModules(modCache, deleted)
+	if err != nil {
+		t.Fatalf("listing kept modules: %v", err)
+	}
+	want := []module.Version{
+		{Path: "github.com/BurntSushi/toml", Version: "v1.3.2"},
+		{Path: "golang.org/x/mod", Version: "v0.12.0"},
+	}
+	if !slices.Equal(mods, want) {
+		t.Errorf("keptModules() = %v, want %v", mods, want)
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "cache-key")
+	readKey := func() string {
+		t.Helper()
+		if err := writeCacheKey(keyFile, modCache, deleted); err != nil {
+			t.Fatalf("writing cache key: %v", err)
+		}
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			t.Fatalf("reading cache key: %v", err)
+		}
+		return string(key)
+	}
+	key := readKey()
+	if len(key) != 65 {
+		t.Errorf("expected a hex encoded SHA-256 checksum, got %q", key)
+	}
+	if readKey() != key {
+		t.Error("expected the same kept modules to have the same cache key")
+	}
+	deleted = nil
+	if readKey() == key {
+		t.Error("expected different kept modules to have a different cache key")
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+// cacheProgUsedLog returns the path of the file that entries used from
+// the GOCACHEPROG store dir are appended to. It is kept next to the
+// store so it isn't mistaken for a cache entry when pruning.
+func cacheProgUsedLog(dir string) string {
+	return filepath.Clean(dir) + ".used.log"
+}
+
+// cacheProgRequest is a request sent by the go command to a GOCACHEPROG
+// program.
+type cacheProgRequest struct {
+	ID       int64
+	Command  string
+	ActionID []byte `json:",omitempty"`
+	OutputID []byte `json:",omitempty"`
+	BodySize int64  `json:",omitempty"`
+}
+
+// cacheProgResponse is a response sent to the go command by a
+// GOCACHEPROG program.
+type cacheProgResponse struct {
+	ID            int64
+	Err           string     `json:",omitempty"`
+	KnownCommands []string   `json:",omitempty"`
+	Miss          bool       `json:",omitempty"`
+	OutputID      []byte     `json:",omitempty"`
+	Size          int64      `json:",omitempty"`
+	Time          *time.Time `json:",omitempty"`
+	DiskPath      string     `json:",omitempty"`
+}
+
+// cacheProgStore stores build cache entries in the same layout as the
+// go command so it can be pruned like a regular build cache, and
+// records every entry that is used.
+type cacheProgStore struct {
+	dir     string
+	usedLog *os.File
+	used    map[string]bool
+}
+
+// serveCacheProg serves the GOCACHEPROG protocol, reading requests from
+// r and writing responses to w until the go command closes the cache.
+// Entries are stored in dir.
+func serveCacheProg(dir string, r io.Reader, w io.Writer) error {
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return fmt.Errorf("creating cache dir: %w", err)
+	}
+	usedLog, err := os.OpenFile(cacheProgUsedLog(dir), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o666)
+	if err != nil {
+		return fmt.Errorf("opening used entries log: %w", err)
+	}
+	defer usedLog.Close()
+
+	s := &cacheProgStore{
+		dir:     dir,
+		usedLog: usedLog,
+		used:    make(map[string]bool),
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	respond := func(resp *cacheProgResponse) error {
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	if err := respond(&cacheProgResponse{KnownCommands: []string{"get", "put", "close"}}); err != nil {
+		return fmt.Errorf("writing response: %w", err)
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var req cacheProgRequest
+		if err := dec.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("reading request: %w", err)
+		}
+
+		var resp *cacheProgResponse
+		switch req.Command {
+		case "get":
+			resp, err = s.get(req.ActionID)
+		case "put":
+			// the body follows the request as a base64 JSON string
+			var body []byte
+			if req.BodySize > 0 {
+				if err := dec.Decode(&body); err != nil {
+					return fmt.Errorf("reading body of put request: %w", err)
+				}
+			}
+			if int64(len(body)) != req.BodySize {
+				err = fmt.Errorf("body is %d bytes, expected %d", len(body), req.BodySize)
+				break
+			}
+			resp, err = s.put(req.ActionID, req.OutputID, body)
+		case "close":
+			return respond(&cacheProgResponse{ID: req.ID})
+		default:
+			err = fmt.Errorf("unknown command %q", req.Command)
+		}
+		if resp == nil {
+			resp = new(cacheProgResponse)
+		}
+		resp.ID = req.ID
+		if err != nil {
+			resp.Err = err.Error()
+		}
+		if err := respond(resp); err != nil {
+			return fmt.Errorf("writing response: %w", err)
+		}
+	}
+}
+
+func (s *cacheProgStore) entryPath(id []byte, kind byte) string {
+	name := hex.EncodeToString(id)
+	return filepath.Join(s.dir, name[:2], name+"-"+string(kind))
+}
+
+func (s *cacheProgStore) get(actionID []byte) (*cacheProgResponse, error) {
+	if len(actionID) != sha256.Size {
+		return nil, errors.New("invalid action ID")
+	}
+
+	actionPath := s.entryPath(actionID, 'a')
+	entry, err := os.ReadFile(actionPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &cacheProgResponse{Miss: true}, nil
+		}
+		return nil, err
+	}
+	outputID, size, t, err := parseActionEntry(entry, actionID)
+	if err != nil {
+		// treat corrupted entries as missing so they are replaced
+		return &cacheProgResponse{Miss: true}, nil
+	}
+	outputPath := s.entryPath(outputID, 'd')
+	if _, err := os.Stat(outputPath); err != nil {
+		return &cacheProgResponse{Miss: true}, nil
+	}
+
+	if err := s.markUsed(actionPath, outputPath); err != nil {
+		return nil, err
+	}
+	return &cacheProgResponse{
+		OutputID: outputID,
+		Size:     size,
+		Time:     &t,
+		DiskPath: outputPath,
+	}, nil
+}
+
+func (s *cacheProgStore) put(actionID, outputID, body []byte) (*cacheProgResponse, error) {
+	if len(actionID) != sha256.Size || len(outputID) != sha256.Size {
+		return nil, errors.New("invalid action or output ID")
+	}
+
+	outputPath := s.entryPath(outputID, 'd')
+	if err := writeFileAtomic(outputPath, body); err != nil {
+		return nil, fmt.Errorf("writing output entry: %w", err)
+	}
+	actionPath := s.entryPath(actionID, 'a')
+	entry := fmt.Sprintf("v1 %x %x %20d %20d\n", actionID, outputID, len(body), time.Now().UnixNano())
+	if err := writeFileAtomic(actionPath, []byte(entry)); err != nil {
+		return nil, fmt.Errorf("writing action entry: %w", err)
+	}
+
+	if err := s.markUsed(actionPath, outputPath); err != nil {
+		return nil, err
+	}
+	return &cacheProgResponse{DiskPath: outputPath}, nil
+}
+
+// markUsed appends paths that haven't been used yet to the used entries
+// log.
+func (s *cacheProgStore) markUsed(paths ...string) error {
+	var sb strings.Builder
+	for _, path := range paths {
+		if !s.used[path] {
+			s.used[path] = true
+			sb.WriteString(path)
+			sb.WriteByte('\n')
+		}
+	}
+	if sb.Len() == 0 {
+		return nil
+	}
+
+	// appends are atomic so multiple go commands can share the log
+	if _, err := s.usedLog.WriteString(sb.String()); err != nil {
+		return fmt.Errorf("recording used entries: %w", err)
+	}
+	return nil
+}
+
+// parseActionEntry parses an action entry of the build cache, returning
+// the output ID, size and time it was created.
+func parseActionEntry(entry, actionID []byte) ([]byte, int64, time.Time, error) {
+	fields := bytes.Fields(entry)
+	if len(fields) != 5 || string(fields[0]) != "v1" || string(fields[1]) != hex.EncodeToString(actionID) {
+		return nil, 0, time.Time{}, errors.New("malformed action entry")
+	}
+	outputID, err := hex.DecodeString(string(fields[2]))
+	if err != nil {
+		return nil, 0, time.Time{}, fmt.Errorf("parsing output ID: %w", err)
+	}
+	size, err := strconv.ParseInt(string(fields[3]), 10, 64)
+	if err != nil {
+		return nil, 0, time.Time{}, fmt.Errorf("parsing size: %w", err)
+	}
+	nanos, err := strconv.ParseInt(string(fields[4]), 10, 64)
+	if err != nil {
+		return nil, 0, time.Time{}, fmt.Errorf("parsing time: %w", err)
+	}
+
+	return outputID, size, time.Unix(0, nanos), nil
+}
+
+// writeFileAtomic writes data to path so that other processes never see
+// a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// resetCacheProgUsage clears the used entries log of a GOCACHEPROG
+// store.
+func resetCacheProgUsage(dir string) error {
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return fmt.Errorf("creating cache dir: %w", err)
+	}
+	return os.WriteFile(cacheProgUsedLog(dir), nil, 0o666)
+}
+
+// readCacheProgUsage returns the entries of a GOCACHEPROG store that
+// were used since its used entries log was last reset.
+func readCacheProgUsage(dir string, dirLevel bool) (cache.UsedFiles, error) {
+	log, err := os.ReadFile(cacheProgUsedLog(dir))
+	if err != nil {
+		return cache.UsedFiles{}, fmt.Errorf("reading used entries log: %w", err)
+	}
+
+	usedFiles := cache.NewUsedFiles()
+	for _, path := range strings.Split(string(log), "\n") {
+		if path == "" {
+			continue
+		}
+		if dirLevel {
+			path = filepath.Dir(path)
+		}
+		usedFiles.Add(path, cache.DefaultPhaseMask)
+	}
+
+	return usedFiles, nil
+}
+
+// cacheProgCommand returns the value of GOCACHEPROG that makes the go
+// command use this executable to serve the build cache from dir.
+func cacheProgCommand(dir string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("getting executable path: %w", err)
+	}
+	return quoteCacheProgArg(exe) + " " + quoteCacheProgArg("-serve-cacheprog="+dir), nil
+}
+
+// quoteCacheProgArg quotes arg so the go command will split GOCACHEPROG
+// correctly, it doesn't support escaping quotes.
+func quoteCacheProgArg(arg string) string {
+	if !strings.ContainsAny(arg, " \t\n'\"") {
+		return arg
+	}
+	if !strings.Contains(arg, "'") {
+		return "'" + arg + "'"
+	}
+	return `"` + arg + `"`
+}
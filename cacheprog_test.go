@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+func TestServeCacheProg(t *testing.T) {
+	dir := t.TempDir()
+	actionID := sha256.Sum256([]byte("action"))
+	missingID := sha256.Sum256([]byte("missing"))
+	body := []byte("output")
+	outputID := sha256.Sum256(body)
+
+	var reqs bytes.Buffer
+	enc := json.NewEncoder(&reqs)
+	for _, v := range []any{
+		cacheProgRequest{ID: 1, Command: "put", ActionID: actionID[:], OutputID: outputID[:], BodySize: int64(len(body))},
+		body,
+		cacheProgRequest{ID: 2, Command: "get", ActionID: actionID[:]},
+		cacheProgRequest{ID: 3, Command: "get", ActionID: missingID[:]},
+		cacheProgRequest{ID: 4, Command: "close"},
+	} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("encoding request: %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := serveCacheProg(dir, &reqs, &out); err != nil {
+		t.Fatalf("serving cacheprog: %v", err)
+	}
+
+	var resps []cacheProgResponse
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var resp cacheProgResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		if resp.Err != "" {
+			t.Fatalf("response %d has error: %s", resp.ID, resp.Err)
+		}
+		resps = append(resps, resp)
+	}
+	if len(resps) != 5 {
+		t.Fatalf("got %d responses, want 5", len(resps))
+	}
+	if len(resps[0].KnownCommands) == 0 {
+		t.Errorf("initial response has no known commands")
+	}
+
+	get := resps[2]
+	if get.Miss || !bytes.Equal(get.OutputID, outputID[:]) || get.Size != int64(len(body)) {
+		t.Errorf("get returned %+v, want hit with output ID %x", get, outputID)
+	}
+	if got, err := os.ReadFile(get.DiskPath); err != nil || !bytes.Equal(got, body) {
+		t.Errorf("reading disk path = %q, %v, want %q", got, err, body)
+	}
+	if !cache.IsBuildEntry(filepath.Base(get.DiskPath)) {
+		t.Errorf("output entry %q isn't named like a build cache entry", get.DiskPath)
+	}
+	if !resps[3].Miss {
+		t.Errorf("get of missing entry didn't miss")
+	}
+
+	used, err := readCacheProgUsage(dir, false)
+	if err != nil {
+		t.Fatalf("reading usage: %v", err)
+	}
+	if used.Len() != 2 {
+		t.Errorf("got %d used entries, want 2", used.Len())
+	}
+	used.Range(func(path string, _ cache.PhaseMask) bool {
+		if !strings.HasPrefix(filepath.Base(path), filepath.Base(filepath.Dir(path))) {
+			t.Errorf("entry %q is in the wrong shard", path)
+		}
+		return true
+	})
+}
+
+func TestQuoteCacheProgArg(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"/usr/bin/go-cache-prune", "/usr/bin/go-cache-prune"},
+		{`C:\Program Files\go-cache-prune.exe`, `'C:\Program Files\go-cache-prune.exe'`},
+		{"/tmp/it's here", `"/tmp/it's here"`},
+	}
+	for _, tt := range tests {
+		if got := quoteCacheProgArg(tt.arg); got != tt.want {
+			t.Errorf("quoteCacheProgArg(%q) = %q, want %q", tt.arg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Package clock lets the time used by watching and pruning be injected,
+// so time based policies can be tested deterministically.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates tickers and timers.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) *Ticker
+	NewTimer(d time.Duration) *Timer
+}
+
+// Ticker delivers ticks of a Clock on C at intervals.
+type Ticker struct {
+	C    <-chan time.Time
+	stop func()
+}
+
+// Stop turns off the ticker.
+func (t *Ticker) Stop() {
+	t.stop()
+}
+
+// Timer delivers the time of a Clock on C once.
+type Timer struct {
+	C    <-chan time.Time
+	stop func() bool
+}
+
+// Stop prevents the timer from firing, it returns false if the timer
+// already fired or was stopped.
+func (t *Timer) Stop() bool {
+	return t.stop()
+}
+
+// System is the Clock of the system.
+var System Clock = systemClock{}
+
+// Or returns c, or System if c is nil.
+func Or(c Clock) Clock {
+	if c == nil {
+		return System
+	}
+	return c
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) *Ticker {
+	t := time.NewTicker(d)
+	return &Ticker{C: t.C, stop: t.Stop}
+}
+
+func (systemClock) NewTimer(d time.Duration) *Timer {
+	t := time.NewTimer(d)
+	return &Timer{C: t.C, stop: t.Stop}
+}
+
+// Fake is a Clock whose time only changes when it is advanced. It is
+// safe to use concurrently.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter is a ticker or timer of a Fake clock.
+type fakeWaiter struct {
+	when   time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+// NewFake returns a Fake clock whose time is now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the current time of the clock.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// Advance moves the clock forward by d, firing any tickers and timers
+// that are due. Like the system clock, ticks are dropped if the
+// previous one wasn't received yet.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	waiters := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.when.After(f.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+
+		select {
+		case w.c <- f.now:
+		default:
+		}
+		if w.period > 0 {
+			for !w.when.After(f.now) {
+				w.when = w.when.Add(w.period)
+			}
+			waiters = append(waiters, w)
+		}
+	}
+	f.waiters = waiters
+}
+
+// NewTicker returns a Ticker that ticks every d of the clock's time.
+func (f *Fake) NewTicker(d time.Duration) *Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	w := f.addWaiter(d, d)
+	return &Ticker{C: w.c, stop: func() { f.removeWaiter(w) }}
+}
+
+// NewTimer returns a Timer that fires once d of the clock's time has
+// passed.
+func (f *Fake) NewTimer(d time.Duration) *Timer {
+	w := f.addWaiter(d, 0)
+	return &Timer{C: w.c, stop: func() bool { return f.removeWaiter(w) }}
+}
+
+func (f *Fake) addWaiter(d, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &fakeWaiter{
+		when:   f.now.Add(d),
+		period: period,
+		c:      make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		w.c <- f.now
+		return w
+	}
+	f.waiters = append(f.waiters, w)
+	return w
+}
+
+// removeWaiter stops w, returning false if it already stopped.
+func (f *Fake) removeWaiter(w *fakeWaiter) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Waiters returns how many tickers and timers are waiting to fire, so
+// tests can wait until code under test is waiting on the clock before
+// advancing it.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.waiters)
+}
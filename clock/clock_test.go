@@ -0,0 +1,55 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+
+	ticker := c.NewTicker(time.Minute)
+	defer ticker.Stop()
+	timer := c.NewTimer(90 * time.Second)
+
+	c.Advance(30 * time.Second)
+	select {
+	case <-ticker.C:
+		t.Fatal("ticker fired early")
+	case <-timer.C:
+		t.Fatal("timer fired early")
+	default:
+	}
+
+	c.Advance(30 * time.Second)
+	if got, want := <-ticker.C, start.Add(time.Minute); !got.Equal(want) {
+		t.Errorf("ticker fired at %v, want %v", got, want)
+	}
+
+	// ticks that weren't received are dropped
+	c.Advance(3 * time.Minute)
+	if got, want := <-timer.C, start.Add(4*time.Minute); !got.Equal(want) {
+		t.Errorf("timer fired at %v, want %v", got, want)
+	}
+	<-ticker.C
+	select {
+	case <-ticker.C:
+		t.Fatal("ticker didn't drop ticks")
+	default:
+	}
+
+	if timer.Stop() {
+		t.Error("stopping fired timer returned true")
+	}
+	if got := c.Waiters(); got != 1 {
+		t.Errorf("got %d waiters, want 1", got)
+	}
+	ticker.Stop()
+	if got := c.Waiters(); got != 0 {
+		t.Errorf("got %d waiters after stopping ticker, want 0", got)
+	}
+	if got, want := c.Now(), start.Add(4*time.Minute); !got.Equal(want) {
+		t.Errorf("Now() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// configOption is an option set in a config file.
+type configOption struct {
+	name   string
+	values []string
+	// list is true if the option was set to a list
+	list bool
+	line int
+}
+
+// loadConfigFile sets the flags of fs to the options in the config file
+// at path. Flags that were already set aren't changed, so options
+// passed on the command line override the config file.
+func loadConfigFile(path string, fs *flag.FlagSet) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening config file: %w", err)
+	}
+	defer f.Close()
+
+	opts, err := parseConfigFile(f)
+	if err != nil {
+		return fmt.Errorf("%s:%w", path, err)
+	}
+
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	for _, opt := range opts {
+		fl := fs.Lookup(opt.name)
+		if fl == nil || opt.name == "config" || opt.name == "version" {
+			return fmt.Errorf("%s:%d: unknown option %q", path, opt.line, opt.name)
+		}
+		if setFlags[opt.name] {
+			continue
+		}
+
+		values := opt.values
+		if _, repeatable := fl.Value.(*stringsFlag); !repeatable && opt.list {
+			// flags that aren't repeatable take comma separated lists
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, val := range values {
+			if err := fs.Set(opt.name, val); err != nil {
+				return fmt.Errorf("%s:%d: invalid value %q for option %q: %w", path, opt.line, val, opt.name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// envPrefix is the prefix of environment variables that set flags.
+const envPrefix = "GO_CACHE_PRUNE_"
+
+// envVarName returns the environment variable that sets the flag name.
+func envVarName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadEnv sets the flags of fs that weren't already set from
+// environment variables, looked up with getenv. Repeatable flags take
+// a comma separated list.
+func loadEnv(fs *flag.FlagSet, getenv func(string) string) error {
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || setFlags[f.Name] || f.Name == "version" {
+			return
+		}
+		envVar := envVarName(f.Name)
+		val := getenv(envVar)
+		if val == "" {
+			return
+		}
+
+		values := []string{val}
+		if _, repeatable := f.Value.(*stringsFlag); repeatable {
+			values = parseList(val)
+		}
+		for _, val := range values {
+			if setErr := fs.Set(f.Name, val); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %w", val, envVar, setErr)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// parseConfigFile parses a config file. It is a YAML mapping of flag
+// names to values, which may be lists. Only the subset of YAML needed
+// for that is supported, nested mappings and multi-line strings are not.
+func parseConfigFile(r io.Reader) ([]configOption, error) {
+	var (
+		opts []configOption
+		seen = make(map[string]bool)
+		// cur is the option whose block list is being parsed
+		cur *configOption
+	)
+	s := bufio.NewScanner(r)
+	line := 1
+	for ; s.Scan(); line++ {
+		text, err := stripComment(s.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%d: %w", line, err)
+		}
+		if strings.TrimSpace(text) == "" || text == "---" {
+			continue
+		}
+
+		indented := text[0] == ' ' || text[0] == '\t'
+		text = strings.TrimSpace(text)
+		if item, ok := strings.CutPrefix(text, "-"); ok && (item == "" || item[0] == ' ') {
+			if cur == nil {
+				return nil, fmt.Errorf("%d: list item isn't the value of an option", line)
+			}
+			val, err := parseConfigValue(strings.TrimSpace(item))
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", line, err)
+			}
+			cur.values = append(cur.values, val)
+			continue
+		}
+		if indented {
+			return nil, fmt.Errorf("%d: nested options aren't supported", line)
+		}
+
+		name, val, ok := strings.Cut(text, ":")
+		if !ok {
+			return nil, fmt.Errorf("%d: expected \"option: value\"", line)
+		}
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			return nil, fmt.Errorf("%d: option %q is set more than once", line, name)
+		}
+		seen[name] = true
+
+		opt := configOption{name: name, line: line}
+		val = strings.TrimSpace(val)
+		switch {
+		case val == "":
+			// the value is a block list on the following lines
+			opt.list = true
+		case strings.HasPrefix(val, "["):
+			if !strings.HasSuffix(val, "]") {
+				return nil, fmt.Errorf("%d: unterminated list", line)
+			}
+			opt.list = true
+			for _, item := range strings.Split(val[1:len(val)-1], ",") {
+				if item = strings.TrimSpace(item); item == "" {
+					continue
+				}
+				item, err := parseConfigValue(item)
+				if err != nil {
+					return nil, fmt.Errorf("%d: %w", line, err)
+				}
+				opt.values = append(opt.values, item)
+			}
+		default:
+			val, err := parseConfigValue(val)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", line, err)
+			}
+			opt.values = []string{val}
+		}
+		opts = append(opts, opt)
+		cur = nil
+		if val == "" {
+			cur = &opts[len(opts)-1]
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%d: reading config file: %w", line, err)
+	}
+
+	return opts, nil
+}
+
+// stripComment removes a trailing comment from a line of a config file.
+func stripComment(line string) (string, error) {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t[,", line[i-1]) != -1):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return strings.TrimRight(line[:i], " \t"), nil
+		}
+	}
+	if quote != 0 {
+		return "", errors.New("unterminated quoted string")
+	}
+	return strings.TrimRight(line, " \t"), nil
+}
+
+// parseConfigValue unquotes a value of a config file if it is quoted.
+func parseConfigValue(val string) (string, error) {
+	if len(val) >= 2 && val[0] == '\'' && val[len(val)-1] == '\'' {
+		return strings.ReplaceAll(val[1:len(val)-1], "''", "'"), nil
+	}
+	if strings.HasPrefix(val, `"`) {
+		unquoted, err := strconv.Unquote(val)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %s", val)
+		}
+		return unquoted, nil
+	}
+	return val, nil
+}
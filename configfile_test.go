@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []configOption
+		wantErr bool
+	}{
+		{
+			name: "values",
+			data: `---
+# comment
+mod-cache: /var/cache/go mod # trailing comment
+dry-run: true
+report: "report #1.json"
+protect: 'it''s'
+`,
+			want: []configOption{
+				{name: "mod-cache", values: []string{"/var/cache/go mod"}, line: 3},
+				{name: "dry-run", values: []string{"true"}, line: 4},
+				{name: "report", values: []string{"report #1.json"}, line: 5},
+				{name: "protect", values: []string{"it's"}, line: 6},
+			},
+		},
+		{
+			name: "lists",
+			data: `prune-exclude:
+  - 'github.com/mycorp/**'
+
+  - golang.org/x/*
+protect: [a.com, "b.com", ]
+ignore-procs: []
+`,
+			want: []configOption{
+				{name: "prune-exclude", values: []string{"github.com/mycorp/**", "golang.org/x/*"}, list: true, line: 1},
+				{name: "protect", values: []string{"a.com", "b.com"}, list: true, line: 5},
+				{name: "ignore-procs", list: true, line: 6},
+			},
+		},
+		{
+			name:    "nested",
+			data:    "watch:\n  backend: poll\n",
+			wantErr: true,
+		},
+		{
+			name:    "orphan list item",
+			data:    "- a\n",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate",
+			data:    "dry-run: true\ndry-run: false\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated quote",
+			data:    "report: \"report.json\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated list",
+			data:    "protect: [a.com\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseConfigFile(strings.NewReader(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got options %+v", opts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsing config file: %v", err)
+			}
+			if !reflect.DeepEqual(opts, tt.want) {
+				t.Errorf("parseConfigFile() = %+v, want %+v", opts, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "go-cache-prune.yaml")
+	const data = `mod-cache: /from/config
+max-age: 24h
+protect: [a.com, b.com]
+prune-exclude:
+  - one
+  - two
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("writing config file: %v", err)
+	}
+
+	var (
+		fs       = flag.NewFlagSet("test", flag.ContinueOnError)
+		modCache string
+		protect  string
+		maxAge   time.Duration
+		excludes stringsFlag
+	)
+	fs.StringVar(&modCache, "mod-cache", "", "")
+	fs.StringVar(&protect, "protect", "", "")
+	fs.DurationVar(&maxAge, "max-age", 0, "")
+	fs.Var(&excludes, "prune-exclude", "")
+	if err := fs.Parse([]string{"-mod-cache=/from/flag"}); err != nil {
+		t.Fatalf("parsing flags: %v", err)
+	}
+
+	if err := loadConfigFile(path, fs); err != nil {
+		t.Fatalf("loading config file: %v", err)
+	}
+	if modCache != "/from/flag" {
+		t.Errorf("expected flag to override config file, got mod-cache %q", modCache)
+	}
+	if maxAge != 24*time.Hour {
+		t.Errorf("expected max-age 24h, got %s", maxAge)
+	}
+	if protect != "a.com,b.com" {
+		t.Errorf("expected protect list to be joined, got %q", protect)
+	}
+	if want := (stringsFlag{"one", "two"}); !reflect.DeepEqual(excludes, want) {
+		t.Errorf("expected prune-exclude %q, got %q", want, excludes)
+	}
+
+	for _, data := range []string{"unknown: true\n", "max-age: soon\n"} {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("writing config file: %v", err)
+		}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.DurationVar(&maxAge, "max-age", 0, "")
+		if err := loadConfigFile(path, fs); err == nil {
+			t.Errorf("expected loading %q to fail", data)
+		}
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	env := map[string]string{
+		"GO_CACHE_PRUNE_MOD_CACHE":     "/from/env",
+		"GO_CACHE_PRUNE_MAX_AGE":       "24h",
+		"GO_CACHE_PRUNE_PRUNE_EXCLUDE": "one, two",
+		"GO_CACHE_PRUNE_VERSION":       "1.2.3",
+	}
+	var (
+		fs       = flag.NewFlagSet("test", flag.ContinueOnError)
+		modCache string
+		maxAge   time.Duration
+		excludes stringsFlag
+		version  bool
+	)
+	fs.StringVar(&modCache, "mod-cache", "", "")
+	fs.DurationVar(&maxAge, "max-age", 0, "")
+	fs.Var(&excludes, "prune-exclude", "")
+	fs.BoolVar(&version, "version", false, "")
+	if err := fs.Parse([]string{"-mod-cache=/from/flag"}); err != nil {
+		t.Fatalf("parsing flags: %v", err)
+	}
+
+	getenv := func(key string) string { return env[key] }
+	if err := loadEnv(fs, getenv); err != nil {
+		t.Fatalf("loading environment: %v", err)
+	}
+	if modCache != "/from/flag" {
+		t.Errorf("expected flag to override environment, got mod-cache %q", modCache)
+	}
+	if maxAge != 24*time.Hour {
+		t.Errorf("expected max-age 24h, got %s", maxAge)
+	}
+	if want := (stringsFlag{"one", "two"}); !reflect.DeepEqual(excludes, want) {
+		t.Errorf("expected prune-exclude %q, got %q", want, excludes)
+	}
+	if version {
+		t.Error("expected -version to not be set from the environment")
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.DurationVar(&maxAge, "max-age", 0, "")
+	env["GO_CACHE_PRUNE_MAX_AGE"] = "soon"
+	if err := loadEnv(fs, getenv); err == nil {
+		t.Error("expected invalid duration to fail")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/capnspacehook/go-cache-prune/control"
+)
+
+const controlSocketName = "go-cache-prune.sock"
+
+// logStatusCommand is the control command that makes go-cache-prune log
+// how watching caches is going, as SIGUSR1 does.
+const logStatusCommand = "log-status"
+
+// abortCommand is the control command that makes go-cache-prune shut
+// down without pruning caches.
+const abortCommand = "abort"
+
+// controlHandler handles a command sent to the control socket and
+// returns the command's output.
+type controlHandler func(args []string) (string, error)
+
+// serveControl listens for commands on a Unix socket at sockPath and
+// dispatches them to handlers. The returned function stops listening.
+func serveControl(sockPath string, handlers map[string]controlHandler) (func(), error) {
+	// remove the socket if a previous go-cache-prune didn't exit
+	// cleanly; the instance registry already ensured no other
+	// go-cache-prune is running
+	if err := os.Remove(sockPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("removing stale control socket: %w", err)
+	}
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return nil, fmt.Errorf("listening on control socket: %w", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				log.Warningf("accepting control connection: %v", err)
+				continue
+			}
+			go handleControlConn(conn, handlers)
+		}
+	}()
+
+	return func() {
+		if err := l.Close(); err != nil {
+			log.Warningf("closing control socket: %v", err)
+		}
+	}, nil
+}
+
+func handleControlConn(conn net.Conn, handlers map[string]controlHandler) {
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		log.Warningf("reading control command: %v", err)
+		return
+	}
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		fmt.Fprint(conn, "error: empty command\n")
+		return
+	}
+
+	handler, ok := handlers[args[0]]
+	if !ok {
+		fmt.Fprintf(conn, "error: unknown command %q\n", args[0])
+		return
+	}
+	log.Debugf("got control command %q", strings.Join(args, " "))
+	out, err := handler(args[1:])
+	if err != nil {
+		fmt.Fprintf(conn, "error: %v\n", err)
+		return
+	}
+
+	fmt.Fprintf(conn, "ok\n%s", out)
+}
+
+// sendControlCommand sends a command to the go-cache-prune process
+// listening on sockPath and returns its output.
+func sendControlCommand(sockPath string, args ...string) (string, error) {
+	return control.Send(context.Background(), sockPath, args...)
+}
@@ -0,0 +1,117 @@
+// Package control talks to a running go-cache-prune process over its
+// control socket, so other programs on a build machine can orchestrate
+// watching and pruning caches without signals or PID files.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Send sends a command to the go-cache-prune process listening on
+// sockPath and returns its output.
+func Send(ctx context.Context, sockPath string, args ...string) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", sockPath)
+	if err != nil {
+		return "", fmt.Errorf("connecting to control socket: %w", err)
+	}
+	defer conn.Close()
+	// commands can take as long as pruning, only give up on them if
+	// the context is canceled
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if _, err := fmt.Fprintf(conn, "%s\n", strings.Join(args, " ")); err != nil {
+		return "", fmt.Errorf("sending control command: %w", err)
+	}
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("reading control response: %w", err)
+	}
+
+	status, out, _ := strings.Cut(string(resp), "\n")
+	if status != "ok" {
+		return "", fmt.Errorf("running control command: %s", strings.TrimPrefix(status, "error: "))
+	}
+
+	return out, nil
+}
+
+// Client controls a go-cache-prune daemon started with -daemon.
+type Client struct {
+	// SockPath is the path of the daemon's control socket,
+	// go-cache-prune.sock in its runtime dir
+	SockPath string
+}
+
+// Stats is how watching and pruning caches is going.
+type Stats struct {
+	// PID is the process ID of the daemon
+	PID int `json:"pid"`
+	// Events is how many events were received while watching
+	Events uint64 `json:"events"`
+	// Used is how many entries were recorded as used
+	Used uint64 `json:"used"`
+	// Watches is how many watches are registered
+	Watches int64 `json:"watches"`
+	// WatchingSince is when caches started being watched
+	WatchingSince time.Time `json:"watchingSince"`
+	// LastPrune is when caches were last pruned, or nil if they
+	// haven't been
+	LastPrune *time.Time `json:"lastPrune,omitempty"`
+	// LastReport is the report of the last prune, in the format
+	// written by -report
+	LastReport json.RawMessage `json:"lastReport,omitempty"`
+}
+
+// GetStats returns how watching and pruning caches is going.
+func (c Client) GetStats(ctx context.Context) (*Stats, error) {
+	out, err := Send(ctx, c.SockPath, "status")
+	if err != nil {
+		return nil, err
+	}
+	stats := new(Stats)
+	if err := json.Unmarshal([]byte(out), stats); err != nil {
+		return nil, fmt.Errorf("decoding stats: %w", err)
+	}
+	return stats, nil
+}
+
+// StartWatch discards the usage recorded so far and starts watching
+// caches again.
+func (c Client) StartWatch(ctx context.Context) error {
+	_, err := Send(ctx, c.SockPath, "reset")
+	return err
+}
+
+// StopAndPrune stops watching and prunes the caches, keeping entries
+// used since caches started being watched. Watching starts again once
+// pruning has started.
+func (c Client) StopAndPrune(ctx context.Context) error {
+	_, err := Send(ctx, c.SockPath, "prune")
+	return err
+}
+
+// DryRun returns the report of what pruning caches now would delete,
+// in the format written by -report. Recorded usage is kept.
+func (c Client) DryRun(ctx context.Context) (json.RawMessage, error) {
+	out, err := Send(ctx, c.SockPath, "dry-run")
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(out), nil
+}
+
+// Stop shuts the daemon down without pruning caches.
+func (c Client) Stop(ctx context.Context) error {
+	_, err := Send(ctx, c.SockPath, "stop")
+	return err
+}
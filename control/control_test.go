@@ -0,0 +1,78 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serve answers commands sent to a socket in a temporary dir with
+// responses, and returns the socket's path.
+func serve(t *testing.T, responses map[string]string) string {
+	t.Helper()
+
+	sockPath := filepath.Join(t.TempDir(), "go-cache-prune.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			resp, ok := responses[strings.TrimSpace(line)]
+			if !ok {
+				resp = fmt.Sprintf("error: unknown command %q\n", strings.TrimSpace(line))
+			}
+			fmt.Fprint(conn, resp)
+			conn.Close()
+		}
+	}()
+
+	return sockPath
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c := Client{SockPath: serve(t, map[string]string{
+		"status":  "ok\n" + `{"pid":42,"events":10,"used":3,"watches":7,"watchingSince":"2024-01-01T00:00:00Z"}` + "\n",
+		"dry-run": "ok\n" + `{"dryRun":true}` + "\n",
+		"prune":   "ok\n",
+		"reset":   "error: caches aren't being watched yet\n",
+	})}
+
+	stats, err := c.GetStats(ctx)
+	if err != nil {
+		t.Fatalf("getting stats: %v", err)
+	}
+	if stats.PID != 42 || stats.Events != 10 || stats.Used != 3 || stats.Watches != 7 || stats.LastPrune != nil {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	report, err := c.DryRun(ctx)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if strings.TrimSpace(string(report)) != `{"dryRun":true}` {
+		t.Errorf("unexpected dry run report %s", report)
+	}
+
+	if err := c.StopAndPrune(ctx); err != nil {
+		t.Errorf("pruning: %v", err)
+	}
+	if err := c.StartWatch(ctx); err == nil || !strings.Contains(err.Error(), "aren't being watched yet") {
+		t.Errorf("expected error from daemon, got %v", err)
+	}
+	if err := c.Stop(ctx); err == nil {
+		t.Error("expected unknown command to fail")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestControlSocket(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), controlSocketName)
+	stop, err := serveControl(sockPath, map[string]controlHandler{
+		"echo": func(args []string) (string, error) {
+			return strings.Join(args, " ") + "\n", nil
+		},
+		"fail": func([]string) (string, error) {
+			return "", errors.New("failed")
+		},
+	})
+	if err != nil {
+		t.Fatalf("serving control socket: %v", err)
+	}
+	t.Cleanup(stop)
+
+	out, err := sendControlCommand(sockPath, "echo", "hello", "world")
+	if err != nil {
+		t.Fatalf("sending command: %v", err)
+	}
+	if out != "hello world\n" {
+		t.Fatalf("expected output %q, got %q", "hello world\n", out)
+	}
+
+	if _, err := sendControlCommand(sockPath, "fail"); err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Fatalf("expected command to fail, got %v", err)
+	}
+	if _, err := sendControlCommand(sockPath, "unknown"); err == nil {
+		t.Fatal("expected unknown command to fail")
+	}
+}
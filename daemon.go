@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/clock"
+	"github.com/capnspacehook/go-cache-prune/prune"
+	"github.com/capnspacehook/go-cache-prune/watch"
+)
+
+// Commands the daemon's control socket accepts that end a watch cycle.
+const (
+	daemonPrune  = "prune"
+	daemonDryRun = "dry-run"
+	daemonReset  = "reset"
+	daemonStop   = "stop"
+	// daemonPruneStop prunes caches and then stops, it's done when
+	// systemd stops the daemon
+	daemonPruneStop = "prune-stop"
+)
+
+// daemon watches caches indefinitely, pruning them when told to over
+// the control socket or with SIGHUP. Each prune keeps the entries used
+// since the daemon started or the caches were last pruned or reset.
+type daemon struct {
+	cfg    *config
+	clk    clock.Clock
+	status *runStatus
+
+	mu sync.Mutex
+	// endCycle stops watching the caches for the current cycle
+	endCycle context.CancelFunc
+	// action is what to do once the current cycle ends
+	action string
+	// dryRuns are waiting for the reports of dry runs
+	dryRuns []chan<- dryRunResult
+
+	// usage recorded in earlier cycles that ended with a dry run
+	carriedMod   cache.UsedFiles
+	carriedBuild cache.UsedFiles
+}
+
+// dryRunResult is the JSON report of a dry run, or why it failed.
+type dryRunResult struct {
+	report []byte
+	err    error
+}
+
+// runDaemon runs go-cache-prune as a daemon until ctx is canceled or
+// the stop control command is sent.
+func runDaemon(ctx context.Context, cfg *config, controlSocket string, status *runStatus) error {
+	d := &daemon{cfg: cfg, clk: clock.System, status: status}
+	stopControl, err := serveControl(controlSocket, map[string]controlHandler{
+		"status":         status.controlHandler(),
+		"stats":          status.controlHandler(),
+		logStatusCommand: status.logStatusHandler(),
+		abortCommand:     d.endCycleWith(daemonStop),
+		daemonPrune:      d.endCycleWith(daemonPrune),
+		daemonDryRun:     d.dryRun,
+		daemonReset:      d.endCycleWith(daemonReset),
+		daemonStop:       d.endCycleWith(daemonStop),
+	})
+	if err != nil {
+		return err
+	}
+	defer stopControl()
+
+	if _, pruneSigs := serviceSignals(cfg); len(pruneSigs) != 0 {
+		pruneSig := make(chan os.Signal, 1)
+		signal.Notify(pruneSig, pruneSigs...)
+		defer signal.Stop(pruneSig)
+		go func() {
+			for sig := range pruneSig {
+				action := daemonPrune
+				if sig == syscall.SIGTERM {
+					action = daemonPruneStop
+				}
+				_, _ = d.endCycleWith(action)(nil)
+			}
+		}()
+	}
+	go runWatchdog(ctx, cfg)
+
+	log.Infof("starting %s daemon version=%s commit=%s", projectName, version, cfg.commit)
+	logConfig(cfg)
+
+	dirLevel := cfg.buildGranularity == dirGranularity
+	for {
+		cycleCtx, cycleCancel := context.WithCancel(ctx)
+		stats := new(watch.Stats)
+		d.mu.Lock()
+		d.endCycle, d.action = cycleCancel, ""
+		d.mu.Unlock()
+		status.watching(stats, d.clk.Now())
+
+		watchOpts := watch.Options{
+			Backend:         cfg.watchBackend,
+			Scan:            cfg.mode == atimeMode,
+			IgnoredProcs:    watch.ParseProcNames(cfg.ignoreProcs),
+			Stats:           stats,
+			Clock:           d.clk,
+			RaiseWatchLimit: cfg.raiseWatchLimit,
+			Ready: func() {
+				status.ready(d.clk.Now())
+				notifySystemd(cfg, "READY=1\nSTATUS=watching caches")
+			},
+		}
+		modFiles, buildFiles, err := watch.Caches(cycleCtx, cycleCtx, cfg.moduleCache, cfg.buildCache, dirLevel, watchOpts)
+		cycleCancel()
+		if err != nil {
+			return fmt.Errorf("watching caches: %w", err)
+		}
+		if ctx.Err() != nil {
+			log.Infof("signal received, shutting down without pruning caches")
+			return nil
+		}
+
+		d.mu.Lock()
+		action, dryRuns := d.action, d.dryRuns
+		d.dryRuns = nil
+		d.mu.Unlock()
+		if action != daemonDryRun {
+			for _, dryRun := range dryRuns {
+				dryRun <- dryRunResult{err: fmt.Errorf("%s command was sent at the same time", action)}
+			}
+		}
+		switch action {
+		case daemonStop:
+			log.Infof("stop command received, shutting down without pruning caches")
+			notifySystemd(cfg, "STOPPING=1")
+			return nil
+		case daemonReset:
+			log.Infof("reset command received, discarding recorded usage")
+			d.carriedMod, d.carriedBuild = cache.UsedFiles{}, cache.UsedFiles{}
+			continue
+		}
+
+		modFiles, buildFiles = mergeUsedFiles(modFiles, d.carriedMod), mergeUsedFiles(buildFiles, d.carriedBuild)
+		if action == daemonDryRun {
+			// keep the usage for the next cycle, the caches weren't
+			// really pruned
+			d.carriedMod, d.carriedBuild = modFiles.Clone(), buildFiles.Clone()
+			report, err := d.prune(ctx, modFiles, buildFiles, true)
+			var reportBytes []byte
+			if err == nil {
+				reportBytes, err = json.Marshal(report)
+			}
+			for _, dryRun := range dryRuns {
+				dryRun <- dryRunResult{report: reportBytes, err: err}
+			}
+			continue
+		}
+
+		d.carriedMod, d.carriedBuild = cache.UsedFiles{}, cache.UsedFiles{}
+		if action == daemonPruneStop {
+			notifySystemd(cfg, "STOPPING=1\nSTATUS=pruning caches")
+		} else {
+			notifySystemd(cfg, "STATUS=pruning caches")
+		}
+		pruneStart := d.clk.Now()
+		report, err := d.prune(ctx, modFiles, buildFiles, false)
+		if err != nil {
+			return err
+		}
+		if report != nil {
+			d.status.pruned(report, pruneStart, d.clk.Now())
+		}
+		if action == daemonPruneStop {
+			return nil
+		}
+	}
+}
+
+// mergeUsedFiles adds the entries of src to dst, which is allocated if
+// it is the zero value, and returns dst.
+func mergeUsedFiles(dst, src cache.UsedFiles) cache.UsedFiles {
+	if dst == (cache.UsedFiles{}) {
+		dst = cache.NewUsedFiles()
+	}
+	src.Range(func(path string, mask cache.PhaseMask) bool {
+		dst.Add(path, mask)
+		return true
+	})
+	return dst
+}
+
+// endCycleWith returns a control handler that ends the current watch
+// cycle and then does action.
+func (d *daemon) endCycleWith(action string) controlHandler {
+	return func(args []string) (string, error) {
+		if len(args) != 0 {
+			return "", fmt.Errorf("usage: %s", action)
+		}
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.endCycle == nil {
+			return "", errors.New("caches aren't being watched yet")
+		}
+		// don't let a later command override stopping, except stopping
+		// without pruning
+		switch {
+		case d.action == daemonStop:
+		case d.action == daemonPruneStop && action != daemonStop:
+		default:
+			d.action = action
+		}
+		d.endCycle()
+		return "", nil
+	}
+}
+
+// dryRun handles the dry-run control command, waiting until the
+// report of what pruning would delete is ready.
+func (d *daemon) dryRun(args []string) (string, error) {
+	if len(args) != 0 {
+		return "", fmt.Errorf("usage: %s", daemonDryRun)
+	}
+
+	resultCh := make(chan dryRunResult, 1)
+	d.mu.Lock()
+	if d.endCycle == nil {
+		d.mu.Unlock()
+		return "", errors.New("caches aren't being watched yet")
+	}
+	if d.action == "" {
+		d.action = daemonDryRun
+	}
+	d.dryRuns = append(d.dryRuns, resultCh)
+	d.endCycle()
+	d.mu.Unlock()
+
+	res := <-resultCh
+	if res.err != nil {
+		return "", res.err
+	}
+	return string(res.report) + "\n", nil
+}
+
+// prune prunes the caches keeping modFiles and buildFiles, or only
+// reports what would be pruned if dryRun is true. The report of what
+// was pruned is returned, or nil if nothing was.
+func (d *daemon) prune(ctx context.Context, modFiles, buildFiles cache.UsedFiles, dryRun bool) (*pruneReport, error) {
+	cfg := *d.cfg
+	cfg.dryRun = cfg.dryRun || dryRun
+	if modFiles.Len() == 0 && buildFiles.Len() == 0 {
+		log.Infof("no cached files were used, not pruning caches")
+		if dryRun {
+			return &pruneReport{DryRun: true}, nil
+		}
+		return nil, nil
+	}
+
+	if cfg.keepListFile != "" {
+		if err := applyKeepList(cfg.keepListFile, cfg.moduleCache, modFiles); err != nil {
+			return nil, err
+		}
+	}
+	if err := keepDeps(ctx, cfg.keepDepsDirs, cfg.moduleCache, modFiles); err != nil {
+		return nil, err
+	}
+	pinned := &pins{path: cfg.pinsFile}
+	dirLevel := cfg.buildGranularity == dirGranularity
+	if err := pinned.apply(cfg.moduleCache, cfg.buildCache, modFiles, buildFiles, dirLevel); err != nil {
+		return nil, err
+	}
+
+	lowerPriority(&cfg)
+	if err := emptyTrash(&cfg); err != nil {
+		return nil, err
+	}
+	checkGoCommands(ctx, &cfg, d.clk)
+	report := &pruneReport{DryRun: cfg.dryRun}
+	modRes, buildRes := prune.Caches(ctx, cfg.moduleCache, cfg.buildCache, modFiles, buildFiles, pruneOptions(&cfg, d.clk, d.clk.Now()))
+	report.addCaches(cfg.moduleCache, cfg.buildCache, modRes, buildRes)
+	// dry runs are only returned to who asked for them
+	if dryRun {
+		report.suggest(&cfg)
+	} else if err := report.publish(&cfg); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
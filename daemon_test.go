@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDaemonEndCycle(t *testing.T) {
+	d := new(daemon)
+	if _, err := d.endCycleWith(daemonPrune)(nil); err == nil {
+		t.Fatal("expected ending a cycle before watching started to fail")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.endCycle = cancel
+	if _, err := d.endCycleWith(daemonPrune)([]string{"now"}); err == nil {
+		t.Fatal("expected passing arguments to fail")
+	}
+	for _, action := range []string{daemonReset, daemonStop, daemonPrune} {
+		if _, err := d.endCycleWith(action)(nil); err != nil {
+			t.Fatalf("sending %s: %v", action, err)
+		}
+	}
+	if ctx.Err() == nil {
+		t.Error("expected cycle to be ended")
+	}
+	// stopping can't be overridden by a later command
+	if d.action != daemonStop {
+		t.Errorf("expected action %q, got %q", daemonStop, d.action)
+	}
+
+	// stopping after pruning can only be overridden by stopping
+	// without pruning
+	d.action = daemonPruneStop
+	for _, action := range []string{daemonReset, daemonPrune, daemonStop} {
+		if _, err := d.endCycleWith(action)(nil); err != nil {
+			t.Fatalf("sending %s: %v", action, err)
+		}
+		want := daemonPruneStop
+		if action == daemonStop {
+			want = daemonStop
+		}
+		if d.action != want {
+			t.Errorf("after %s expected action %q, got %q", action, want, d.action)
+		}
+	}
+}
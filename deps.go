@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+// listedModule is a module printed by 'go list -m -json'.
+type listedModule struct {
+	Path    string
+	Version string
+	Main    bool
+	Replace *listedModule
+}
+
+// keepDeps marks the module versions that the modules in dirs depend on
+// as used in the module cache modCache. A dir in a Go workspace, or a
+// go.work file, keeps what every module of the workspace depends on, as
+// other modules of the workspace may be built later.
+func keepDeps(ctx context.Context, dirs []string, modCache string, modFiles cache.UsedFiles) error {
+	for _, dir := range dirs {
+		mods, workFile, err := listDeps(ctx, dir, modCache)
+		if err != nil {
+			return fmt.Errorf("listing dependencies of %q: %w", dir, err)
+		}
+		keepModules(modCache, modFiles, mods)
+		if workFile != "" {
+			log.Infof("keeping %d modules the modules of workspace %q depend on", len(mods), workFile)
+		} else {
+			log.Infof("keeping %d modules %q depends on", len(mods), dir)
+		}
+	}
+
+	return nil
+}
+
+// listDeps returns the module versions in the build list of the module
+// in dir using the module cache modCache. If dir is in a workspace or
+// is a go.work file, the build list of the workspace and the path of
+// its go.work file are returned.
+func listDeps(ctx context.Context, dir, modCache string) ([]module.Version, string, error) {
+	env := append(os.Environ(), "GOMODCACHE="+modCache)
+	if filepath.Base(dir) == "go.work" {
+		workFile, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, "", fmt.Errorf("getting absolute path of %q: %w", dir, err)
+		}
+		dir = filepath.Dir(workFile)
+		env = append(env, "GOWORK="+workFile)
+	}
+
+	workFile, err := runGo(ctx, dir, env, "env", "GOWORK")
+	if err != nil {
+		return nil, "", err
+	}
+	workFile = strings.TrimSpace(workFile)
+	// GOWORK is "off" when workspaces were disabled
+	if workFile == "off" {
+		workFile = ""
+	}
+	list, err := runGo(ctx, dir, env, "list", "-mod=readonly", "-m", "-json", "all")
+	if err != nil {
+		return nil, "", err
+	}
+	mods, err := parseModuleList(strings.NewReader(list))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return mods, workFile, nil
+}
+
+// runGo runs the go command with args in dir and returns its output.
+func runGo(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running 'go %s': %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// parseModuleList parses the output of 'go list -m -json' and returns
+// the module versions that are in the module cache. Main modules and
+// modules replaced by directories are skipped.
+func parseModuleList(r io.Reader) ([]module.Version, error) {
+	var mods []module.Version
+	dec := json.NewDecoder(r)
+	for {
+		var m listedModule
+		if err := dec.Decode(&m); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("parsing module list: %w", err)
+		}
+		if m.Main {
+			continue
+		}
+		if m.Replace != nil {
+			m = *m.Replace
+		}
+		if m.Version == "" {
+			continue
+		}
+		mods = append(mods, module.Version{Path: m.Path, Version: m.Version})
+	}
+
+	return mods, nil
+}
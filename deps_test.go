@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+func TestParseModuleList(t *testing.T) {
+	const list = `{
+	"Path": "example.com/main",
+	"Main": true,
+	"Dir": "/src/main"
+}
+{
+	"Path": "example.com/a",
+	"Version": "v1.0.0"
+}
+{
+	"Path": "example.com/b",
+	"Version": "v1.2.0",
+	"Replace": {
+		"Path": "example.com/fork/b",
+		"Version": "v1.2.1"
+	}
+}
+{
+	"Path": "example.com/c",
+	"Version": "v0.1.0",
+	"Replace": {
+		"Path": "../c",
+		"Dir": "/src/c"
+	}
+}
+`
+	mods, err := parseModuleList(strings.NewReader(list))
+	if err != nil {
+		t.Fatalf("parsing module list: %v", err)
+	}
+	want := []module.Version{
+		{Path: "example.com/a", Version: "v1.0.0"},
+		{Path: "example.com/fork/b", Version: "v1.2.1"},
+	}
+	if !slices.Equal(mods, want) {
+		t.Errorf("parseModuleList() = %v, want %v", mods, want)
+	}
+}
+
+func TestListDepsWorkspace(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	t.Setenv("GOPROXY", "off")
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GOWORK", "")
+	t.Setenv("GONOSUMDB", "*")
+	t.Setenv("GOTOOLCHAIN", "local")
+
+	// only a module of the workspace that isn't listed depends on
+	// example.com/dep
+	root := t.TempDir()
+	modCache := filepath.Join(root, "mod")
+	files := map[string]string{
+		"go.work":  "go 1.21\n\nuse (\n\t./a\n\t./b\n)\n",
+		"a/go.mod": "module example.com/a\n\ngo 1.21\n",
+		"b/go.mod": "module example.com/b\n\ngo 1.21\n\nrequire example.com/dep v1.0.0\n",
+		"mod/cache/download/example.com/dep/@v/v1.0.0.mod":  "module example.com/dep\n",
+		"mod/cache/download/example.com/dep/@v/v1.0.0.info": `{"Version":"v1.0.0"}`,
+	}
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("creating dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	want := []module.Version{{Path: "example.com/dep", Version: "v1.0.0"}}
+
+	for _, dir := range []string{filepath.Join(root, "a"), filepath.Join(root, "go.work")} {
+		mods, workFile, err := listDeps(context.Background(), dir, modCache)
+		if err != nil {
+			t.Fatalf("listing dependencies of %q: %v", dir, err)
+		}
+		if !slices.Equal(mods, want) {
+			t.Errorf("listDeps(%q) = %v, want %v", dir, mods, want)
+		}
+		if workFile != filepath.Join(root, "go.work") {
+			t.Errorf("expected workspace of %q to be found, got %q", dir, workFile)
+		}
+	}
+}
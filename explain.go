@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/clock"
+	"github.com/capnspacehook/go-cache-prune/prune"
+)
+
+// explainEntry prints why the cache entry containing cfg.explainPath
+// would be kept or deleted. Caches aren't watched, so only entries kept
+// by manifests, the keep list, -keep-from-deps or pins count as used.
+func explainEntry(ctx context.Context, cfg *config) error {
+	path, err := filepath.Abs(cfg.explainPath)
+	if err != nil {
+		return fmt.Errorf("getting absolute path of -explain path: %w", err)
+	}
+	modCache, err := absDir(cfg.moduleCache)
+	if err != nil {
+		return err
+	}
+	buildCache, err := absDir(cfg.buildCache)
+	if err != nil {
+		return err
+	}
+
+	var isModCache bool
+	switch {
+	case cache.IsWithinDir(modCache, path):
+		isModCache = true
+	case cache.IsWithinDir(buildCache, path):
+	default:
+		return fmt.Errorf("%q isn't in the module or build cache", cfg.explainPath)
+	}
+
+	dirLevel := cfg.buildGranularity == dirGranularity
+	manifestFiles := cfg.mergeManifests
+	if cfg.fromManifest != "" {
+		manifestFiles = append([]string{cfg.fromManifest}, manifestFiles...)
+	}
+	manifests, err := readUsedManifests(manifestFiles, dirLevel)
+	if err != nil {
+		return err
+	}
+	modFiles, buildFiles := cache.NewUsedFiles(), cache.NewUsedFiles()
+	mergeUsedManifests(manifests, modCache, buildCache, modFiles, buildFiles, dirLevel)
+	if isModCache {
+		if cfg.keepListFile != "" {
+			if err := applyKeepList(cfg.keepListFile, modCache, modFiles); err != nil {
+				return err
+			}
+		}
+		if err := keepDeps(ctx, cfg.keepDepsDirs, modCache, modFiles); err != nil {
+			return err
+		}
+	}
+
+	pinned := &pins{path: cfg.pinsFile}
+	if err := pinned.apply(modCache, buildCache, modFiles, buildFiles, dirLevel); err != nil {
+		return err
+	}
+
+	clk := clock.System
+	opts := pruneOptions(cfg, clk, clk.Now())
+	if cfg.maxAge > 0 {
+		opts.KeepAccessedAfter = clk.Now().Add(-cfg.maxAge)
+	}
+	dir, usedFiles := modCache, modFiles
+	if !isModCache {
+		dir, usedFiles = buildCache, buildFiles
+		opts.DirLevel = opts.BuildDirLevel
+	}
+	e, err := prune.Explain(ctx, dir, isModCache, path, usedFiles, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("entry:          %s\n", e.Entry)
+	fmt.Printf("added:          %s\n", e.Added.Format(time.RFC3339))
+	fmt.Printf("last accessed:  %s\n", e.LastAccessed.Format(time.RFC3339))
+	fmt.Printf("last used:      %s\n", e.LastUsed.Format(time.RFC3339))
+	if e.Anomaly != "" {
+		fmt.Printf("quarantine:     %s\n", e.Anomaly)
+	}
+	for _, v := range e.Verdicts {
+		verdict := "no opinion"
+		if v.Keep {
+			verdict = "keep"
+		}
+		fmt.Printf("%-15s %s\n", v.Rule+":", verdict)
+	}
+	switch {
+	case e.Anomaly != "":
+		fmt.Println("verdict:        quarantine")
+	case e.Deleted:
+		fmt.Println("verdict:        delete")
+	default:
+		fmt.Println("verdict:        keep")
+	}
+
+	return nil
+}
+
+// absDir returns the absolute path of dir, or an empty string if dir is
+// empty.
+func absDir(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("getting absolute path of %q: %w", dir, err)
+	}
+	return abs, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/prune"
+)
+
+// firstRunMarkerName is the name of the file in the root of a cache
+// that records it was analyzed by a -first-run-safe run.
+const firstRunMarkerName = prune.StateFilePrefix + "first-run"
+
+// firstRunCaches returns the caches that weren't analyzed by a
+// -first-run-safe run yet.
+func firstRunCaches(caches []string) []string {
+	var firstRun []string
+	for _, dir := range caches {
+		_, err := os.Stat(filepath.Join(dir, firstRunMarkerName))
+		if errors.Is(err, fs.ErrNotExist) {
+			firstRun = append(firstRun, dir)
+		} else if err != nil {
+			log.Warningf("checking if %q was pruned before: %v", dir, err)
+		}
+	}
+	return firstRun
+}
+
+// markFirstRunDone records that caches were analyzed, so the next
+// -first-run-safe run prunes them.
+func markFirstRunDone(caches []string, now time.Time) {
+	for _, dir := range caches {
+		marker := filepath.Join(dir, firstRunMarkerName)
+		if err := os.WriteFile(marker, []byte(now.Format(time.RFC3339)+"\n"), 0o644); err != nil {
+			log.Warningf("recording first run of %q: %v", dir, err)
+			continue
+		}
+		log.Infof("%q will be pruned by the next run", dir)
+	}
+}
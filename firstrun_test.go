@@ -0,0 +1,24 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFirstRunCaches(t *testing.T) {
+	modCache, buildCache := t.TempDir(), t.TempDir()
+	caches := []string{modCache, buildCache}
+
+	if got := firstRunCaches(caches); !slices.Equal(got, caches) {
+		t.Fatalf("expected both caches to be new, got %q", got)
+	}
+	markFirstRunDone([]string{modCache}, time.Now())
+	if got := firstRunCaches(caches); !slices.Equal(got, []string{buildCache}) {
+		t.Fatalf("expected only the build cache to be new, got %q", got)
+	}
+	markFirstRunDone([]string{buildCache}, time.Now())
+	if got := firstRunCaches(caches); len(got) != 0 {
+		t.Fatalf("expected no caches to be new, got %q", got)
+	}
+}
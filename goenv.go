@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// goEnvDefault returns the value of the Go environment variable name
+// without running the go command by resolving it the same way the go
+// command would.
+func goEnvDefault(name string) (string, error) {
+	if v := os.Getenv(name); v != "" {
+		return v, nil
+	}
+	v, err := goEnvFileValue(name)
+	if err != nil {
+		return "", err
+	}
+	if v != "" {
+		return v, nil
+	}
+
+	switch name {
+	case "GOMODCACHE":
+		gopath, err := goEnvDefault("GOPATH")
+		if err != nil {
+			return "", err
+		}
+		// only the first GOPATH entry is used
+		gopath, _, _ = strings.Cut(gopath, string(filepath.ListSeparator))
+		return filepath.Join(gopath, "pkg", "mod"), nil
+	case "GOPATH":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("getting home dir: %w", err)
+		}
+		return filepath.Join(home, "go"), nil
+	case "GOCACHE":
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("getting user cache dir: %w", err)
+		}
+		return filepath.Join(cacheDir, "go-build"), nil
+	case "GOPROXY":
+		return "https://proxy.golang.org,direct", nil
+	case "GONOPROXY":
+		return goEnvDefault("GOPRIVATE")
+	case "GOPRIVATE", "GOFLAGS":
+		return "", nil
+	case "GOAUTH":
+		return "netrc", nil
+	case "GOTELEMETRYDIR":
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("getting user config dir: %w", err)
+		}
+		return filepath.Join(configDir, "go", "telemetry"), nil
+	}
+
+	return "", fmt.Errorf("unknown Go environment variable %q", name)
+}
+
+// goEnvFileValue returns the value of name set in the Go environment
+// configuration file written to by 'go env -w', or an empty string if
+// it isn't set.
+func goEnvFileValue(name string) (string, error) {
+	envFile := os.Getenv("GOENV")
+	if envFile == "off" {
+		return "", nil
+	}
+	if envFile == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", nil
+		}
+		envFile = filepath.Join(configDir, "go", "env")
+	}
+
+	contents, err := os.ReadFile(envFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("reading Go environment file: %w", err)
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(contents))
+	for s.Scan() {
+		key, val, ok := strings.Cut(s.Text(), "=")
+		if ok && key == name {
+			return val, nil
+		}
+	}
+
+	return "", nil
+}
+
+// modCacheRW returns true if goFlags, the value of GOFLAGS, makes the
+// go command leave the module cache writable with -modcacherw.
+func modCacheRW(goFlags string) bool {
+	rw := false
+	// later flags override earlier ones like on the command line
+	for _, f := range strings.Fields(goFlags) {
+		name, val, hasVal := strings.Cut(strings.TrimLeft(f, "-"), "=")
+		if name != "modcacherw" {
+			continue
+		}
+		if !hasVal {
+			rw = true
+			continue
+		}
+		if b, err := strconv.ParseBool(val); err == nil {
+			rw = b
+		}
+	}
+	return rw
+}
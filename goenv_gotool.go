@@ -0,0 +1,28 @@
+//go:build !nogotool
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+func getGoEnv(ctx context.Context, name string) (string, error) {
+	if _, err := exec.LookPath("go"); err != nil {
+		log.Debugf("go command not found, resolving %s without it", name)
+		return goEnvDefault(name)
+	}
+
+	cmd := exec.CommandContext(ctx, "go", "env", name)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("running %s: %w", cmd, err)
+	}
+	if len(out) < 1 {
+		return "", fmt.Errorf("'go env' output is too short: %v", out)
+	}
+
+	// trim ending newline
+	return string(out[:len(out)-1]), nil
+}
@@ -0,0 +1,11 @@
+//go:build nogotool
+
+package main
+
+import "context"
+
+// getGoEnv never runs the go command when built with the nogotool tag
+// so go-cache-prune can run in images that only contain the Go caches.
+func getGoEnv(_ context.Context, name string) (string, error) {
+	return goEnvDefault(name)
+}
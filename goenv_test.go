@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGoEnvDefault(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("GOENV", "")
+	t.Setenv("GOPATH", "")
+	t.Setenv("GOMODCACHE", "")
+	t.Setenv("GOCACHE", "")
+
+	checkEnv := func(t *testing.T, name, want string) {
+		t.Helper()
+
+		got, err := goEnvDefault(name)
+		if err != nil {
+			t.Fatalf("getting %s: %v", name, err)
+		}
+		if got != want {
+			t.Fatalf("expected %s to be %q, got %q", name, want, got)
+		}
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		checkEnv(t, "GOMODCACHE", filepath.Join(home, "go", "pkg", "mod"))
+		checkEnv(t, "GOCACHE", filepath.Join(home, ".cache", "go-build"))
+		checkEnv(t, "GOTELEMETRYDIR", filepath.Join(home, ".config", "go", "telemetry"))
+	})
+
+	t.Run("GOPATH list", func(t *testing.T) {
+		t.Setenv("GOPATH", "/first"+string(filepath.ListSeparator)+"/second")
+		checkEnv(t, "GOMODCACHE", filepath.Join("/first", "pkg", "mod"))
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv("GOMODCACHE", "/mod")
+		t.Setenv("GOCACHE", "/build")
+		checkEnv(t, "GOMODCACHE", "/mod")
+		checkEnv(t, "GOCACHE", "/build")
+	})
+
+	t.Run("env file", func(t *testing.T) {
+		envFile := filepath.Join(t.TempDir(), "env")
+		if err := os.WriteFile(envFile, []byte("GOCACHE=/file/build\nGOPATH=/file/gopath\n"), 0o644); err != nil {
+			t.Fatalf("writing env file: %v", err)
+		}
+		t.Setenv("GOENV", envFile)
+
+		checkEnv(t, "GOCACHE", "/file/build")
+		checkEnv(t, "GOMODCACHE", filepath.Join("/file/gopath", "pkg", "mod"))
+	})
+}
+
+func TestModCacheRW(t *testing.T) {
+	tests := []struct {
+		goFlags string
+		want    bool
+	}{
+		{goFlags: "", want: false},
+		{goFlags: "-mod=mod", want: false},
+		{goFlags: "-modcacherw", want: true},
+		{goFlags: "-mod=mod --modcacherw", want: true},
+		{goFlags: "-modcacherw=true", want: true},
+		{goFlags: "-modcacherw -modcacherw=false", want: false},
+	}
+	for _, tt := range tests {
+		if got := modCacheRW(tt.goFlags); got != tt.want {
+			t.Errorf("modCacheRW(%q): expected %t, got %t", tt.goFlags, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/clock"
+)
+
+// goProcess is a running go command.
+type goProcess struct {
+	pid     int
+	cmdline string
+}
+
+// waitForGoCommands waits for at most timeout until none of the go
+// commands returned by list are running, so pruning doesn't delete files
+// a build is about to read. Go commands still running once timeout
+// elapses are logged as warnings.
+func waitForGoCommands(ctx context.Context, clk clock.Clock, timeout time.Duration, list func() ([]goProcess, error)) {
+	procs, err := list()
+	if err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			log.Debugf("running go commands can't be found on this platform")
+		} else {
+			log.Warningf("finding running go commands: %v", err)
+		}
+		return
+	}
+	if len(procs) == 0 {
+		return
+	}
+
+	if timeout > 0 {
+		log.Infof("waiting up to %s for %d running go commands to exit before pruning", timeout, len(procs))
+		timer := clk.NewTimer(timeout)
+		defer timer.Stop()
+		ticker := clk.NewTicker(time.Second)
+		defer ticker.Stop()
+
+	wait:
+		for len(procs) != 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				break wait
+			case <-ticker.C:
+				procs, err = list()
+				if err != nil {
+					log.Warningf("finding running go commands: %v", err)
+					return
+				}
+			}
+		}
+		if len(procs) == 0 {
+			log.Infof("running go commands exited")
+			return
+		}
+	}
+
+	for _, p := range procs {
+		log.Warningf("go command %q with PID %d is running, pruning may delete files it is about to use", p.cmdline, p.pid)
+	}
+}
+
+// checkGoCommands waits for at most -wait-for-go for running go commands
+// to exit before caches are pruned, and warns about ones that are still
+// running. Nothing is checked on dry runs.
+func checkGoCommands(ctx context.Context, cfg *config, clk clock.Clock) {
+	if cfg.dryRun {
+		return
+	}
+	waitForGoCommands(ctx, clk, cfg.waitForGo, runningGoCommands)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// runningGoCommands returns the running go commands, except ones that
+// go-cache-prune is a descendant of like 'go run'.
+func runningGoCommands() ([]goProcess, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("listing processes: %w", err)
+	}
+
+	ancestors := ancestorPIDs()
+	var procs []goProcess
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || ancestors[pid] {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "comm"))
+		if err != nil || strings.TrimSuffix(string(comm), "\n") != "go" {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		// arguments are NUL terminated
+		cmdline = bytes.ReplaceAll(bytes.TrimSuffix(cmdline, []byte{0}), []byte{0}, []byte{' '})
+		procs = append(procs, goProcess{pid: pid, cmdline: string(cmdline)})
+	}
+
+	return procs, nil
+}
+
+// ancestorPIDs returns the PIDs of this process and its ancestors.
+func ancestorPIDs() map[int]bool {
+	ancestors := make(map[int]bool)
+	for pid := os.Getpid(); pid > 1 && !ancestors[pid]; {
+		ancestors[pid] = true
+		stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+		if err != nil {
+			break
+		}
+		// the process name can contain spaces and parentheses, the
+		// state and parent PID follow the last parenthesis
+		i := bytes.LastIndexByte(stat, ')')
+		if i == -1 {
+			break
+		}
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) < 2 {
+			break
+		}
+		pid, err = strconv.Atoi(fields[1])
+		if err != nil {
+			break
+		}
+	}
+	return ancestors
+}
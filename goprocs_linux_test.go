@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestAncestorPIDs(t *testing.T) {
+	ancestors := ancestorPIDs()
+	if !ancestors[os.Getpid()] || !ancestors[os.Getppid()] {
+		t.Errorf("expected this process and its parent to be ancestors, got %v", ancestors)
+	}
+}
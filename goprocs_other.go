@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func runningGoCommands() ([]goProcess, error) {
+	return nil, errors.ErrUnsupported
+}
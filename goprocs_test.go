@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/clock"
+)
+
+func TestWaitForGoCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		// exitAfter is how many times go commands are listed before
+		// they exit, or 0 if they never do
+		exitAfter  int32
+		wantWaited time.Duration
+	}{
+		{name: "exit", exitAfter: 3, wantWaited: 2 * time.Second},
+		{name: "timeout", wantWaited: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			c := clock.NewFake(start)
+			var listed atomic.Int32
+			list := func() ([]goProcess, error) {
+				if n := listed.Add(1); tt.exitAfter != 0 && n >= tt.exitAfter {
+					return nil, nil
+				}
+				return []goProcess{{pid: 42, cmdline: "go build ./..."}}, nil
+			}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				waitForGoCommands(context.Background(), c, 5*time.Second, list)
+			}()
+			waitForWaiters(t, c)
+
+			for {
+				select {
+				case <-done:
+					if waited := c.Now().Sub(start); waited != tt.wantWaited {
+						t.Fatalf("returned after %s, want %s", waited, tt.wantWaited)
+					}
+					return
+				case <-time.After(10 * time.Millisecond):
+					c.Advance(time.Second)
+				}
+			}
+		})
+	}
+}
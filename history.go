@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+// usageHistory records how many runs each module was used in.
+type usageHistory struct {
+	Runs    uint            `json:"runs"`
+	Modules map[string]uint `json:"modules"`
+}
+
+// readUsageHistory reads a usage history file, an empty history is
+// returned if it doesn't exist yet.
+func readUsageHistory(path string) (*usageHistory, error) {
+	h := &usageHistory{Modules: make(map[string]uint)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return h, nil
+		}
+		return nil, fmt.Errorf("reading usage history: %w", err)
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("parsing usage history: %w", err)
+	}
+	if h.Modules == nil {
+		h.Modules = make(map[string]uint)
+	}
+
+	return h, nil
+}
+
+// writeUsageHistory writes h to path.
+func writeUsageHistory(path string, h *usageHistory) error {
+	data, err := json.MarshalIndent(h, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding usage history: %w", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("writing usage history: %w", err)
+	}
+
+	return nil
+}
+
+// record adds a run that used mods to the history.
+func (h *usageHistory) record(mods []module.Version) {
+	h.Runs++
+	for _, mod := range mods {
+		h.Modules[mod.String()]++
+	}
+}
+
+// keepList returns the modules used in at least threshold of the
+// recorded runs.
+func (h *usageHistory) keepList(threshold float64) []module.Version {
+	if h.Runs == 0 {
+		return nil
+	}
+
+	var mods []module.Version
+	for modVer, runs := range h.Modules {
+		if float64(runs)/float64(h.Runs) < threshold {
+			continue
+		}
+		modPath, ver, ok := strings.Cut(modVer, "@")
+		if !ok {
+			continue
+		}
+		mods = append(mods, module.Version{Path: modPath, Version: ver})
+	}
+	module.Sort(mods)
+
+	return mods
+}
+
+// readKeepList reads module@version lines from path, as written by
+// -used-modules or -export-keep-list. Empty lines and lines starting
+// with '#' are ignored.
+func readKeepList(path string) ([]module.Version, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening keep list: %w", err)
+	}
+	defer f.Close()
+
+	var mods []module.Version
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		modPath, ver, ok := strings.Cut(text, "@")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected module@version", path, line)
+		}
+		mod := module.Version{Path: modPath, Version: ver}
+		if err := module.Check(mod.Path, mod.Version); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		mods = append(mods, mod)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("reading keep list: %w", err)
+	}
+
+	return mods, nil
+}
+
+// applyKeepList marks the modules of the keep list at path as used in
+// the module cache modCache.
+func applyKeepList(path, modCache string, modFiles cache.UsedFiles) error {
+	mods, err := readKeepList(path)
+	if err != nil {
+		return err
+	}
+	keepModules(modCache, modFiles, mods)
+	log.Infof("keeping %d modules from keep list %q", len(mods), path)
+
+	return nil
+}
+
+// keepModules marks the dependency dirs of mods in the module cache
+// modCache as used.
+func keepModules(modCache string, modFiles cache.UsedFiles, mods []module.Version) {
+	for _, mod := range mods {
+		escPath, err := module.EscapePath(mod.Path)
+		if err != nil {
+			continue
+		}
+		escVer, err := module.EscapeVersion(mod.Version)
+		if err != nil {
+			continue
+		}
+		depDir := filepath.Join(modCache, filepath.FromSlash(escPath+"@"+escVer))
+		modFiles.Add(depDir, cache.DefaultPhaseMask)
+	}
+}
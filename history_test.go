@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/mod/module"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+func TestUsageHistory(t *testing.T) {
+	var (
+		a = module.Version{Path: "example.com/a", Version: "v1.0.0"}
+		b = module.Version{Path: "example.com/b", Version: "v1.0.0"}
+		c = module.Version{Path: "example.com/c", Version: "v1.0.0"}
+	)
+
+	path := filepath.Join(t.TempDir(), "history.json")
+	runs := [][]module.Version{
+		{a, b, c},
+		{a, b},
+		{a, b},
+		{a, b},
+		{a},
+	}
+	for _, mods := range runs {
+		h, err := readUsageHistory(path)
+		if err != nil {
+			t.Fatalf("reading usage history: %v", err)
+		}
+		h.record(mods)
+		if err := writeUsageHistory(path, h); err != nil {
+			t.Fatalf("writing usage history: %v", err)
+		}
+	}
+
+	h, err := readUsageHistory(path)
+	if err != nil {
+		t.Fatalf("reading usage history: %v", err)
+	}
+	if h.Runs != uint(len(runs)) {
+		t.Errorf("expected %d runs, got %d", len(runs), h.Runs)
+	}
+	if got, want := h.keepList(0.8), []module.Version{a, b}; !slices.Equal(got, want) {
+		t.Errorf("expected keep list %v, got %v", want, got)
+	}
+	if got, want := h.keepList(0.1), []module.Version{a, b, c}; !slices.Equal(got, want) {
+		t.Errorf("expected keep list %v, got %v", want, got)
+	}
+}
+
+func TestKeepList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keep.txt")
+	list := "# kept modules\nexample.com/a v1.0.0\n"
+	if err := os.WriteFile(path, []byte(list), 0o644); err != nil {
+		t.Fatalf("writing keep list: %v", err)
+	}
+	if _, err := readKeepList(path); err == nil {
+		t.Fatalf("expected error reading malformed keep list")
+	}
+
+	want := []module.Version{
+		{Path: "example.com/a", Version: "v1.0.0"},
+		{Path: "github.com/Foo/bar", Version: "v0.1.0"},
+	}
+	if err := writeUsedModules(path, want); err != nil {
+		t.Fatalf("writing keep list: %v", err)
+	}
+	mods, err := readKeepList(path)
+	if err != nil {
+		t.Fatalf("reading keep list: %v", err)
+	}
+	if !slices.Equal(mods, want) {
+		t.Fatalf("expected modules %v, got %v", want, mods)
+	}
+
+	modCache := t.TempDir()
+	modFiles := cache.NewUsedFiles()
+	keepModules(modCache, modFiles, mods)
+	for _, depDir := range []string{
+		filepath.Join(modCache, "example.com", "a@v1.0.0"),
+		filepath.Join(modCache, "github.com", "!foo", "bar@v0.1.0"),
+	} {
+		if !modFiles.Has(depDir) {
+			t.Errorf("expected %q to be kept", depDir)
+		}
+		if mod, ok := dirModule(modCache, depDir); !ok || !slices.Contains(want, mod) {
+			t.Errorf("dirModule(%q) = %v, %v, want a kept module", depDir, mod, ok)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/clock"
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+	"github.com/capnspacehook/go-cache-prune/prune"
+)
+
+// incrementalPruneInterval is how often the sizes of caches are checked
+// while watching when -watch-max-size is set.
+const incrementalPruneInterval = 5 * time.Minute
+
+// incrementalPruner prunes caches that grew larger than -watch-max-size
+// while they are still being watched, so long watch sessions don't
+// need enough disk space for every entry added until watching stops.
+// Only entries that weren't used while watching and weren't accessed in
+// the last -watch-prune-age are deleted.
+type incrementalPruner struct {
+	cfg    *config
+	clk    clock.Clock
+	pinned *pins
+	// report has what was pruned added to it
+	report *pruneReport
+	// prepare adds entries that must be kept even though they weren't
+	// recorded as used while watching and checks that the caches
+	// should be pruned now if non-nil
+	prepare func(modCache, buildCache string, modFiles, buildFiles cache.UsedFiles) error
+
+	mu         sync.Mutex
+	modFiles   cache.UsedFiles
+	buildFiles cache.UsedFiles
+
+	// pruneMu is held while pruning so a cache that stopped being
+	// watched isn't pruned at the same time as it's pruned for good
+	pruneMu    sync.Mutex
+	modCache   string
+	buildCache string
+}
+
+func newIncrementalPruner(cfg *config, clk clock.Clock, pinned *pins, report *pruneReport, modCache, buildCache string) *incrementalPruner {
+	return &incrementalPruner{
+		cfg:        cfg,
+		clk:        clk,
+		pinned:     pinned,
+		report:     report,
+		modFiles:   cache.NewUsedFiles(),
+		buildFiles: cache.NewUsedFiles(),
+		modCache:   modCache,
+		buildCache: buildCache,
+	}
+}
+
+// used records path as used, it is passed to watch.Options.OnUsed.
+// Phases aren't recorded, so entries only used in phases that are
+// ignored are kept until watching stops.
+func (p *incrementalPruner) used(isModCache bool, path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if isModCache {
+		p.modFiles.Add(path, cache.DefaultPhaseMask)
+	} else {
+		p.buildFiles.Add(path, cache.DefaultPhaseMask)
+	}
+}
+
+// cacheDone stops the module cache if isModCache is true, or the build
+// cache otherwise, from being pruned incrementally. It waits for it to
+// finish being pruned if it is.
+func (p *incrementalPruner) cacheDone(isModCache bool) {
+	p.pruneMu.Lock()
+	defer p.pruneMu.Unlock()
+
+	if isModCache {
+		p.modCache = ""
+	} else {
+		p.buildCache = ""
+	}
+}
+
+// run prunes caches larger than -watch-max-size every
+// incrementalPruneInterval until ctx is canceled.
+func (p *incrementalPruner) run(ctx context.Context) {
+	ticker := p.clk.NewTicker(incrementalPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.pruneOversized(ctx)
+		}
+	}
+}
+
+// pruneOversized prunes the caches that are larger than -watch-max-size
+// down to it.
+func (p *incrementalPruner) pruneOversized(ctx context.Context) {
+	p.pruneMu.Lock()
+	defer p.pruneMu.Unlock()
+
+	var modCache, buildCache string
+	if p.modCache != "" && p.oversized(p.modCache) {
+		modCache = p.modCache
+	}
+	if p.buildCache != "" && p.oversized(p.buildCache) {
+		buildCache = p.buildCache
+	}
+	if modCache == "" && buildCache == "" {
+		return
+	}
+
+	p.mu.Lock()
+	modFiles, buildFiles := p.modFiles.Clone(), p.buildFiles.Clone()
+	p.mu.Unlock()
+	if p.prepare != nil {
+		if err := p.prepare(modCache, buildCache, modFiles, buildFiles); err != nil {
+			if errors.Is(err, errNothingUsed) || errors.Is(err, errCommandRunning) {
+				log.Infof("not pruning caches while watching: %v", err)
+			} else {
+				log.Warningf("not pruning caches while watching: %v", err)
+			}
+			return
+		}
+	}
+	dirLevel := p.cfg.buildGranularity == dirGranularity
+	if err := p.pinned.apply(modCache, buildCache, modFiles, buildFiles, dirLevel); err != nil {
+		log.Warningf("%v", err)
+		return
+	}
+
+	now := p.clk.Now()
+	opts := pruneOptions(p.cfg, p.clk, now)
+	opts.MaxSize = p.cfg.watchMaxBytes
+	opts.KeepAccessedAfter = now.Add(-p.cfg.watchPruneAge)
+	// entries must really be deleted to free space, the trash isn't
+	// emptied until the next run
+	opts.TrashDir = ""
+	opts.SoftDelete = 0
+	log.Infof("pruning caches larger than %s while watching, entries not used in the last %s are deleted",
+		bytesize.Format(p.cfg.watchMaxBytes), p.cfg.watchPruneAge)
+	lowerPriority(p.cfg)
+	modRes, buildRes := prune.Caches(ctx, modCache, buildCache, modFiles, buildFiles, opts)
+	p.report.addCaches(modCache, buildCache, modRes, buildRes)
+}
+
+// oversized returns true if dir is larger than -watch-max-size.
+func (p *incrementalPruner) oversized(dir string) bool {
+	size := dirSize(dir)
+	log.Debugf("cache dir %q is %s", dir, bytesize.Format(size))
+	return size > p.cfg.watchMaxBytes
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/clock"
+)
+
+func TestIncrementalPruner(t *testing.T) {
+	dir := t.TempDir()
+	shard := filepath.Join(dir, "00")
+	if err := os.Mkdir(shard, 0o755); err != nil {
+		t.Fatalf("creating shard: %v", err)
+	}
+
+	now := time.Now()
+	entries := []struct {
+		name  string
+		mtime time.Time
+		used  bool
+	}{
+		{name: "used", mtime: now.Add(-72 * time.Hour), used: true},
+		{name: "old", mtime: now.Add(-48 * time.Hour)},
+		{name: "recent", mtime: now.Add(-time.Hour)},
+	}
+	trashDir := filepath.Join(t.TempDir(), "trash")
+	cfg := &config{
+		buildGranularity: fileGranularity,
+		concurrency:      1,
+		trashDir:         trashDir,
+		watchMaxBytes:    250,
+		watchPruneAge:    24 * time.Hour,
+	}
+	report := new(pruneReport)
+	p := newIncrementalPruner(cfg, clock.NewFake(now), &pins{}, report, "", dir)
+	for _, e := range entries {
+		path := filepath.Join(shard, e.name)
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", 100)), 0o644); err != nil {
+			t.Fatalf("writing entry: %v", err)
+		}
+		if err := os.Chtimes(path, e.mtime, e.mtime); err != nil {
+			t.Fatalf("setting times of entry: %v", err)
+		}
+		if e.used {
+			p.used(false, path)
+		}
+	}
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(shard, name))
+		return err == nil
+	}
+	p.pruneOversized(context.Background())
+	if exists("old") {
+		t.Error("expected old unused entry to be deleted")
+	}
+	// space has to be freed, so entries aren't moved to the trash
+	if _, err := os.Stat(trashDir); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected nothing to be moved to the trash, got %v", err)
+	}
+	if build := report.BuildCache; build == nil || len(build.Deleted) != 1 || build.BytesFreed != 100 {
+		t.Errorf("expected deleted entry to be reported, got %+v", build)
+	}
+	for _, name := range []string{"used", "recent"} {
+		if !exists(name) {
+			t.Errorf("expected entry %q to be kept", name)
+		}
+	}
+
+	// caches that stopped being watched aren't pruned anymore
+	cfg.watchMaxBytes = 1
+	cfg.watchPruneAge = time.Minute
+	p.cacheDone(false)
+	p.pruneOversized(context.Background())
+	if !exists("recent") {
+		t.Error("expected cache that stopped being watched not to be pruned")
+	}
+}
@@ -0,0 +1,61 @@
+// Package bytesize formats and parses sizes in bytes for humans.
+package bytesize
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Format returns b formatted with binary unit prefixes, e.g. 1.5KiB.
+func Format(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+var units = []struct {
+	suffix string
+	size   float64
+}{
+	// longer suffixes first so "B" doesn't match "KiB"
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"TB", 1e12},
+	{"B", 1},
+}
+
+// Parse parses a size with an optional decimal or binary unit suffix,
+// e.g. 512MB or 1.5GiB. A size without a suffix is in bytes.
+func Parse(s string) (int64, error) {
+	num, mult := strings.TrimSpace(s), 1.0
+	for _, u := range units {
+		if n, ok := strings.CutSuffix(num, u.suffix); ok {
+			num, mult = strings.TrimSpace(n), u.size
+			break
+		}
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if f < 0 {
+		return 0, errors.New("size must not be negative")
+	}
+	return int64(f * mult), nil
+}
@@ -0,0 +1,46 @@
+package bytesize
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		b    int64
+		want string
+	}{
+		{b: 0, want: "0B"},
+		{b: 1023, want: "1023B"},
+		{b: 1024, want: "1.0KiB"},
+		{b: 1536, want: "1.5KiB"},
+		{b: 10 << 30, want: "10.0GiB"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.b); got != tt.want {
+			t.Errorf("Format(%d) = %q, want %q", tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{
+		{s: "0", want: 0},
+		{s: "1024", want: 1024},
+		{s: "10B", want: 10},
+		{s: "1.5KiB", want: 1536},
+		{s: "2GiB", want: 2 << 30},
+		{s: "500MB", want: 500e6},
+		{s: "1 TB", want: 1e12},
+		{s: "GiB", wantErr: true},
+		{s: "-1MB", wantErr: true},
+		{s: "1PiB", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Parse(%q) = %d, %v, want %d, error %t", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
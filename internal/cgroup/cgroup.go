@@ -0,0 +1,84 @@
+// Package cgroup finds the limits cgroups put on this process.
+package cgroup
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// unlimited is the smallest cgroup v1 memory limit that is treated as
+// no limit; the kernel reports a page aligned math.MaxInt64 instead.
+const unlimited = 1 << 62
+
+// memoryLimit returns the lowest memory limit of the cgroups in fsys,
+// a view of the root filesystem, that the process is a member of
+// according to /proc/self/cgroup.
+func memoryLimit(fsys fs.FS) (int64, bool) {
+	data, err := fs.ReadFile(fsys, "proc/self/cgroup")
+	if err != nil {
+		return 0, false
+	}
+
+	var (
+		limit int64
+		found bool
+	)
+	setLimit := func(l int64) {
+		if l > 0 && l < unlimited && (!found || l < limit) {
+			limit, found = l, true
+		}
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		// lines are hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(s.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		switch {
+		case fields[0] == "0" && fields[1] == "":
+			// cgroup v2, limits of parent cgroups apply too
+			for dir := fields[2]; ; dir = path.Dir(dir) {
+				if l, ok := readLimit(fsys, path.Join("sys/fs/cgroup", dir, "memory.max")); ok {
+					setLimit(l)
+				}
+				if dir == "/" || dir == "." {
+					break
+				}
+			}
+		case slices.Contains(strings.Split(fields[1], ","), "memory"):
+			// cgroup v1, in containers the cgroup of the process is
+			// usually mounted at the root of the hierarchy
+			if l, ok := readLimit(fsys, path.Join("sys/fs/cgroup/memory", fields[2], "memory.limit_in_bytes")); ok {
+				setLimit(l)
+			} else if l, ok := readLimit(fsys, "sys/fs/cgroup/memory/memory.limit_in_bytes"); ok {
+				setLimit(l)
+			}
+		}
+	}
+
+	return limit, found
+}
+
+// readLimit reads a memory limit file, which is "max" if there is no
+// limit.
+func readLimit(fsys fs.FS, name string) (int64, bool) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return 0, false
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, false
+	}
+	l, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return l, true
+}
@@ -0,0 +1,9 @@
+package cgroup
+
+import "os"
+
+// MemoryLimit returns the memory limit cgroups put on this process, if
+// there is one.
+func MemoryLimit() (int64, bool) {
+	return memoryLimit(os.DirFS("/"))
+}
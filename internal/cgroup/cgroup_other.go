@@ -0,0 +1,9 @@
+//go:build !linux
+
+package cgroup
+
+// MemoryLimit returns the memory limit cgroups put on this process, if
+// there is one. Cgroups only exist on Linux.
+func MemoryLimit() (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,73 @@
+package cgroup
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		fsys  fstest.MapFS
+		limit int64
+		found bool
+	}{
+		{
+			name: "v2",
+			fsys: fstest.MapFS{
+				"proc/self/cgroup":                    {Data: []byte("0::/job\n")},
+				"sys/fs/cgroup/memory.max":            {Data: []byte("max\n")},
+				"sys/fs/cgroup/job/memory.max":        {Data: []byte("134217728\n")},
+				"sys/fs/cgroup/unrelated/memory.max":  {Data: []byte("1024\n")},
+				"sys/fs/cgroup/job/nested/memory.max": {Data: []byte("1024\n")},
+			},
+			limit: 128 << 20,
+			found: true,
+		},
+		{
+			name: "v2 parent limit",
+			fsys: fstest.MapFS{
+				"proc/self/cgroup":                       {Data: []byte("0::/pod/container\n")},
+				"sys/fs/cgroup/pod/memory.max":           {Data: []byte("268435456\n")},
+				"sys/fs/cgroup/pod/container/memory.max": {Data: []byte("max\n")},
+			},
+			limit: 256 << 20,
+			found: true,
+		},
+		{
+			name: "v2 unlimited",
+			fsys: fstest.MapFS{
+				"proc/self/cgroup":         {Data: []byte("0::/\n")},
+				"sys/fs/cgroup/memory.max": {Data: []byte("max\n")},
+			},
+		},
+		{
+			name: "v1 in container",
+			fsys: fstest.MapFS{
+				"proc/self/cgroup":                           {Data: []byte("5:cpu,cpuacct:/docker/abc\n4:memory:/docker/abc\n")},
+				"sys/fs/cgroup/memory/memory.limit_in_bytes": {Data: []byte("536870912\n")},
+			},
+			limit: 512 << 20,
+			found: true,
+		},
+		{
+			name: "v1 unlimited",
+			fsys: fstest.MapFS{
+				"proc/self/cgroup":                           {Data: []byte("4:memory:/\n")},
+				"sys/fs/cgroup/memory/memory.limit_in_bytes": {Data: []byte("9223372036854771712\n")},
+			},
+		},
+		{
+			name: "no cgroups",
+			fsys: fstest.MapFS{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, found := memoryLimit(tt.fsys)
+			if limit != tt.limit || found != tt.found {
+				t.Errorf("memoryLimit() = %d, %t, want %d, %t", limit, found, tt.limit, tt.found)
+			}
+		})
+	}
+}
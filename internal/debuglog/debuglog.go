@@ -0,0 +1,129 @@
+// Package debuglog logs a sample of frequent debug messages.
+package debuglog
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+const (
+	// debugSamples is how many debug messages of each kind are logged
+	// before the rest are only counted.
+	debugSamples = 25
+	// debugTopDirs is how many of the directories with the most
+	// suppressed debug messages are summarized.
+	debugTopDirs = 10
+)
+
+// sampledLogger logs a sample of frequent debug messages and counts the
+// rest per directory, so enabling debug logging doesn't generate
+// hundreds of thousands of lines on large caches.
+type sampledLogger struct {
+	mtx sync.Mutex
+	log logging.Logger
+	// full disables sampling
+	full bool
+	// logged is how many messages of each kind were logged
+	logged map[string]uint
+	// suppressed is how many messages of each kind were suppressed
+	// per directory
+	suppressed map[string]map[string]uint
+}
+
+var std = &sampledLogger{
+	log:        logging.Actions,
+	logged:     make(map[string]uint),
+	suppressed: make(map[string]map[string]uint),
+}
+
+// SetFull disables sampling if full is true, so every debug message is
+// logged.
+func SetFull(full bool) {
+	std.mtx.Lock()
+	defer std.mtx.Unlock()
+
+	std.full = full
+}
+
+// SetLogger makes debug messages be logged to l.
+func SetLogger(l logging.Logger) {
+	std.mtx.Lock()
+	defer std.mtx.Unlock()
+
+	std.log = l
+}
+
+// Debugf logs a debug message of kind about path if not too many
+// messages of the same kind were already logged.
+func Debugf(kind, path, format string, args ...any) {
+	std.Debugf(kind, path, format, args...)
+}
+
+// Flush logs a summary of suppressed messages and resets sampling.
+func Flush() {
+	std.flush()
+}
+
+func (s *sampledLogger) Debugf(kind, path, format string, args ...any) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.full || s.logged[kind] < debugSamples {
+		s.logged[kind]++
+		s.log.Debugf(format, args...)
+		return
+	}
+
+	dirs, ok := s.suppressed[kind]
+	if !ok {
+		dirs = make(map[string]uint)
+		s.suppressed[kind] = dirs
+	}
+	dirs[filepath.Dir(path)]++
+}
+
+func (s *sampledLogger) flush() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	kinds := make([]string, 0, len(s.suppressed))
+	for kind := range s.suppressed {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+
+	type dirCount struct {
+		dir   string
+		count uint
+	}
+	for _, kind := range kinds {
+		var (
+			total  uint
+			counts []dirCount
+		)
+		for dir, count := range s.suppressed[kind] {
+			total += count
+			counts = append(counts, dirCount{dir: dir, count: count})
+		}
+		slices.SortFunc(counts, func(a, b dirCount) int {
+			if c := cmp.Compare(b.count, a.count); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.dir, b.dir)
+		})
+
+		msg := fmt.Sprintf("suppressed %d %s debug messages, use -full-debug to log all of them", total, kind)
+		for _, c := range counts[:min(len(counts), debugTopDirs)] {
+			msg += fmt.Sprintf("\n  %s: %d", c.dir, c.count)
+		}
+		s.log.Debugf("%s", msg)
+	}
+
+	clear(s.logged)
+	clear(s.suppressed)
+}
@@ -0,0 +1,45 @@
+package debuglog
+
+import (
+	"testing"
+
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+func TestSampledLogger(t *testing.T) {
+	s := &sampledLogger{
+		log:        logging.Discard,
+		logged:     make(map[string]uint),
+		suppressed: make(map[string]map[string]uint),
+	}
+
+	for i := 0; i < debugSamples+10; i++ {
+		s.Debugf("event", "/cache/ab/file", "event %d", i)
+	}
+	for i := 0; i < debugSamples+5; i++ {
+		s.Debugf("delete", "/cache/cd/file", "delete %d", i)
+	}
+
+	if s.logged["event"] != debugSamples {
+		t.Fatalf("expected %d event messages to be logged, got %d", debugSamples, s.logged["event"])
+	}
+	if got := s.suppressed["event"]["/cache/ab"]; got != 10 {
+		t.Fatalf("expected 10 event messages to be suppressed, got %d", got)
+	}
+	if got := s.suppressed["delete"]["/cache/cd"]; got != 5 {
+		t.Fatalf("expected 5 delete messages to be suppressed, got %d", got)
+	}
+
+	s.flush()
+	if len(s.logged) != 0 || len(s.suppressed) != 0 {
+		t.Fatal("expected flush to reset sampling")
+	}
+
+	s.full = true
+	for i := 0; i < debugSamples+10; i++ {
+		s.Debugf("event", "/cache/ab/file", "event %d", i)
+	}
+	if len(s.suppressed) != 0 {
+		t.Fatal("expected no messages to be suppressed when sampling is disabled")
+	}
+}
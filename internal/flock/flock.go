@@ -0,0 +1,58 @@
+// Package flock takes advisory locks on files that other processes
+// taking the same lock respect.
+package flock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// pollInterval is how often taking a lock held by another process is
+// retried.
+const pollInterval = 100 * time.Millisecond
+
+// ErrTimeout is returned by Lock when the lock is still held by another
+// process once the timeout elapsed.
+var ErrTimeout = errors.New("timed out waiting for lock held by another process")
+
+// Lock takes an exclusive lock on the file at path, creating it if it
+// doesn't exist. If another process holds the lock it waits for at most
+// timeout for it to be released. The returned function releases the
+// lock.
+func Lock(ctx context.Context, path string, timeout time.Duration) (func() error, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %w", err)
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking %q: %w", path, err)
+		}
+		if locked {
+			return func() error {
+				err := unlock(f)
+				return errors.Join(err, f.Close())
+			}, nil
+		}
+		if !time.Now().Before(deadline) {
+			f.Close()
+			return nil, ErrTimeout
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,50 @@
+//go:build !windows
+
+package flock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	unlock, err := Lock(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("taking lock: %v", err)
+	}
+
+	// flock locks are per open file, so a lock taken through another
+	// open file conflicts like one of another process would
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening lock file: %v", err)
+	}
+	defer f.Close()
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); !errors.Is(err, unix.EWOULDBLOCK) {
+		t.Fatalf("expected lock to be held, got %v", err)
+	}
+	if _, err := Lock(context.Background(), path, 2*pollInterval); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected timeout taking held lock, got %v", err)
+	}
+
+	// the lock is taken once it is released while waiting
+	time.AfterFunc(pollInterval, func() {
+		if err := unlock(); err != nil {
+			t.Errorf("releasing lock: %v", err)
+		}
+	})
+	unlock2, err := Lock(context.Background(), path, time.Minute)
+	if err != nil {
+		t.Fatalf("taking released lock: %v", err)
+	}
+	if err := unlock2(); err != nil {
+		t.Fatalf("releasing lock: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+// Package goproxy fetches files from module proxies the way the go
+// command does, respecting the order of GOPROXY, GONOPROXY and
+// credentials configured with GOAUTH.
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// special GOPROXY list entries
+const (
+	Direct = "direct"
+	Off    = "off"
+)
+
+// ErrNotFound is returned when no proxy has a file, or the module has
+// to be fetched directly from its version control repository.
+var ErrNotFound = errors.New("not found in any proxy")
+
+// Entry is an entry of a GOPROXY list.
+type Entry struct {
+	// URL is the URL of the proxy, or Direct or Off
+	URL string
+	// FallbackOnError is true if the next entry is tried after any
+	// error instead of only when the proxy doesn't have a file
+	FallbackOnError bool
+}
+
+// ParseList parses the GOPROXY list goproxy. Entries are separated by
+// commas, or pipes to fall back to the next entry on any error.
+func ParseList(goproxy string) ([]Entry, error) {
+	var entries []Entry
+	for goproxy != "" {
+		var entry Entry
+		if i := strings.IndexAny(goproxy, ",|"); i >= 0 {
+			entry.URL = goproxy[:i]
+			entry.FallbackOnError = goproxy[i] == '|'
+			goproxy = goproxy[i+1:]
+		} else {
+			entry.URL = goproxy
+			goproxy = ""
+		}
+
+		entry.URL = strings.TrimSpace(entry.URL)
+		switch {
+		case entry.URL == "":
+			continue
+		case entry.URL == Direct || entry.URL == Off:
+			entries = append(entries, entry)
+			continue
+		case strings.ContainsAny(entry.URL, ".:/") && !strings.Contains(entry.URL, ":/") && !filepath.IsAbs(entry.URL) && !path.IsAbs(entry.URL):
+			// the go command assumes HTTPS if there's no scheme
+			entry.URL = "https://" + entry.URL
+		}
+
+		u, err := url.Parse(entry.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", entry.URL, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("unsupported proxy URL %q: only http and https proxies are supported", entry.URL)
+		}
+		entry.URL = strings.TrimSuffix(entry.URL, "/")
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Client fetches files from module proxies.
+type Client struct {
+	proxies []Entry
+	noProxy string
+	creds   []netrcLine
+	client  *http.Client
+}
+
+// NewClient returns a Client that fetches from the proxies in the
+// GOPROXY list goproxy. Modules matching the GONOPROXY patterns noProxy
+// are never fetched from proxies. Of the GOAUTH methods in goauth only
+// netrc and off are supported, unsupported methods are returned so
+// they can be reported.
+func NewClient(goproxy, noProxy, goauth string) (_ *Client, unsupported []string, err error) {
+	proxies, err := ParseList(goproxy)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := &Client{
+		proxies: proxies,
+		noProxy: noProxy,
+		client:  http.DefaultClient,
+	}
+
+	for _, method := range strings.Split(goauth, ";") {
+		method = strings.TrimSpace(method)
+		switch method {
+		case "":
+		case Off:
+			c.creds = nil
+			return c, nil, nil
+		case "netrc":
+			creds, err := readNetrc()
+			if err != nil {
+				return nil, nil, err
+			}
+			c.creds = append(c.creds, creds...)
+		default:
+			unsupported = append(unsupported, method)
+		}
+	}
+
+	return c, unsupported, nil
+}
+
+// FallsBackToDirect returns true if modules that aren't in any proxy
+// are fetched directly from their version control repositories.
+func (c *Client) FallsBackToDirect() bool {
+	for _, proxy := range c.proxies {
+		switch proxy.URL {
+		case Direct:
+			return true
+		case Off:
+			return false
+		}
+	}
+	return false
+}
+
+// Get fetches file of the module modPath, e.g. "@v/list" or
+// "@v/v1.0.0.zip" with the version escaped, from the first proxy that
+// has it. The caller must close the body of the returned response.
+// ErrNotFound is returned if no proxy has it.
+func (c *Client) Get(ctx context.Context, modPath, file string) (*http.Response, error) {
+	if module.MatchPrefixPatterns(c.noProxy, modPath) {
+		return nil, ErrNotFound
+	}
+	escapedPath, err := module.EscapePath(modPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, proxy := range c.proxies {
+		if proxy.URL == Direct || proxy.URL == Off {
+			break
+		}
+
+		resp, err := c.get(ctx, proxy.URL+"/"+escapedPath+"/"+file)
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, err)
+		if !proxy.FallbackOnError && !errors.Is(err, ErrNotFound) {
+			return nil, errors.Join(errs...)
+		}
+	}
+
+	return nil, errors.Join(append(errs, ErrNotFound)...)
+}
+
+func (c *Client) get(ctx context.Context, fileURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if login, password, ok := netrcCredentials(c.creds, req.URL.Hostname()); ok {
+		req.SetBasicAuth(login, password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	err = fmt.Errorf("fetching %q: %s", fileURL, resp.Status)
+	// like the go command, only these mean the next proxy should be
+	// tried
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return nil, err
+}
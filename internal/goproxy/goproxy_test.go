@@ -0,0 +1,114 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		goproxy string
+		want    []Entry
+		wantErr bool
+	}{
+		{
+			goproxy: "https://proxy.golang.org,direct",
+			want:    []Entry{{URL: "https://proxy.golang.org"}, {URL: Direct}},
+		},
+		{
+			goproxy: "corp.example.com/proxy/|https://proxy.golang.org, off",
+			want: []Entry{
+				{URL: "https://corp.example.com/proxy", FallbackOnError: true},
+				{URL: "https://proxy.golang.org"},
+				{URL: Off},
+			},
+		},
+		{
+			goproxy: ",,",
+		},
+		{
+			goproxy: "file:///srv/proxy",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseList(tt.goproxy)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseList(%q) error = %v, want error %t", tt.goproxy, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ParseList(%q) = %v, want %v", tt.goproxy, got, tt.want)
+		}
+	}
+}
+
+func TestClientGet(t *testing.T) {
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if login, password, ok := r.BasicAuth(); !ok || login != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/example.com/!private/@v/list" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, "v1.0.0\n")
+	}))
+	defer private.Close()
+
+	privateURL, err := url.Parse(private.URL)
+	if err != nil {
+		t.Fatalf("parsing server URL: %v", err)
+	}
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(netrc, []byte("machine "+privateURL.Hostname()+" login user password secret\n"), 0o600); err != nil {
+		t.Fatalf("writing netrc: %v", err)
+	}
+	t.Setenv("NETRC", netrc)
+
+	c, unsupported, err := NewClient(missing.URL+","+private.URL+",direct", "example.com/direct", "netrc;git /src")
+	if err != nil {
+		t.Fatalf("creating client: %v", err)
+	}
+	if !slices.Equal(unsupported, []string{"git /src"}) {
+		t.Errorf("unsupported GOAUTH methods = %q, want %q", unsupported, []string{"git /src"})
+	}
+
+	resp, err := c.Get(context.Background(), "example.com/Private", "@v/list")
+	if err != nil {
+		t.Fatalf("getting version list: %v", err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(data) != "v1.0.0\n" {
+		t.Errorf("reading version list = %q, %v", data, err)
+	}
+
+	for _, modPath := range []string{"example.com/missing", "example.com/direct/sub"} {
+		if _, err := c.Get(context.Background(), modPath, "@v/list"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) error = %v, want %v", modPath, err, ErrNotFound)
+		}
+	}
+
+	// without credentials the private proxy fails, which ends the
+	// search as it isn't followed by a pipe
+	c, _, err = NewClient(private.URL+","+missing.URL, "", "off")
+	if err != nil {
+		t.Fatalf("creating client: %v", err)
+	}
+	if _, err := c.Get(context.Background(), "example.com/Private", "@v/list"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+}
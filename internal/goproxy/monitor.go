@@ -0,0 +1,101 @@
+package goproxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/module"
+)
+
+// Monitor is a module proxy that fetches files from the proxies of a
+// Client and records which module versions were downloaded.
+type Monitor struct {
+	client *Client
+
+	mu         sync.Mutex
+	downloaded map[module.Version]bool
+}
+
+// NewMonitor returns a Monitor that fetches files using c.
+func NewMonitor(c *Client) *Monitor {
+	return &Monitor{
+		client:     c,
+		downloaded: make(map[module.Version]bool),
+	}
+}
+
+// ServeHTTP serves a file of a module. Requests for files no proxy has
+// or that must be fetched directly are answered with a 404 so the go
+// command tries the next entry of its GOPROXY list.
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// checksum database requests aren't proxied so the go command
+	// connects to the checksum database directly
+	escPath, file, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/@")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	modPath, err := module.UnescapePath(escPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	file = "@" + file
+
+	resp, err := m.client.Get(r.Context(), modPath, file)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return
+	}
+
+	// the go command only extracts modules into the module cache after
+	// downloading their zips
+	if dir, name := path.Split(file); dir == "@v/" && strings.HasSuffix(name, ".zip") {
+		version, err := module.UnescapeVersion(strings.TrimSuffix(name, ".zip"))
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		m.downloaded[module.Version{Path: modPath, Version: version}] = true
+		m.mu.Unlock()
+	}
+}
+
+// Downloaded returns the sorted module versions whose zips were
+// downloaded.
+func (m *Monitor) Downloaded() []module.Version {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mods := make([]module.Version, 0, len(m.downloaded))
+	for mod := range m.downloaded {
+		mods = append(mods, mod)
+	}
+	module.Sort(mods)
+
+	return mods
+}
@@ -0,0 +1,69 @@
+package goproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+func TestMonitor(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/example.com/!a/@v/list":
+			io.WriteString(w, "v1.0.0\n")
+		case "/example.com/!a/@v/v1.0.0.mod":
+			io.WriteString(w, "module example.com/A\n")
+		case "/example.com/!a/@v/v1.0.0.zip":
+			w.Header().Set("Content-Type", "application/zip")
+			io.WriteString(w, "zip")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	c, _, err := NewClient(upstream.URL, "", "off")
+	if err != nil {
+		t.Fatalf("creating client: %v", err)
+	}
+	monitor := NewMonitor(c)
+	srv := httptest.NewServer(monitor)
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{path: "/example.com/!a/@v/list", wantStatus: http.StatusOK, wantBody: "v1.0.0\n"},
+		{path: "/example.com/!a/@v/v1.0.0.mod", wantStatus: http.StatusOK, wantBody: "module example.com/A\n"},
+		{path: "/example.com/!a/@v/v1.0.0.zip", wantStatus: http.StatusOK, wantBody: "zip"},
+		{path: "/example.com/!a/@v/v1.1.0.zip", wantStatus: http.StatusNotFound},
+		{path: "/sumdb/sum.golang.org/supported", wantStatus: http.StatusNotFound},
+	} {
+		resp, err := http.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatalf("getting %q: %v", tt.path, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("reading %q: %v", tt.path, err)
+		}
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("GET %q status = %d, want %d", tt.path, resp.StatusCode, tt.wantStatus)
+		}
+		if tt.wantStatus == http.StatusOK && string(body) != tt.wantBody {
+			t.Errorf("GET %q = %q, want %q", tt.path, body, tt.wantBody)
+		}
+	}
+
+	want := []module.Version{{Path: "example.com/A", Version: "v1.0.0"}}
+	if got := monitor.Downloaded(); !slices.Equal(got, want) {
+		t.Errorf("Downloaded() = %v, want %v", got, want)
+	}
+}
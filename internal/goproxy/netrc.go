@@ -0,0 +1,104 @@
+package goproxy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+type netrcLine struct {
+	machine  string
+	login    string
+	password string
+}
+
+// readNetrc reads the credentials of the netrc file at $NETRC, or in
+// the home dir if it's unset. No credentials are returned if it doesn't
+// exist.
+func readNetrc() ([]netrcLine, error) {
+	path := os.Getenv("NETRC")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		name := ".netrc"
+		if runtime.GOOS == "windows" {
+			name = "_netrc"
+		}
+		path = filepath.Join(home, name)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading netrc: %w", err)
+	}
+
+	return parseNetrc(string(data)), nil
+}
+
+// parseNetrc parses the credentials of a netrc file the same way the go
+// command does.
+func parseNetrc(data string) []netrcLine {
+	var (
+		lines   []netrcLine
+		l       netrcLine
+		inMacro bool
+	)
+	for _, line := range strings.Split(data, "\n") {
+		// macro definitions end at an empty line
+		if inMacro {
+			if line == "" {
+				inMacro = false
+			}
+			continue
+		}
+
+		f := strings.Fields(line)
+		for i := 0; i < len(f); i += 2 {
+			// the default token must come after all machine tokens,
+			// and its credentials are for any machine so aren't used
+			if f[i] == "default" {
+				return lines
+			}
+			if i == len(f)-1 {
+				break
+			}
+
+			switch f[i] {
+			case "machine":
+				l = netrcLine{machine: f[i+1]}
+			case "login":
+				l.login = f[i+1]
+			case "password":
+				l.password = f[i+1]
+			case "macdef":
+				inMacro = true
+			}
+			if l.machine != "" && l.login != "" && l.password != "" {
+				lines = append(lines, l)
+				l = netrcLine{}
+			}
+		}
+	}
+
+	return lines
+}
+
+// netrcCredentials returns the credentials of the first line of lines
+// for host.
+func netrcCredentials(lines []netrcLine, host string) (login, password string, ok bool) {
+	for _, l := range lines {
+		if l.machine == host {
+			return l.login, l.password, true
+		}
+	}
+	return "", "", false
+}
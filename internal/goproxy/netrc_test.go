@@ -0,0 +1,29 @@
+package goproxy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	const netrc = `machine a.example.com login alice password pw1
+machine b.example.com
+	login bob
+	password pw2
+macdef init
+machine c.example.com login mallory password macro
+
+machine d.example.com login dave password pw3
+default
+login anon password anon
+machine e.example.com login eve password pw4
+`
+	want := []netrcLine{
+		{machine: "a.example.com", login: "alice", password: "pw1"},
+		{machine: "b.example.com", login: "bob", password: "pw2"},
+		{machine: "d.example.com", login: "dave", password: "pw3"},
+	}
+	if got := parseNetrc(netrc); !slices.Equal(got, want) {
+		t.Errorf("parseNetrc() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,258 @@
+// Package mirror makes a directory tree a copy of another, only copying
+// files that changed.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Stats is what was changed to mirror a dir.
+type Stats struct {
+	// Copied is how many files were copied
+	Copied int
+	// Unchanged is how many files were already up to date
+	Unchanged int
+	// Removed is how many files and dirs that weren't in the source
+	// were removed
+	Removed int
+	// BytesCopied is the total size of the copied files
+	BytesCopied int64
+}
+
+// Dir makes dst a copy of src. Files in dst that are hardlinks to the
+// file in src or have the same size and modification time are assumed
+// to be unchanged and aren't copied, and files in dst that aren't in
+// src are removed. Copied files keep their permissions and modification
+// times, and dirs keep their permissions once all files are copied.
+func Dir(ctx context.Context, src, dst string) (Stats, error) {
+	var (
+		stats Stats
+		// dirs are made writable while copying so files can be created
+		// in read-only dirs like the ones in the module cache
+		dirModes = make(map[string]fs.FileMode)
+		dirs     []string
+	)
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relPath)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if err := mkdirWritable(target); err != nil {
+				return err
+			}
+			dirModes[target] = info.Mode().Perm()
+			dirs = append(dirs, target)
+		case d.Type()&fs.ModeSymlink != 0:
+			copied, err := copySymlink(path, target)
+			if err != nil {
+				return err
+			}
+			if copied {
+				stats.Copied++
+			} else {
+				stats.Unchanged++
+			}
+		case d.Type().IsRegular():
+			if unchanged(info, target) {
+				stats.Unchanged++
+				return nil
+			}
+			if err := copyFile(path, target, info); err != nil {
+				return err
+			}
+			stats.Copied++
+			stats.BytesCopied += info.Size()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("copying %q: %w", src, err)
+	}
+
+	// remove everything that isn't in src
+	err = filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		srcInfo, err := os.Lstat(filepath.Join(src, relPath))
+		if err == nil && srcInfo.Mode().Type() == d.Type() {
+			return nil
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		if d.IsDir() {
+			makeTreeWritable(path)
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		stats.Removed++
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("removing files not in %q: %w", src, err)
+	}
+
+	// restore permissions of the deepest dirs first so parents are
+	// still writable
+	slices.Reverse(dirs)
+	for _, dir := range dirs {
+		if err := os.Chmod(dir, dirModes[dir]); err != nil {
+			return stats, fmt.Errorf("setting permissions of %q: %w", dir, err)
+		}
+	}
+
+	return stats, nil
+}
+
+// unchanged returns true if target is the same file as the one info
+// describes, or has the same size and modification time.
+func unchanged(info fs.FileInfo, target string) bool {
+	targetInfo, err := os.Lstat(target)
+	if err != nil || !targetInfo.Mode().IsRegular() {
+		return false
+	}
+	if os.SameFile(info, targetInfo) {
+		return true
+	}
+	return info.Size() == targetInfo.Size() && info.ModTime().Equal(targetInfo.ModTime())
+}
+
+// mkdirWritable creates dir if necessary and makes sure files can be
+// created in it.
+func mkdirWritable(dir string) error {
+	info, err := os.Lstat(dir)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return os.Mkdir(dir, 0o755)
+	case err != nil:
+		return err
+	case !info.IsDir():
+		if err := os.Remove(dir); err != nil {
+			return err
+		}
+		return os.Mkdir(dir, 0o755)
+	case info.Mode().Perm()&0o200 == 0:
+		return os.Chmod(dir, info.Mode().Perm()|0o700)
+	}
+
+	return nil
+}
+
+// makeTreeWritable makes dir and everything in it writable so it can be
+// removed.
+func makeTreeWritable(dir string) {
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			_ = os.Chmod(path, 0o755)
+		}
+		return nil
+	})
+}
+
+// copyFile copies the regular file src to dst, replacing dst
+// atomically.
+func copyFile(src, dst string, info fs.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), ".mirror-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(out.Name(), info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Chtimes(out.Name(), info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		// a dir can't be replaced by renaming a file over it
+		if dstInfo, statErr := os.Lstat(dst); statErr == nil && dstInfo.IsDir() {
+			makeTreeWritable(dst)
+			err = os.RemoveAll(dst)
+		}
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+
+	return nil
+}
+
+// copySymlink makes dst a symlink with the same target as src, and
+// returns false if it already was.
+func copySymlink(src, dst string) (bool, error) {
+	linkTarget, err := os.Readlink(src)
+	if err != nil {
+		return false, err
+	}
+	if existing, err := os.Readlink(dst); err == nil && existing == linkTarget {
+		return false, nil
+	}
+
+	if dstInfo, err := os.Lstat(dst); err == nil {
+		if dstInfo.IsDir() {
+			makeTreeWritable(dst)
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			return false, err
+		}
+	}
+	if err := os.Symlink(linkTarget, dst); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,87 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("creating dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0o444); err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+}
+
+func TestDir(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	dst := filepath.Join(t.TempDir(), "dst")
+	depDir := filepath.Join(src, "example.com", "a@v1.0.0")
+	writeFile(t, filepath.Join(depDir, "go.mod"), "module example.com/a\n")
+	writeFile(t, filepath.Join(depDir, "a.go"), "package a\n")
+	writeFile(t, filepath.Join(src, "00", "entry-a"), "output")
+	if err := os.Symlink("entry-a", filepath.Join(src, "00", "link")); err != nil {
+		t.Fatalf("creating symlink: %v", err)
+	}
+	// module cache dirs are read-only
+	if err := os.Chmod(depDir, 0o555); err != nil {
+		t.Fatalf("making dir read-only: %v", err)
+	}
+	t.Cleanup(func() {
+		makeTreeWritable(src)
+		makeTreeWritable(dst)
+	})
+
+	stats, err := Dir(context.Background(), src, dst)
+	if err != nil {
+		t.Fatalf("mirroring dir: %v", err)
+	}
+	if stats.Copied != 4 || stats.Unchanged != 0 || stats.Removed != 0 {
+		t.Errorf("unexpected stats of first mirror: %+v", stats)
+	}
+	data, err := os.ReadFile(filepath.Join(dst, "example.com", "a@v1.0.0", "a.go"))
+	if err != nil || string(data) != "package a\n" {
+		t.Errorf("reading copied file = %q, %v", data, err)
+	}
+	info, err := os.Stat(filepath.Join(dst, "example.com", "a@v1.0.0"))
+	if err != nil || info.Mode().Perm() != 0o555 {
+		t.Errorf("expected copied dir to be read-only, got %v, %v", info, err)
+	}
+
+	// remove a file from the source, and hardlink a file in the
+	// destination to the source
+	makeTreeWritable(src)
+	if err := os.Remove(filepath.Join(depDir, "a.go")); err != nil {
+		t.Fatalf("removing file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dst, "00", "entry-a")); err != nil {
+		t.Fatalf("removing file: %v", err)
+	}
+	if err := os.Link(filepath.Join(src, "00", "entry-a"), filepath.Join(dst, "00", "entry-a")); err != nil {
+		t.Fatalf("linking file: %v", err)
+	}
+	writeFile(t, filepath.Join(dst, "extra", "file"), "extra")
+
+	stats, err = Dir(context.Background(), src, dst)
+	if err != nil {
+		t.Fatalf("mirroring dir: %v", err)
+	}
+	if stats.Copied != 0 || stats.Unchanged != 3 || stats.Removed != 2 {
+		t.Errorf("unexpected stats of second mirror: %+v", stats)
+	}
+	for _, path := range []string{
+		filepath.Join(dst, "example.com", "a@v1.0.0", "a.go"),
+		filepath.Join(dst, "extra"),
+	} {
+		if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %q to be removed, got %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Package otlp exports spans and metrics to an OpenTelemetry collector
+// with OTLP over HTTP, using the JSON encoding so no protobuf or
+// OpenTelemetry SDK dependencies are needed.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scopeName is the instrumentation scope of exported spans and metrics.
+const scopeName = "github.com/capnspacehook/go-cache-prune"
+
+// Attr is an attribute of a span, metric data point or resource. Value
+// must be a string, bool, int, int64, uint64 or float64.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// Span is a finished span.
+type Span struct {
+	// TraceID and SpanID are hex encoded IDs, as returned by NewTraceID
+	// and NewSpanID
+	TraceID string
+	SpanID  string
+	// ParentSpanID is the SpanID of the parent span, or empty if the
+	// span is a root span
+	ParentSpanID string
+	Name         string
+	Start        time.Time
+	End          time.Time
+	Attrs        []Attr
+}
+
+// Metric is a metric with its data points.
+type Metric struct {
+	Name        string
+	Description string
+	Unit        string
+	// Monotonic is true if the metric is a cumulative sum that only
+	// increases, and false if it is a gauge
+	Monotonic bool
+	Points    []Point
+}
+
+// Point is a data point of a metric.
+type Point struct {
+	Attrs []Attr
+	// Start is when a cumulative sum started being recorded
+	Start time.Time
+	Time  time.Time
+	// Value must be an int64 or float64
+	Value any
+}
+
+// Exporter exports spans and metrics to an OTLP/HTTP endpoint.
+type Exporter struct {
+	// Endpoint is the base URL of the collector, such as
+	// http://localhost:4318. Signal paths like /v1/traces are appended
+	// to it.
+	Endpoint string
+	// Headers are added to every request
+	Headers map[string]string
+	// Resource describes what is exporting, such as service.name
+	Resource []Attr
+	// Client sends requests, or http.DefaultClient if nil
+	Client *http.Client
+}
+
+// ExportSpans exports spans.
+func (e *Exporter) ExportSpans(ctx context.Context, spans []Span) error {
+	jsonSpans := make([]map[string]any, len(spans))
+	for i, span := range spans {
+		s := map[string]any{
+			"traceId":           span.TraceID,
+			"spanId":            span.SpanID,
+			"name":              span.Name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": unixNano(span.Start),
+			"endTimeUnixNano":   unixNano(span.End),
+			"attributes":        jsonAttrs(span.Attrs),
+		}
+		if span.ParentSpanID != "" {
+			s["parentSpanId"] = span.ParentSpanID
+		}
+		jsonSpans[i] = s
+	}
+
+	return e.post(ctx, "/v1/traces", map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": jsonAttrs(e.Resource)},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": scopeName},
+				"spans": jsonSpans,
+			}},
+		}},
+	})
+}
+
+// ExportMetrics exports metrics.
+func (e *Exporter) ExportMetrics(ctx context.Context, metrics []Metric) error {
+	jsonMetrics := make([]map[string]any, len(metrics))
+	for i, metric := range metrics {
+		points := make([]map[string]any, len(metric.Points))
+		for j, p := range metric.Points {
+			point := map[string]any{
+				"attributes":   jsonAttrs(p.Attrs),
+				"timeUnixNano": unixNano(p.Time),
+			}
+			if !p.Start.IsZero() {
+				point["startTimeUnixNano"] = unixNano(p.Start)
+			}
+			switch v := p.Value.(type) {
+			case int64:
+				point["asInt"] = strconv.FormatInt(v, 10)
+			case float64:
+				point["asDouble"] = v
+			default:
+				return fmt.Errorf("metric %s has a data point of unsupported type %T", metric.Name, p.Value)
+			}
+			points[j] = point
+		}
+
+		m := map[string]any{
+			"name":        metric.Name,
+			"description": metric.Description,
+			"unit":        metric.Unit,
+		}
+		if metric.Monotonic {
+			m["sum"] = map[string]any{
+				"dataPoints":             points,
+				"aggregationTemporality": 2, // AGGREGATION_TEMPORALITY_CUMULATIVE
+				"isMonotonic":            true,
+			}
+		} else {
+			m["gauge"] = map[string]any{"dataPoints": points}
+		}
+		jsonMetrics[i] = m
+	}
+
+	return e.post(ctx, "/v1/metrics", map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource": map[string]any{"attributes": jsonAttrs(e.Resource)},
+			"scopeMetrics": []any{map[string]any{
+				"scope":   map[string]any{"name": scopeName},
+				"metrics": jsonMetrics,
+			}},
+		}},
+	})
+}
+
+func (e *Exporter) post(ctx context.Context, path string, body any) error {
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("encoding request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(e.Endpoint, "/")+path, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s responded with %s: %s", req.URL, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func jsonAttrs(attrs []Attr) []map[string]any {
+	jsonAttrs := make([]map[string]any, 0, len(attrs))
+	for _, attr := range attrs {
+		var val map[string]any
+		switch v := attr.Value.(type) {
+		case string:
+			val = map[string]any{"stringValue": v}
+		case bool:
+			val = map[string]any{"boolValue": v}
+		case int:
+			val = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			val = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case uint64:
+			val = map[string]any{"intValue": strconv.FormatUint(v, 10)}
+		case float64:
+			val = map[string]any{"doubleValue": v}
+		default:
+			val = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		jsonAttrs = append(jsonAttrs, map[string]any{"key": attr.Key, "value": val})
+	}
+	return jsonAttrs
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// NewTraceID returns a new random hex encoded trace ID.
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+// NewSpanID returns a new random hex encoded span ID.
+func NewSpanID() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ParseTraceparent returns the trace and parent span IDs of a W3C
+// traceparent header, as CI systems set in the TRACEPARENT environment
+// variable to make spans of commands part of the trace of the build.
+func ParseTraceparent(traceparent string) (traceID, spanID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", "", false
+	}
+	traceID, spanID = parts[1], parts[2]
+	if !isHexID(traceID, 32) || !isHexID(spanID, 16) {
+		return "", "", false
+	}
+	return traceID, spanID, true
+}
+
+// isHexID returns true if id is n lower case hex digits and not all
+// zeros.
+func isHexID(id string, n int) bool {
+	if len(id) != n || strings.Trim(id, "0") == "" {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil && strings.ToLower(id) == id
+}
+
+// ParseHeaders parses headers in the format of the
+// OTEL_EXPORTER_OTLP_HEADERS environment variable, comma separated
+// key=value pairs with URL encoded values.
+func ParseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("header %q isn't of the form key=value", pair)
+		}
+		val, err := url.QueryUnescape(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of header %q: %w", key, err)
+		}
+		headers[strings.TrimSpace(key)] = val
+	}
+	return headers, nil
+}
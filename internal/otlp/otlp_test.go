@@ -0,0 +1,114 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	type request struct {
+		path   string
+		header string
+		body   map[string]any
+	}
+	reqs := make(chan request, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var decoded map[string]any
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			t.Errorf("decoding request body: %v", err)
+		}
+		reqs <- request{path: r.URL.Path, header: r.Header.Get("Authorization"), body: decoded}
+	}))
+	defer srv.Close()
+
+	e := &Exporter{
+		Endpoint: srv.URL + "/",
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+		Resource: []Attr{{Key: "service.name", Value: "go-cache-prune"}},
+	}
+	start := time.Unix(1, 0)
+	err := e.ExportSpans(context.Background(), []Span{{
+		TraceID:      "0af7651916cd43dd8448eb211c80319c",
+		SpanID:       "b7ad6b7169203331",
+		ParentSpanID: "00f067aa0ba902b7",
+		Name:         "prune",
+		Start:        start,
+		End:          start.Add(time.Second),
+		Attrs:        []Attr{{Key: "bytes_freed", Value: int64(10)}},
+	}})
+	if err != nil {
+		t.Fatalf("exporting spans: %v", err)
+	}
+	req := <-reqs
+	if req.path != "/v1/traces" || req.header != "Bearer token" {
+		t.Errorf("expected authorized request to /v1/traces, got %q with Authorization %q", req.path, req.header)
+	}
+	span := req.body["resourceSpans"].([]any)[0].(map[string]any)["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)[0].(map[string]any)
+	if span["parentSpanId"] != "00f067aa0ba902b7" || span["startTimeUnixNano"] != "1000000000" || span["endTimeUnixNano"] != "2000000000" {
+		t.Errorf("unexpected span: %v", span)
+	}
+	attr := span["attributes"].([]any)[0].(map[string]any)
+	if attr["key"] != "bytes_freed" || attr["value"].(map[string]any)["intValue"] != "10" {
+		t.Errorf("unexpected span attribute: %v", attr)
+	}
+
+	err = e.ExportMetrics(context.Background(), []Metric{{
+		Name:      "deleted",
+		Monotonic: true,
+		Points:    []Point{{Start: start, Time: start.Add(time.Second), Value: int64(3)}},
+	}})
+	if err != nil {
+		t.Fatalf("exporting metrics: %v", err)
+	}
+	req = <-reqs
+	if req.path != "/v1/metrics" {
+		t.Errorf("expected request to /v1/metrics, got %q", req.path)
+	}
+	metric := req.body["resourceMetrics"].([]any)[0].(map[string]any)["scopeMetrics"].([]any)[0].(map[string]any)["metrics"].([]any)[0].(map[string]any)
+	sum, ok := metric["sum"].(map[string]any)
+	if !ok || sum["isMonotonic"] != true || sum["dataPoints"].([]any)[0].(map[string]any)["asInt"] != "3" {
+		t.Errorf("unexpected metric: %v", metric)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		traceparent string
+		ok          bool
+	}{
+		{traceparent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", ok: true},
+		{traceparent: "00-00000000000000000000000000000000-b7ad6b7169203331-01", ok: false},
+		{traceparent: "00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01", ok: false},
+		{traceparent: "ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", ok: false},
+		{traceparent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b71692033-01", ok: false},
+		{traceparent: "", ok: false},
+	}
+	for _, tt := range tests {
+		traceID, spanID, ok := ParseTraceparent(tt.traceparent)
+		if ok != tt.ok {
+			t.Errorf("ParseTraceparent(%q) ok = %v, want %v", tt.traceparent, ok, tt.ok)
+		}
+		if ok && (traceID != "0af7651916cd43dd8448eb211c80319c" || spanID != "b7ad6b7169203331") {
+			t.Errorf("ParseTraceparent(%q) = %q, %q", tt.traceparent, traceID, spanID)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := ParseHeaders("api-key=secret%3D, x-team = ci")
+	if err != nil {
+		t.Fatalf("parsing headers: %v", err)
+	}
+	if len(headers) != 2 || headers["api-key"] != "secret=" || headers["x-team"] != "ci" {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+	if _, err := ParseHeaders("api-key"); err == nil {
+		t.Error("expected header without value to fail")
+	}
+}
@@ -0,0 +1,53 @@
+// Package sdnotify implements the systemd service notification
+// protocol, see sd_notify(3).
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notify sends state, newline separated variable assignments such as
+// "READY=1", to the service manager. It does nothing and returns false
+// if the service manager doesn't expect notifications.
+func Notify(state string) (bool, error) {
+	sockPath := os.Getenv("NOTIFY_SOCKET")
+	if sockPath == "" {
+		return false, nil
+	}
+
+	// socket paths starting with '@' are in the abstract namespace,
+	// which the net package handles on Linux
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sockPath, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("connecting to notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("sending notification: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often the service manager expects
+// "WATCHDOG=1" to be sent, or 0 if the watchdog isn't enabled for this
+// process.
+func WatchdogInterval() (time.Duration, error) {
+	usecStr := os.Getenv("WATCHDOG_USEC")
+	if usecStr == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	usec, err := strconv.ParseInt(usecStr, 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC %q", usecStr)
+	}
+	return time.Duration(usec) * time.Microsecond, nil
+}
@@ -0,0 +1,69 @@
+//go:build !windows
+
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify("READY=1"); sent || err != nil {
+		t.Fatalf("expected nothing to be sent without a notify socket, got sent=%t err=%v", sent, err)
+	}
+
+	sockPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sockPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", sockPath)
+
+	if sent, err := Notify("READY=1\nSTATUS=watching"); !sent || err != nil {
+		t.Fatalf("expected notification to be sent, got sent=%t err=%v", sent, err)
+	}
+	buf := make([]byte, 64)
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("reading notification: %v", err)
+	}
+	if got := string(buf[:n]); got != "READY=1\nSTATUS=watching" {
+		t.Errorf("unexpected notification %q", got)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		usec    string
+		pid     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{usec: "", want: 0},
+		{usec: "30000000", want: 30 * time.Second},
+		{usec: "30000000", pid: pid, want: 30 * time.Second},
+		{usec: "30000000", pid: "1", want: 0},
+		{usec: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		got, err := WatchdogInterval()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("WATCHDOG_USEC=%q: unexpected error %v", tt.usec, err)
+		}
+		if got != tt.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: expected %s, got %s", tt.usec, tt.pid, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/capnspacehook/go-cache-prune/internal/debuglog"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+// Formats logs can be written in.
+const (
+	actionsLogFormat   = "actions"
+	textLogFormat      = "text"
+	jsonLogFormat      = "json"
+	gitlabLogFormat    = "gitlab"
+	buildkiteLogFormat = "buildkite"
+	circleciLogFormat  = "circleci"
+	azureLogFormat     = "azure"
+)
+
+// logFormats are the formats -log-format accepts.
+var logFormats = []string{actionsLogFormat, textLogFormat, jsonLogFormat, gitlabLogFormat, buildkiteLogFormat, circleciLogFormat, azureLogFormat}
+
+// log is where go-cache-prune logs to. It logs workflow commands when
+// running in GitHub Actions, and text to stderr otherwise until
+// setupLogging changes it.
+var log = newLogger(defaultLogFormat(), slog.LevelInfo)
+
+// defaultLogFormat returns the format logs are written in if
+// -log-format isn't set, which depends on the CI system being run in.
+func defaultLogFormat() string {
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		return actionsLogFormat
+	case os.Getenv("GITLAB_CI") == "true":
+		return gitlabLogFormat
+	case os.Getenv("BUILDKITE") == "true":
+		return buildkiteLogFormat
+	case os.Getenv("CIRCLECI") == "true":
+		return circleciLogFormat
+	case os.Getenv("TF_BUILD") == "True":
+		return azureLogFormat
+	default:
+		return textLogFormat
+	}
+}
+
+// newLogger returns a Logger that logs messages of at least level in
+// format. Logs not written as logging commands go to stderr, so they
+// don't mix with the output of commands like -ctl.
+func newLogger(format string, level slog.Level) logging.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case textLogFormat:
+		return logging.Slog(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case jsonLogFormat:
+		return logging.Slog(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	case gitlabLogFormat:
+		return levelLogger{Logger: logging.GitLab(os.Stderr), level: level}
+	case buildkiteLogFormat:
+		return levelLogger{Logger: logging.Buildkite(os.Stderr), level: level}
+	case circleciLogFormat:
+		return levelLogger{Logger: logging.CircleCI(os.Stderr), level: level}
+	case azureLogFormat:
+		return ciCommandLogger(logging.Azure(os.Stdout), level)
+	default:
+		return ciCommandLogger(logging.Actions, level)
+	}
+}
+
+// ciCommandLogger returns l, which writes the logging commands of a CI
+// system, filtered to messages of at least level. Debug messages are
+// hidden by the CI system unless debug logging is enabled, so let it
+// decide whether to show them by default.
+func ciCommandLogger(l logging.Logger, level slog.Level) logging.Logger {
+	if level == slog.LevelInfo {
+		return l
+	}
+	return levelLogger{Logger: l, level: level, debugAsInfo: true}
+}
+
+// levelLogger only logs messages of at least level.
+type levelLogger struct {
+	logging.Logger
+	level slog.Level
+	// debugAsInfo logs debug messages as info messages so they aren't
+	// hidden
+	debugAsInfo bool
+}
+
+func (l levelLogger) Debugf(format string, args ...any) {
+	switch {
+	case l.level > slog.LevelDebug:
+	case l.debugAsInfo:
+		l.Logger.Infof(format, args...)
+	default:
+		l.Logger.Debugf(format, args...)
+	}
+}
+
+func (l levelLogger) Infof(format string, args ...any) {
+	if l.level <= slog.LevelInfo {
+		l.Logger.Infof(format, args...)
+	}
+}
+
+func (l levelLogger) Warningf(format string, args ...any) {
+	if l.level <= slog.LevelWarn {
+		l.Logger.Warningf(format, args...)
+	}
+}
+
+// setupLogging makes everything log messages of at least level in
+// format, or the default format if it is empty.
+func setupLogging(format string, level slog.Level) error {
+	if format == "" {
+		format = defaultLogFormat()
+	} else if !slices.Contains(logFormats, format) {
+		return fmt.Errorf("-log-format must be one of %s", strings.Join(logFormats, ", "))
+	}
+
+	log = newLogger(format, level)
+	debuglog.SetLogger(log)
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+func TestSetupLogging(t *testing.T) {
+	t.Cleanup(func() {
+		log = newLogger(defaultLogFormat(), slog.LevelInfo)
+	})
+
+	tests := []struct {
+		format      string
+		githubEnv   string
+		wantActions bool
+		wantErr     bool
+	}{
+		{format: "", githubEnv: "true", wantActions: true},
+		{format: "", githubEnv: "", wantActions: false},
+		{format: gitlabLogFormat, githubEnv: "true", wantActions: false},
+		{format: textLogFormat, githubEnv: "true", wantActions: false},
+		{format: jsonLogFormat, githubEnv: "", wantActions: false},
+		{format: actionsLogFormat, githubEnv: "", wantActions: true},
+		{format: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("GITHUB_ACTIONS", tt.githubEnv)
+		t.Setenv("GITLAB_CI", "")
+		t.Setenv("BUILDKITE", "")
+		t.Setenv("CIRCLECI", "")
+		t.Setenv("TF_BUILD", "")
+		err := setupLogging(tt.format, slog.LevelInfo)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("expected -log-format=%s to fail", tt.format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("setting up logging with -log-format=%q: %v", tt.format, err)
+		}
+		if isActions := log == logging.Actions; isActions != tt.wantActions {
+			t.Errorf("-log-format=%q with GITHUB_ACTIONS=%q: logging as workflow commands = %v, want %v", tt.format, tt.githubEnv, isActions, tt.wantActions)
+		}
+	}
+}
+
+// recordLogger records the levels of messages logged to it.
+type recordLogger struct {
+	logging.Logger
+	levels []string
+}
+
+func (r *recordLogger) Debugf(string, ...any)   { r.levels = append(r.levels, "debug") }
+func (r *recordLogger) Infof(string, ...any)    { r.levels = append(r.levels, "info") }
+func (r *recordLogger) Warningf(string, ...any) { r.levels = append(r.levels, "warn") }
+func (r *recordLogger) Errorf(string, ...any)   { r.levels = append(r.levels, "error") }
+
+func TestLevelLogger(t *testing.T) {
+	tests := []struct {
+		level       slog.Level
+		debugAsInfo bool
+		want        []string
+	}{
+		{level: slog.LevelDebug, want: []string{"debug", "info", "warn", "error"}},
+		{level: slog.LevelDebug, debugAsInfo: true, want: []string{"info", "info", "warn", "error"}},
+		{level: slog.LevelInfo, want: []string{"info", "warn", "error"}},
+		{level: slog.LevelWarn, want: []string{"warn", "error"}},
+		{level: slog.LevelError, debugAsInfo: true, want: []string{"error"}},
+	}
+	for _, tt := range tests {
+		rec := &recordLogger{Logger: logging.Discard}
+		l := levelLogger{Logger: rec, level: tt.level, debugAsInfo: tt.debugAsInfo}
+		l.Debugf("debug")
+		l.Infof("info")
+		l.Warningf("warn")
+		l.Errorf("error")
+		if !slices.Equal(rec.levels, tt.want) {
+			t.Errorf("at level %s logged %q, want %q", tt.level, rec.levels, tt.want)
+		}
+	}
+}
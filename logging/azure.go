@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Azure returns a Logger that writes Azure Pipelines logging commands
+// to w, typically the output of a pipeline step. Warnings and errors
+// are shown in the summary of the pipeline run like they are with
+// Actions, and groups are collapsible.
+func Azure(w io.Writer) Logger {
+	return &azureLogger{lineWriter: lineWriter{w: w}}
+}
+
+type azureLogger struct {
+	lineWriter
+}
+
+// azureEscaper escapes messages of logging commands so they can't span
+// multiple lines.
+var azureEscaper = strings.NewReplacer("%", "%AZP25", "\r", "%0D", "\n", "%0A")
+
+func (a *azureLogger) Debugf(format string, args ...any) {
+	a.command("##[debug]", fmt.Sprintf(format, args...))
+}
+
+func (a *azureLogger) Infof(format string, args ...any) {
+	a.printf("", "", format, args...)
+}
+
+func (a *azureLogger) Warningf(format string, args ...any) {
+	a.command("##vso[task.logissue type=warning]", fmt.Sprintf(format, args...))
+}
+
+func (a *azureLogger) Errorf(format string, args ...any) {
+	a.command("##vso[task.logissue type=error]", fmt.Sprintf(format, args...))
+}
+
+func (a *azureLogger) Group(title string) {
+	a.command("##[group]", title)
+}
+
+func (a *azureLogger) EndGroup() {
+	a.command("##[endgroup]", "")
+}
+
+// command writes the logging command cmd with msg.
+func (a *azureLogger) command(cmd, msg string) {
+	a.printf("", cmd, "%s", azureEscaper.Replace(msg))
+}
@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+)
+
+// Buildkite returns a Logger that writes to w, typically the job log of
+// a Buildkite job. Groups are written as collapsed log groups, which
+// are expanded if an error is logged in them, and warnings and errors
+// are highlighted.
+func Buildkite(w io.Writer) Logger {
+	return &buildkiteLogger{lineWriter: lineWriter{w: w}}
+}
+
+type buildkiteLogger struct {
+	lineWriter
+	// inGroup is true if messages are being logged in a group
+	inGroup bool
+}
+
+func (b *buildkiteLogger) Debugf(format string, args ...any) {
+	b.printf("", "DEBUG: ", format, args...)
+}
+
+func (b *buildkiteLogger) Infof(format string, args ...any) {
+	b.printf("", "", format, args...)
+}
+
+func (b *buildkiteLogger) Warningf(format string, args ...any) {
+	b.printf(warningColor, "WARNING: ", format, args...)
+}
+
+func (b *buildkiteLogger) Errorf(format string, args ...any) {
+	b.mtx.Lock()
+	if b.inGroup {
+		// expand the group so the error isn't hidden
+		fmt.Fprintln(b.w, "^^^ +++")
+	}
+	b.mtx.Unlock()
+
+	b.printf(errorColor, "ERROR: ", format, args...)
+}
+
+func (b *buildkiteLogger) Group(title string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	fmt.Fprintf(b.w, "--- %s\n", title)
+	b.inGroup = true
+}
+
+// EndGroup ends the current group. Buildkite groups end where the next
+// one starts, so messages logged after it are written in an untitled
+// group.
+func (b *buildkiteLogger) EndGroup() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.inGroup {
+		fmt.Fprintln(b.w, "+++")
+		b.inGroup = false
+	}
+}
@@ -0,0 +1,41 @@
+package logging
+
+import "io"
+
+// boldStyle is the ANSI escape code group titles are written in.
+const boldStyle = "\x1b[1m"
+
+// CircleCI returns a Logger that writes to w, typically the output of a
+// CircleCI step. CircleCI adds timestamps to the output and can't
+// collapse parts of it, so messages are written without timestamps and
+// group titles are highlighted instead. Warnings and errors are
+// highlighted too.
+func CircleCI(w io.Writer) Logger {
+	return &circleciLogger{lineWriter: lineWriter{w: w}}
+}
+
+type circleciLogger struct {
+	lineWriter
+}
+
+func (c *circleciLogger) Debugf(format string, args ...any) {
+	c.printf("", "DEBUG: ", format, args...)
+}
+
+func (c *circleciLogger) Infof(format string, args ...any) {
+	c.printf("", "", format, args...)
+}
+
+func (c *circleciLogger) Warningf(format string, args ...any) {
+	c.printf(warningColor, "WARNING: ", format, args...)
+}
+
+func (c *circleciLogger) Errorf(format string, args ...any) {
+	c.printf(errorColor, "ERROR: ", format, args...)
+}
+
+func (c *circleciLogger) Group(title string) {
+	c.printf(boldStyle, "==> ", "%s", title)
+}
+
+func (c *circleciLogger) EndGroup() {}
@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// GitLab returns a Logger that writes to w, typically the job log of a
+// GitLab CI job. Groups are written as collapsible sections, and
+// warnings and errors are highlighted the same way GitLab Runner
+// highlights its own.
+func GitLab(w io.Writer) Logger {
+	return &gitlabLogger{lineWriter: lineWriter{w: w}}
+}
+
+type gitlabLogger struct {
+	lineWriter
+	// section is the name of the current section
+	section string
+}
+
+// gitlabClearLine is written around section markers so they aren't
+// shown in the job log.
+const gitlabClearLine = "\x1b[0K"
+
+func (g *gitlabLogger) Debugf(format string, args ...any) {
+	g.printf("", "DEBUG: ", format, args...)
+}
+
+func (g *gitlabLogger) Infof(format string, args ...any) {
+	g.printf("", "", format, args...)
+}
+
+func (g *gitlabLogger) Warningf(format string, args ...any) {
+	g.printf(warningColor, "WARNING: ", format, args...)
+}
+
+func (g *gitlabLogger) Errorf(format string, args ...any) {
+	g.printf(errorColor, "ERROR: ", format, args...)
+}
+
+func (g *gitlabLogger) Group(title string) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	g.endSection()
+	g.section = gitlabSectionName(title)
+	fmt.Fprintf(g.w, "%ssection_start:%d:%s[collapsed=true]\r%s%s\n", gitlabClearLine, time.Now().Unix(), g.section, gitlabClearLine, title)
+}
+
+func (g *gitlabLogger) EndGroup() {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	g.endSection()
+}
+
+func (g *gitlabLogger) endSection() {
+	if g.section == "" {
+		return
+	}
+	fmt.Fprintf(g.w, "%ssection_end:%d:%s\r%s\n", gitlabClearLine, time.Now().Unix(), g.section, gitlabClearLine)
+	g.section = ""
+}
+
+// gitlabSectionName returns the name of a section with title. Section
+// names may only contain letters, numbers and '_', '.' or '-'.
+func gitlabSectionName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '_'
+		}
+	}, title)
+	return "go_cache_prune_" + name
+}
@@ -0,0 +1,136 @@
+// Package logging lets programs embedding the watch and prune packages
+// choose where their logs go. By default logs are written as GitHub
+// Actions workflow commands.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	actions "github.com/sethvargo/go-githubactions"
+)
+
+// Logger logs messages of watching and pruning caches.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Warningf(format string, args ...any)
+	Errorf(format string, args ...any)
+	// Group starts a group of related messages that is ended by
+	// EndGroup.
+	Group(title string)
+	EndGroup()
+}
+
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx that watching and pruning with will
+// log to l.
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the Logger of ctx, or Actions if ctx doesn't have
+// one.
+func FromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return l
+	}
+	return Actions
+}
+
+// Actions logs using GitHub Actions workflow commands.
+var Actions Logger = actionsLogger{}
+
+type actionsLogger struct{}
+
+func (actionsLogger) Debugf(format string, args ...any)   { actions.Debugf(format, args...) }
+func (actionsLogger) Infof(format string, args ...any)    { actions.Infof(format, args...) }
+func (actionsLogger) Warningf(format string, args ...any) { actions.Warningf(format, args...) }
+func (actionsLogger) Errorf(format string, args ...any)   { actions.Errorf(format, args...) }
+func (actionsLogger) Group(title string)                  { actions.Group(title) }
+func (actionsLogger) EndGroup()                           { actions.EndGroup() }
+
+// ANSI escape codes warnings and errors are highlighted with by loggers
+// of CI systems that show colors.
+const (
+	warningColor = "\x1b[0;33m"
+	errorColor   = "\x1b[31;1m"
+	resetColor   = "\x1b[0m"
+)
+
+// lineWriter writes log lines of CI systems that show colors. It is safe
+// to use concurrently.
+type lineWriter struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+// printf writes a line of prefix and the formatted message, in color if
+// it isn't empty.
+func (l *lineWriter) printf(color, prefix, format string, args ...any) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	msg := prefix + fmt.Sprintf(format, args...)
+	if color != "" {
+		msg = color + msg + resetColor
+	}
+	fmt.Fprintln(l.w, msg)
+}
+
+// Discard discards everything logged to it.
+var Discard Logger = discardLogger{}
+
+type discardLogger struct{}
+
+func (discardLogger) Debugf(string, ...any)   {}
+func (discardLogger) Infof(string, ...any)    {}
+func (discardLogger) Warningf(string, ...any) {}
+func (discardLogger) Errorf(string, ...any)   {}
+func (discardLogger) Group(string)            {}
+func (discardLogger) EndGroup()               {}
+
+// Slog returns a Logger that logs to l. Groups are logged as the
+// "group" attribute of messages logged in them. Loggers of other
+// logging libraries such as logr can be used with a slog.Handler that
+// wraps them.
+func Slog(l *slog.Logger) Logger {
+	s := &slogLogger{base: l}
+	s.l.Store(l)
+	return s
+}
+
+type slogLogger struct {
+	base *slog.Logger
+	// l is base with the current group
+	l atomic.Pointer[slog.Logger]
+}
+
+func (s *slogLogger) Debugf(format string, args ...any) {
+	s.l.Load().Debug(fmt.Sprintf(format, args...))
+}
+
+func (s *slogLogger) Infof(format string, args ...any) {
+	s.l.Load().Info(fmt.Sprintf(format, args...))
+}
+
+func (s *slogLogger) Warningf(format string, args ...any) {
+	s.l.Load().Warn(fmt.Sprintf(format, args...))
+}
+
+func (s *slogLogger) Errorf(format string, args ...any) {
+	s.l.Load().Error(fmt.Sprintf(format, args...))
+}
+
+func (s *slogLogger) Group(title string) {
+	s.l.Store(s.base.With("group", title))
+}
+
+func (s *slogLogger) EndGroup() {
+	s.l.Store(s.base)
+}
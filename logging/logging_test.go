@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"regexp"
+	"testing"
+)
+
+func TestFromContext(t *testing.T) {
+	if l := FromContext(context.Background()); l != Actions {
+		t.Errorf("FromContext() without a logger = %v, want Actions", l)
+	}
+
+	l := Slog(slog.Default())
+	if got := FromContext(NewContext(context.Background(), l)); got != l {
+		t.Errorf("FromContext() = %v, want %v", got, l)
+	}
+}
+
+func TestSlog(t *testing.T) {
+	var buf bytes.Buffer
+	l := Slog(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		// remove times so output is deterministic
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})))
+
+	l.Group("Pruning")
+	l.Infof("deleted %d files", 2)
+	l.EndGroup()
+	l.Warningf("cache %q is missing", "mod")
+	l.Debugf("not logged")
+
+	want := `level=INFO msg="deleted 2 files" group=Pruning
+level=WARN msg="cache \"mod\" is missing"
+`
+	if got := buf.String(); got != want {
+		t.Errorf("logged:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGitLab(t *testing.T) {
+	var buf bytes.Buffer
+	l := GitLab(&buf)
+
+	l.Group("Pruning cache files")
+	l.Infof("deleted %d files", 2)
+	l.EndGroup()
+	l.Warningf("cache %q is missing", "mod")
+	l.Errorf("failed")
+
+	// remove times so output is deterministic
+	got := regexp.MustCompile(`:\d+:`).ReplaceAllString(buf.String(), ":0:")
+	want := "\x1b[0Ksection_start:0:go_cache_prune_pruning_cache_files[collapsed=true]\r\x1b[0KPruning cache files\n" +
+		"deleted 2 files\n" +
+		"\x1b[0Ksection_end:0:go_cache_prune_pruning_cache_files\r\x1b[0K\n" +
+		"\x1b[0;33mWARNING: cache \"mod\" is missing\x1b[0m\n" +
+		"\x1b[31;1mERROR: failed\x1b[0m\n"
+	if got != want {
+		t.Errorf("logged:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestBuildkite(t *testing.T) {
+	var buf bytes.Buffer
+	l := Buildkite(&buf)
+
+	l.Group("Pruning cache files")
+	l.Infof("deleted %d files", 2)
+	l.Errorf("failed")
+	l.EndGroup()
+	l.Warningf("cache %q is missing", "mod")
+
+	want := "--- Pruning cache files\n" +
+		"deleted 2 files\n" +
+		"^^^ +++\n" +
+		"\x1b[31;1mERROR: failed\x1b[0m\n" +
+		"+++\n" +
+		"\x1b[0;33mWARNING: cache \"mod\" is missing\x1b[0m\n"
+	if got := buf.String(); got != want {
+		t.Errorf("logged:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestCircleCI(t *testing.T) {
+	var buf bytes.Buffer
+	l := CircleCI(&buf)
+
+	l.Group("Pruning cache files")
+	l.Infof("deleted %d files", 2)
+	l.EndGroup()
+	l.Errorf("failed")
+
+	want := "\x1b[1m==> Pruning cache files\x1b[0m\n" +
+		"deleted 2 files\n" +
+		"\x1b[31;1mERROR: failed\x1b[0m\n"
+	if got := buf.String(); got != want {
+		t.Errorf("logged:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestAzure(t *testing.T) {
+	var buf bytes.Buffer
+	l := Azure(&buf)
+
+	l.Group("Pruning cache files")
+	l.Infof("deleted %d files", 2)
+	l.Debugf("deleted %q", "a")
+	l.EndGroup()
+	l.Warningf("100%% of cache %q is missing", "mod")
+	l.Errorf("failed:\nno space left")
+
+	want := `##[group]Pruning cache files
+deleted 2 files
+##[debug]deleted "a"
+##[endgroup]
+##vso[task.logissue type=warning]100%AZP25 of cache "mod" is missing
+##vso[task.logissue type=error]failed:%0Ano space left
+`
+	if got := buf.String(); got != want {
+		t.Errorf("logged:\n%s\nwant:\n%s", got, want)
+	}
+}
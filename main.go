@@ -6,20 +6,30 @@ import (
 	"flag"
 	"fmt"
 	"io/fs"
+	"log/slog"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"text/tabwriter"
+	"time"
 
-	"github.com/fsnotify/fsnotify"
-	actions "github.com/sethvargo/go-githubactions"
 	"golang.org/x/mod/module"
-	"golang.org/x/mod/semver"
-	"golang.org/x/sys/unix"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/clock"
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+	"github.com/capnspacehook/go-cache-prune/internal/debuglog"
+	"github.com/capnspacehook/go-cache-prune/internal/goproxy"
+	"github.com/capnspacehook/go-cache-prune/internal/mirror"
+	"github.com/capnspacehook/go-cache-prune/logging"
+	"github.com/capnspacehook/go-cache-prune/prune"
+	"github.com/capnspacehook/go-cache-prune/watch"
 )
 
 const (
@@ -31,7 +41,16 @@ func usage() {
 	fmt.Fprintf(os.Stderr, `
 Prune unused files in Go module and build caches
 
-go-cache-prune [flags]
+go-cache-prune [flags] [-- command [args...]]
+go-cache-prune -trash-dir dir [flags] restore
+
+If a command is passed, it is run once the caches are being watched and
+the caches are pruned when it exits. restore moves the entries the
+last prune moved to the -trash-dir back into the caches.
+
+Every flag can also be set with a GO_CACHE_PRUNE_ environment variable,
+for example GO_CACHE_PRUNE_MAX_AGE for -max-age. Flags take precedence
+over environment variables, which take precedence over -config.
 
 %s accepts the following flags:
 
@@ -48,26 +67,125 @@ func main() {
 }
 
 func mainRetCode() int {
-	if err := mainErr(); err != nil {
-		var exitCode *errJustExit
-		if errors.As(err, &exitCode) {
-			return int(*exitCode)
-		}
-		actions.Errorf("%v", err)
-		return 1
+	return exitCode(mainErr())
+}
+
+// exitCode returns the code to exit with when main returned err.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
 	}
-	return 0
+	var code errJustExit
+	if errors.As(err, &code) {
+		return int(code)
+	}
+	log.Errorf("%v", err)
+	return 1
 }
 
 type config struct {
 	commit string
 
-	moduleCache     string
-	buildCache      string
-	pruneModCache   bool
-	pruneBuildCache bool
-	usePIDFile      bool
-	signalProc      bool
+	configFile        string
+	moduleCache       string
+	buildCache        string
+	pruneModCache     bool
+	pruneBuildCache   bool
+	lintCache         string
+	staticcheckCache  string
+	extraCacheFlags   stringsFlag
+	extraCaches       []extraCache
+	buildGranularity  string
+	granularitySet    bool
+	mode              string
+	watchBackend      string
+	raiseWatchLimit   bool
+	readOnlyModCache  bool
+	modCacheWritable  bool
+	writableSet       bool
+	waitForStable     time.Duration
+	restoreWindow     time.Duration
+	gracePeriod       time.Duration
+	minResidency      time.Duration
+	maxAge            time.Duration
+	dryRun            bool
+	reportFile        string
+	syncDir           string
+	telemetryMaxAge   time.Duration
+	maxCacheSize      string
+	maxCacheBytes     int64
+	verify            string
+	verifyMaxMisses   int
+	watchMaxSize      string
+	watchMaxBytes     int64
+	watchPruneAge     time.Duration
+	pruneFuzzCache    bool
+	maxFuzzSize       string
+	maxFuzzBytes      int64
+	deleteRate        string
+	deleteEntryRate   float64
+	deleteBytesRate   int64
+	deletePause       time.Duration
+	concurrency       int
+	pruneInclude      stringsFlag
+	pruneExclude      stringsFlag
+	protect           string
+	protectFile       string
+	protected         []string
+	softDelete        time.Duration
+	firstRunSafe      bool
+	keepModMetadata   bool
+	keepToolchains    bool
+	daemon            bool
+	ctlCommand        string
+	listenAddr        string
+	metricsFile       string
+	otlpEndpoint      string
+	systemd           bool
+	pruneTestCache    bool
+	pinsFile          string
+	ignoreProcs       string
+	ignorePhases      string
+	usedModulesFile   string
+	manifestFile      string
+	mergeManifests    stringsFlag
+	fromManifest      string
+	noPrune           bool
+	sbomFile          string
+	usageHistory      string
+	keepListFile      string
+	keepDepsDirs      stringsFlag
+	explainPath       string
+	listInstances     bool
+	exportKeepList    string
+	keepThreshold     float64
+	tripwire          bool
+	quarantineDir     string
+	trashDir          string
+	lockTimeout       time.Duration
+	waitForGo         time.Duration
+	lowPriority       bool
+	restore           bool
+	statusInterval    time.Duration
+	cacheProgDir      string
+	proxyMonitor      bool
+	serveCacheProg    string
+	logFormat         string
+	buildkiteAnnotate bool
+	cacheKeyFile      string
+	logLevel          slog.Level
+	verbose           bool
+	fullDebug         bool
+	skipPruneOnFail   bool
+	command           []string
+	usePIDFile        bool
+	runtimeDir        string
+	instance          string
+	signalAction      signalFlag
+	pruneOnly         string
+	signalRestored    bool
+	signalStatus      bool
+	phase             string
 }
 
 func parseFlags() (*config, error) {
@@ -77,15 +195,121 @@ func parseFlags() (*config, error) {
 	)
 
 	flag.Usage = usage
+	flag.StringVar(&cfg.configFile, "config", "", "read options from YAML config `file`, options passed on the command line override it")
 	flag.StringVar(&cfg.moduleCache, "mod-cache", "", "path to Go module cache")
 	flag.StringVar(&cfg.buildCache, "build-cache", "", "path to Go build cache")
 	flag.BoolVar(&cfg.pruneModCache, "prune-mod-cache", true, "prune the Go module cache")
 	flag.BoolVar(&cfg.pruneBuildCache, "prune-build-cache", true, "prune the Go build cache")
+	flag.StringVar(&cfg.lintCache, "lint-cache", "", "also watch and prune the golangci-lint cache in `dir`, or where golangci-lint puts it if \""+defaultToolCache+"\"")
+	flag.StringVar(&cfg.staticcheckCache, "staticcheck-cache", "", "also watch and prune the staticcheck cache in `dir`, or where staticcheck puts it if \""+defaultToolCache+"\"")
+	flag.Var(&cfg.extraCacheFlags, "extra-cache", "also watch and prune the cache in `path[:type]`, recording usage per file if type is file (the default) or per directory if it is dir, can be passed multiple times")
+	flag.BoolVar(&cfg.readOnlyModCache, "mod-cache-read-only", false, "make kept module cache entries read-only after pruning, as the go command does without -modcacherw")
+	flag.BoolVar(&cfg.modCacheWritable, "mod-cache-writable", false, "don't make read-only module cache entries writable before deleting them, only if deleting them fails. Defaults to true if GOFLAGS contains -modcacherw")
+	flag.StringVar(&cfg.buildGranularity, "build-cache-granularity", fileGranularity, "`granularity` to record build cache usage at, either file or dir")
+	flag.StringVar(&cfg.mode, "mode", watchMode, "`mode` to record used entries in, either watch for accesses or atime to scan for entries accessed while running when pruning")
+	flag.StringVar(&cfg.watchBackend, "watch-backend", watch.NativeBackend, fmt.Sprintf("how to watch caches, one of %s", strings.Join(watch.Backends, ", ")))
+	flag.BoolVar(&cfg.raiseWatchLimit, "raise-watch-limit", false, "raise fs.inotify.max_user_watches if caches need more inotify watches than it allows, instead of polling them. Requires root (Linux only)")
+	flag.DurationVar(&cfg.waitForStable, "wait-for-path-stable", 0, "wait until caches are unchanged for `duration` before watching")
+	flag.DurationVar(&cfg.restoreWindow, "restore-window", 0, "treat cache events in the first `duration` of watching, or until -restore-done is used, as the cache being restored instead of used")
+	flag.DurationVar(&cfg.gracePeriod, "grace-period", 0, "keep entries modified or accessed in the last `duration` of watching even if they weren't recorded as used")
+	flag.DurationVar(&cfg.minResidency, "min-residency", 0, "never prune entries added to the cache in the last `duration`, even if they weren't used")
+	flag.StringVar(&cfg.syncDir, "sync-to", "", "after pruning, mirror the module and build caches to 'mod' and 'build' in `dir`, copying only changed files")
+	flag.StringVar(&cfg.reportFile, "report", "", "write a JSON report of what was pruned to `file`")
+	flag.BoolVar(&cfg.dryRun, "dry-run", false, "log what would be deleted from the caches instead of deleting it")
+	flag.DurationVar(&cfg.telemetryMaxAge, "telemetry-max-age", 0, "delete Go telemetry counter files and reports that weren't modified in the last `duration`")
+	flag.DurationVar(&cfg.maxAge, "max-age", 0, "don't watch caches, instead delete entries that weren't accessed or modified in the last `duration`")
+	flag.StringVar(&cfg.maxCacheSize, "max-cache-size", "", "only delete unused entries until each cache is at most `size`, e.g. 2GiB, least recently used first")
+	flag.StringVar(&cfg.verify, "verify", "", "after pruning, run `command`, either 'go build' or 'go install' with arguments or packages to build, and report whether it hit the caches")
+	flag.IntVar(&cfg.verifyMaxMisses, "verify-max-misses", 0, "warn if the -verify command downloads and compiles more than `n` modules and packages in total")
+	flag.StringVar(&cfg.watchMaxSize, "watch-max-size", "", "while watching, prune caches larger than `size` down to it, deleting entries that weren't used while watching or in the last -watch-prune-age")
+	flag.DurationVar(&cfg.watchPruneAge, "watch-prune-age", 24*time.Hour, "only delete entries that weren't accessed or modified in the last `duration` when pruning with -watch-max-size")
+	flag.BoolVar(&cfg.pruneFuzzCache, "prune-fuzz-cache", true, "prune the fuzz corpus cache in the fuzz dir of the build cache")
+	flag.StringVar(&cfg.maxFuzzSize, "max-fuzz-cache-size", "", "only delete unused fuzz corpus entries until the fuzz corpus cache is at most `size`, they don't count towards -max-cache-size then")
+	flag.IntVar(&cfg.concurrency, "concurrency", prune.DefaultConcurrency, "delete at most `n` entries at the same time across both caches")
+	flag.StringVar(&cfg.deleteRate, "delete-rate", "", "delete at most `rate` entries per second across both caches, or bytes per second if it has a size unit, e.g. 100 or 50MB")
+	flag.DurationVar(&cfg.deletePause, "delete-pause", 0, "pause for `duration` after starting to delete every -concurrency entries, so other processes can use the disk")
+	flag.Var(&cfg.pruneInclude, "prune-include", "only prune entries matching glob `pattern`, module paths in the module cache and paths relative to the build cache, can be passed multiple times")
+	flag.Var(&cfg.pruneExclude, "prune-exclude", "never prune entries matching glob `pattern`, can be passed multiple times")
+	flag.BoolVar(&cfg.pruneTestCache, "prune-test-cache", false, "don't watch caches, instead only delete cached test results from the build cache so tests run again")
+	flag.BoolVar(&cfg.keepToolchains, "keep-toolchains", false, "never delete Go toolchains downloaded to the module cache, even if they weren't run")
+	flag.BoolVar(&cfg.keepModMetadata, "keep-mod-metadata", false, "keep the downloaded .mod and .info files of deleted module versions, only deleting their zips and extracted files")
+	flag.BoolVar(&cfg.firstRunSafe, "first-run-safe", false, "only report what would be pruned the first time a cache is pruned, and prune it in later runs")
+	flag.DurationVar(&cfg.softDelete, "soft-delete", 0, "only mark unused entries for deletion, and delete them in a later run if they are still unused after `duration`")
+	flag.StringVar(&cfg.pinsFile, "pins", "", "`file` entries pinned with the pin control command are stored in (default pins.json in the user config dir)")
+	flag.StringVar(&cfg.protect, "protect", "", "comma separated `list` of module path patterns, as used by GOPRIVATE, that are never pruned")
+	flag.StringVar(&cfg.protectFile, "protect-file", "", "never prune modules matching the module path patterns in `file`, one per line")
+	flag.StringVar(&cfg.ignoreProcs, "ignore-procs", "", "comma separated `list` of process names whose cache accesses aren't usage, e.g. gopls")
+	flag.StringVar(&cfg.ignorePhases, "ignore-phases", "", "comma separated `list` of phases, entries only used during them aren't kept")
+	flag.StringVar(&cfg.usedModulesFile, "used-modules", "", "write used modules as module@version lines to `file`")
+	flag.StringVar(&cfg.manifestFile, "write-manifest", "", "write the used module and build cache entries to `file` as JSON")
+	flag.Var(&cfg.mergeManifests, "merge-manifest", "also keep entries in `file` written by -write-manifest, can be passed multiple times")
+	flag.StringVar(&cfg.fromManifest, "from-manifest", "", "don't watch caches, instead delete entries that aren't in `file` written by -write-manifest")
+	flag.BoolVar(&cfg.noPrune, "no-prune", false, "only record which entries were used, don't prune the module and build caches")
+	flag.StringVar(&cfg.usageHistory, "usage-history", "", "record which modules were used in each run in `file`")
+	flag.StringVar(&cfg.exportKeepList, "export-keep-list", "", "write modules used in at least -keep-list-threshold of the runs in -usage-history as module@version lines to `file`")
+	flag.Float64Var(&cfg.keepThreshold, "keep-list-threshold", 0.8, "`fraction` of runs a module must be used in to be exported by -export-keep-list")
+	flag.StringVar(&cfg.keepListFile, "keep-list", "", "never prune modules in `file` of module@version lines, as written by -used-modules or -export-keep-list")
+	flag.Var(&cfg.keepDepsDirs, "keep-from-deps", "never prune modules the module in `dir`, or every module of its workspace, depends on according to 'go list -m all', can be passed multiple times")
+	flag.StringVar(&cfg.explainPath, "explain", "", "print why the cache entry containing `path` would be kept or deleted and exit")
+	flag.StringVar(&cfg.sbomFile, "sbom", "", "report differences between used modules and modules in a SPDX or CycloneDX JSON SBOM `file`")
+	flag.BoolVar(&cfg.tripwire, "report-unexpected-writes", false, "report files created in the caches that the go command wouldn't create")
+	flag.StringVar(&cfg.quarantineDir, "quarantine-dir", "", "move invalid or corrupted build cache entries to `dir` instead of keeping them")
+	flag.BoolVar(&cfg.lowPriority, "low-priority", false, "lower the CPU and I/O priority of go-cache-prune before pruning, so pruning doesn't compete with builds running at the same time")
+	flag.DurationVar(&cfg.waitForGo, "wait-for-go", 0, "before pruning, wait at most `duration` for running go commands to exit, running go commands are only warned about if 0 (Linux only)")
+	flag.DurationVar(&cfg.lockTimeout, "lock-timeout", time.Minute, "wait at most `duration` for another process pruning a cache to finish before skipping the cache")
+	flag.StringVar(&cfg.trashDir, "trash-dir", "", "move unused entries to `dir` instead of deleting them so they can be restored, emptying it before the next prune after a successful command")
 	flag.BoolVar(&cfg.usePIDFile, "pid-file", false, "create a PID file")
-	flag.BoolVar(&cfg.signalProc, "signal", false, "signal a running go-cache-prune to start pruning")
+	flag.StringVar(&cfg.runtimeDir, "runtime-dir", "", "`dir` to create the PID file, control socket and other runtime files in (default $XDG_RUNTIME_DIR, or the temporary dir if unset)")
+	flag.StringVar(&cfg.instance, "instance", "", "`name` of this go-cache-prune instance, so multiple instances watching different caches can run at once with their own PID file and control socket; -signal and other commands only reach the instance with the same name")
+	flag.BoolVar(&cfg.daemon, "daemon", false, "watch caches until stopped, pruning them whenever the prune command is sent with -ctl or SIGHUP is received")
+	flag.BoolVar(&cfg.systemd, "systemd", false, "run as a systemd Type=notify service: notify systemd once caches are being watched, send watchdog keep-alives and prune caches before exiting on SIGTERM")
+	flag.StringVar(&cfg.listenAddr, "listen", "", "serve the current watch stats and the result of the last prune as JSON over HTTP at /status on `addr`, e.g. :9090")
+	flag.StringVar(&cfg.metricsFile, "metrics-textfile", "", "write Prometheus metrics to `file` after pruning, for the node_exporter textfile collector")
+	flag.StringVar(&cfg.otlpEndpoint, "otlp-endpoint", "", "export spans and metrics of watching and pruning to the OpenTelemetry collector at OTLP/HTTP `url` (default $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	flag.StringVar(&cfg.ctlCommand, "ctl", "", "send `command` to a running go-cache-prune daemon and print its output: status, prune, dry-run, reset or stop")
+	flag.Var(&cfg.signalAction, "signal", "signal a running go-cache-prune to start pruning, or with -signal=`action` to do action: prune, abort to shut down without pruning, or status to log how watching is going")
+	flag.BoolVar(&cfg.listInstances, "ps", false, "list the running go-cache-prune processes and the caches they are watching and exit")
+	flag.StringVar(&cfg.pruneOnly, "only", "", "with -signal, only prune the `cache`, either mod or build, and keep watching the other one")
+	flag.BoolVar(&cfg.signalRestored, "restore-done", false, "signal a running go-cache-prune that caches have been restored")
+	flag.BoolVar(&cfg.signalStatus, "status", false, "make a running go-cache-prune log how many events it processed, entries it recorded as used, watches and memory it uses, as it also does on SIGUSR1")
+	flag.StringVar(&cfg.phase, "phase", "", "tell a running go-cache-prune that the `name`d phase has started")
+	flag.BoolVar(&cfg.skipPruneOnFail, "skip-prune-on-failure", false, "don't prune caches if the passed command fails")
+	flag.StringVar(&cfg.cacheProgDir, "cacheprog", "", "serve the build cache to the command from `dir` with GOCACHEPROG and record exactly which entries are used instead of watching it, requires Go 1.24+")
+	flag.BoolVar(&cfg.proxyMonitor, "proxy-monitor", false, "experimental: serve GOPROXY to the command from a local proxy in front of the configured proxies, and keep modules downloaded through it even if watching missed them")
+	flag.StringVar(&cfg.serveCacheProg, "serve-cacheprog", "", "serve the GOCACHEPROG protocol over stdin and stdout storing entries in `dir`, used by -cacheprog")
+	flag.DurationVar(&cfg.statusInterval, "status-interval", 0, "log a line summarizing watching every `duration`")
+	flag.StringVar(&cfg.cacheKeyFile, "cache-key-file", "", "after pruning, write a checksum of the modules kept in the module cache to `file`, for use in CI cache keys such as CircleCI's {{ checksum }}")
+	flag.BoolVar(&cfg.buildkiteAnnotate, "buildkite-annotate", false, "annotate the Buildkite build with a summary of what was pruned using buildkite-agent")
+	flag.StringVar(&cfg.logFormat, "log-format", "", "`format` to log in, one of "+strings.Join(logFormats, ", ")+" (default the format of the CI system being run in, or text)")
+	flag.TextVar(&cfg.logLevel, "log-level", slog.LevelInfo, "only log messages of at least `level`, one of debug, info, warn or error")
+	flag.BoolVar(&cfg.verbose, "v", false, "log debug messages, the same as -log-level=debug")
+	flag.BoolVar(&cfg.fullDebug, "full-debug", false, "log every debug message instead of a sample of frequent ones")
 	flag.BoolVar(&printVersion, "version", false, "print version and build information and exit")
 	flag.Parse()
 
+	if err := loadEnv(flag.CommandLine, os.Getenv); err != nil {
+		return nil, err
+	}
+	if cfg.configFile != "" {
+		if err := loadConfigFile(cfg.configFile, flag.CommandLine); err != nil {
+			return nil, err
+		}
+	}
+	cfg.command = flag.Args()
+	// a command named restore can still be run after --
+	if len(cfg.command) == 1 && cfg.command[0] == restoreCommand && !slices.Contains(os.Args[1:], "--") {
+		cfg.restore = true
+		cfg.command = nil
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "build-cache-granularity":
+			cfg.granularitySet = true
+		case "mod-cache-writable":
+			cfg.writableSet = true
+		}
+	})
+
 	info, ok := debug.ReadBuildInfo()
 	if !ok {
 		return nil, errors.New("build information not found")
@@ -95,16 +319,260 @@ func parseFlags() (*config, error) {
 		printVersionInfo(info)
 		return nil, errJustExit(0)
 	}
+	if cfg.runtimeDir == "" {
+		cfg.runtimeDir = defaultRuntimeDir()
+	}
+	if cfg.pinsFile == "" {
+		cfg.pinsFile = defaultPinsFile()
+	}
+	if cfg.instance != "" && !instanceNameRe.MatchString(cfg.instance) {
+		return nil, errors.New("-instance must start with a letter or digit and only contain letters, digits, '.', '_' and '-'")
+	}
 
+	var clientActions int
+	for _, set := range []bool{cfg.signalAction != "", cfg.signalStatus, cfg.signalRestored, cfg.phase != "", cfg.ctlCommand != ""} {
+		if set {
+			clientActions++
+		}
+	}
+	if clientActions > 1 {
+		return nil, errors.New("only one of -signal, -status, -restore-done, -phase or -ctl can be set")
+	}
+	if clientActions > 0 && len(cfg.command) != 0 {
+		return nil, errors.New("a command can't be passed with -signal, -status, -restore-done, -phase or -ctl")
+	}
+	if cfg.ctlCommand != "" && !slices.Contains([]string{"status", daemonPrune, daemonDryRun, daemonReset, daemonStop}, cfg.ctlCommand) {
+		return nil, fmt.Errorf("-ctl must be one of status, %s, %s, %s or %s", daemonPrune, daemonDryRun, daemonReset, daemonStop)
+	}
+	if cfg.listInstances && (len(cfg.command) != 0 || clientActions > 0 || cfg.explainPath != "") {
+		return nil, errors.New("a command, -signal, -status, -restore-done, -phase or -explain can't be passed with -ps")
+	}
+	if cfg.explainPath != "" && (len(cfg.command) != 0 || clientActions > 0) {
+		return nil, errors.New("a command, -signal, -status, -restore-done or -phase can't be passed with -explain")
+	}
+	if cfg.restore && (clientActions > 0 || cfg.listInstances || cfg.explainPath != "" || cfg.daemon || cfg.dryRun) {
+		return nil, errors.New("-signal, -status, -restore-done, -phase, -ctl, -ps, -explain, -daemon and -dry-run can't be passed with restore")
+	}
+	if cfg.restore && cfg.trashDir == "" {
+		return nil, errors.New("restore requires -trash-dir to be set")
+	}
+	if cfg.pruneOnly != "" && cfg.signalAction != signalPrune {
+		return nil, errors.New("-only requires -signal or -signal=prune to be set")
+	}
+	if cfg.pruneOnly != "" && cfg.pruneOnly != modCacheOnly && cfg.pruneOnly != buildCacheOnly {
+		return nil, fmt.Errorf("-only must be %q or %q", modCacheOnly, buildCacheOnly)
+	}
+	if cfg.skipPruneOnFail && len(cfg.command) == 0 {
+		return nil, errors.New("-skip-prune-on-failure requires a command to be passed")
+	}
+	if cfg.cacheProgDir != "" && len(cfg.command) == 0 {
+		return nil, errors.New("-cacheprog requires a command to be passed")
+	}
+	if cfg.cacheProgDir != "" && (!cfg.pruneBuildCache || cfg.buildCache != "") {
+		return nil, errors.New("-build-cache must be unset and -prune-build-cache must be true when -cacheprog is set")
+	}
+	if cfg.proxyMonitor && (len(cfg.command) == 0 || !cfg.pruneModCache) {
+		return nil, errors.New("-proxy-monitor requires a command to be passed and -prune-mod-cache to be true")
+	}
 	if !cfg.pruneModCache && !cfg.pruneBuildCache {
 		return nil, errors.New("either -prune-mod-cache or -prune-build-cache must be true")
 	}
 	if !cfg.pruneModCache && cfg.moduleCache != "" {
 		return nil, errors.New("-mod-cache must be unset when -prune-mod-cache is false")
 	}
+	if !cfg.pruneModCache && cfg.usedModulesFile != "" {
+		return nil, errors.New("-used-modules must be unset when -prune-mod-cache is false")
+	}
+	if !cfg.pruneModCache && (cfg.usageHistory != "" || cfg.keepListFile != "" || len(cfg.keepDepsDirs) != 0) {
+		return nil, errors.New("-usage-history, -keep-list and -keep-from-deps must be unset when -prune-mod-cache is false")
+	}
+	if cfg.exportKeepList != "" && cfg.usageHistory == "" {
+		return nil, errors.New("-export-keep-list requires -usage-history to be set")
+	}
+	if cfg.keepThreshold <= 0 || cfg.keepThreshold > 1 {
+		return nil, errors.New("-keep-list-threshold must be greater than 0 and at most 1")
+	}
+	if !cfg.pruneModCache && cfg.cacheKeyFile != "" {
+		return nil, errors.New("-cache-key-file must be unset when -prune-mod-cache is false")
+	}
+	if !cfg.pruneModCache && cfg.sbomFile != "" {
+		return nil, errors.New("-sbom must be unset when -prune-mod-cache is false")
+	}
+	if !cfg.pruneModCache && cfg.readOnlyModCache {
+		return nil, errors.New("-mod-cache-read-only must be unset when -prune-mod-cache is false")
+	}
+	if !cfg.pruneModCache && cfg.modCacheWritable {
+		return nil, errors.New("-mod-cache-writable must be unset when -prune-mod-cache is false")
+	}
 	if !cfg.pruneBuildCache && cfg.buildCache != "" {
 		return nil, errors.New("-build-cache must be unset when -prune-build-cache is false")
 	}
+	if cfg.mode != watchMode && cfg.mode != atimeMode {
+		return nil, fmt.Errorf("-mode must be %q or %q", watchMode, atimeMode)
+	}
+	if cfg.mode == atimeMode && cfg.watchBackend != watch.NativeBackend {
+		return nil, errors.New("-watch-backend must be unset when -mode is atime")
+	}
+	if !slices.Contains(watch.Backends, cfg.watchBackend) {
+		return nil, fmt.Errorf("-watch-backend must be one of %s", strings.Join(watch.Backends, ", "))
+	}
+	if cfg.raiseWatchLimit && (cfg.mode == atimeMode || cfg.watchBackend != watch.NativeBackend) {
+		return nil, errors.New("-raise-watch-limit must be unset when -mode is atime or -watch-backend is set")
+	}
+	if cfg.syncDir != "" && cfg.dryRun {
+		return nil, errors.New("-sync-to must be unset when -dry-run is set")
+	}
+	if cfg.telemetryMaxAge < 0 {
+		return nil, errors.New("-telemetry-max-age must not be negative")
+	}
+	if cfg.softDelete < 0 {
+		return nil, errors.New("-soft-delete must not be negative")
+	}
+	if cfg.maxAge < 0 {
+		return nil, errors.New("-max-age must not be negative")
+	}
+	if cfg.lockTimeout < 0 {
+		return nil, errors.New("-lock-timeout must not be negative")
+	}
+	if cfg.waitForGo < 0 {
+		return nil, errors.New("-wait-for-go must not be negative")
+	}
+	// caches aren't watched with these flags
+	if cfg.pruneTestCache && (cfg.maxAge > 0 || cfg.fromManifest != "" || !cfg.pruneBuildCache) {
+		return nil, errors.New("-prune-test-cache requires -prune-build-cache to be true and -max-age and -from-manifest to be unset")
+	}
+	for _, s := range cfg.extraCacheFlags {
+		extra, err := parseExtraCache(s)
+		if err != nil {
+			return nil, err
+		}
+		cfg.extraCaches = append(cfg.extraCaches, extra)
+	}
+	if (cfg.lintCache != "" || cfg.staticcheckCache != "" || len(cfg.extraCaches) != 0) && (cfg.pruneTestCache || cfg.fromManifest != "") {
+		return nil, errors.New("-lint-cache, -staticcheck-cache and -extra-cache must be unset when -prune-test-cache or -from-manifest is set")
+	}
+	var noWatchFlag string
+	switch {
+	case cfg.pruneTestCache:
+		noWatchFlag = "-prune-test-cache"
+	case cfg.fromManifest != "":
+		noWatchFlag = "-from-manifest"
+	case cfg.maxAge > 0:
+		noWatchFlag = "-max-age"
+	}
+	if noWatchFlag != "" && (len(cfg.command) != 0 || clientActions > 0) {
+		return nil, fmt.Errorf("a command, -signal, -status, -restore-done or -phase can't be passed with %s", noWatchFlag)
+	}
+	if cfg.daemon && (len(cfg.command) != 0 || clientActions > 0 || noWatchFlag != "") {
+		return nil, errors.New("a command, -signal, -status, -restore-done, -phase, -ctl, -max-age, -from-manifest or -prune-test-cache can't be passed with -daemon")
+	}
+	if cfg.daemon && (cfg.cacheProgDir != "" || cfg.proxyMonitor || cfg.lintCache != "" || cfg.staticcheckCache != "" || len(cfg.extraCaches) != 0 || cfg.usePIDFile || cfg.firstRunSafe) {
+		return nil, errors.New("-cacheprog, -proxy-monitor, -lint-cache, -staticcheck-cache, -extra-cache, -pid-file and -first-run-safe must be unset when -daemon is set")
+	}
+	if cfg.daemon && (cfg.manifestFile != "" || len(cfg.mergeManifests) != 0 || cfg.usedModulesFile != "" || cfg.usageHistory != "" || cfg.sbomFile != "" || cfg.syncDir != "" || cfg.noPrune) {
+		return nil, errors.New("-write-manifest, -merge-manifest, -used-modules, -usage-history, -sbom, -sync-to and -no-prune must be unset when -daemon is set")
+	}
+	if noWatchFlag != "" && (cfg.gracePeriod != 0 || cfg.usedModulesFile != "" || cfg.sbomFile != "" || cfg.usageHistory != "") {
+		return nil, fmt.Errorf("-grace-period, -used-modules, -sbom and -usage-history must be unset when %s is set", noWatchFlag)
+	}
+	if cfg.noPrune && cfg.manifestFile == "" && cfg.usedModulesFile == "" && cfg.usageHistory == "" {
+		return nil, errors.New("-no-prune requires -write-manifest, -used-modules or -usage-history to be set")
+	}
+	if noWatchFlag != "" && (cfg.manifestFile != "" || cfg.noPrune) {
+		return nil, fmt.Errorf("-write-manifest and -no-prune must be unset when %s is set", noWatchFlag)
+	}
+	for _, patterns := range [][]string{cfg.pruneInclude, cfg.pruneExclude} {
+		for _, pattern := range patterns {
+			if _, err := prune.MatchGlob(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	if !cfg.pruneModCache && (cfg.protect != "" || cfg.protectFile != "" || cfg.keepModMetadata || cfg.keepToolchains) {
+		return nil, errors.New("-protect, -protect-file, -keep-mod-metadata and -keep-toolchains must be unset when -prune-mod-cache is false")
+	}
+	for _, pattern := range parseList(cfg.protect) {
+		if err := checkModulePattern(pattern); err != nil {
+			return nil, fmt.Errorf("-protect: %w", err)
+		}
+		cfg.protected = append(cfg.protected, pattern)
+	}
+	if cfg.protectFile != "" {
+		patterns, err := readProtectFile(cfg.protectFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.protected = append(cfg.protected, patterns...)
+	}
+	if cfg.concurrency < 1 {
+		return nil, errors.New("-concurrency must be at least 1")
+	}
+	if cfg.deleteRate != "" {
+		var err error
+		cfg.deleteEntryRate, cfg.deleteBytesRate, err = parseDeleteRate(cfg.deleteRate)
+		if err != nil {
+			return nil, fmt.Errorf("-delete-rate: %w", err)
+		}
+	}
+	if cfg.deletePause < 0 {
+		return nil, errors.New("-delete-pause must not be negative")
+	}
+	if cfg.maxCacheSize != "" {
+		var err error
+		cfg.maxCacheBytes, err = bytesize.Parse(cfg.maxCacheSize)
+		if err != nil {
+			return nil, fmt.Errorf("-max-cache-size: %w", err)
+		}
+		if cfg.maxCacheBytes == 0 {
+			return nil, errors.New("-max-cache-size must be greater than 0")
+		}
+	}
+	if cfg.verify != "" {
+		if cfg.daemon || cfg.noPrune {
+			return nil, errors.New("-verify must be unset when -daemon or -no-prune is set")
+		}
+		if _, err := verifyArgs(cfg.verify); err != nil {
+			return nil, fmt.Errorf("-verify: %w", err)
+		}
+	}
+	if cfg.verifyMaxMisses < 0 {
+		return nil, errors.New("-verify-max-misses must not be negative")
+	}
+	if cfg.watchMaxSize != "" {
+		if noWatchFlag != "" || cfg.daemon || cfg.mode == atimeMode {
+			return nil, errors.New("-watch-max-size must be unset when -max-age, -from-manifest, -prune-test-cache or -daemon is set or -mode is atime")
+		}
+		if cfg.noPrune || cfg.dryRun {
+			return nil, errors.New("-watch-max-size must be unset when -no-prune or -dry-run is set")
+		}
+		var err error
+		cfg.watchMaxBytes, err = bytesize.Parse(cfg.watchMaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("-watch-max-size: %w", err)
+		}
+		if cfg.watchMaxBytes == 0 {
+			return nil, errors.New("-watch-max-size must be greater than 0")
+		}
+		if cfg.watchPruneAge <= 0 {
+			return nil, errors.New("-watch-prune-age must be greater than 0")
+		}
+	}
+	if cfg.maxFuzzSize != "" {
+		if !cfg.pruneBuildCache || !cfg.pruneFuzzCache {
+			return nil, errors.New("-max-fuzz-cache-size must be unset when -prune-build-cache or -prune-fuzz-cache is false")
+		}
+		var err error
+		cfg.maxFuzzBytes, err = bytesize.Parse(cfg.maxFuzzSize)
+		if err != nil {
+			return nil, fmt.Errorf("-max-fuzz-cache-size: %w", err)
+		}
+		if cfg.maxFuzzBytes == 0 {
+			return nil, errors.New("-max-fuzz-cache-size must be greater than 0")
+		}
+	}
+	if cfg.buildGranularity != fileGranularity && cfg.buildGranularity != dirGranularity {
+		return nil, fmt.Errorf("-build-cache-granularity must be %q or %q", fileGranularity, dirGranularity)
+	}
 
 	for _, buildSetting := range info.Settings {
 		if buildSetting.Key == "vcs.revision" {
@@ -116,6 +584,64 @@ func parseFlags() (*config, error) {
 	return &cfg, nil
 }
 
+// caches -only can restrict pruning to
+const (
+	modCacheOnly   = "mod"
+	buildCacheOnly = "build"
+)
+
+// modes used entries can be recorded in
+const (
+	watchMode = "watch"
+	atimeMode = "atime"
+)
+
+// granularities that build cache usage can be recorded at
+const (
+	fileGranularity = "file"
+	dirGranularity  = "dir"
+)
+
+type setting struct {
+	name  string
+	value string
+}
+
+// settings returns the resolved value of every flag in the order they
+// are printed by -help.
+func (c *config) settings() []setting {
+	resolved := map[string]string{
+		"mod-cache":   c.moduleCache,
+		"build-cache": c.buildCache,
+		"runtime-dir": c.runtimeDir,
+	}
+
+	var settings []setting
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if v, ok := resolved[f.Name]; ok {
+			value = v
+		}
+		settings = append(settings, setting{name: f.Name, value: value})
+	})
+
+	return settings
+}
+
+// logConfig logs the fully resolved configuration in one block.
+func logConfig(cfg *config) {
+	var sb strings.Builder
+	tw := tabwriter.NewWriter(&sb, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "version:\t%s\n", version)
+	fmt.Fprintf(tw, "commit:\t%s\n", cfg.commit)
+	for _, s := range cfg.settings() {
+		fmt.Fprintf(tw, "%s:\t%s\n", s.name, s.value)
+	}
+	tw.Flush()
+
+	log.Infof("configuration:\n%s", strings.TrimSuffix(sb.String(), "\n"))
+}
+
 type errJustExit int
 
 func (e errJustExit) Error() string { return fmt.Sprintf("exit: %d", e) }
@@ -125,39 +651,69 @@ func mainErr() error {
 	if err != nil {
 		return err
 	}
+	if cfg.verbose {
+		cfg.logLevel = slog.LevelDebug
+	}
+	if err := setupLogging(cfg.logFormat, cfg.logLevel); err != nil {
+		return err
+	}
+	debuglog.SetFull(cfg.fullDebug)
 
-	// signal a running go-cache-prune process if necessary
-	pidFile := filepath.Join(os.TempDir(), pidFilename)
-	if cfg.signalProc {
-		pidBytes, err := os.ReadFile(pidFile)
-		if err != nil {
-			return fmt.Errorf("reading PID file: %w", err)
-		}
-		pid, err := strconv.Atoi(string(pidBytes))
-		if err != nil {
-			return fmt.Errorf("parsing PID from PID file: %w", err)
-		}
-
-		p, _ := os.FindProcess(pid) // always succeeds for Unix systems
-		if err := p.Signal(unix.SIGHUP); err != nil {
-			return fmt.Errorf("signaling go-cache-prune process: %w", err)
+	// the go command is talking to us over stdout, so don't log there
+	if cfg.serveCacheProg != "" {
+		if err := serveCacheProg(cfg.serveCacheProg, os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "go-cache-prune: %v\n", err)
+			return errJustExit(1)
 		}
+		return nil
+	}
 
-		if _, err := p.Wait(); err != nil {
-			return fmt.Errorf("waiting for signaling go-cache-prune process to complete: %w", err)
+	// signal a running go-cache-prune process if necessary
+	pidFile, controlSocket := runtimePaths(cfg.runtimeDir, cfg.instance)
+	if cfg.phase != "" {
+		_, err := sendControlCommand(controlSocket, "phase", cfg.phase)
+		return err
+	}
+	if cfg.signalRestored {
+		return requestRestoreDone(pidFile, controlSocket)
+	}
+	if cfg.signalStatus || cfg.signalAction == signalStatus {
+		return requestStatus(pidFile, controlSocket)
+	}
+	if cfg.signalAction == signalAbort {
+		return requestAbort(pidFile, controlSocket)
+	}
+	if cfg.signalAction == signalPrune {
+		if cfg.pruneOnly != "" {
+			_, err := sendControlCommand(controlSocket, "prune", "--only="+cfg.pruneOnly)
+			return err
 		}
+		return requestPrune(pidFile, controlSocket)
+	}
 
-		return nil
+	if cfg.ctlCommand != "" {
+		out, err := sendControlCommand(controlSocket, cfg.ctlCommand)
+		fmt.Print(out)
+		return err
+	}
+	if cfg.listInstances {
+		return printInstances(os.Stdout, cfg.runtimeDir, controlSocket)
 	}
 
-	if cfg.usePIDFile {
-		if _, err := os.Stat(pidFile); err == nil {
-			return errors.New("go-cache-prune is already running")
-		}
+	adaptToMemoryLimit(cfg)
+
+	if err := os.MkdirAll(cfg.runtimeDir, 0o700); err != nil {
+		return fmt.Errorf("creating runtime dir: %w", err)
+	}
+	// clean up after previous go-cache-prune processes that crashed
+	if err := cleanStaleState(cfg.runtimeDir, pidFile, cfg.usePIDFile); err != nil {
+		return err
 	}
 
-	mainCtx, mainCancel := signal.NotifyContext(context.Background(), os.Interrupt, unix.SIGTERM)
+	terminateSigs, pruneSigs := serviceSignals(cfg)
+	mainCtx, mainCancel := signal.NotifyContext(logging.NewContext(context.Background(), log), terminateSigs...)
 	defer mainCancel()
+	go runWatchdog(mainCtx, cfg)
 
 	// if the caches weren't explicitly passed, get them
 	if cfg.pruneModCache && cfg.moduleCache == "" {
@@ -166,13 +722,184 @@ func mainErr() error {
 			return fmt.Errorf("getting GOMODCACHE: %w", err)
 		}
 	}
-	if cfg.pruneBuildCache && cfg.buildCache == "" {
+	if cfg.pruneModCache && !cfg.writableSet {
+		goFlags, err := getGoEnv(mainCtx, "GOFLAGS")
+		if err != nil {
+			return fmt.Errorf("getting GOFLAGS: %w", err)
+		}
+		cfg.modCacheWritable = modCacheRW(goFlags)
+		if cfg.modCacheWritable {
+			log.Debugf("GOFLAGS contains -modcacherw, not making module cache entries writable before deleting them")
+		}
+	}
+	if cfg.cacheProgDir != "" {
+		cfg.buildCache, err = filepath.Abs(cfg.cacheProgDir)
+		if err != nil {
+			return fmt.Errorf("getting absolute path of -cacheprog dir: %w", err)
+		}
+	} else if cfg.pruneBuildCache && cfg.buildCache == "" {
 		cfg.buildCache, err = getGoEnv(mainCtx, "GOCACHE")
 		if err != nil {
 			return fmt.Errorf("getting GOCACHE: %w", err)
 		}
 	}
 
+	if cfg.explainPath != "" {
+		return explainEntry(mainCtx, cfg)
+	}
+	if cfg.lintCache != "" {
+		cfg.lintCache, err = toolCacheDir(cfg.lintCache, "GOLANGCI_LINT_CACHE", "golangci-lint")
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.staticcheckCache != "" {
+		cfg.staticcheckCache, err = toolCacheDir(cfg.staticcheckCache, "STATICCHECK_CACHE", "staticcheck")
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.restore {
+		return restoreCaches(mainCtx, cfg)
+	}
+
+	var caches []string
+	if cfg.moduleCache != "" {
+		caches = append(caches, cfg.moduleCache)
+	}
+	if cfg.buildCache != "" {
+		caches = append(caches, cfg.buildCache)
+	}
+	for _, c := range toolCaches(cfg) {
+		caches = append(caches, c.dir)
+	}
+	if err := checkCachesNotWatched(cfg.runtimeDir, caches); err != nil {
+		return err
+	}
+	unregister, err := registerInstance(cfg.runtimeDir, cfg.instance, controlSocket, caches)
+	if err != nil {
+		return err
+	}
+	defer unregister()
+
+	var newCaches []string
+	if cfg.firstRunSafe {
+		newCaches = firstRunCaches(caches)
+		if len(newCaches) != 0 && !cfg.dryRun {
+			log.Infof("first run against %q, only reporting what would be pruned", newCaches)
+			cfg.dryRun = true
+		}
+	}
+
+	tracer, err := newTracing(cfg, clock.System.Now())
+	if err != nil {
+		return err
+	}
+	status := &runStatus{metricsFile: cfg.metricsFile, tracing: tracer}
+	stopStatusSignals := status.logStatusOnSignal()
+	defer stopStatusSignals()
+	if cfg.listenAddr != "" {
+		stopStatus, err := serveStatus(cfg.listenAddr, status)
+		if err != nil {
+			return err
+		}
+		defer stopStatus()
+	}
+	if cfg.daemon {
+		return runDaemon(mainCtx, cfg, controlSocket, status)
+	}
+
+	// stop watching on SIGHUP or when the prune control command is sent
+	watchCtx, watchCancel := notifyContext(mainCtx, pruneSigs...)
+	defer watchCancel()
+	// each cache can be pruned on its own while the other one is still
+	// being watched
+	modWatchCtx, modWatchCancel := context.WithCancel(watchCtx)
+	defer modWatchCancel()
+	buildWatchCtx, buildWatchCancel := context.WithCancel(watchCtx)
+	defer buildWatchCancel()
+
+	watchPhases := cache.NewPhases()
+	watchStats := new(watch.Stats)
+	pinned := &pins{path: cfg.pinsFile}
+	restoreTrigger := make(chan struct{}, 1)
+	stopControl, err := serveControl(controlSocket, map[string]controlHandler{
+		"prune": func(args []string) (string, error) {
+			switch {
+			case len(args) == 0:
+				watchCancel()
+			case len(args) == 1 && args[0] == "--only="+modCacheOnly:
+				modWatchCancel()
+			case len(args) == 1 && args[0] == "--only="+buildCacheOnly:
+				buildWatchCancel()
+			default:
+				return "", errors.New("usage: prune [--only=mod|build]")
+			}
+			return "", nil
+		},
+		"restore-done": func([]string) (string, error) {
+			select {
+			case restoreTrigger <- struct{}{}:
+			default:
+			}
+			return "", nil
+		},
+		"phase": func(args []string) (string, error) {
+			if len(args) != 1 {
+				return "", errors.New("usage: phase <name>")
+			}
+			if err := watchPhases.Start(args[0]); err != nil {
+				return "", err
+			}
+			log.Infof("started phase %q", args[0])
+			return "", nil
+		},
+		"pin": func(args []string) (string, error) {
+			if len(args) != 1 {
+				return "", errors.New("usage: pin <path|module@version>")
+			}
+			if err := checkPin(args[0], cfg.moduleCache, cfg.buildCache); err != nil {
+				return "", err
+			}
+			if err := pinned.pin(args[0]); err != nil {
+				return "", err
+			}
+			log.Infof("pinned %q", args[0])
+			return "", nil
+		},
+		"unpin": func(args []string) (string, error) {
+			if len(args) != 1 {
+				return "", errors.New("usage: unpin <path|module@version>")
+			}
+			if err := pinned.unpin(args[0]); err != nil {
+				return "", err
+			}
+			log.Infof("unpinned %q", args[0])
+			return "", nil
+		},
+		"pins": func([]string) (string, error) {
+			entries, err := pinned.read()
+			if err != nil {
+				return "", err
+			}
+			var sb strings.Builder
+			for _, entry := range entries {
+				sb.WriteString(entry + "\n")
+			}
+			return sb.String(), nil
+		},
+		"stats":          status.controlHandler(),
+		logStatusCommand: status.logStatusHandler(),
+		abortCommand: func([]string) (string, error) {
+			mainCancel()
+			return "", nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer stopControl()
+
 	if cfg.usePIDFile {
 		// create PID file
 		pidBytes := []byte(strconv.Itoa(os.Getpid()))
@@ -183,284 +910,930 @@ func mainErr() error {
 		defer os.Remove(pidFile)
 	}
 
-	// stop watching on SIGHUP
-	watchCtx, watchCancel := signal.NotifyContext(mainCtx, unix.SIGHUP)
-	defer watchCancel()
+	log.Infof("starting %s version=%s commit=%s", projectName, version, cfg.commit)
+	logConfig(cfg)
 
-	actions.Infof("starting %s version=%s commit=%s", projectName, version, cfg.commit)
+	clk := clock.System
+	status.watching(watchStats, clk.Now())
+	if cfg.waitForStable > 0 {
+		// don't record caches being restored as cache usage
+		log.Infof("waiting for caches to be unchanged for %s", cfg.waitForStable)
+		waitForStableDirs(watchCtx, clk, cfg.waitForStable, cfg.moduleCache, cfg.buildCache)
+	}
 
-	modFiles, buildFiles, err := watchCaches(watchCtx, cfg.moduleCache, cfg.buildCache)
+	dirLevel := cfg.buildGranularity == dirGranularity
+	manifestFiles := cfg.mergeManifests
+	if cfg.fromManifest != "" {
+		manifestFiles = append([]string{cfg.fromManifest}, manifestFiles...)
+	}
+	manifests, err := readUsedManifests(manifestFiles, dirLevel)
 	if err != nil {
-		return fmt.Errorf("watching caches: %w", err)
+		return err
 	}
-	actions.EndGroup()
-
-	if mainCtx.Err() != nil {
-		actions.Infof("signal received, shutting down without pruning caches")
-		return errJustExit(2)
+	watchOpts := watch.Options{
+		Backend:         cfg.watchBackend,
+		Scan:            cfg.mode == atimeMode,
+		RestoreDone:     waitForRestore(watchCtx, clk, cfg.restoreWindow, restoreTrigger),
+		IgnoredProcs:    watch.ParseProcNames(cfg.ignoreProcs),
+		Phases:          watchPhases,
+		Stats:           watchStats,
+		Clock:           clk,
+		RaiseWatchLimit: cfg.raiseWatchLimit,
 	}
-
-	if len(modFiles) == 0 && len(buildFiles) == 0 {
-		actions.Infof("no cached files were used, nothing to do")
-		return errJustExit(2)
+	if cfg.tripwire {
+		watchOpts.UnexpectedWrites = new(watch.UnexpectedWrites)
 	}
+	if cfg.statusInterval > 0 {
+		go watchStats.Log(watchCtx, clk, cfg.statusInterval)
+	}
+	// when serving the build cache to the command it doesn't need to be
+	// watched, usage is recorded by the GOCACHEPROG processes
+	watchBuildCache := cfg.buildCache
+	var cmdEnv []string
+	if cfg.cacheProgDir != "" {
+		watchBuildCache = ""
+		if err := resetCacheProgUsage(cfg.buildCache); err != nil {
+			return fmt.Errorf("resetting build cache usage: %w", err)
+		}
+		cacheProg, err := cacheProgCommand(cfg.buildCache)
+		if err != nil {
+			return err
+		}
+		cmdEnv = append(cmdEnv, "GOCACHEPROG="+cacheProg)
+	}
+	var monitor *goproxy.Monitor
+	if cfg.proxyMonitor {
+		proxyCtx, proxyCancel := context.WithCancel(mainCtx)
+		defer proxyCancel()
 
-	pruneCaches(cfg.moduleCache, cfg.buildCache, modFiles, buildFiles)
-
-	return nil
-}
+		var cmdProxy string
+		monitor, cmdProxy, err = startProxyMonitor(proxyCtx)
+		if err != nil {
+			return err
+		}
+		cmdEnv = append(cmdEnv, "GOPROXY="+cmdProxy)
+	}
 
-func getGoEnv(ctx context.Context, name string) (string, error) {
-	cmd := exec.CommandContext(ctx, "go", "env", name)
-	out, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("running %s: %w", cmd, err)
+	newPruneOptions := func(watchEnd time.Time) prune.Options {
+		return pruneOptions(cfg, clk, watchEnd)
 	}
-	if len(out) < 1 {
-		return "", fmt.Errorf("'go env' output is too short: %v", out)
+	report := pruneReport{DryRun: cfg.dryRun}
+	if cfg.telemetryMaxAge > 0 {
+		if err := pruneTelemetry(mainCtx, cfg, newPruneOptions(clk.Now()), &report); err != nil {
+			return err
+		}
 	}
 
-	// trim ending newline
-	return string(out[:len(out)-1]), nil
-}
-
-type usedCacheFiles map[string]struct{}
+	if cfg.pruneTestCache {
+		lowerPriority(cfg)
+		if err := emptyTrash(cfg); err != nil {
+			return err
+		}
+		checkGoCommands(mainCtx, cfg, clk)
+		opts := newPruneOptions(clk.Now())
+		if opts.TrashDir != "" {
+			opts.TrashDir = filepath.Join(opts.TrashDir, "build")
+		}
+		pruneStart := clk.Now()
+		res := prune.TestResults(mainCtx, cfg.buildCache, opts)
+		if cfg.dryRun {
+			log.Infof("would delete %d cached test result files from build cache, freeing %s", res.Deleted, bytesize.Format(res.BytesFreed))
+		} else {
+			log.Infof("deleted %d cached test result files from build cache, freed %s", res.Deleted, bytesize.Format(res.BytesFreed))
+		}
+		report.addCaches("", cfg.buildCache, prune.Result{}, res)
+		if err := report.publish(cfg); err != nil {
+			return err
+		}
+		status.pruned(&report, pruneStart, clk.Now())
+		if res.Interrupted {
+			log.Infof("signal received, stopped pruning caches early")
+			return errJustExit(2)
+		}
+		markFirstRunDone(newCaches, clk.Now())
+		verifyCaches(mainCtx, cfg)
+		return syncCaches(mainCtx, cfg, clk)
+	}
 
-func watchCaches(ctx context.Context, modCache, buildCache string) (usedCacheFiles, usedCacheFiles, error) {
-	actions.Group("Recording used cache files")
-	defer actions.EndGroup()
+	// without watching, entries that are too old or aren't in the
+	// manifest are unused
+	if cfg.maxAge > 0 || cfg.fromManifest != "" {
+		modFiles, buildFiles := cache.NewUsedFiles(), cache.NewUsedFiles()
+		mergeUsedManifests(manifests, cfg.moduleCache, cfg.buildCache, modFiles, buildFiles, dirLevel)
+		pruneModCache, pruneBuildCache := cfg.moduleCache, cfg.buildCache
+		opts := newPruneOptions(clk.Now())
+		if cfg.maxAge > 0 {
+			log.Infof("deleting entries not used in the last %s", cfg.maxAge)
+			opts.KeepAccessedAfter = clk.Now().Add(-cfg.maxAge)
+		} else {
+			// a cache without entries in the manifest likely wasn't
+			// recorded, and pruning would delete all of it
+			log.Infof("deleting entries not in manifest %q", cfg.fromManifest)
+			if pruneModCache != "" && modFiles.Len() == 0 {
+				log.Warningf("no module cache entries are in the manifest, not pruning the module cache")
+				pruneModCache = ""
+			}
+			if pruneBuildCache != "" && buildFiles.Len() == 0 {
+				log.Warningf("no build cache entries are in the manifest, not pruning the build cache")
+				pruneBuildCache = ""
+			}
+			if pruneModCache == "" && pruneBuildCache == "" {
+				return errJustExit(2)
+			}
+		}
+		if cfg.keepListFile != "" && pruneModCache != "" {
+			if err := applyKeepList(cfg.keepListFile, cfg.moduleCache, modFiles); err != nil {
+				return err
+			}
+		}
+		if pruneModCache != "" {
+			if err := keepDeps(mainCtx, cfg.keepDepsDirs, cfg.moduleCache, modFiles); err != nil {
+				return err
+			}
+		}
+		if err := pinned.apply(pruneModCache, pruneBuildCache, modFiles, buildFiles, dirLevel); err != nil {
+			return err
+		}
+		lowerPriority(cfg)
+		if err := emptyTrash(cfg); err != nil {
+			return err
+		}
+		checkGoCommands(mainCtx, cfg, clk)
+		pruneStart := clk.Now()
+		modRes, buildRes := prune.Caches(mainCtx, pruneModCache, pruneBuildCache, modFiles, buildFiles, opts)
+		report.addCaches(pruneModCache, pruneBuildCache, modRes, buildRes)
+		for _, c := range toolCaches(cfg) {
+			pruneToolCache(mainCtx, c, cache.NewUsedFiles(), opts, &report)
+		}
+		if err := report.publish(cfg); err != nil {
+			return err
+		}
+		status.pruned(&report, pruneStart, clk.Now())
+		if mainCtx.Err() != nil {
+			log.Infof("signal received, stopped pruning caches early")
+			return errJustExit(2)
+		}
+		markFirstRunDone(newCaches, clk.Now())
+		verifyCaches(mainCtx, cfg)
+		return syncCaches(mainCtx, cfg, clk)
+	}
 
+	// the trash is emptied at most once, before the first prune after
+	// the wrapped command succeeded if there is one, so what a failing
+	// command may have needed can still be restored
 	var (
-		modFiles      usedCacheFiles
-		buildFiles    usedCacheFiles
-		watchModErr   error
-		watchBuildErr error
-		wg            sync.WaitGroup
+		trashMu      sync.Mutex
+		trashEmptied bool
 	)
+	emptyTrashOnce := func(empty bool) error {
+		if !empty {
+			return nil
+		}
+		trashMu.Lock()
+		defer trashMu.Unlock()
 
-	if modCache != "" {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			modFiles, watchModErr = watchCache(ctx, true, modCache)
-			if watchModErr != nil {
-				watchModErr = fmt.Errorf("watching module cache: %w", watchModErr)
+		if trashEmptied {
+			return nil
+		}
+		if err := emptyTrash(cfg); err != nil {
+			return err
+		}
+		trashEmptied = true
+		return nil
+	}
+
+	// prune a cache as soon as it stops being watched if the other one
+	// is still being watched
+	var modPruned, buildPruned bool
+	watchOpts.CacheDone = func(isModCache bool, usedFiles cache.UsedFiles) {
+		if cfg.noPrune || watchCtx.Err() != nil || mainCtx.Err() != nil {
+			return
+		}
+		name, modCache, buildCache := "build cache", "", cfg.buildCache
+		if isModCache {
+			name, modCache, buildCache = "module cache", cfg.moduleCache, ""
+		}
+		// the command stops watching both caches when it exits, so it
+		// is still running
+		cmdExitCode := 0
+		if len(cfg.command) != 0 {
+			cmdExitCode = -1
+		}
+		// the watcher still owns usedFiles, change a copy
+		usedFiles = usedFiles.Clone()
+		if ignoreMask := watchPhases.Mask(parseList(cfg.ignorePhases)); ignoreMask != 0 {
+			usedFiles.RemovePhases(ignoreMask)
+		}
+		if isModCache && monitor != nil {
+			keepModules(cfg.moduleCache, usedFiles, monitor.Downloaded())
+		}
+		if len(manifests) != 0 {
+			if isModCache {
+				mergeUsedManifests(manifests, cfg.moduleCache, "", usedFiles, cache.UsedFiles{}, dirLevel)
+			} else {
+				mergeUsedManifests(manifests, "", cfg.buildCache, cache.UsedFiles{}, usedFiles, dirLevel)
 			}
+		}
+		modFiles, buildFiles := cache.UsedFiles{}, usedFiles
+		if isModCache {
+			modFiles, buildFiles = usedFiles, cache.UsedFiles{}
+		}
+		err := preparePrune(mainCtx, cfg, modCache, buildCache, modFiles, buildFiles, cmdExitCode)
+		switch {
+		case errors.Is(err, errCommandRunning):
+			log.Infof("not pruning %s until the command succeeds", name)
+			return
+		case errors.Is(err, errNothingUsed):
+			log.Infof("no %s entries were used, not pruning it", name)
+			return
+		case err != nil:
+			log.Warningf("not pruning %s: %v", name, err)
+			return
+		}
+		if err := pinned.apply(modCache, buildCache, modFiles, buildFiles, dirLevel); err != nil {
+			log.Warningf("%v", err)
+		}
+		lowerPriority(cfg)
+		// it isn't known yet whether a running command will fail
+		if err := emptyTrashOnce(cmdExitCode == 0); err != nil {
+			log.Warningf("not pruning %s: %v", name, err)
+			return
+		}
+		if isModCache {
+			log.Infof("pruning module cache while the build cache is still being watched")
+		} else {
+			log.Infof("pruning build cache while the module cache is still being watched")
+		}
+		modRes, buildRes := prune.Caches(mainCtx, modCache, buildCache, modFiles, buildFiles, newPruneOptions(clk.Now()))
+		report.addCaches(modCache, buildCache, modRes, buildRes)
+		if isModCache {
+			modPruned = true
+		} else {
+			buildPruned = true
+		}
+	}
+
+	// stopIncremental stops incremental pruning and waits for it to
+	// finish, so it doesn't overlap with pruning once watching stopped
+	stopIncremental := func() {}
+	if cfg.watchMaxBytes > 0 {
+		incremental := newIncrementalPruner(cfg, clk, pinned, &report, cfg.moduleCache, watchBuildCache)
+		incremental.prepare = func(modCache, buildCache string, modFiles, buildFiles cache.UsedFiles) error {
+			if monitor != nil {
+				keepModules(cfg.moduleCache, modFiles, monitor.Downloaded())
+			}
+			mergeUsedManifests(manifests, cfg.moduleCache, cfg.buildCache, modFiles, buildFiles, dirLevel)
+			cmdExitCode := 0
+			if len(cfg.command) != 0 {
+				cmdExitCode = -1
+			}
+			if err := preparePrune(mainCtx, cfg, modCache, buildCache, modFiles, buildFiles, cmdExitCode); err != nil {
+				return err
+			}
+			return emptyTrashOnce(cmdExitCode == 0)
+		}
+		watchOpts.OnUsed = incremental.used
+		cacheDone := watchOpts.CacheDone
+		watchOpts.CacheDone = func(isModCache bool, usedFiles cache.UsedFiles) {
+			incremental.cacheDone(isModCache)
+			cacheDone(isModCache, usedFiles)
+		}
+		// usage of a cache that failed to be watched may be incomplete
+		watchOpts.CacheFailed = incremental.cacheDone
+		incrementalCtx, incrementalCancel := context.WithCancel(watchCtx)
+		incrementalDone := make(chan struct{})
+		go func() {
+			defer close(incrementalDone)
+			incremental.run(incrementalCtx)
 		}()
+		stopIncremental = func() {
+			incrementalCancel()
+			<-incrementalDone
+		}
 	}
-	if buildCache != "" {
-		wg.Add(1)
+
+	var cmdDone chan error
+	if len(cfg.command) != 0 {
+		// run the command once the caches are being watched and stop
+		// watching when it exits
+		ready := make(chan struct{})
+		watchOpts.Ready = func() { close(ready) }
+		cmdDone = make(chan error, 1)
 		go func() {
-			defer wg.Done()
-			buildFiles, watchBuildErr = watchCache(ctx, false, buildCache)
-			if watchBuildErr != nil {
-				watchModErr = fmt.Errorf("watching build cache: %w", watchBuildErr)
+			defer watchCancel()
+
+			select {
+			case <-ready:
+				cmdDone <- runCommand(mainCtx, cfg.command, append(os.Environ(), cmdEnv...))
+			case <-watchCtx.Done():
+				cmdDone <- errors.New("command was not run")
 			}
 		}()
 	}
-	wg.Wait()
 
-	err := errors.Join(watchModErr, watchBuildErr)
+	ready := watchOpts.Ready
+	watchOpts.Ready = func() {
+		status.ready(clk.Now())
+		notifySystemd(cfg, "READY=1\nSTATUS=watching caches")
+		if ready != nil {
+			ready()
+		}
+	}
+	var waitToolCaches func() ([]cache.UsedFiles, error)
+	if tools := toolCaches(cfg); len(tools) != 0 {
+		waitToolCaches = watchToolCaches(buildWatchCtx, tools, &watchOpts)
+	}
+	modFiles, buildFiles, err := watch.Caches(modWatchCtx, buildWatchCtx, cfg.moduleCache, watchBuildCache, dirLevel, watchOpts)
+	stopIncremental()
 	if err != nil {
-		return nil, nil, err
+		return fmt.Errorf("watching caches: %w", err)
+	}
+	var toolFiles []cache.UsedFiles
+	if waitToolCaches != nil {
+		toolFiles, err = waitToolCaches()
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.cacheProgDir != "" {
+		buildFiles, err = readCacheProgUsage(cfg.buildCache, dirLevel)
+		if err != nil {
+			return fmt.Errorf("reading build cache usage: %w", err)
+		}
+	}
+	watchEnd := clk.Now()
+	notifySystemd(cfg, "STOPPING=1\nSTATUS=pruning caches")
+
+	// exit with the command's exit code once done
+	var cmdExitCode int
+	if cmdDone != nil {
+		if err := <-cmdDone; err != nil {
+			cmdExitCode = commandExitCode(err)
+			log.Errorf("running command: %v", err)
+		}
 	}
 
-	return modFiles, buildFiles, nil
-}
+	if watchOpts.UnexpectedWrites != nil {
+		log.Infof("%d unexpected writes to caches", len(watchOpts.UnexpectedWrites.List()))
+	}
 
-func watchCache(ctx context.Context, isModCache bool, dir string) (usedCacheFiles, error) {
-	actions.Infof("creating watches for cache dir %q", dir)
+	logPhaseUsage("module cache", modFiles, watchPhases)
+	logPhaseUsage("build cache", buildFiles, watchPhases)
+	for i, c := range toolCaches(cfg) {
+		logPhaseUsage(c.name, toolFiles[i], watchPhases)
+	}
+	if ignoreMask := watchPhases.Mask(parseList(cfg.ignorePhases)); ignoreMask != 0 {
+		modFiles.RemovePhases(ignoreMask)
+		buildFiles.RemovePhases(ignoreMask)
+		for _, usedFiles := range toolFiles {
+			usedFiles.RemovePhases(ignoreMask)
+		}
+	}
+	if monitor != nil {
+		downloaded := monitor.Downloaded()
+		watched := modFiles.Len()
+		keepModules(cfg.moduleCache, modFiles, downloaded)
+		log.Infof("%d modules were downloaded through the proxy monitor, %d of them weren't recorded by watching",
+			len(downloaded), modFiles.Len()-watched)
+	}
 
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, fmt.Errorf("creating file watcher: %w", err)
+	log.EndGroup()
+
+	if mainCtx.Err() != nil {
+		log.Infof("signal received, shutting down without pruning caches")
+		return errJustExit(2)
 	}
-	defer func() {
-		err := watcher.Close()
-		if err != nil {
-			actions.Warningf("closing file watchers: %v", err)
+	if cfg.usedModulesFile != "" || cfg.sbomFile != "" || cfg.usageHistory != "" {
+		mods := usedModules(cfg.moduleCache, modFiles)
+		if cfg.usedModulesFile != "" {
+			if err := writeUsedModules(cfg.usedModulesFile, mods); err != nil {
+				return err
+			}
+			log.Infof("wrote %d used modules to %q", len(mods), cfg.usedModulesFile)
 		}
-	}()
+		if cfg.usageHistory != "" {
+			if err := updateUsageHistory(cfg, mods); err != nil {
+				return err
+			}
+		}
+		if cfg.sbomFile != "" {
+			if err := checkSBOM(cfg.sbomFile, mods); err != nil {
+				return fmt.Errorf("checking SBOM: %w", err)
+			}
+		}
+	}
 
-	flags := uint32(unix.IN_ACCESS | unix.IN_CREATE)
-	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
+	if cfg.manifestFile != "" {
+		manifest := newUsedManifest(cfg.moduleCache, cfg.buildCache, modFiles, buildFiles, dirLevel)
+		if err := manifest.write(cfg.manifestFile); err != nil {
 			return err
 		}
+		log.Infof("wrote %d used module cache entries and %d used build cache entries to %q",
+			len(manifest.ModuleCache), len(manifest.BuildCache), cfg.manifestFile)
+	}
+	if cfg.noPrune {
+		log.Infof("-no-prune is set, not pruning caches")
+		return errJustExit(cmdExitCode)
+	}
 
-		if isModCache {
-			depDir, ok := dependencyDir(path, d)
-			if ok {
-				err := watcher.AddWith(depDir, fsnotify.WithInotifyFlags(flags))
-				if err != nil {
-					return fmt.Errorf("adding watch for %q: %w", depDir, err)
-				}
-			}
+	if len(manifests) != 0 {
+		if modFiles == (cache.UsedFiles{}) {
+			modFiles = cache.NewUsedFiles()
+		}
+		if buildFiles == (cache.UsedFiles{}) {
+			buildFiles = cache.NewUsedFiles()
+		}
+		mergeUsedManifests(manifests, cfg.moduleCache, cfg.buildCache, modFiles, buildFiles, dirLevel)
+		log.Infof("merged %d manifests of used entries", len(manifests))
+	}
 
-			actions.Debugf("added watch for %q", depDir)
-			return nil
-		} else if d.IsDir() {
-			err := watcher.AddWith(path, fsnotify.WithInotifyFlags(flags))
-			if err != nil {
-				return fmt.Errorf("adding watch for %q: %w", path, err)
-			}
-			actions.Debugf("added watch for %q", path)
+	pruneModCache, pruneBuildCache := cfg.moduleCache, cfg.buildCache
+	if modPruned {
+		pruneModCache = ""
+	}
+	if buildPruned {
+		pruneBuildCache = ""
+	}
+	err = preparePrune(mainCtx, cfg, pruneModCache, pruneBuildCache, modFiles, buildFiles, cmdExitCode)
+	if errors.Is(err, errNothingUsed) {
+		// still prune tool caches that were used and report what was
+		// pruned while watching
+		toolsUsed := slices.ContainsFunc(toolFiles, func(usedFiles cache.UsedFiles) bool { return usedFiles.Len() != 0 })
+		if !toolsUsed && !modPruned && !buildPruned {
+			log.Infof("no cached files were used, nothing to do")
+			return errJustExit(2)
 		}
+		pruneModCache, pruneBuildCache = "", ""
+	} else if err != nil {
+		return err
+	}
+	if err := pinned.apply(pruneModCache, pruneBuildCache, modFiles, buildFiles, dirLevel); err != nil {
+		return err
+	}
+	lowerPriority(cfg)
+	// if the command failed the last prune may be why, keep what it
+	// pruned so it can be restored
+	if err := emptyTrashOnce(cmdExitCode == 0); err != nil {
+		return err
+	}
+	checkGoCommands(mainCtx, cfg, clk)
+	pruneStart := clk.Now()
+	modRes, buildRes := prune.Caches(mainCtx, pruneModCache, pruneBuildCache, modFiles, buildFiles, newPruneOptions(watchEnd))
+	report.addCaches(pruneModCache, pruneBuildCache, modRes, buildRes)
+	for i, c := range toolCaches(cfg) {
+		// the tool likely wasn't run if none of its cache was used
+		if toolFiles[i].Len() == 0 {
+			log.Infof("no %s entries were used, not pruning it", c.name)
+			continue
+		}
+		if mainCtx.Err() != nil {
+			break
+		}
+		pruneToolCache(mainCtx, c, toolFiles[i], newPruneOptions(watchEnd), &report)
+	}
+	if err := report.publish(cfg); err != nil {
+		return err
+	}
+	status.pruned(&report, pruneStart, clk.Now())
+	if mainCtx.Err() != nil {
+		log.Infof("signal received, stopped pruning caches early")
+		return errJustExit(2)
+	}
+	markFirstRunDone(newCaches, clk.Now())
+	verifyCaches(mainCtx, cfg)
+	if err := syncCaches(mainCtx, cfg, clk); err != nil {
+		return err
+	}
+	if cmdExitCode != 0 {
+		return errJustExit(cmdExitCode)
+	}
 
-		return nil
-	})
+	return nil
+}
+
+// updateUsageHistory records mods as being used in the usage history
+// and exports a keep list from it if necessary.
+func updateUsageHistory(cfg *config, mods []module.Version) error {
+	history, err := readUsageHistory(cfg.usageHistory)
 	if err != nil {
-		return nil, fmt.Errorf("walking %q: %w", dir, err)
+		return err
 	}
+	history.record(mods)
+	if err := writeUsageHistory(cfg.usageHistory, history); err != nil {
+		return err
+	}
+	log.Infof("recorded %d used modules in usage history of %d runs", len(mods), history.Runs)
 
-	usedFiles := make(usedCacheFiles)
-	for {
-		select {
-		case event, ok := <-watcher.Events:
-			if !ok {
-				return nil, errors.New("file watcher event channel closed")
-			}
+	if cfg.exportKeepList != "" {
+		keep := history.keepList(cfg.keepThreshold)
+		if err := writeUsedModules(cfg.exportKeepList, keep); err != nil {
+			return err
+		}
+		log.Infof("wrote %d modules used in at least %.0f%% of runs to %q", len(keep), cfg.keepThreshold*100, cfg.exportKeepList)
+	}
 
-			actions.Debugf("got event: path=%q op=%s", event.Name, event.Op)
+	return nil
+}
 
-			isDirEvent := event.Mask&unix.IN_ISDIR == unix.IN_ISDIR
-			if isModCache && isDirEvent || !isModCache && !isDirEvent {
-				usedFiles[event.Name] = struct{}{}
-			}
-			if !isModCache && isDirEvent && event.Mask&unix.IN_CREATE == unix.IN_CREATE {
-				err := watcher.AddWith(event.Name, fsnotify.WithInotifyFlags(flags))
-				if err != nil {
-					actions.Errorf("adding watch for %q: %v", event.Name, err)
-					continue
-				}
-			}
-		case err, ok := <-watcher.Errors:
-			if !ok {
-				return nil, errors.New("file watcher error channel closed")
-			}
-			actions.Errorf("file watcher: %v", err)
-		case <-ctx.Done():
-			return usedFiles, nil
+// pruneTelemetry deletes old files from the Go telemetry dir and adds
+// what was deleted to report.
+func pruneTelemetry(ctx context.Context, cfg *config, opts prune.Options, report *pruneReport) error {
+	dir, err := getGoEnv(ctx, "GOTELEMETRYDIR")
+	if err != nil {
+		return fmt.Errorf("getting GOTELEMETRYDIR: %w", err)
+	}
+	// versions of Go before telemetry print nothing
+	if dir == "" {
+		dir, err = goEnvDefault("GOTELEMETRYDIR")
+		if err != nil {
+			return fmt.Errorf("getting GOTELEMETRYDIR: %w", err)
 		}
 	}
+
+	opts.KeepAccessedAfter = opts.Clock.Now().Add(-cfg.telemetryMaxAge)
+	res := prune.Telemetry(ctx, dir, opts)
+	report.Telemetry = newCacheReport(dir, res)
+	if opts.DryRun {
+		log.Infof("would delete %d files from telemetry dir %q, freeing %s", res.Deleted, dir, bytesize.Format(res.BytesFreed))
+	} else {
+		log.Infof("deleted %d files from telemetry dir %q, freeing %s", res.Deleted, dir, bytesize.Format(res.BytesFreed))
+	}
+
+	return nil
 }
 
-func dependencyDir(path string, d fs.DirEntry) (string, bool) {
-	if d.IsDir() && strings.Contains(d.Name(), "@") {
-		// if the dir name contains a valid module version, this is a dep dir
-		_, ver, _ := strings.Cut(d.Name(), "@")
-		if strings.HasSuffix(ver, "+incompatible") || semver.IsValid(ver) || module.IsPseudoVersion(ver) {
-			return path, true
+// syncCaches mirrors the pruned caches to the sync dir if one was
+// passed, and records the time of clk the seed was written at.
+func syncCaches(ctx context.Context, cfg *config, clk clock.Clock) error {
+	if cfg.syncDir == "" {
+		return nil
+	}
+
+	log.Group("Syncing caches")
+	defer log.EndGroup()
+
+	var seedModCache, seedBuildCache string
+	for _, c := range []struct {
+		name string
+		dir  string
+	}{
+		{name: "mod", dir: cfg.moduleCache},
+		{name: "build", dir: cfg.buildCache},
+	} {
+		if c.dir == "" {
+			continue
+		}
+		dest := filepath.Join(cfg.syncDir, c.name)
+		if err := os.MkdirAll(cfg.syncDir, 0o755); err != nil {
+			return fmt.Errorf("creating sync dir: %w", err)
+		}
+		stats, err := mirror.Dir(ctx, c.dir, dest)
+		if err != nil {
+			return fmt.Errorf("syncing %q to %q: %w", c.dir, dest, err)
+		}
+		log.Infof("synced %q to %q: copied %d files (%s), %d unchanged, removed %d",
+			c.dir, dest, stats.Copied, bytesize.Format(stats.BytesCopied), stats.Unchanged, stats.Removed)
+		if c.name == "mod" {
+			seedModCache = dest
+		} else {
+			seedBuildCache = dest
 		}
-	} else if !d.IsDir() && d.Name() == "go.mod" {
-		// If the dir contains 'go.mod', this is a dep dir
-		return filepath.Dir(path), true
 	}
 
-	return "", false
+	return writeSeedManifest(cfg.syncDir, seedModCache, seedBuildCache, clk.Now())
 }
 
-func pruneCaches(modCache, buildCache string, modFiles, buildFiles usedCacheFiles) {
-	actions.Group("Pruning cache files")
-	defer actions.EndGroup()
+// defaultRuntimeDir returns the dir runtime files are created in by
+// default, XDG_RUNTIME_DIR if it's set so go-cache-prune works where
+// the temporary dir isn't writable.
+func defaultRuntimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+	return os.TempDir()
+}
 
-	var wg sync.WaitGroup
+// runCommand runs a command, connecting it to the standard streams of
+// this process.
+func runCommand(ctx context.Context, command, env []string) error {
+	log.Infof("running command %q", command)
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
 
-	if modCache != "" {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+// commandExitCode returns the exit code of a command that failed with
+// err, or 1 if it didn't exit on its own.
+func commandExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
 
-			d := pruneCache(modCache, true, modFiles)
-			actions.Infof("deleted %d directories from module cache", d)
-		}()
+// signalProcess sends sig to the go-cache-prune process whose PID is
+// stored in pidFile.
+func signalProcess(pidFile string, sig os.Signal) (*os.Process, error) {
+	pid, err := readPIDFile(pidFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading PID file: %w", err)
 	}
 
-	if buildCache != "" {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+	p, _ := os.FindProcess(pid) // always succeeds for Unix systems
+	if err := p.Signal(sig); err != nil {
+		return nil, fmt.Errorf("signaling go-cache-prune process: %w", err)
+	}
 
-			d := pruneCache(buildCache, false, buildFiles)
-			actions.Infof("deleted %d files from build cache", d)
-		}()
+	return p, nil
+}
+
+// notifyContext is like signal.NotifyContext but doesn't relay all
+// signals if no signals are passed.
+func notifyContext(ctx context.Context, sigs ...os.Signal) (context.Context, context.CancelFunc) {
+	if len(sigs) == 0 {
+		return context.WithCancel(ctx)
 	}
+	return signal.NotifyContext(ctx, sigs...)
+}
+
+// waitForRestore returns a channel that will be closed once window has
+// elapsed, SIGUSR2 is received or trigger is sent to, whichever is
+// first. If window is 0 nil is returned.
+func waitForRestore(ctx context.Context, clk clock.Clock, window time.Duration, trigger <-chan struct{}) <-chan struct{} {
+	if window == 0 {
+		return nil
+	}
+
+	restoreSig := make(chan os.Signal, 1)
+	if len(restoreDoneSignals) != 0 {
+		signal.Notify(restoreSig, restoreDoneSignals...)
+	}
+	restoreDone := make(chan struct{})
+	go func() {
+		defer close(restoreDone)
+		defer signal.Stop(restoreSig)
+
+		timer := clk.NewTimer(window)
+		defer timer.Stop()
+
+		select {
+		case <-restoreSig:
+		case <-trigger:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}()
 
-	wg.Wait()
+	return restoreDone
 }
 
-func pruneCache(dir string, isModCache bool, usedFiles usedCacheFiles) uint {
-	var deletedCtr uint
-	newWalkFunc := func(root string) fs.WalkDirFunc {
-		return func(path string, d fs.DirEntry, err error) error {
+// waitForStableDirs blocks until the contents of dirs haven't changed
+// for stableFor or ctx is canceled.
+func waitForStableDirs(ctx context.Context, clk clock.Clock, stableFor time.Duration, dirs ...string) {
+	pollInterval := min(stableFor, time.Second)
+	ticker := clk.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	lastFP := dirsFingerprint(dirs)
+	lastChange := clk.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			fp := dirsFingerprint(dirs)
+			if fp != lastFP {
+				log.Debugf("caches changed, waiting for them to be unchanged")
+				lastFP = fp
+				lastChange = now
+				continue
+			}
+			if now.Sub(lastChange) >= stableFor {
+				return
+			}
+		}
+	}
+}
+
+type fingerprint struct {
+	entries   int
+	size      int64
+	lastMtime time.Time
+}
+
+// dirsFingerprint returns a summary of the contents of dirs that will
+// change if files are added or modified.
+func dirsFingerprint(dirs []string) fingerprint {
+	var fp fingerprint
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+
+		_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
 			if err != nil {
-				// ignore file not found errors, most will be because
-				// module cache dirs were recursively deleted
-				if isModCache && errors.Is(err, os.ErrNotExist) {
-					return nil
-				}
-				actions.Warningf("walking %q: %v", path, err)
 				return nil
 			}
-			if path == root {
+			info, err := d.Info()
+			if err != nil {
 				return nil
 			}
 
-			if isModCache {
-				depDir, ok := dependencyDir(path, d)
-				if !ok {
-					return nil
-				}
-				if _, ok := usedFiles[depDir]; ok {
-					return nil
-				}
-
-				// allow module files to be deleted
-				chmodDir(depDir)
-				err := os.RemoveAll(depDir)
-				if err != nil {
-					actions.Warningf("deleting directory from module cache: %v", err)
-					return nil
-				}
-				actions.Debugf("deleted directory %q from module cache", depDir)
-				deletedCtr++
-			} else if !d.IsDir() {
-				if _, ok := usedFiles[path]; ok {
-					return nil
-				}
-				// leave this file these files to make testing easier
-				if d.Name() == "trim.txt" || d.Name() == "README" {
-					return nil
-				}
-
-				err := os.Remove(path)
-				if err != nil {
-					actions.Warningf("deleting file from build cache: %v", err)
-					return nil
-				}
-				actions.Debugf("deleted file %q from build cache", path)
-				deletedCtr++
+			fp.entries++
+			fp.size += info.Size()
+			if info.ModTime().After(fp.lastMtime) {
+				fp.lastMtime = info.ModTime()
 			}
-
 			return nil
+		})
+	}
+
+	return fp
+}
+
+var (
+	// errCommandRunning is returned by preparePrune when caches can't
+	// be pruned until the wrapped command exits
+	errCommandRunning = errors.New("command is still running")
+	// errNothingUsed is returned by preparePrune when none of the
+	// entries of the caches were recorded as used
+	errNothingUsed = errors.New("no cached files were used")
+)
+
+// preparePrune checks that modCache and buildCache, either of which may
+// be empty, should be pruned now with the used entries modFiles and
+// buildFiles, and marks the modules -keep-list and -keep-from-deps keep
+// as used. cmdExitCode is the exit code of the wrapped command, or -1
+// if it is still running.
+//
+// If -skip-prune-on-failure is set, errJustExit with the exit code of
+// the command is returned if it failed, or errCommandRunning if it
+// hasn't exited yet. errNothingUsed is returned if none of the entries
+// of the caches were used, as pruning would delete all of them.
+func preparePrune(ctx context.Context, cfg *config, modCache, buildCache string, modFiles, buildFiles cache.UsedFiles, cmdExitCode int) error {
+	if cfg.skipPruneOnFail {
+		switch {
+		case cmdExitCode < 0:
+			return errCommandRunning
+		case cmdExitCode > 0:
+			log.Infof("command failed, not pruning caches")
+			return errJustExit(cmdExitCode)
+		}
+	}
+	if (modCache == "" || modFiles.Len() == 0) && (buildCache == "" || buildFiles.Len() == 0) {
+		return errNothingUsed
+	}
+	if modCache == "" {
+		return nil
+	}
+
+	if cfg.keepListFile != "" {
+		if err := applyKeepList(cfg.keepListFile, modCache, modFiles); err != nil {
+			return err
 		}
 	}
+	return keepDeps(ctx, cfg.keepDepsDirs, modCache, modFiles)
+}
 
-	_ = filepath.WalkDir(dir, newWalkFunc(dir))
-	return deletedCtr
+// pruneOptions returns the options to prune the caches with when
+// watching them ended at watchEnd.
+func pruneOptions(cfg *config, clk clock.Clock, watchEnd time.Time) prune.Options {
+	opts := prune.Options{
+		BuildDirLevel:    cfg.buildGranularity == dirGranularity,
+		QuarantineDir:    cfg.quarantineDir,
+		TrashDir:         cfg.trashDir,
+		LockTimeout:      cfg.lockTimeout,
+		ReadOnlyModCache: cfg.readOnlyModCache,
+		ModCacheWritable: cfg.modCacheWritable,
+		MaxSize:          cfg.maxCacheBytes,
+		KeepFuzzCache:    !cfg.pruneFuzzCache,
+		MaxFuzzSize:      cfg.maxFuzzBytes,
+		Concurrency:      cfg.concurrency,
+		DeleteRate:       cfg.deleteEntryRate,
+		DeleteBytesRate:  cfg.deleteBytesRate,
+		DeletePause:      cfg.deletePause,
+		Include:          cfg.pruneInclude,
+		Exclude:          cfg.pruneExclude,
+		Protect:          cfg.protected,
+		SoftDelete:       cfg.softDelete,
+		KeepModMetadata:  cfg.keepModMetadata,
+		KeepToolchains:   cfg.keepToolchains,
+		DryRun:           cfg.dryRun,
+		Clock:            clk,
+	}
+	if cfg.gracePeriod > 0 {
+		opts.KeepUsedAfter = watchEnd.Add(-cfg.gracePeriod)
+	}
+	if cfg.minResidency > 0 {
+		opts.KeepAddedAfter = clk.Now().Add(-cfg.minResidency)
+	}
+	return opts
 }
 
-func chmodDir(dir string) {
-	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			actions.Warningf("walking %q: %v", path, err)
-			return nil
+// Actions -signal can request of a running go-cache-prune.
+const (
+	signalPrune  = "prune"
+	signalAbort  = "abort"
+	signalStatus = "status"
+)
+
+// signalFlag is the value of -signal. Passing -signal without a value
+// requests pruning like it did before it accepted actions.
+type signalFlag string
+
+func (s *signalFlag) String() string {
+	return string(*s)
+}
+
+func (s *signalFlag) Set(value string) error {
+	switch value {
+	case "true":
+		*s = signalPrune
+	case "false":
+		*s = ""
+	case signalPrune, signalAbort, signalStatus:
+		*s = signalFlag(value)
+	default:
+		return fmt.Errorf("must be %s, %s or %s", signalPrune, signalAbort, signalStatus)
+	}
+	return nil
+}
+
+func (s *signalFlag) IsBoolFlag() bool {
+	return true
+}
+
+// stringsFlag is a flag that can be passed multiple times.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// parseList parses a comma separated list.
+func parseList(s string) []string {
+	var list []string
+	for _, elem := range strings.Split(s, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			list = append(list, elem)
 		}
+	}
+
+	return list
+}
 
-		if err := os.Chmod(path, 0o777); err != nil {
-			actions.Warningf("changing permissions of %q: %v", path, err)
+// parseDeleteRate parses the value of -delete-rate, either a number of
+// entries or a size with a unit per second. An optional '/s' suffix is
+// allowed.
+func parseDeleteRate(s string) (entries float64, bytes int64, err error) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if !strings.HasSuffix(s, "B") {
+		entries, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid rate %q", s)
 		}
+		if !(entries > 0) {
+			return 0, 0, errors.New("rate must be greater than 0")
+		}
+		return entries, 0, nil
+	}
 
-		return nil
-	})
+	bytes, err = bytesize.Parse(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	if bytes == 0 {
+		return 0, 0, errors.New("rate must be greater than 0")
+	}
+	return 0, bytes, nil
+}
+
+// logPhaseUsage logs how many entries of a cache were used in each
+// phase if any phases were started.
+func logPhaseUsage(cacheName string, usedFiles cache.UsedFiles, p *cache.Phases) {
+	names := p.List()
+	if len(names) == 1 {
+		return
+	}
+
+	for i, name := range names {
+		var used int
+		usedFiles.Range(func(_ string, mask cache.PhaseMask) bool {
+			if mask&(1<<i) != 0 {
+				used++
+			}
+			return true
+		})
+		log.Infof("%s: %d entries used during phase %q", cacheName, used, name)
+	}
 }
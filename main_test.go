@@ -2,10 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/clock"
+	"github.com/capnspacehook/go-cache-prune/prune"
+	"github.com/capnspacehook/go-cache-prune/watch"
 )
 
 func TestBuildCache(t *testing.T) {
@@ -113,17 +123,24 @@ func startWatching(t *testing.T, ctx context.Context, cacheDir string, isModCach
 
 	var (
 		errCh     = make(chan error)
-		usedFiles usedCacheFiles
+		usedFiles cache.UsedFiles
 	)
 
 	watchCtx, watchCancel := context.WithCancel(ctx)
 	t.Cleanup(watchCancel)
 
+	// don't use the cache until every directory of it is being watched
+	ready := make(chan struct{})
 	go func() {
 		var err error
-		usedFiles, err = watchCache(watchCtx, false, cacheDir)
+		usedFiles, err = watch.Cache(watchCtx, false, cacheDir, watch.Options{Ready: func() { close(ready) }})
 		errCh <- err
 	}()
+	select {
+	case <-ready:
+	case err := <-errCh:
+		t.Fatalf("watching cache: %v", err)
+	}
 
 	return func() uint {
 		t.Helper()
@@ -134,7 +151,7 @@ func startWatching(t *testing.T, ctx context.Context, cacheDir string, isModCach
 			t.Fatalf("watching cache: %v", err)
 		}
 
-		return pruneCache(cacheDir, isModCache, usedFiles)
+		return prune.Cache(ctx, cacheDir, isModCache, usedFiles, prune.Options{}).Deleted
 	}
 }
 
@@ -159,3 +176,184 @@ func cacheWasUsed(t *testing.T, output []byte) {
 		t.Fatalf("cache was not used, expected it to be used")
 	}
 }
+
+// waitForWaiters blocks until code under test is waiting on c.
+func waitForWaiters(t *testing.T, c *clock.Fake) {
+	t.Helper()
+
+	for c.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWaitForRestore(t *testing.T) {
+	c := clock.NewFake(time.Now())
+	done := waitForRestore(context.Background(), c, time.Minute, nil)
+	waitForWaiters(t, c)
+
+	c.Advance(59 * time.Second)
+	select {
+	case <-done:
+		t.Fatal("restore window ended early")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	c.Advance(time.Second)
+	<-done
+}
+
+func TestWaitForStableDirs(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now()
+	c := clock.NewFake(start)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		waitForStableDirs(context.Background(), c, 5*time.Second, dir)
+	}()
+	waitForWaiters(t, c)
+
+	for {
+		select {
+		case <-done:
+			if waited := c.Now().Sub(start); waited < 5*time.Second {
+				t.Fatalf("returned after %s, want at least 5s", waited)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+			c.Advance(time.Second)
+		}
+	}
+}
+
+func TestSignalFlag(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    signalFlag
+		wantErr bool
+	}{
+		{args: nil, want: ""},
+		{args: []string{"-signal"}, want: signalPrune},
+		{args: []string{"-signal=prune"}, want: signalPrune},
+		{args: []string{"-signal=abort"}, want: signalAbort},
+		{args: []string{"-signal=status"}, want: signalStatus},
+		{args: []string{"-signal=false"}, want: ""},
+		{args: []string{"-signal=restart"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		var s signalFlag
+		fs.Var(&s, "signal", "")
+		err := fs.Parse(tt.args)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%v: expected error", tt.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: parsing flags: %v", tt.args, err)
+			continue
+		}
+		if s != tt.want {
+			t.Errorf("%v: expected %q, got %q", tt.args, tt.want, s)
+		}
+	}
+}
+
+func TestParseDeleteRate(t *testing.T) {
+	tests := []struct {
+		val         string
+		wantEntries float64
+		wantBytes   int64
+		wantErr     bool
+	}{
+		{val: "100", wantEntries: 100},
+		{val: "0.5/s", wantEntries: 0.5},
+		{val: "50MB", wantBytes: 50_000_000},
+		{val: "1MiB/s", wantBytes: 1 << 20},
+		{val: "0", wantErr: true},
+		{val: "0B", wantErr: true},
+		{val: "NaN", wantErr: true},
+		{val: "fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		entries, bytes, err := parseDeleteRate(tt.val)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error", tt.val)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: parsing: %v", tt.val, err)
+			continue
+		}
+		if entries != tt.wantEntries || bytes != tt.wantBytes {
+			t.Errorf("%q: expected %v entries and %d bytes, got %v and %d", tt.val, tt.wantEntries, tt.wantBytes, entries, bytes)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skipf("no shell: %v", err)
+	}
+
+	// the exit code of a wrapped command is passed through
+	cmdErr := runCommand(context.Background(), []string{sh, "-c", "exit 3"}, os.Environ())
+	if code := commandExitCode(cmdErr); code != 3 {
+		t.Fatalf("expected command exit code 3, got %d", code)
+	}
+	if code := exitCode(errJustExit(commandExitCode(cmdErr))); code != 3 {
+		t.Errorf("expected exit code 3, got %d", code)
+	}
+	if code := exitCode(fmt.Errorf("pruning: %w", errJustExit(2))); code != 2 {
+		t.Errorf("expected wrapped exit code 2, got %d", code)
+	}
+	if code := exitCode(errJustExit(0)); code != 0 {
+		t.Errorf("expected exit code 0, got %d", code)
+	}
+	if code := exitCode(errors.New("failed")); code != 1 {
+		t.Errorf("expected exit code 1 for errors, got %d", code)
+	}
+	if code := exitCode(nil); code != 0 {
+		t.Errorf("expected exit code 0 without an error, got %d", code)
+	}
+}
+
+func TestPreparePrune(t *testing.T) {
+	tmp := t.TempDir()
+	modCache, buildCache := filepath.Join(tmp, "mod"), filepath.Join(tmp, "build")
+	keepList := filepath.Join(tmp, "keep.txt")
+	if err := os.WriteFile(keepList, []byte("golang.org/x/mod@v0.17.0\n"), 0o644); err != nil {
+		t.Fatalf("writing keep list: %v", err)
+	}
+	used := cache.NewUsedFiles(filepath.Join(buildCache, "00", "entry"))
+
+	cfg := &config{skipPruneOnFail: true, keepListFile: keepList}
+	if err := preparePrune(context.Background(), cfg, modCache, "", cache.NewUsedFiles(), cache.UsedFiles{}, -1); !errors.Is(err, errCommandRunning) {
+		t.Errorf("expected pruning to wait for the command, got %v", err)
+	}
+	var exitCode errJustExit
+	if err := preparePrune(context.Background(), cfg, "", buildCache, cache.UsedFiles{}, used, 3); !errors.As(err, &exitCode) || exitCode != 3 {
+		t.Errorf("expected exit code 3 when the command failed, got %v", err)
+	}
+
+	// a cache none of which was used isn't pruned
+	cfg.skipPruneOnFail = false
+	if err := preparePrune(context.Background(), cfg, modCache, "", cache.NewUsedFiles(), cache.UsedFiles{}, -1); !errors.Is(err, errNothingUsed) {
+		t.Errorf("expected nothing to be used, got %v", err)
+	}
+
+	// kept modules are used even if only the build cache was
+	modFiles := cache.NewUsedFiles()
+	if err := preparePrune(context.Background(), cfg, modCache, buildCache, modFiles, used, 0); err != nil {
+		t.Fatalf("preparing to prune: %v", err)
+	}
+	if !modFiles.Has(filepath.Join(modCache, "golang.org", "x", "mod@v0.17.0")) {
+		t.Errorf("expected module of keep list to be used")
+	}
+}
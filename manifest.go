@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+// usedManifest is the entries of the caches that were used, written by
+// -write-manifest so usage can be recorded in one job and caches pruned
+// in another. Entries are relative to their cache so the manifest can
+// be used with caches in other locations.
+type usedManifest struct {
+	// BuildDirLevel is true if build cache usage was recorded per
+	// shard dir instead of per file
+	BuildDirLevel bool     `json:"buildDirLevel"`
+	ModuleCache   []string `json:"moduleCache"`
+	BuildCache    []string `json:"buildCache"`
+}
+
+// newUsedManifest returns the manifest of the used entries modFiles of
+// the module cache modCache and buildFiles of the build cache
+// buildCache.
+func newUsedManifest(modCache, buildCache string, modFiles, buildFiles cache.UsedFiles, buildDirLevel bool) *usedManifest {
+	return &usedManifest{
+		BuildDirLevel: buildDirLevel,
+		ModuleCache:   relativeEntries(modCache, modFiles),
+		BuildCache:    relativeEntries(buildCache, buildFiles),
+	}
+}
+
+// relativeEntries returns the sorted paths of usedFiles relative to
+// dir. Paths outside of dir are skipped.
+func relativeEntries(dir string, usedFiles cache.UsedFiles) []string {
+	entries := []string{}
+	if dir == "" {
+		return entries
+	}
+
+	usedFiles.Range(func(path string, _ cache.PhaseMask) bool {
+		if !cache.IsWithinDir(dir, path) {
+			return true
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return true
+		}
+		entries = append(entries, filepath.ToSlash(relPath))
+		return true
+	})
+	slices.Sort(entries)
+
+	return entries
+}
+
+// write writes the manifest to path.
+func (m *usedManifest) write(path string) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	return nil
+}
+
+// readUsedManifests reads the manifests at paths, which must be usable
+// with build cache usage being recorded per dir if buildDirLevel is
+// true or per file otherwise.
+func readUsedManifests(paths []string, buildDirLevel bool) ([]*usedManifest, error) {
+	manifests := make([]*usedManifest, 0, len(paths))
+	for _, path := range paths {
+		m, err := readUsedManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		// shard dirs can't be converted to the files that were used
+		if m.BuildDirLevel && !buildDirLevel && len(m.BuildCache) != 0 {
+			return nil, fmt.Errorf("manifest %q recorded build cache usage per dir, -build-cache-granularity must be %q to use it", path, dirGranularity)
+		}
+		manifests = append(manifests, m)
+	}
+
+	return manifests, nil
+}
+
+// mergeUsedManifests adds the entries of manifests to the used entries
+// of the caches, so entries used by any of the runs that wrote them are
+// kept.
+func mergeUsedManifests(manifests []*usedManifest, modCache, buildCache string, modFiles, buildFiles cache.UsedFiles, buildDirLevel bool) {
+	for _, m := range manifests {
+		m.addTo(modCache, buildCache, modFiles, buildFiles, buildDirLevel)
+	}
+}
+
+// readUsedManifest reads a manifest written by -write-manifest.
+func readUsedManifest(path string) (*usedManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	var m usedManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing manifest %q: %w", path, err)
+	}
+
+	return &m, nil
+}
+
+// addTo adds the entries of the manifest to the used entries modFiles
+// of the module cache modCache and buildFiles of the build cache
+// buildCache. Either cache can be empty to skip it. Build cache files
+// are converted to their shard dirs if buildDirLevel is true.
+func (m *usedManifest) addTo(modCache, buildCache string, modFiles, buildFiles cache.UsedFiles, buildDirLevel bool) {
+	if modCache != "" {
+		for _, entry := range m.ModuleCache {
+			modFiles.Add(filepath.Join(modCache, filepath.FromSlash(entry)), cache.DefaultPhaseMask)
+		}
+	}
+	if buildCache != "" {
+		for _, entry := range m.BuildCache {
+			path := filepath.Join(buildCache, filepath.FromSlash(entry))
+			if buildDirLevel && !m.BuildDirLevel {
+				path = filepath.Dir(path)
+			}
+			buildFiles.Add(path, cache.DefaultPhaseMask)
+		}
+	}
+}
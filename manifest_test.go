@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+func TestUsedManifest(t *testing.T) {
+	modCache := filepath.FromSlash("/cache/mod")
+	buildCache := filepath.FromSlash("/cache/build")
+	modFiles := cache.NewUsedFiles(
+		filepath.Join(modCache, "example.com", "b@v1.0.0"),
+		filepath.Join(modCache, "example.com", "a@v1.0.0"),
+	)
+	buildFiles := cache.NewUsedFiles(
+		filepath.Join(buildCache, "00"),
+		filepath.FromSlash("/somewhere/else/file"),
+	)
+
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	if err := newUsedManifest(modCache, buildCache, modFiles, buildFiles, true).write(path); err != nil {
+		t.Fatalf("writing manifest: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading manifest: %v", err)
+	}
+	var got usedManifest
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decoding manifest: %v", err)
+	}
+
+	if !got.BuildDirLevel {
+		t.Error("expected build cache usage to be recorded per dir")
+	}
+	if want := []string{"example.com/a@v1.0.0", "example.com/b@v1.0.0"}; !slices.Equal(got.ModuleCache, want) {
+		t.Errorf("module cache entries = %q, want %q", got.ModuleCache, want)
+	}
+	if want := []string{"00"}; !slices.Equal(got.BuildCache, want) {
+		t.Errorf("build cache entries = %q, want %q", got.BuildCache, want)
+	}
+}
+
+func TestMergeUsedManifests(t *testing.T) {
+	dir := t.TempDir()
+	fileLevel := filepath.Join(dir, "file-level.json")
+	dirLevel := filepath.Join(dir, "dir-level.json")
+	for path, m := range map[string]*usedManifest{
+		fileLevel: {ModuleCache: []string{"example.com/a@v1.0.0"}, BuildCache: []string{"00/entry-a"}},
+		dirLevel:  {BuildDirLevel: true, ModuleCache: []string{"example.com/b@v1.0.0"}, BuildCache: []string{"01"}},
+	} {
+		if err := m.write(path); err != nil {
+			t.Fatalf("writing manifest: %v", err)
+		}
+	}
+
+	if _, err := readUsedManifests([]string{fileLevel, dirLevel}, false); err == nil {
+		t.Error("expected error reading manifest recorded per dir when recording per file")
+	}
+	manifests, err := readUsedManifests([]string{fileLevel, dirLevel}, true)
+	if err != nil {
+		t.Fatalf("reading manifests: %v", err)
+	}
+
+	modCache := filepath.FromSlash("/cache/mod")
+	buildCache := filepath.FromSlash("/cache/build")
+	modFiles := cache.NewUsedFiles(filepath.Join(modCache, "example.com", "c@v1.0.0"))
+	buildFiles := cache.NewUsedFiles()
+	mergeUsedManifests(manifests, modCache, buildCache, modFiles, buildFiles, true)
+
+	wantMod := []string{"example.com/a@v1.0.0", "example.com/b@v1.0.0", "example.com/c@v1.0.0"}
+	if got := relativeEntries(modCache, modFiles); !slices.Equal(got, wantMod) {
+		t.Errorf("module cache entries = %q, want %q", got, wantMod)
+	}
+	// files are converted to their shard dirs
+	wantBuild := []string{"00", "01"}
+	if got := relativeEntries(buildCache, buildFiles); !slices.Equal(got, wantBuild) {
+		t.Errorf("build cache entries = %q, want %q", got, wantBuild)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"runtime/debug"
+
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+	"github.com/capnspacehook/go-cache-prune/internal/cgroup"
+)
+
+// lowMemoryLimit is the cgroup memory limit below which build cache
+// usage is recorded per dir by default, as recording every used file
+// of a large build cache can take hundreds of megabytes.
+const lowMemoryLimit = 512 << 20
+
+// adaptToMemoryLimit makes the garbage collector keep memory usage
+// within the cgroup memory limit of this process if there is one, so
+// go-cache-prune isn't OOM killed in constrained containers. When the
+// limit is low, build cache usage is recorded per dir unless
+// -build-cache-granularity was passed.
+func adaptToMemoryLimit(cfg *config) {
+	limit, ok := cgroup.MemoryLimit()
+	if !ok {
+		return
+	}
+	log.Infof("running with a memory limit of %s", bytesize.Format(limit))
+
+	// the watched command is usually in the same cgroup, leave it half
+	if os.Getenv("GOMEMLIMIT") == "" {
+		debug.SetMemoryLimit(limit / 2)
+	}
+	if limit < lowMemoryLimit && !cfg.granularitySet && cfg.buildGranularity == fileGranularity {
+		cfg.buildGranularity = dirGranularity
+		log.Infof("recording build cache usage per dir to use less memory, pass -build-cache-granularity=%s to record it per file", fileGranularity)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// metricsNamespace prefixes the names of Prometheus metrics.
+const metricsNamespace = "go_cache_prune_"
+
+// metricsTotals are totals of pruning across all watch cycles.
+type metricsTotals struct {
+	// pastEvents are the events of earlier watch cycles
+	pastEvents uint64
+	prunes     uint64
+	deleted    map[string]uint64
+	freed      map[string]int64
+	// lastPruneDuration is how long the last prune took
+	lastPruneDuration time.Duration
+}
+
+// addReport adds what was pruned according to report to the totals.
+func (m *metricsTotals) addReport(report *pruneReport) {
+	if m.deleted == nil {
+		m.deleted = make(map[string]uint64)
+		m.freed = make(map[string]int64)
+	}
+	m.prunes++
+	for _, c := range report.caches() {
+		m.deleted[c.name] += uint64(len(c.report.Deleted))
+		m.freed[c.name] += c.report.BytesFreed
+	}
+}
+
+// namedCacheReport is the report of a cache and its name in metrics.
+type namedCacheReport struct {
+	name   string
+	report *cacheReport
+}
+
+// caches returns the reports of the caches that were pruned.
+func (r *pruneReport) caches() []namedCacheReport {
+	var caches []namedCacheReport
+	for _, c := range []namedCacheReport{
+		{name: "module", report: r.ModuleCache},
+		{name: "build", report: r.BuildCache},
+		{name: "golangci-lint", report: r.LintCache},
+		{name: "staticcheck", report: r.StaticcheckCache},
+		{name: "telemetry", report: r.Telemetry},
+	} {
+		if c.report != nil {
+			caches = append(caches, c)
+		}
+	}
+	// extra caches are named by their dir
+	for _, extra := range r.ExtraCaches {
+		caches = append(caches, namedCacheReport{name: extra.Dir, report: extra})
+	}
+	return caches
+}
+
+// writeMetrics writes the status as metrics in the Prometheus text
+// exposition format to w.
+func (s *runStatus) writeMetrics(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&buf, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsNamespace, name, help, metricsNamespace, name, typ)
+	}
+	value := func(name, labels string, val any) {
+		fmt.Fprintf(&buf, "%s%s%s %v\n", metricsNamespace, name, labels, val)
+	}
+	perCache := func(name string, vals func(cache string) any, caches []string) {
+		for _, cache := range caches {
+			value(name, fmt.Sprintf("{cache=%q}", cache), vals(cache))
+		}
+	}
+
+	var events, used uint64
+	var watches, usedMemory int64
+	if s.stats != nil {
+		events, used, watches, usedMemory = s.stats.Events(), s.stats.Used(), s.stats.Watches(), s.stats.UsedMemory()
+	}
+	metric("watch_events_total", "counter", "Cache events processed while watching.")
+	value("watch_events_total", "", s.totals.pastEvents+events)
+	metric("watches", "gauge", "Watches currently added to caches.")
+	value("watches", "", watches)
+	metric("used_entries", "gauge", "Cache entries recorded as used since watching started.")
+	value("used_entries", "", used)
+	metric("used_entries_memory_bytes", "gauge", "Peak memory used to record which cache entries were used.")
+	value("used_entries_memory_bytes", "", usedMemory)
+
+	caches := make([]string, 0, len(s.totals.deleted))
+	for cache := range s.totals.deleted {
+		caches = append(caches, cache)
+	}
+	slices.Sort(caches)
+	metric("prunes_total", "counter", "Times caches were pruned.")
+	value("prunes_total", "", s.totals.prunes)
+	metric("deleted_entries_total", "counter", "Cache entries deleted.")
+	perCache("deleted_entries_total", func(cache string) any { return s.totals.deleted[cache] }, caches)
+	metric("freed_bytes_total", "counter", "Bytes freed by deleting cache entries.")
+	perCache("freed_bytes_total", func(cache string) any { return s.totals.freed[cache] }, caches)
+	if !s.lastPrune.IsZero() {
+		metric("last_prune_duration_seconds", "gauge", "How long the last prune took.")
+		value("last_prune_duration_seconds", "", s.totals.lastPruneDuration.Seconds())
+		metric("last_prune_timestamp_seconds", "gauge", "When the last prune finished as a Unix timestamp.")
+		value("last_prune_timestamp_seconds", "", s.lastPrune.Unix())
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// serveMetrics serves the status as Prometheus metrics.
+func (s *runStatus) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = s.writeMetrics(w)
+}
+
+// writeMetricsFile writes the status as Prometheus metrics to the
+// -metrics-textfile file, atomically so the node_exporter textfile
+// collector never reads a partially written file.
+func (s *runStatus) writeMetricsFile() error {
+	var buf bytes.Buffer
+	if err := s.writeMetrics(&buf); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.metricsFile, buf.Bytes()); err != nil {
+		return fmt.Errorf("writing metrics file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/watch"
+)
+
+func TestMetrics(t *testing.T) {
+	metricsFile := filepath.Join(t.TempDir(), "go-cache-prune.prom")
+	status := &runStatus{metricsFile: metricsFile}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	status.watching(new(watch.Stats), start)
+
+	report := &pruneReport{
+		ModuleCache: &cacheReport{Deleted: []string{"a@v1.0.0", "b@v1.0.0"}, BytesFreed: 2048},
+		BuildCache:  &cacheReport{Deleted: []string{"00/x-d"}, BytesFreed: 10},
+	}
+	status.pruned(report, start, start.Add(1500*time.Millisecond))
+	// dry runs don't delete anything
+	status.pruned(&pruneReport{DryRun: true, BuildCache: &cacheReport{Deleted: []string{"00/y-d"}}}, start, start.Add(time.Second))
+	status.watching(new(watch.Stats), start.Add(time.Minute))
+	status.pruned(report, start, start.Add(time.Second))
+
+	rec := httptest.NewRecorder()
+	status.serveMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	metrics := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE go_cache_prune_watch_events_total counter\ngo_cache_prune_watch_events_total 0\n",
+		"go_cache_prune_prunes_total 2\n",
+		`go_cache_prune_deleted_entries_total{cache="build"} 2` + "\n",
+		`go_cache_prune_deleted_entries_total{cache="module"} 4` + "\n",
+		`go_cache_prune_freed_bytes_total{cache="module"} 4096` + "\n",
+		"go_cache_prune_last_prune_duration_seconds 1\n",
+		"go_cache_prune_last_prune_timestamp_seconds 1704067201\n",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, metrics)
+		}
+	}
+
+	textfile, err := os.ReadFile(metricsFile)
+	if err != nil {
+		t.Fatalf("reading metrics file: %v", err)
+	}
+	if string(textfile) != metrics {
+		t.Errorf("expected metrics file to contain:\n%s\ngot:\n%s", metrics, textfile)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+// usedModules returns the module versions of the used dependency dirs
+// of the module cache.
+func usedModules(modCache string, modFiles cache.UsedFiles) []module.Version {
+	var mods []module.Version
+	modFiles.Range(func(depDir string, _ cache.PhaseMask) bool {
+		if mod, ok := dirModule(modCache, depDir); ok {
+			mods = append(mods, mod)
+		}
+		return true
+	})
+	module.Sort(mods)
+
+	return mods
+}
+
+// dirModule returns the module version a dependency dir of the module
+// cache contains.
+func dirModule(modCache, depDir string) (module.Version, bool) {
+	relDir, err := filepath.Rel(modCache, depDir)
+	if err != nil {
+		return module.Version{}, false
+	}
+	escPath, escVer, ok := strings.Cut(filepath.ToSlash(relDir), "@")
+	if !ok {
+		return module.Version{}, false
+	}
+
+	modPath, err := module.UnescapePath(escPath)
+	if err != nil {
+		return module.Version{}, false
+	}
+	modVer, err := module.UnescapeVersion(escVer)
+	if err != nil {
+		return module.Version{}, false
+	}
+
+	return module.Version{Path: modPath, Version: modVer}, true
+}
+
+// writeUsedModules writes mods to path as module@version lines.
+func writeUsedModules(path string, mods []module.Version) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating used modules file: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, mod := range mods {
+		fmt.Fprintln(w, mod.String())
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing used modules file: %w", err)
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/internal/otlp"
+)
+
+// otlpExportTimeout is how long exporting spans and metrics of a prune
+// may take.
+const otlpExportTimeout = 10 * time.Second
+
+// tracing exports spans and metrics of watching and pruning caches to
+// an OpenTelemetry collector.
+type tracing struct {
+	exporter *otlp.Exporter
+	// traceID is the trace spans are part of, and parentID the span
+	// they are children of if set
+	traceID  string
+	parentID string
+	// started is when cumulative metrics started being recorded
+	started time.Time
+}
+
+// newTracing returns a tracing that exports to the -otlp-endpoint
+// endpoint, or the one the standard OTEL_EXPORTER_OTLP_ENDPOINT
+// environment variable sets. If neither is set nil is returned. Spans
+// are part of the trace TRACEPARENT refers to if it is set.
+func newTracing(cfg *config, now time.Time) (*tracing, error) {
+	endpoint := cfg.otlpEndpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+	headers, err := otlp.ParseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+	}
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "go-cache-prune"
+	}
+
+	t := &tracing{
+		exporter: &otlp.Exporter{
+			Endpoint: endpoint,
+			Headers:  headers,
+			Resource: []otlp.Attr{
+				{Key: "service.name", Value: serviceName},
+				{Key: "service.version", Value: version},
+			},
+		},
+		started: now,
+	}
+	if traceparent := os.Getenv("TRACEPARENT"); traceparent != "" {
+		var ok bool
+		t.traceID, t.parentID, ok = otlp.ParseTraceparent(traceparent)
+		if !ok {
+			log.Warningf("ignoring invalid TRACEPARENT %q", traceparent)
+		}
+	}
+	if t.traceID == "" {
+		t.traceID = otlp.NewTraceID()
+	}
+
+	return t, nil
+}
+
+// pruneSpans returns the spans of watching caches and the last prune of
+// s, which started at pruneStart. There are no watch spans if caches
+// weren't watched. It must be called with s.mu held.
+func (t *tracing) pruneSpans(s *runStatus, watchEvents uint64, pruneStart time.Time) []otlp.Span {
+	var spans []otlp.Span
+	span := func(name, parentID string, start, end time.Time, attrs ...otlp.Attr) string {
+		id := otlp.NewSpanID()
+		spans = append(spans, otlp.Span{
+			TraceID:      t.traceID,
+			SpanID:       id,
+			ParentSpanID: parentID,
+			Name:         name,
+			Start:        start,
+			End:          end,
+			Attrs:        attrs,
+		})
+		return id
+	}
+
+	// caches are never ready if they weren't watched
+	if !s.readyAt.IsZero() {
+		var used uint64
+		var watches int64
+		if s.stats != nil {
+			used, watches = s.stats.Used(), s.stats.Watches()
+		}
+		watchID := span("watch caches", t.parentID, s.since, pruneStart,
+			otlp.Attr{Key: "go_cache_prune.watch.events", Value: watchEvents},
+			otlp.Attr{Key: "go_cache_prune.watch.used_entries", Value: used},
+			otlp.Attr{Key: "go_cache_prune.watch.watches", Value: watches},
+		)
+		span("set up watches", watchID, s.since, s.readyAt)
+	}
+
+	attrs := []otlp.Attr{{Key: "go_cache_prune.dry_run", Value: s.lastReport.DryRun}}
+	for _, c := range s.lastReport.caches() {
+		attrs = append(attrs,
+			otlp.Attr{Key: "go_cache_prune." + c.name + ".deleted_entries", Value: len(c.report.Deleted)},
+			otlp.Attr{Key: "go_cache_prune." + c.name + ".bytes_freed", Value: c.report.BytesFreed},
+		)
+	}
+	span("prune caches", t.parentID, pruneStart, s.lastPrune, attrs...)
+
+	return spans
+}
+
+// metrics returns the metrics of watching and pruning caches. It must
+// be called with s.mu held.
+func (t *tracing) metrics(s *runStatus, watchEvents uint64) []otlp.Metric {
+	now := s.lastPrune
+	caches := make([]string, 0, len(s.totals.deleted))
+	for cache := range s.totals.deleted {
+		caches = append(caches, cache)
+	}
+	slices.Sort(caches)
+
+	var deleted, freed []otlp.Point
+	for _, cache := range caches {
+		attrs := []otlp.Attr{{Key: "cache", Value: cache}}
+		deleted = append(deleted, otlp.Point{Attrs: attrs, Start: t.started, Time: now, Value: int64(s.totals.deleted[cache])})
+		freed = append(freed, otlp.Point{Attrs: attrs, Start: t.started, Time: now, Value: s.totals.freed[cache]})
+	}
+
+	return []otlp.Metric{
+		{
+			Name:        "go_cache_prune.watch.events",
+			Description: "Cache events processed while watching.",
+			Unit:        "{event}",
+			Monotonic:   true,
+			Points:      []otlp.Point{{Start: t.started, Time: now, Value: int64(s.totals.pastEvents + watchEvents)}},
+		},
+		{
+			Name:        "go_cache_prune.deleted_entries",
+			Description: "Cache entries deleted.",
+			Unit:        "{entry}",
+			Monotonic:   true,
+			Points:      deleted,
+		},
+		{
+			Name:        "go_cache_prune.bytes_freed",
+			Description: "Bytes freed by deleting cache entries.",
+			Unit:        "By",
+			Monotonic:   true,
+			Points:      freed,
+		},
+		{
+			Name:        "go_cache_prune.prune.duration",
+			Description: "How long the last prune took.",
+			Unit:        "s",
+			Points:      []otlp.Point{{Time: now, Value: s.totals.lastPruneDuration.Seconds()}},
+		},
+	}
+}
+
+// export exports spans and metrics.
+func (t *tracing) export(spans []otlp.Span, metrics []otlp.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), otlpExportTimeout)
+	defer cancel()
+	if err := t.exporter.ExportSpans(ctx, spans); err != nil {
+		log.Warningf("exporting spans: %v", err)
+	}
+	if err := t.exporter.ExportMetrics(ctx, metrics); err != nil {
+		log.Warningf("exporting metrics: %v", err)
+	}
+}
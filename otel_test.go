@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/watch"
+)
+
+func TestTracingSpans(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	if tr, err := newTracing(&config{}, time.Now()); err != nil || tr != nil {
+		t.Fatalf("expected no tracing without an endpoint, got %v, %v", tr, err)
+	}
+
+	t.Setenv("TRACEPARENT", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr, err := newTracing(&config{otlpEndpoint: "http://localhost:4318"}, start)
+	if err != nil {
+		t.Fatalf("creating tracing: %v", err)
+	}
+
+	status := &runStatus{}
+	status.watching(new(watch.Stats), start)
+	status.ready(start.Add(time.Second))
+	pruneStart := start.Add(time.Minute)
+	status.lastPrune = pruneStart.Add(time.Second)
+	status.lastReport = &pruneReport{BuildCache: &cacheReport{Deleted: []string{"00/x-d"}, BytesFreed: 10}}
+	status.totals.addReport(status.lastReport)
+
+	spans := tr.pruneSpans(status, 0, pruneStart)
+	if len(spans) != 3 {
+		t.Fatalf("expected watch, watch setup and prune spans, got %+v", spans)
+	}
+	watchSpan, setupSpan, pruneSpan := spans[0], spans[1], spans[2]
+	for _, span := range spans {
+		if span.TraceID != "0af7651916cd43dd8448eb211c80319c" {
+			t.Errorf("expected span %q to be part of the TRACEPARENT trace, got trace %q", span.Name, span.TraceID)
+		}
+	}
+	if watchSpan.ParentSpanID != "b7ad6b7169203331" || pruneSpan.ParentSpanID != "b7ad6b7169203331" {
+		t.Error("expected watch and prune spans to be children of the TRACEPARENT span")
+	}
+	if setupSpan.ParentSpanID != watchSpan.SpanID || !setupSpan.End.Equal(start.Add(time.Second)) {
+		t.Errorf("unexpected watch setup span: %+v", setupSpan)
+	}
+	if !pruneSpan.Start.Equal(pruneStart) || !pruneSpan.End.Equal(status.lastPrune) {
+		t.Errorf("unexpected prune span: %+v", pruneSpan)
+	}
+
+	// caches that weren't watched only have a prune span
+	status.watching(new(watch.Stats), pruneStart)
+	if spans := tr.pruneSpans(status, 0, pruneStart); len(spans) != 1 {
+		t.Errorf("expected only a prune span, got %+v", spans)
+	}
+
+	metrics := tr.metrics(status, 5)
+	if len(metrics) != 4 || metrics[0].Points[0].Value != int64(5) || metrics[2].Points[0].Value != int64(10) {
+		t.Errorf("unexpected metrics: %+v", metrics)
+	}
+}
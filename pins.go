@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/module"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+const pinsFilename = "pins.json"
+
+// defaultPinsFile returns the file pins are stored in if -pins isn't
+// set. It isn't in the runtime dir as pins must survive reboots.
+func defaultPinsFile() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, projectName, pinsFilename)
+}
+
+// pins are cache entries that are never pruned until they are
+// unpinned. A pin is either a module@version, or the absolute path of a
+// module cache dir or build cache file.
+type pins struct {
+	mu   sync.Mutex
+	path string
+}
+
+// read returns the pins, none are returned if the file doesn't exist.
+func (p *pins) read() ([]string, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading pins: %w", err)
+	}
+	var pinned []string
+	if err := json.Unmarshal(data, &pinned); err != nil {
+		return nil, fmt.Errorf("parsing pins: %w", err)
+	}
+
+	return pinned, nil
+}
+
+// update replaces the pins with what f returns.
+func (p *pins) update(f func(pinned []string) ([]string, error)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pinned, err := p.read()
+	if err != nil {
+		return err
+	}
+	if pinned, err = f(pinned); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pinned, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding pins: %w", err)
+	}
+	if err := writeFileAtomic(p.path, append(data, '\n')); err != nil {
+		return fmt.Errorf("writing pins: %w", err)
+	}
+
+	return nil
+}
+
+// pin pins entry, which is checked by checkPin.
+func (p *pins) pin(entry string) error {
+	return p.update(func(pinned []string) ([]string, error) {
+		if slices.Contains(pinned, entry) {
+			return pinned, nil
+		}
+		pinned = append(pinned, entry)
+		slices.Sort(pinned)
+		return pinned, nil
+	})
+}
+
+// unpin removes the pin of entry.
+func (p *pins) unpin(entry string) error {
+	return p.update(func(pinned []string) ([]string, error) {
+		i := slices.Index(pinned, entry)
+		if i == -1 {
+			return nil, fmt.Errorf("%q isn't pinned", entry)
+		}
+		return slices.Delete(pinned, i, i+1), nil
+	})
+}
+
+// checkPin returns an error if entry isn't a module@version or the
+// absolute path of an entry in the module cache modCache or build cache
+// buildCache.
+func checkPin(entry, modCache, buildCache string) error {
+	if filepath.IsAbs(entry) {
+		if !cache.IsWithinDir(modCache, entry) && !cache.IsWithinDir(buildCache, entry) {
+			return fmt.Errorf("%q isn't in the module or build cache", entry)
+		}
+		return nil
+	}
+
+	modPath, ver, ok := strings.Cut(entry, "@")
+	if !ok {
+		return fmt.Errorf("%q must be a module@version or an absolute path", entry)
+	}
+	return module.Check(modPath, ver)
+}
+
+// apply marks the pinned entries as used in the module cache modCache
+// and build cache buildCache, either can be empty.
+func (p *pins) apply(modCache, buildCache string, modFiles, buildFiles cache.UsedFiles, dirLevel bool) error {
+	p.mu.Lock()
+	pinned, err := p.read()
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var (
+		mods []module.Version
+		n    int
+	)
+	for _, entry := range pinned {
+		switch {
+		case !filepath.IsAbs(entry):
+			if modCache == "" {
+				continue
+			}
+			modPath, ver, _ := strings.Cut(entry, "@")
+			mods = append(mods, module.Version{Path: modPath, Version: ver})
+		case cache.IsWithinDir(modCache, entry):
+			depDir, ok := cache.ContainingDependencyDir(modCache, entry)
+			if !ok {
+				continue
+			}
+			modFiles.Add(depDir, cache.DefaultPhaseMask)
+		case cache.IsWithinDir(buildCache, entry):
+			if dirLevel {
+				entry = filepath.Dir(entry)
+			}
+			buildFiles.Add(entry, cache.DefaultPhaseMask)
+		default:
+			continue
+		}
+		n++
+	}
+	keepModules(modCache, modFiles, mods)
+	if n != 0 {
+		log.Infof("keeping %d pinned entries", n)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+func TestPins(t *testing.T) {
+	root := t.TempDir()
+	p := &pins{path: filepath.Join(root, "pins", pinsFilename)}
+	modCache, buildCache := filepath.Join(root, "mod"), filepath.Join(root, "build")
+	buildEntry := filepath.Join(buildCache, "ab", "ab12-d")
+
+	for _, entry := range []string{"example.com/a@v1.0.0", buildEntry, filepath.Join(modCache, "example.com", "b@v1.2.0", "b.go")} {
+		if err := checkPin(entry, modCache, buildCache); err != nil {
+			t.Fatalf("checking pin %q: %v", entry, err)
+		}
+		if err := p.pin(entry); err != nil {
+			t.Fatalf("pinning %q: %v", entry, err)
+		}
+	}
+	// pinning twice does nothing
+	if err := p.pin("example.com/a@v1.0.0"); err != nil {
+		t.Fatalf("pinning again: %v", err)
+	}
+	if err := p.unpin(buildEntry); err != nil {
+		t.Fatalf("unpinning: %v", err)
+	}
+	if err := p.unpin(buildEntry); err == nil {
+		t.Error("expected unpinning an entry that isn't pinned to fail")
+	}
+
+	modFiles, buildFiles := cache.NewUsedFiles(), cache.NewUsedFiles()
+	if err := p.apply(modCache, buildCache, modFiles, buildFiles, false); err != nil {
+		t.Fatalf("applying pins: %v", err)
+	}
+	want := []string{
+		filepath.Join(modCache, "example.com", "a@v1.0.0"),
+		filepath.Join(modCache, "example.com", "b@v1.2.0"),
+	}
+	got := make([]string, 0, modFiles.Len())
+	modFiles.Range(func(path string, _ cache.PhaseMask) bool {
+		got = append(got, path)
+		return true
+	})
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("expected pinned module dirs %q, got %q", want, got)
+	}
+	if buildFiles.Len() != 0 {
+		t.Errorf("expected no pinned build entries, got %d", buildFiles.Len())
+	}
+
+	for _, entry := range []string{"example.com/a", "relative/path", filepath.Join(root, "elsewhere")} {
+		if err := checkPin(entry, modCache, buildCache); err == nil {
+			t.Errorf("expected pin %q to be invalid", entry)
+		}
+	}
+}
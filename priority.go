@@ -0,0 +1,22 @@
+package main
+
+import "sync"
+
+var lowerPriorityOnce sync.Once
+
+// lowerPriority lowers the CPU and I/O priority of go-cache-prune if
+// -low-priority is set, so pruning doesn't compete with builds running
+// at the same time. The priority stays lowered until go-cache-prune
+// exits.
+func lowerPriority(cfg *config) {
+	if !cfg.lowPriority {
+		return
+	}
+	lowerPriorityOnce.Do(func() {
+		if err := setLowPriority(); err != nil {
+			log.Warningf("lowering priority: %v", err)
+			return
+		}
+		log.Debugf("lowered CPU and I/O priority")
+	})
+}
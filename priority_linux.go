@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// niceLowest is the nice value with the lowest CPU priority
+	niceLowest = 19
+
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// setLowPriority sets the lowest nice value and the idle I/O scheduling
+// class. Both are per thread on Linux, so every thread of the process
+// is changed, and threads created later inherit them.
+func setLowPriority() error {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("listing threads: %w", err)
+	}
+
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		err = unix.Setpriority(unix.PRIO_PROCESS, tid, niceLowest)
+		if errors.Is(err, unix.ESRCH) {
+			// the thread exited
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("setting nice value: %w", err)
+		}
+		_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift)
+		if errno != 0 && errno != unix.ESRCH {
+			return fmt.Errorf("setting I/O priority: %w", errno)
+		}
+	}
+
+	return nil
+}
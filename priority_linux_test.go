@@ -0,0 +1,34 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSetLowPriority(t *testing.T) {
+	// lowering the priority of the test process is harmless, it exits
+	// once the tests are done
+	if err := setLowPriority(); err != nil {
+		t.Fatalf("lowering priority: %v", err)
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	// the raw syscall returns 20 minus the nice value
+	prio, err := unix.Getpriority(unix.PRIO_PROCESS, 0)
+	if err != nil {
+		t.Fatalf("getting priority: %v", err)
+	}
+	if nice := 20 - prio; nice != niceLowest {
+		t.Errorf("expected nice value %d, got %d", niceLowest, nice)
+	}
+	ioprio, _, errno := unix.Syscall(unix.SYS_IOPRIO_GET, ioprioWhoProcess, 0, 0)
+	if errno != 0 {
+		t.Fatalf("getting I/O priority: %v", errno)
+	}
+	if class := ioprio >> ioprioClassShift; class != ioprioClassIdle {
+		t.Errorf("expected I/O scheduling class %d, got %d", ioprioClassIdle, class)
+	}
+}
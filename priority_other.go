@@ -0,0 +1,21 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// niceLowest is the nice value with the lowest CPU priority
+const niceLowest = 20
+
+// setLowPriority sets the lowest nice value, the I/O priority can't be
+// changed.
+func setLowPriority() error {
+	if err := unix.Setpriority(unix.PRIO_PROCESS, 0, niceLowest); err != nil {
+		return fmt.Errorf("setting nice value: %w", err)
+	}
+	return nil
+}
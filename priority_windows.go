@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// setLowPriority puts the process in background processing mode, which
+// lowers both its CPU and I/O priority.
+func setLowPriority() error {
+	if err := windows.SetPriorityClass(windows.CurrentProcess(), windows.PROCESS_MODE_BACKGROUND_BEGIN); err != nil {
+		return fmt.Errorf("entering background processing mode: %w", err)
+	}
+	return nil
+}
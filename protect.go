@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// readProtectFile reads the module path patterns in the file at path,
+// one per line. Empty lines and lines starting with '#' are ignored.
+func readProtectFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening protected modules file: %w", err)
+	}
+	defer f.Close()
+
+	var patterns []string
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := checkModulePattern(text); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		patterns = append(patterns, text)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("reading protected modules file: %w", err)
+	}
+
+	return patterns, nil
+}
+
+// checkModulePattern returns an error if pattern isn't a valid module
+// path pattern as used by GOPRIVATE.
+func checkModulePattern(pattern string) error {
+	if strings.Contains(pattern, ",") {
+		return fmt.Errorf("pattern %q must not contain a comma", pattern)
+	}
+	for _, elem := range strings.Split(pattern, "/") {
+		if _, err := path.Match(elem, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
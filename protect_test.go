@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReadProtectFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "protect.txt")
+	const data = `# slow to download through the proxy
+corp.example.com/huge
+
+*.corp.example.com
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("writing protected modules file: %v", err)
+	}
+
+	patterns, err := readProtectFile(path)
+	if err != nil {
+		t.Fatalf("reading protected modules file: %v", err)
+	}
+	want := []string{"corp.example.com/huge", "*.corp.example.com"}
+	if !slices.Equal(patterns, want) {
+		t.Errorf("readProtectFile() = %q, want %q", patterns, want)
+	}
+
+	if err := os.WriteFile(path, []byte("corp.example.com/[\n"), 0o644); err != nil {
+		t.Fatalf("writing protected modules file: %v", err)
+	}
+	if _, err := readProtectFile(path); err == nil {
+		t.Error("expected invalid pattern to fail")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/internal/goproxy"
+)
+
+// startProxyMonitor starts a module proxy in front of the configured
+// proxies that records which modules are downloaded through it, and
+// returns the value of GOPROXY that makes the go command use it. The
+// proxy is stopped when ctx is canceled.
+func startProxyMonitor(ctx context.Context) (*goproxy.Monitor, string, error) {
+	var env []string
+	for _, name := range []string{"GOPROXY", "GONOPROXY", "GOAUTH"} {
+		value, err := goEnvDefault(name)
+		if err != nil {
+			return nil, "", fmt.Errorf("getting %s: %w", name, err)
+		}
+		env = append(env, value)
+	}
+	client, unsupported, err := goproxy.NewClient(env[0], env[1], env[2])
+	if err != nil {
+		return nil, "", fmt.Errorf("configuring proxy monitor: %w", err)
+	}
+	for _, method := range unsupported {
+		log.Warningf("GOAUTH method %q isn't supported by the proxy monitor, ignoring it", method)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", fmt.Errorf("listening for proxy requests: %w", err)
+	}
+	monitor := goproxy.NewMonitor(client)
+	srv := &http.Server{
+		Handler:           monitor,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Warningf("serving proxy requests: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	// the go command falls back to the next entry of GOPROXY when the
+	// monitor doesn't find a module
+	cmdProxy := "http://" + ln.Addr().String()
+	if client.FallsBackToDirect() {
+		cmdProxy += "," + goproxy.Direct
+	}
+	log.Infof("proxy monitor is serving GOPROXY=%s to the command", cmdProxy)
+
+	return monitor, cmdProxy, nil
+}
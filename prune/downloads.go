@@ -0,0 +1,77 @@
+package prune
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+	"github.com/capnspacehook/go-cache-prune/internal/debuglog"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+// downloadExts are the extensions of the files the go command downloads
+// for a module version to cache/download/<module>/@v.
+var downloadExts = []string{".zip", ".ziphash", ".mod", ".info"}
+
+// metadataExts are the extensions of the downloaded files that describe
+// a module version instead of containing it.
+var metadataExts = []string{".mod", ".info"}
+
+// downloadFiles returns the paths of the files in cache/download of the
+// module cache modCache for the module version of the dependency dir
+// depDir. They may not exist. If keepMetadata is true the small
+// metadata files aren't returned.
+func downloadFiles(modCache, depDir string, keepMetadata bool) []string {
+	relPath, err := filepath.Rel(modCache, depDir)
+	if err != nil {
+		return nil
+	}
+	escPath, escVer, ok := strings.Cut(filepath.ToSlash(relPath), "@")
+	if !ok {
+		return nil
+	}
+
+	vDir := filepath.Join(modCache, "cache", "download", filepath.FromSlash(escPath), "@v")
+	var files []string
+	for _, ext := range downloadExts {
+		if keepMetadata && slices.Contains(metadataExts, ext) {
+			continue
+		}
+		files = append(files, filepath.Join(vDir, escVer+ext))
+	}
+	return files
+}
+
+// deleteDownloads deletes the downloaded files of the module version
+// of the dependency dir depDir from the module cache with rm and
+// returns how many bytes were freed. If keepMetadata is true the .mod
+// and .info files are kept. If dryRun is true they are only logged.
+func deleteDownloads(log logging.Logger, rm remover, depDir string, keepMetadata, dryRun bool) int64 {
+	var freed int64
+	for _, path := range downloadFiles(rm.dir, depDir, keepMetadata) {
+		info, err := os.Lstat(path)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				log.Warningf("getting info of %q: %v", path, err)
+			}
+			continue
+		}
+		if dryRun {
+			log.Infof("would delete file %q from module cache, %s", path, bytesize.Format(info.Size()))
+			freed += info.Size()
+			continue
+		}
+		if err := rm.remove(path); err != nil {
+			log.Warningf("deleting file from module cache: %v", err)
+			continue
+		}
+		debuglog.Debugf("module deletion", path, "%s file %q from module cache", rm.verb(), path)
+		freed += info.Size()
+	}
+
+	return freed
+}
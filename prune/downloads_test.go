@@ -0,0 +1,29 @@
+package prune
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDownloadFiles(t *testing.T) {
+	modCache := filepath.FromSlash("/cache/mod")
+	depDir := filepath.Join(modCache, "github.com", "!burnt!sushi", "toml@v1.3.2")
+	vDir := filepath.Join(modCache, "cache", "download", "github.com", "!burnt!sushi", "toml", "@v")
+
+	got := downloadFiles(modCache, depDir, false)
+	want := []string{
+		filepath.Join(vDir, "v1.3.2.zip"),
+		filepath.Join(vDir, "v1.3.2.ziphash"),
+		filepath.Join(vDir, "v1.3.2.mod"),
+		filepath.Join(vDir, "v1.3.2.info"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("downloadFiles() = %q, want %q", got, want)
+	}
+
+	got = downloadFiles(modCache, depDir, true)
+	if !slices.Equal(got, want[:2]) {
+		t.Errorf("downloadFiles() keeping metadata = %q, want %q", got, want[:2])
+	}
+}
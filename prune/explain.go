@@ -0,0 +1,89 @@
+package prune
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+// Verdict is the verdict of a rule on an entry of a cache.
+type Verdict struct {
+	// Rule is the name of the rule
+	Rule string
+	// Keep is true if the rule keeps the entry
+	Keep bool
+}
+
+// Explanation is why an entry of a cache would be kept or deleted.
+type Explanation struct {
+	// Entry is the dependency dir of a module cache entry, or the path
+	// of a build cache file
+	Entry string
+	// LastUsed is when the entry was last modified, accessed or changed
+	LastUsed time.Time
+	// LastAccessed is when the entry was last modified or accessed
+	LastAccessed time.Time
+	// Added is when the entry was added to the cache
+	Added time.Time
+	// Anomaly is why the entry would be quarantined if it isn't empty
+	Anomaly string
+	// Verdicts are the verdicts of every rule in the order they are
+	// evaluated
+	Verdicts []Verdict
+	// Deleted is true if the entry would be deleted when the whole
+	// cache is pruned. It is false even if no rule keeps the entry when
+	// Options.MaxSize is set and the cache is small enough without
+	// deleting it.
+	Deleted bool
+}
+
+// Explain explains why the entry of the cache dir that contains path
+// would be kept or deleted if the cache was pruned. The cache is pruned
+// as a dry run to find out, nothing in it is changed.
+func Explain(ctx context.Context, dir string, isModCache bool, path string, usedFiles cache.UsedFiles, opts Options) (Explanation, error) {
+	entry, usedPath := path, path
+	if isModCache {
+		depDir, ok := cache.ContainingDependencyDir(dir, path)
+		if !ok {
+			return Explanation{}, fmt.Errorf("%q isn't in a dependency dir of the module cache", path)
+		}
+		entry, usedPath = depDir, depDir
+	} else if opts.DirLevel {
+		usedPath = filepath.Dir(path)
+	}
+
+	info, err := os.Lstat(entry)
+	if err != nil {
+		return Explanation{}, err
+	}
+	if !isModCache && info.IsDir() {
+		return Explanation{}, fmt.Errorf("%q is a dir, build cache entries are files", path)
+	}
+
+	e := Explanation{
+		Entry:        entry,
+		LastUsed:     cache.LastUsed(info),
+		LastAccessed: cache.LastAccessed(info),
+		Added:        info.ModTime(),
+	}
+	if !isModCache && opts.QuarantineDir != "" {
+		e.Anomaly = buildEntryAnomaly(dir, entry, info.Size())
+	}
+	used := usedFiles.Has(usedPath)
+	re := ruleEntry{name: filterName(dir, entry, isModCache), modCache: isModCache, info: info, used: used}
+	for _, rule := range keepRules {
+		e.Verdicts = append(e.Verdicts, Verdict{Rule: rule.name, Keep: rule.keeps(re, opts)})
+	}
+
+	opts.DryRun = true
+	res := Cache(logging.NewContext(ctx, logging.Discard), dir, isModCache, usedFiles, opts)
+	e.Deleted = slices.Contains(res.DeletedPaths, entry)
+
+	return e, nil
+}
@@ -0,0 +1,79 @@
+package prune
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+func TestExplain(t *testing.T) {
+	modCache := t.TempDir()
+	usedDir := filepath.Join(modCache, "example.com", "used@v1.0.0")
+	unusedDir := filepath.Join(modCache, "example.com", "unused@v1.0.0")
+	old := time.Now().Add(-48 * time.Hour)
+	for _, dir := range []string{usedDir, unusedDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("creating dependency dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com\n"), 0o644); err != nil {
+			t.Fatalf("writing go.mod: %v", err)
+		}
+		if err := os.Chtimes(dir, old, old); err != nil {
+			t.Fatalf("setting times of dependency dir: %v", err)
+		}
+	}
+	usedFiles := cache.NewUsedFiles(usedDir)
+	opts := Options{KeepAddedAfter: time.Now().Add(-24 * time.Hour)}
+
+	tests := []struct {
+		path     string
+		entry    string
+		verdicts []bool
+		deleted  bool
+	}{
+		{
+			path:     filepath.Join(usedDir, "go.mod"),
+			entry:    usedDir,
+			verdicts: []bool{true, false, false, false, false, false},
+		},
+		{
+			path:     unusedDir,
+			entry:    unusedDir,
+			verdicts: []bool{false, false, false, false, false, false},
+			deleted:  true,
+		},
+	}
+	for _, tt := range tests {
+		e, err := Explain(context.Background(), modCache, true, tt.path, usedFiles, opts)
+		if err != nil {
+			t.Fatalf("explaining %q: %v", tt.path, err)
+		}
+		if e.Entry != tt.entry {
+			t.Errorf("expected entry %q, got %q", tt.entry, e.Entry)
+		}
+		if len(e.Verdicts) != len(tt.verdicts) {
+			t.Fatalf("expected %d verdicts, got %v", len(tt.verdicts), e.Verdicts)
+		}
+		for i, keep := range tt.verdicts {
+			if e.Verdicts[i].Keep != keep {
+				t.Errorf("%q: expected rule %q to keep = %t", tt.path, e.Verdicts[i].Rule, keep)
+			}
+		}
+		if e.Deleted != tt.deleted {
+			t.Errorf("%q: expected deleted = %t", tt.path, tt.deleted)
+		}
+	}
+
+	// nothing is deleted when explaining
+	if _, err := os.Stat(unusedDir); err != nil {
+		t.Errorf("expected unused dependency dir to be kept, got %v", err)
+	}
+
+	if _, err := Explain(context.Background(), modCache, true, filepath.Join(modCache, "example.com"), usedFiles, opts); err == nil {
+		t.Error("expected explaining a path outside of dependency dirs to fail")
+	}
+}
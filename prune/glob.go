@@ -0,0 +1,84 @@
+package prune
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// MatchGlob reports whether name matches the glob pattern. Patterns are
+// matched per slash separated element like path.Match, and an element
+// that is '**' matches any number of elements, including none. The only
+// possible error is path.ErrBadPattern.
+func MatchGlob(pattern, name string) (bool, error) {
+	elems := strings.Split(pattern, "/")
+	for _, elem := range elems {
+		if _, err := path.Match(elem, ""); err != nil {
+			return false, err
+		}
+	}
+	return matchElems(elems, strings.Split(name, "/")), nil
+}
+
+func matchElems(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+
+	return len(elems) == 0
+}
+
+// filterName returns the name include and exclude patterns are matched
+// against for the entry at path of the cache dir: the module path of a
+// module cache entry, or the slash separated path relative to the cache
+// of a build cache entry.
+func filterName(dir, path string, isModCache bool) string {
+	relPath, err := filepath.Rel(dir, path)
+	if err != nil {
+		return ""
+	}
+	relPath = filepath.ToSlash(relPath)
+	if !isModCache {
+		return relPath
+	}
+
+	escPath, _, _ := strings.Cut(relPath, "@")
+	if modPath, err := module.UnescapePath(escPath); err == nil {
+		return modPath
+	}
+	return escPath
+}
+
+// filteredOut returns true if the entry named name isn't matched by any
+// of opts.Include if it is set, or is matched by any of opts.Exclude.
+func filteredOut(name string, opts Options) bool {
+	if len(opts.Include) != 0 && !matchesAny(opts.Include, name) {
+		return true
+	}
+	return matchesAny(opts.Exclude, name)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := MatchGlob(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package prune
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "github.com/mycorp/**", name: "github.com/mycorp/app", want: true},
+		{pattern: "github.com/mycorp/**", name: "github.com/mycorp/app/v2", want: true},
+		{pattern: "github.com/mycorp/**", name: "github.com/mycorp", want: true},
+		{pattern: "github.com/mycorp/**", name: "github.com/other/app", want: false},
+		{pattern: "github.com/*/app", name: "github.com/mycorp/app", want: true},
+		{pattern: "github.com/*/app", name: "github.com/mycorp/app/v2", want: false},
+		{pattern: "**/v2", name: "github.com/mycorp/app/v2", want: true},
+		{pattern: "0?/**", name: "0a/0a12-d", want: true},
+		{pattern: "0?/**", name: "1a/1a12-d", want: false},
+		{pattern: "**", name: "anything/at/all", want: true},
+	}
+	for _, tt := range tests {
+		got, err := MatchGlob(tt.pattern, tt.name)
+		if err != nil {
+			t.Fatalf("MatchGlob(%q, %q): %v", tt.pattern, tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("MatchGlob(%q, %q) = %t, want %t", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := MatchGlob("a/[", ""); err == nil {
+		t.Error("expected bad pattern to fail")
+	}
+}
+
+func TestCacheFilters(t *testing.T) {
+	modCache := t.TempDir()
+	depDirs := map[string]string{
+		"github.com/mycorp/app": filepath.Join(modCache, "github.com", "mycorp", "app@v1.0.0"),
+		"github.com/MyCorp/lib": filepath.Join(modCache, "github.com", "!my!corp", "lib@v1.0.0"),
+		"github.com/other/app":  filepath.Join(modCache, "github.com", "other", "app@v1.0.0"),
+		"golang.org/x/mod":      filepath.Join(modCache, "golang.org", "x", "mod@v0.20.0"),
+	}
+	for _, depDir := range depDirs {
+		if err := os.MkdirAll(depDir, 0o755); err != nil {
+			t.Fatalf("creating dependency dir: %v", err)
+		}
+	}
+
+	opts := Options{
+		Include: []string{"github.com/**"},
+		Exclude: []string{"github.com/mycorp/**", "github.com/MyCorp/**"},
+	}
+	res := Cache(context.Background(), modCache, true, cache.UsedFiles{}, opts)
+	if res.Deleted != 1 {
+		t.Fatalf("expected 1 dir to be deleted, got %d", res.Deleted)
+	}
+	for modPath, depDir := range depDirs {
+		_, err := os.Stat(depDir)
+		if exists := err == nil; exists != (modPath != "github.com/other/app") {
+			t.Errorf("dependency dir of %s exists = %t", modPath, exists)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package prune
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/capnspacehook/go-cache-prune/internal/flock"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+// LockFileName is the name of the file in the root of a cache that is
+// locked while the cache is being pruned.
+const LockFileName = StateFilePrefix + "lock"
+
+// lockCache takes the prune lock of the cache dir so other
+// go-cache-prune processes don't prune it at the same time, waiting for
+// at most opts.LockTimeout if another process holds it. It returns
+// false if the cache shouldn't be pruned because the lock wasn't
+// released in time or ctx was canceled. If the lock can't be taken for
+// another reason the cache is pruned without it.
+func lockCache(ctx context.Context, dir string, opts Options) (func(), bool) {
+	log := logging.FromContext(ctx)
+
+	unlock, err := flock.Lock(ctx, filepath.Join(dir, LockFileName), opts.LockTimeout)
+	switch {
+	case err == nil:
+		return func() {
+			if err := unlock(); err != nil {
+				log.Warningf("unlocking %q: %v", dir, err)
+			}
+		}, true
+	case errors.Is(err, flock.ErrTimeout):
+		log.Warningf("not pruning %q, another process is still pruning it after waiting %s", dir, opts.LockTimeout)
+		return nil, false
+	case ctx.Err() != nil:
+		return nil, false
+	case errors.Is(err, fs.ErrNotExist):
+		// there's nothing to prune
+		return func() {}, true
+	default:
+		log.Warningf("pruning %q without locking it: %v", dir, err)
+		return func() {}, true
+	}
+}
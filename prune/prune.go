@@ -0,0 +1,712 @@
+// Package prune deletes entries of Go's module and build caches that
+// weren't used.
+package prune
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/clock"
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+	"github.com/capnspacehook/go-cache-prune/internal/debuglog"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+// Caches deletes the entries of the module cache modCache and the
+// build cache buildCache that aren't in modFiles and buildFiles
+// respectively and logs what was pruned. Either cache can be empty to
+// not prune it. The results of pruning each cache are returned.
+func Caches(ctx context.Context, modCache, buildCache string, modFiles, buildFiles cache.UsedFiles, opts Options) (modRes, buildRes Result) {
+	log := logging.FromContext(ctx)
+	log.Group("Pruning cache files")
+	defer log.EndGroup()
+
+	// both caches share workers so the total I/O is bounded
+	opts.workers = opts.deleteWorkers()
+	opts.throttle = opts.deleteThrottle()
+	var wg sync.WaitGroup
+
+	if modCache != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			modOpts := opts
+			modOpts.TrashDir = trashSubdir(opts.TrashDir, "mod")
+			modRes = Cache(ctx, modCache, true, modFiles, modOpts)
+			if modRes.Interrupted {
+				log.Warningf("pruning module cache was interrupted")
+			}
+			if opts.DryRun {
+				log.Infof("would delete %d directories from module cache, freeing %s", modRes.Deleted, bytesize.Format(modRes.BytesFreed))
+			} else {
+				log.Infof("deleted %d directories from module cache, freed %s", modRes.Deleted, bytesize.Format(modRes.BytesFreed))
+			}
+			if opts.SoftDelete > 0 {
+				log.Infof("marked %d unused directories of module cache for deletion in %s", modRes.Marked, opts.SoftDelete)
+			}
+			log.Infof("module cache kept %s", modRes.KeptEntries.Summary())
+			log.Infof("module cache deleted %s", modRes.DeletedEntries.Summary())
+		}()
+	}
+
+	if buildCache != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			buildOpts := opts
+			buildOpts.DirLevel = opts.BuildDirLevel
+			buildOpts.TrashDir = trashSubdir(opts.TrashDir, "build")
+			buildRes = Cache(ctx, buildCache, false, buildFiles, buildOpts)
+			if buildRes.Interrupted {
+				log.Warningf("pruning build cache was interrupted")
+			}
+			if opts.DryRun {
+				log.Infof("would delete %d files from build cache, freeing %s", buildRes.Deleted, bytesize.Format(buildRes.BytesFreed))
+			} else {
+				log.Infof("deleted %d files from build cache, freed %s", buildRes.Deleted, bytesize.Format(buildRes.BytesFreed))
+			}
+			if opts.SoftDelete > 0 {
+				log.Infof("marked %d unused files of build cache for deletion in %s", buildRes.Marked, opts.SoftDelete)
+			}
+			if opts.QuarantineDir != "" && opts.DryRun {
+				log.Infof("would quarantine %d files from build cache", buildRes.Quarantined)
+			} else if opts.QuarantineDir != "" {
+				log.Infof("quarantined %d files from build cache", buildRes.Quarantined)
+			}
+			log.Infof("build cache kept %s", buildRes.KeptEntries.Summary())
+			log.Infof("build cache deleted %s", buildRes.DeletedEntries.Summary())
+		}()
+	}
+
+	wg.Wait()
+	debuglog.Flush()
+
+	// the total matters when the caches have to fit within a size limit
+	// together, like the one of GitHub Actions caches
+	if modCache != "" && buildCache != "" {
+		freed := modRes.BytesFreed + buildRes.BytesFreed
+		kept := modRes.KeptEntries.TotalSize() + buildRes.KeptEntries.TotalSize()
+		if opts.DryRun {
+			log.Infof("would free %s in total, %s would be left in the caches", bytesize.Format(freed), bytesize.Format(kept))
+		} else {
+			log.Infof("freed %s in total, %s is left in the caches", bytesize.Format(freed), bytesize.Format(kept))
+		}
+	}
+
+	return modRes, buildRes
+}
+
+// Options configures how caches are pruned.
+type Options struct {
+	// DirLevel is true if usage was recorded per directory instead of
+	// per file
+	DirLevel bool
+	// BuildDirLevel is DirLevel for the build cache when using Caches
+	BuildDirLevel bool
+	// KeepUsedAfter causes entries that were modified or accessed after
+	// it to be kept even if they weren't recorded as used
+	KeepUsedAfter time.Time
+	// KeepAccessedAfter causes entries that were modified or accessed
+	// after it to be kept even if they weren't recorded as used. Unlike
+	// KeepUsedAfter change times are ignored, so restored entries
+	// aren't treated as recently used.
+	KeepAccessedAfter time.Time
+	// KeepAddedAfter causes entries that were added to the cache after
+	// it to be kept even if they weren't recorded as used
+	KeepAddedAfter time.Time
+	// QuarantineDir is where invalid build cache entries are moved to
+	// if set
+	QuarantineDir string
+	// ReadOnlyModCache causes kept module cache entries to be made
+	// read-only
+	ReadOnlyModCache bool
+	// ModCacheWritable causes read-only module cache entries to be
+	// deleted without making them writable first, as the module cache
+	// is expected to be writable, for example because the go command
+	// was run with -modcacherw. Entries that can't be deleted are still
+	// made writable and deleted again.
+	ModCacheWritable bool
+	// Clock is used to get the ages of entries, the system clock is
+	// used if it is nil
+	Clock clock.Clock
+	// DryRun causes entries that would be deleted or quarantined to only
+	// be logged, nothing in the cache is changed
+	DryRun bool
+	// MaxSize causes unused entries to only be deleted until the
+	// entries of the cache total at most MaxSize bytes if it is greater
+	// than 0. Entries that were used least recently are deleted first.
+	MaxSize int64
+	// KeepFuzzCache causes the fuzz corpus cache in the fuzz dir of
+	// the build cache to never be pruned
+	KeepFuzzCache bool
+	// MaxFuzzSize is like MaxSize for the fuzz corpus cache in the
+	// build cache if it is greater than 0. Its entries don't count
+	// towards MaxSize then.
+	MaxFuzzSize int64
+	// Concurrency is how many entries are deleted at the same time,
+	// across both caches when using Caches. DefaultConcurrency is used
+	// if it is 0 or less.
+	Concurrency int
+	// DeleteRate limits how many entries are deleted per second,
+	// across both caches when using Caches, if it is greater than 0
+	DeleteRate float64
+	// DeleteBytesRate limits how many bytes of entries are deleted per
+	// second like DeleteRate if it is greater than 0
+	DeleteBytesRate int64
+	// DeletePause is how long to pause after starting to delete every
+	// Concurrency entries if it is greater than 0, so other processes
+	// can use the disk in between
+	DeletePause time.Duration
+	// Include causes only entries matching any of its glob patterns to
+	// be pruned if it is set, see MatchGlob. Module cache entries are
+	// matched by module path, build cache entries by their slash
+	// separated path relative to the cache.
+	Include []string
+	// Exclude causes entries matching any of its glob patterns to never
+	// be pruned
+	Exclude []string
+	// KeepModMetadata causes the .mod and .info files of deleted module
+	// versions to be kept in cache/download, so resolving and verifying
+	// module graphs doesn't require downloading them again. Only the
+	// extracted module and its zip are deleted.
+	KeepModMetadata bool
+	// SoftDelete causes unused entries to only be marked for deletion,
+	// and deleted when pruning again at least SoftDelete later if they
+	// are still unused. Marks are stored in TombstonesName in the root
+	// of the cache. Marked entries count as deleted for MaxSize.
+	SoftDelete time.Duration
+	// Protect causes modules whose path matches any of its patterns to
+	// never be pruned from the module cache. Patterns are matched like
+	// the ones of GOPRIVATE, see module.MatchPrefixPatterns.
+	Protect []string
+	// KeepToolchains causes Go toolchains downloaded to the module
+	// cache to never be pruned
+	KeepToolchains bool
+	// TrashDir causes unused entries to be moved to the same path
+	// relative to the cache in it instead of being deleted if set, so
+	// they can be restored. Caches moves entries of the module and
+	// build caches to its mod and build subdirs.
+	TrashDir string
+	// LockTimeout is how long to wait for another process pruning a
+	// cache to finish before skipping it. Caches are locked with
+	// LockFileName in their root while they are pruned, except on dry
+	// runs.
+	LockTimeout time.Duration
+
+	workers  *workerPool
+	throttle *throttle
+	// locked is true if the cache was already locked by the caller
+	locked bool
+	// skipDirs are dirs of a build cache that aren't walked
+	skipDirs []string
+}
+
+// Result is what was pruned from a cache.
+type Result struct {
+	// Deleted is how many entries were deleted
+	Deleted uint
+	// DeletedPaths are the paths of the entries that were deleted
+	DeletedPaths []string
+	// Quarantined is how many invalid entries were quarantined
+	Quarantined uint
+	// Marked is how many unused entries were only marked for deletion
+	// because Options.SoftDelete is set
+	Marked uint
+	// Interrupted is true if pruning stopped early
+	Interrupted bool
+	// KeptEntries are the ages and sizes of entries that were kept
+	KeptEntries EntryInfos
+	// DeletedEntries are the ages and sizes of entries that were
+	// deleted
+	DeletedEntries EntryInfos
+	// BytesFreed is the total size of the entries that were deleted
+	BytesFreed int64
+}
+
+// addDeleted records that the entry at path was deleted. It must be
+// called with mu held when entries are deleted concurrently.
+func (r *Result) addDeleted(path string, info EntryInfo) {
+	r.Deleted++
+	r.DeletedPaths = append(r.DeletedPaths, path)
+	r.DeletedEntries = append(r.DeletedEntries, info)
+	r.BytesFreed += info.Size
+}
+
+// Cache deletes entries of a cache that weren't used. If ctx is
+// canceled pruning stops and the results so far are returned.
+func Cache(ctx context.Context, dir string, isModCache bool, usedFiles cache.UsedFiles, opts Options) Result {
+	log := logging.FromContext(ctx)
+	if !opts.DryRun && !opts.locked {
+		unlock, ok := lockCache(ctx, dir, opts)
+		if !ok {
+			return Result{Interrupted: ctx.Err() != nil}
+		}
+		defer unlock()
+	}
+
+	var (
+		res Result
+		// limits on the size of the cache and the fuzz corpus cache in
+		// the build cache
+		limit     = sizeLimit{max: opts.MaxSize}
+		fuzzLimit = sizeLimit{max: opts.MaxFuzzSize}
+		fuzzDir   = filepath.Join(dir, "fuzz")
+		now       = clock.Or(opts.Clock).Now()
+
+		workers  = opts.deleteWorkers()
+		throttle = opts.deleteThrottle()
+		wg       sync.WaitGroup
+		// guards the deleted entries of res and failedSize while
+		// entries are being deleted
+		mu sync.Mutex
+		// total size of entries that couldn't be deleted
+		failedSize int64
+	)
+	rm := newRemover(dir, opts)
+	var tombs *tombstones
+	if opts.SoftDelete > 0 {
+		var err error
+		tombs, err = loadTombstones(dir)
+		if err != nil {
+			log.Warningf("%v", err)
+		}
+		// entries marked in this run are marked again by the next one
+		// if the tombstones aren't saved
+		defer func() {
+			if res.Interrupted || opts.DryRun {
+				return
+			}
+			if err := tombs.save(); err != nil {
+				log.Warningf("%v", err)
+			}
+		}()
+	}
+	// deleteEntry deletes an unused entry with a worker, or only marks
+	// it for deletion if it wasn't marked long enough ago
+	deleteEntry := func(path string, info EntryInfo, readOnly bool) {
+		if tombs != nil {
+			relPath, err := filepath.Rel(dir, path)
+			if err != nil {
+				return
+			}
+			if !tombs.mark(filepath.ToSlash(relPath), now, opts.SoftDelete) {
+				res.Marked++
+				return
+			}
+		}
+		if err := throttle.wait(ctx, info.Size); err != nil {
+			return
+		}
+		workers.run(&wg, func() {
+			var (
+				deleted        bool
+				downloadsFreed int64
+			)
+			if isModCache {
+				deleted = deleteModDir(log, rm, path, info, readOnly && !opts.ModCacheWritable, opts.DryRun)
+				// the downloaded zip and metadata aren't needed anymore
+				// either
+				if deleted {
+					downloadsFreed = deleteDownloads(log, rm, path, opts.KeepModMetadata, opts.DryRun)
+				}
+			} else {
+				deleted = deleteBuildFile(log, rm, path, info, opts.DryRun)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if deleted {
+				res.addDeleted(path, info)
+				res.BytesFreed += downloadsFreed
+			} else {
+				failedSize += info.Size
+			}
+		})
+	}
+	newWalkFunc := func(root string) fs.WalkDirFunc {
+		return func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// ignore file not found errors, most will be because
+				// module cache dirs were recursively deleted
+				if isModCache && errors.Is(err, os.ErrNotExist) {
+					return nil
+				}
+				log.Warningf("walking %q: %v", path, err)
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if path == root {
+				return nil
+			}
+
+			if isModCache {
+				depDir, ok := cache.DependencyDir(path, d)
+				if !ok {
+					return nil
+				}
+				fi, err := os.Lstat(depDir)
+				if err != nil {
+					log.Warningf("getting info of %q: %v", depDir, err)
+					return nil
+				}
+				// only record stats of directories themselves so
+				// dependency dirs found from a 'go.mod' aren't counted
+				// twice
+				var info EntryInfo
+				if path == depDir {
+					info = newEntryInfo(fi, dirSize(depDir), now)
+				}
+				// the go command makes module cache entries read-only
+				// unless -modcacherw is used
+				writable := fi.Mode().Perm()&0o200 != 0
+				used := usedFiles.Has(depDir)
+				if keep(ruleEntry{name: filterName(dir, depDir, true), modCache: true, info: fi, used: used}, opts) {
+					if path == depDir {
+						res.KeptEntries = append(res.KeptEntries, info)
+						limit.total += info.Size
+						if opts.ReadOnlyModCache && writable && !opts.DryRun {
+							workers.run(&wg, func() { makeReadOnly(log, depDir) })
+						}
+					}
+					return nil
+				}
+
+				if limit.max > 0 {
+					if path == depDir {
+						limit.candidates = append(limit.candidates, candidate{path: depDir, info: info, lastUsed: cache.LastUsed(fi), readOnly: !writable})
+						limit.total += info.Size
+						// the whole dir is deleted or kept
+						return fs.SkipDir
+					}
+					return nil
+				}
+				deleteEntry(depDir, info, !writable)
+				// nothing left in the dir needs to be walked
+				return fs.SkipDir
+			} else if d.IsDir() && (path == fuzzDir && opts.KeepFuzzCache || slices.Contains(opts.skipDirs, path)) {
+				return fs.SkipDir
+			} else if !d.IsDir() {
+				usedPath := path
+				if opts.DirLevel {
+					usedPath = filepath.Dir(path)
+				}
+				// leave these files to make testing easier, and the
+				// state go-cache-prune stores in the cache
+				if d.Name() == "trim.txt" || d.Name() == "README" || strings.HasPrefix(d.Name(), StateFilePrefix) {
+					return nil
+				}
+				fi, err := d.Info()
+				if err != nil {
+					log.Warningf("getting info of %q: %v", path, err)
+					return nil
+				}
+				if opts.QuarantineDir != "" {
+					if anomaly := buildEntryAnomaly(dir, path, fi.Size()); anomaly != "" {
+						if opts.DryRun {
+							log.Infof("would quarantine build cache file %q: %s", path, anomaly)
+							res.Quarantined++
+							return nil
+						}
+						if err := moveEntry(dir, opts.QuarantineDir, path); err != nil {
+							log.Warningf("quarantining %q: %v", path, err)
+							return nil
+						}
+						log.Warningf("quarantined build cache file %q: %s", path, anomaly)
+						res.Quarantined++
+						return nil
+					}
+				}
+
+				// the fuzz corpus cache has its own size limit if set
+				lim := &limit
+				if fuzzLimit.max > 0 && cache.IsWithinDir(fuzzDir, path) {
+					lim = &fuzzLimit
+				}
+				info := newEntryInfo(fi, fi.Size(), now)
+				lim.total += info.Size
+				used := usedFiles.Has(usedPath)
+				if keep(ruleEntry{name: filterName(dir, path, false), info: fi, used: used}, opts) {
+					res.KeptEntries = append(res.KeptEntries, info)
+					return nil
+				}
+
+				if lim.max > 0 {
+					lim.candidates = append(lim.candidates, candidate{path: path, info: info, lastUsed: cache.LastUsed(fi)})
+					return nil
+				}
+				deleteEntry(path, info, false)
+			}
+
+			return nil
+		}
+	}
+
+	err := filepath.WalkDir(dir, newWalkFunc(dir))
+	wg.Wait()
+	res.Interrupted = err != nil && ctx.Err() != nil
+	if res.Interrupted {
+		return res
+	}
+
+	for _, lim := range []*sizeLimit{&limit, &fuzzLimit} {
+		if lim.max <= 0 {
+			continue
+		}
+
+		// delete the least recently used entries first, and the
+		// largest ones first if they were used at the same time
+		slices.SortFunc(lim.candidates, func(a, b candidate) int {
+			if c := a.lastUsed.Compare(b.lastUsed); c != 0 {
+				return c
+			}
+			return cmp.Compare(b.info.Size, a.info.Size)
+		})
+		// delete as many entries as needed assuming deleting them
+		// succeeds, and delete more if some couldn't be deleted
+		next := 0
+		for next < len(lim.candidates) && lim.total > lim.max {
+			for ; next < len(lim.candidates) && lim.total > lim.max; next++ {
+				if ctx.Err() != nil {
+					res.Interrupted = true
+					break
+				}
+				c := lim.candidates[next]
+				deleteEntry(c.path, c.info, c.readOnly)
+				lim.total -= c.info.Size
+			}
+			wg.Wait()
+			if res.Interrupted {
+				break
+			}
+			lim.total += failedSize
+			failedSize = 0
+		}
+		for _, kept := range lim.candidates[next:] {
+			res.KeptEntries = append(res.KeptEntries, kept.info)
+		}
+	}
+
+	return res
+}
+
+// sizeLimit limits the total size of entries of a cache.
+type sizeLimit struct {
+	// max is the limit, there is none if it is 0
+	max int64
+	// total is the size of all entries
+	total int64
+	// candidates are unused entries that will be deleted if total is
+	// larger than max
+	candidates []candidate
+}
+
+// candidate is an unused cache entry that may be deleted.
+type candidate struct {
+	path     string
+	info     EntryInfo
+	lastUsed time.Time
+	readOnly bool
+}
+
+// deleteModDir deletes a dependency dir from the module cache with rm
+// and returns true if it was deleted. If readOnly is true it is made
+// writable first, otherwise only if deleting it fails due to missing
+// permissions. If dryRun is true it is only logged.
+func deleteModDir(log logging.Logger, rm remover, depDir string, info EntryInfo, readOnly, dryRun bool) bool {
+	if dryRun {
+		log.Infof("would delete directory %q from module cache, %s", depDir, bytesize.Format(info.Size))
+		return true
+	}
+	// allow module files to be deleted, and the dir to be moved
+	if readOnly {
+		chmodDir(log, depDir)
+	}
+	err := rm.remove(depDir)
+	if err != nil && !readOnly && errors.Is(err, fs.ErrPermission) {
+		debuglog.Debugf("module deletion", depDir, "making directory %q writable after deleting it failed: %v", depDir, err)
+		chmodDir(log, depDir)
+		err = rm.remove(depDir)
+	}
+	if err != nil {
+		log.Warningf("deleting directory from module cache: %v", err)
+		return false
+	}
+	debuglog.Debugf("module deletion", depDir, "%s directory %q from module cache", rm.verb(), depDir)
+	return true
+}
+
+// deleteBuildFile deletes a file from the build cache with rm and
+// returns true if it was deleted. If dryRun is true it is only logged.
+func deleteBuildFile(log logging.Logger, rm remover, path string, info EntryInfo, dryRun bool) bool {
+	if dryRun {
+		log.Infof("would delete file %q from build cache, %s", path, bytesize.Format(info.Size))
+		return true
+	}
+	if err := rm.remove(path); err != nil {
+		log.Warningf("deleting file from build cache: %v", err)
+		return false
+	}
+	debuglog.Debugf("build deletion", path, "%s file %q from build cache", rm.verb(), path)
+	return true
+}
+
+// keepRule is a rule that can keep an entry of a cache.
+type keepRule struct {
+	name  string
+	keeps func(e ruleEntry, opts Options) bool
+}
+
+// ruleEntry is an entry of a cache rules are evaluated against.
+type ruleEntry struct {
+	// name is what include and exclude patterns are matched against
+	name string
+	// modCache is true if the entry is in the module cache
+	modCache bool
+	info     fs.FileInfo
+	// used is true if the entry was recorded as used
+	used bool
+}
+
+// keepRules are the rules deciding whether an entry is kept, in the
+// order they are evaluated. Invalid build cache entries are quarantined
+// before any rule is evaluated if Options.QuarantineDir is set. An
+// entry is kept if any rule keeps it, otherwise it's unused and
+// deleted, unless Options.MaxSize is set and deleting less recently
+// used entries makes the cache small enough.
+var keepRules = []keepRule{
+	{
+		name:  "used",
+		keeps: func(e ruleEntry, _ Options) bool { return e.used },
+	},
+	{
+		name:  "recently used",
+		keeps: func(e ruleEntry, opts Options) bool { return recentlyUsed(e.info, opts) },
+	},
+	{
+		name:  "recently added",
+		keeps: func(e ruleEntry, opts Options) bool { return recentlyAdded(e.info, opts.KeepAddedAfter) },
+	},
+	{
+		name: "protected",
+		keeps: func(e ruleEntry, opts Options) bool {
+			return e.modCache && module.MatchPrefixPatterns(strings.Join(opts.Protect, ","), e.name)
+		},
+	},
+	{
+		name: "toolchain",
+		keeps: func(e ruleEntry, opts Options) bool {
+			return e.modCache && opts.KeepToolchains && e.name == cache.ToolchainModule
+		},
+	},
+	{
+		name:  "excluded",
+		keeps: func(e ruleEntry, opts Options) bool { return filteredOut(e.name, opts) },
+	},
+}
+
+// keep returns true if any rule keeps e.
+func keep(e ruleEntry, opts Options) bool {
+	for _, rule := range keepRules {
+		if rule.keeps(e, opts) {
+			return true
+		}
+	}
+	return false
+}
+
+// recentlyUsed returns true if the file was modified or accessed after
+// opts.KeepUsedAfter or opts.KeepAccessedAfter, which are ignored if
+// they are the zero time.
+func recentlyUsed(info fs.FileInfo, opts Options) bool {
+	if !opts.KeepUsedAfter.IsZero() && cache.LastUsed(info).After(opts.KeepUsedAfter) {
+		return true
+	}
+	return !opts.KeepAccessedAfter.IsZero() && cache.LastAccessed(info).After(opts.KeepAccessedAfter)
+}
+
+// recentlyAdded returns true if the file was added to the cache after
+// t. If t is the zero time false is returned.
+func recentlyAdded(info fs.FileInfo, t time.Time) bool {
+	if t.IsZero() {
+		return false
+	}
+	// the modification time is used as entries aren't modified after
+	// being added, and restoring a cache preserves modification times
+	// so restored entries aren't mistaken for new ones
+	return info.ModTime().After(t)
+}
+
+// chmodDir makes dir and everything in it that needs to be writable
+// to be deleted writable.
+func chmodDir(log logging.Logger, dir string) {
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Warningf("walking %q: %v", path, err)
+			return nil
+		}
+		// only dirs need to be writable for their entries to be
+		// deleted, except on Windows where read-only files can't be
+		// deleted either
+		if !d.IsDir() && runtime.GOOS != "windows" {
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			log.Warningf("getting info of %q: %v", path, err)
+			return nil
+		}
+		perm := info.Mode().Perm()
+		if perm&0o200 != 0 {
+			return nil
+		}
+		if err := os.Chmod(path, perm|0o200); err != nil {
+			log.Warningf("changing permissions of %q: %v", path, err)
+		}
+
+		return nil
+	})
+}
+
+// makeReadOnly removes write permissions from dir and everything in it.
+func makeReadOnly(log logging.Logger, dir string) {
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Warningf("walking %q: %v", path, err)
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			log.Warningf("getting info of %q: %v", path, err)
+			return nil
+		}
+		if err := os.Chmod(path, info.Mode().Perm()&^0o222); err != nil {
+			log.Warningf("changing permissions of %q: %v", path, err)
+		}
+
+		return nil
+	})
+}
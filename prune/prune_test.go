@@ -0,0 +1,455 @@
+package prune
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/clock"
+	"github.com/capnspacehook/go-cache-prune/internal/flock"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+func TestCacheMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	shard := filepath.Join(dir, "00")
+	if err := os.Mkdir(shard, 0o755); err != nil {
+		t.Fatalf("creating shard: %v", err)
+	}
+
+	// entries are created oldest first
+	entries := []struct {
+		name string
+		size int
+		used bool
+	}{
+		{name: "used", size: 1000, used: true},
+		{name: "large", size: 300},
+		{name: "medium", size: 200},
+		{name: "small", size: 100},
+	}
+	usedFiles := cache.NewUsedFiles()
+	for _, e := range entries {
+		path := filepath.Join(shard, e.name)
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", e.size)), 0o644); err != nil {
+			t.Fatalf("writing entry: %v", err)
+		}
+		if e.used {
+			usedFiles.Add(path, cache.DefaultPhaseMask)
+		}
+	}
+
+	res := Cache(context.Background(), dir, false, usedFiles, Options{MaxSize: 1350})
+	if res.Deleted != 1 {
+		t.Fatalf("expected 1 entry to be deleted, got %d", res.Deleted)
+	}
+	if res.BytesFreed != 300 {
+		t.Errorf("expected 300 bytes to be freed, got %d", res.BytesFreed)
+	}
+	if len(res.KeptEntries) != 3 {
+		t.Errorf("expected 3 entries to be kept, got %d", len(res.KeptEntries))
+	}
+	for _, e := range entries {
+		_, err := os.Stat(filepath.Join(shard, e.name))
+		if exists := err == nil; exists != (e.name != "large") {
+			t.Errorf("entry %q exists = %t", e.name, exists)
+		}
+	}
+
+	// used entries are never deleted even if the cache is still too
+	// large
+	res = Cache(context.Background(), dir, false, usedFiles, Options{MaxSize: 1})
+	if res.Deleted != 2 {
+		t.Fatalf("expected 2 entries to be deleted, got %d", res.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(shard, "used")); errors.Is(err, fs.ErrNotExist) {
+		t.Error("used entry was deleted")
+	}
+}
+
+func TestCacheFuzz(t *testing.T) {
+	dir := t.TempDir()
+	corpus := filepath.Join(dir, "fuzz", "example.com", "FuzzParse")
+	entries := []struct {
+		path string
+		size int
+		age  time.Duration
+	}{
+		{path: filepath.Join(dir, "00", "entry"), size: 100},
+		{path: filepath.Join(corpus, "old"), size: 200, age: 2 * time.Hour},
+		{path: filepath.Join(corpus, "new"), size: 100, age: time.Hour},
+	}
+	create := func() {
+		now := time.Now()
+		for _, e := range entries {
+			if err := os.MkdirAll(filepath.Dir(e.path), 0o755); err != nil {
+				t.Fatalf("creating dir: %v", err)
+			}
+			if err := os.WriteFile(e.path, []byte(strings.Repeat("x", e.size)), 0o644); err != nil {
+				t.Fatalf("writing entry: %v", err)
+			}
+			mtime := now.Add(-e.age)
+			if err := os.Chtimes(e.path, mtime, mtime); err != nil {
+				t.Fatalf("setting times: %v", err)
+			}
+		}
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	create()
+	res := Cache(context.Background(), dir, false, cache.UsedFiles{}, Options{KeepFuzzCache: true})
+	if res.Deleted != 1 {
+		t.Fatalf("expected 1 entry to be deleted, got %d", res.Deleted)
+	}
+	if exists(entries[0].path) || !exists(entries[1].path) || !exists(entries[2].path) {
+		t.Error("expected only the entry outside of the fuzz cache to be deleted")
+	}
+
+	// the fuzz cache has its own size limit, and doesn't count
+	// towards the size of the rest of the cache
+	create()
+	res = Cache(context.Background(), dir, false, cache.UsedFiles{}, Options{MaxSize: 100, MaxFuzzSize: 150})
+	if res.Deleted != 1 {
+		t.Fatalf("expected 1 entry to be deleted, got %d", res.Deleted)
+	}
+	if !exists(entries[0].path) || exists(entries[1].path) || !exists(entries[2].path) {
+		t.Error("expected only the oldest fuzz corpus entry to be deleted")
+	}
+}
+
+func TestCacheKeepAccessedAfter(t *testing.T) {
+	dir := t.TempDir()
+	shard := filepath.Join(dir, "00")
+	if err := os.Mkdir(shard, 0o755); err != nil {
+		t.Fatalf("creating shard: %v", err)
+	}
+
+	now := time.Now()
+	times := map[string]time.Time{
+		"old": now.Add(-48 * time.Hour),
+		"new": now.Add(-time.Hour),
+	}
+	for name, mtime := range times {
+		path := filepath.Join(shard, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatalf("writing entry: %v", err)
+		}
+		// the change time of both entries is now, which should be
+		// ignored
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("setting times of entry: %v", err)
+		}
+	}
+
+	opts := Options{
+		KeepAccessedAfter: now.Add(-24 * time.Hour),
+		Clock:             clock.NewFake(now),
+	}
+	res := Cache(context.Background(), dir, false, cache.UsedFiles{}, opts)
+	if res.Deleted != 1 {
+		t.Fatalf("expected 1 entry to be deleted, got %d", res.Deleted)
+	}
+	if len(res.KeptEntries) != 1 || res.KeptEntries[0].Age != time.Hour {
+		t.Errorf("expected kept entry to be 1h old, got %v", res.KeptEntries)
+	}
+	if _, err := os.Stat(filepath.Join(shard, "old")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected old entry to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(shard, "new")); err != nil {
+		t.Errorf("expected new entry to be kept, got %v", err)
+	}
+}
+
+func TestCacheDryRun(t *testing.T) {
+	modCache := t.TempDir()
+	depDir := filepath.Join(modCache, "example.com", "a@v1.0.0")
+	if err := os.MkdirAll(depDir, 0o755); err != nil {
+		t.Fatalf("creating dependency dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(depDir, "go.mod"), []byte("module example.com/a\n"), 0o444); err != nil {
+		t.Fatalf("writing go.mod: %v", err)
+	}
+	if err := os.Chmod(depDir, 0o555); err != nil {
+		t.Fatalf("making dependency dir read-only: %v", err)
+	}
+	t.Cleanup(func() { os.Chmod(depDir, 0o755) })
+
+	res := Cache(context.Background(), modCache, true, cache.UsedFiles{}, Options{DryRun: true})
+	if res.Deleted != 1 {
+		t.Fatalf("expected 1 directory to be deleted, got %d", res.Deleted)
+	}
+	if got, want := res.BytesFreed, int64(len("module example.com/a\n")); got != want {
+		t.Errorf("expected %d bytes to be freed, got %d", want, got)
+	}
+	fi, err := os.Stat(depDir)
+	if err != nil {
+		t.Fatalf("expected dependency dir to be kept, got %v", err)
+	}
+	if fi.Mode().Perm() != 0o555 {
+		t.Errorf("expected permissions of dependency dir to be unchanged, got %v", fi.Mode().Perm())
+	}
+}
+
+func TestCacheProtect(t *testing.T) {
+	modCache := t.TempDir()
+	depDirs := map[string]string{
+		"corp.example.com/huge":     filepath.Join(modCache, "corp.example.com", "huge@v1.0.0"),
+		"corp.example.com/huge/sub": filepath.Join(modCache, "corp.example.com", "huge", "sub@v1.0.0"),
+		"example.com/small":         filepath.Join(modCache, "example.com", "small@v1.0.0"),
+	}
+	for _, depDir := range depDirs {
+		if err := os.MkdirAll(depDir, 0o755); err != nil {
+			t.Fatalf("creating dependency dir: %v", err)
+		}
+	}
+
+	res := Cache(context.Background(), modCache, true, cache.UsedFiles{}, Options{Protect: []string{"corp.example.com"}})
+	if res.Deleted != 1 {
+		t.Fatalf("expected 1 dir to be deleted, got %d", res.Deleted)
+	}
+	for modPath, depDir := range depDirs {
+		_, err := os.Stat(depDir)
+		if exists := err == nil; exists != (modPath != "example.com/small") {
+			t.Errorf("dependency dir of %s exists = %t", modPath, exists)
+		}
+	}
+}
+
+func TestCacheKeepToolchains(t *testing.T) {
+	modCache := t.TempDir()
+	toolchainDir := filepath.Join(modCache, "golang.org", "toolchain@v0.0.1-go1.22.0.linux-amd64")
+	depDir := filepath.Join(modCache, "example.com", "mod@v1.0.0")
+	for _, dir := range []string{toolchainDir, depDir} {
+		if err := os.MkdirAll(filepath.Join(dir, "bin"), 0o755); err != nil {
+			t.Fatalf("creating dependency dir: %v", err)
+		}
+	}
+	if !cache.IsToolchainDir(toolchainDir) || cache.IsToolchainDir(depDir) {
+		t.Fatal("toolchain dir wasn't recognized")
+	}
+
+	res := Cache(context.Background(), modCache, true, cache.UsedFiles{}, Options{KeepToolchains: true})
+	if res.Deleted != 1 {
+		t.Fatalf("expected 1 dir to be deleted, got %d", res.Deleted)
+	}
+	if _, err := os.Stat(toolchainDir); err != nil {
+		t.Errorf("toolchain was deleted: %v", err)
+	}
+
+	res = Cache(context.Background(), modCache, true, cache.UsedFiles{}, Options{})
+	if res.Deleted != 1 {
+		t.Fatalf("expected unused toolchain to be deleted, got %d deletions", res.Deleted)
+	}
+}
+
+func TestCacheSoftDelete(t *testing.T) {
+	dir := t.TempDir()
+	shard := filepath.Join(dir, "00")
+	if err := os.Mkdir(shard, 0o755); err != nil {
+		t.Fatalf("creating shard: %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(shard, name), []byte(name), 0o644); err != nil {
+			t.Fatalf("writing entry: %v", err)
+		}
+	}
+
+	now := time.Now()
+	clk := clock.NewFake(now)
+	opts := Options{SoftDelete: 72 * time.Hour, Clock: clk}
+
+	// unused entries are only marked the first time
+	res := Cache(context.Background(), dir, false, cache.UsedFiles{}, opts)
+	if res.Deleted != 0 || res.Marked != 2 {
+		t.Fatalf("expected 2 entries to be marked and none deleted, got %d marked and %d deleted", res.Marked, res.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(dir, TombstonesName)); err != nil {
+		t.Fatalf("expected tombstones to be written, got %v", err)
+	}
+
+	// a is used again so its mark is removed, and b isn't deleted
+	// before the grace window passes
+	clk.Advance(24 * time.Hour)
+	usedFiles := cache.NewUsedFiles(filepath.Join(shard, "a"))
+	res = Cache(context.Background(), dir, false, usedFiles, opts)
+	if res.Deleted != 0 || res.Marked != 1 {
+		t.Fatalf("expected 1 entry to be marked and none deleted, got %d marked and %d deleted", res.Marked, res.Deleted)
+	}
+
+	// b is deleted once it was unused for the grace window, a was only
+	// just marked again
+	clk.Advance(48 * time.Hour)
+	res = Cache(context.Background(), dir, false, cache.UsedFiles{}, opts)
+	if res.Deleted != 1 || res.Marked != 1 {
+		t.Fatalf("expected 1 entry to be marked and 1 deleted, got %d marked and %d deleted", res.Marked, res.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(shard, "b")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected b to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(shard, "a")); err != nil {
+		t.Errorf("expected a to be kept, got %v", err)
+	}
+}
+
+func TestCacheDownloads(t *testing.T) {
+	modCache := t.TempDir()
+	vDir := filepath.Join(modCache, "cache", "download", "example.com", "!a", "@v")
+	if err := os.MkdirAll(vDir, 0o755); err != nil {
+		t.Fatalf("creating download dir: %v", err)
+	}
+	depDirs := map[string]string{
+		"v1.0.0": filepath.Join(modCache, "example.com", "!a@v1.0.0"),
+		"v1.1.0": filepath.Join(modCache, "example.com", "!a@v1.1.0"),
+	}
+	for ver, depDir := range depDirs {
+		if err := os.MkdirAll(depDir, 0o755); err != nil {
+			t.Fatalf("creating dependency dir: %v", err)
+		}
+		for _, ext := range downloadExts {
+			if err := os.WriteFile(filepath.Join(vDir, ver+ext), []byte("x"), 0o444); err != nil {
+				t.Fatalf("writing download file: %v", err)
+			}
+		}
+	}
+	listPath := filepath.Join(vDir, "list")
+	if err := os.WriteFile(listPath, []byte("v1.0.0\nv1.1.0\n"), 0o644); err != nil {
+		t.Fatalf("writing version list: %v", err)
+	}
+
+	usedFiles := cache.NewUsedFiles(depDirs["v1.1.0"])
+	res := Cache(context.Background(), modCache, true, usedFiles, Options{})
+	if res.Deleted != 1 {
+		t.Fatalf("expected 1 dir to be deleted, got %d", res.Deleted)
+	}
+	if res.BytesFreed != int64(len(downloadExts)) {
+		t.Errorf("expected %d bytes to be freed, got %d", len(downloadExts), res.BytesFreed)
+	}
+	for _, ext := range downloadExts {
+		if _, err := os.Stat(filepath.Join(vDir, "v1.0.0"+ext)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected download file %s of unused version to be deleted, got %v", ext, err)
+		}
+		if _, err := os.Stat(filepath.Join(vDir, "v1.1.0"+ext)); err != nil {
+			t.Errorf("expected download file %s of used version to be kept, got %v", ext, err)
+		}
+	}
+	if _, err := os.Stat(listPath); err != nil {
+		t.Errorf("expected version list to be kept, got %v", err)
+	}
+}
+
+func TestCacheTrash(t *testing.T) {
+	modCache := t.TempDir()
+	trashDir := t.TempDir()
+	depDir := filepath.Join(modCache, "example.com", "a@v1.0.0")
+	vDir := filepath.Join(modCache, "cache", "download", "example.com", "a", "@v")
+	for _, dir := range []string{depDir, vDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("creating dir: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(depDir, "go.mod"), []byte("module example.com/a\n"), 0o444); err != nil {
+		t.Fatalf("writing module file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(vDir, "v1.0.0.zip"), []byte("x"), 0o444); err != nil {
+		t.Fatalf("writing download file: %v", err)
+	}
+	// an older trashed version of the dir is replaced
+	if err := os.MkdirAll(filepath.Join(trashDir, "example.com", "a@v1.0.0", "stale"), 0o755); err != nil {
+		t.Fatalf("creating trashed dir: %v", err)
+	}
+
+	res := Cache(context.Background(), modCache, true, cache.UsedFiles{}, Options{
+		ReadOnlyModCache: true,
+		TrashDir:         trashDir,
+	})
+	if res.Deleted != 1 {
+		t.Fatalf("expected 1 dir to be deleted, got %d", res.Deleted)
+	}
+	if _, err := os.Stat(depDir); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected dependency dir to be removed from the cache, got %v", err)
+	}
+	for _, path := range []string{
+		filepath.Join("example.com", "a@v1.0.0", "go.mod"),
+		filepath.Join("cache", "download", "example.com", "a", "@v", "v1.0.0.zip"),
+	} {
+		if _, err := os.Stat(filepath.Join(trashDir, path)); err != nil {
+			t.Errorf("expected %s to be moved to the trash dir, got %v", path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(trashDir, "example.com", "a@v1.0.0", "stale")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected old trashed dir to be replaced, got %v", err)
+	}
+}
+
+func TestCacheLock(t *testing.T) {
+	modCache := t.TempDir()
+	depDir := filepath.Join(modCache, "example.com", "a@v1.0.0")
+	if err := os.MkdirAll(depDir, 0o755); err != nil {
+		t.Fatalf("creating dependency dir: %v", err)
+	}
+
+	unlock, err := flock.Lock(context.Background(), filepath.Join(modCache, LockFileName), 0)
+	if err != nil {
+		t.Fatalf("locking cache: %v", err)
+	}
+	// another process pruning the cache is emulated by locking it
+	// through another open file, which conflicts the same way
+	res := Cache(context.Background(), modCache, true, cache.UsedFiles{}, Options{LockTimeout: 200 * time.Millisecond})
+	if res.Deleted != 0 {
+		t.Fatalf("expected nothing to be deleted while the cache is locked, got %d", res.Deleted)
+	}
+	if _, err := os.Stat(depDir); err != nil {
+		t.Fatalf("expected dependency dir to be kept, got %v", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatalf("unlocking cache: %v", err)
+	}
+
+	res = Cache(context.Background(), modCache, true, cache.UsedFiles{}, Options{})
+	if res.Deleted != 1 {
+		t.Fatalf("expected 1 dir to be deleted, got %d", res.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(modCache, LockFileName)); err != nil {
+		t.Errorf("expected lock file to be kept, got %v", err)
+	}
+}
+
+func TestCacheModCacheWritable(t *testing.T) {
+	for _, writable := range []bool{false, true} {
+		writable := writable
+		t.Run(fmt.Sprintf("writable=%t", writable), func(t *testing.T) {
+			modCache := t.TempDir()
+			depDir := filepath.Join(modCache, "example.com", "a@v1.0.0")
+			if err := os.MkdirAll(filepath.Join(depDir, "pkg"), 0o755); err != nil {
+				t.Fatalf("creating dependency dir: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(depDir, "pkg", "a.go"), []byte("package pkg\n"), 0o444); err != nil {
+				t.Fatalf("writing module file: %v", err)
+			}
+			// make the dir read-only like the go command does without
+			// -modcacherw
+			makeReadOnly(logging.Discard, depDir)
+			t.Cleanup(func() { chmodDir(logging.Discard, modCache) })
+
+			// read-only dirs are made writable and deleted even if
+			// the module cache is expected to be writable
+			res := Cache(context.Background(), modCache, true, cache.UsedFiles{}, Options{ModCacheWritable: writable})
+			if res.Deleted != 1 {
+				t.Fatalf("expected 1 dir to be deleted, got %d", res.Deleted)
+			}
+			if _, err := os.Stat(depDir); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("expected dependency dir to be deleted, got %v", err)
+			}
+		})
+	}
+}
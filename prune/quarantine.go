@@ -0,0 +1,93 @@
+package prune
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+// size of an action entry in the build cache, see
+// cmd/go/internal/cache.entrySize
+const actionEntrySize = 2 + 1 + 64 + 1 + 64 + 1 + 20 + 1 + 20 + 1
+
+// buildEntryAnomaly returns a description of why a file in the build
+// cache isn't a valid cache entry, or an empty string if it is valid.
+func buildEntryAnomaly(cacheDir, path string, size int64) string {
+	relPath, err := filepath.Rel(cacheDir, path)
+	if err != nil {
+		return ""
+	}
+	elems := strings.Split(filepath.ToSlash(relPath), "/")
+	// files outside of shard dirs aren't entries, unexpected ones are
+	// found by -report-unexpected-writes instead
+	if len(elems) == 1 || elems[0] == "fuzz" {
+		return ""
+	}
+	if len(elems) != 2 || !cache.IsBuildShard(elems[0]) || !cache.IsBuildEntry(elems[1]) {
+		return "invalid name"
+	}
+
+	name := elems[1]
+	hash, kind := name[:64], name[65]
+	if !strings.HasPrefix(hash, elems[0]) {
+		return "entry is in the wrong shard"
+	}
+
+	switch kind {
+	case 'a':
+		if size != actionEntrySize {
+			return fmt.Sprintf("action entry has impossible size %d", size)
+		}
+		entry, err := os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		fields := bytes.Fields(entry)
+		if len(fields) != 5 || string(fields[0]) != "v1" || string(fields[1]) != hash {
+			return "action entry is malformed"
+		}
+	case 'd':
+		f, err := os.Open(path)
+		if err != nil {
+			return ""
+		}
+		defer f.Close()
+
+		// output entries are named after the hash of their contents
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return ""
+		}
+		if hex.EncodeToString(h.Sum(nil)) != hash {
+			return "output entry hash mismatch"
+		}
+	}
+
+	return ""
+}
+
+func copyFile(src, dest string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	destFile, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destFile, srcFile); err != nil {
+		destFile.Close()
+		return err
+	}
+
+	return destFile.Close()
+}
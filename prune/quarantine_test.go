@@ -0,0 +1,72 @@
+package prune
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildEntryAnomaly(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	writeEntry := func(t *testing.T, name string, contents []byte) string {
+		t.Helper()
+
+		path := filepath.Join(cacheDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("creating shard dir: %v", err)
+		}
+		if err := os.WriteFile(path, contents, 0o644); err != nil {
+			t.Fatalf("writing entry: %v", err)
+		}
+		return path
+	}
+	checkAnomaly := func(t *testing.T, path string, wantAnomaly bool) {
+		t.Helper()
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("getting entry info: %v", err)
+		}
+		anomaly := buildEntryAnomaly(cacheDir, path, info.Size())
+		if wantAnomaly && anomaly == "" {
+			t.Fatalf("expected %q to be anomalous", path)
+		} else if !wantAnomaly && anomaly != "" {
+			t.Fatalf("expected %q to be valid, got %q", path, anomaly)
+		}
+	}
+
+	output := []byte("compiled package")
+	outputSum := sha256.Sum256(output)
+	outputHash := hex.EncodeToString(outputSum[:])
+	actionHash := hex.EncodeToString(make([]byte, 32))
+
+	t.Run("valid output", func(t *testing.T) {
+		path := writeEntry(t, filepath.Join(outputHash[:2], outputHash+"-d"), output)
+		checkAnomaly(t, path, false)
+	})
+	t.Run("corrupted output", func(t *testing.T) {
+		path := writeEntry(t, filepath.Join(outputHash[:2], outputHash+"-d"), []byte("tampered"))
+		checkAnomaly(t, path, true)
+	})
+	t.Run("valid action", func(t *testing.T) {
+		entry := fmt.Sprintf("v1 %s %s %20d %20d\n", actionHash, outputHash, len(output), 0)
+		path := writeEntry(t, filepath.Join(actionHash[:2], actionHash+"-a"), []byte(entry))
+		checkAnomaly(t, path, false)
+	})
+	t.Run("truncated action", func(t *testing.T) {
+		path := writeEntry(t, filepath.Join(actionHash[:2], actionHash+"-a"), []byte("v1 "+actionHash))
+		checkAnomaly(t, path, true)
+	})
+	t.Run("invalid name", func(t *testing.T) {
+		path := writeEntry(t, filepath.Join("ab", "payload"), []byte("payload"))
+		checkAnomaly(t, path, true)
+	})
+	t.Run("wrong shard", func(t *testing.T) {
+		path := writeEntry(t, filepath.Join("ff", outputHash+"-d"), output)
+		checkAnomaly(t, path, true)
+	})
+}
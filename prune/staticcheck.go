@@ -0,0 +1,131 @@
+package prune
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/clock"
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+	"github.com/capnspacehook/go-cache-prune/internal/debuglog"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+// errKept stops walking a dir once an entry that will be kept is found.
+var errKept = errors.New("entry is kept")
+
+// Staticcheck prunes the staticcheck cache dir, which is laid out like
+// the build cache but gets a subdir for each Go version staticcheck is
+// run with. Version subdirs without an entry that would be kept are
+// deleted entirely, everything else is pruned like the build cache.
+func Staticcheck(ctx context.Context, dir string, usedFiles cache.UsedFiles, opts Options) Result {
+	log := logging.FromContext(ctx)
+	if !opts.DryRun {
+		unlock, ok := lockCache(ctx, dir, opts)
+		if !ok {
+			return Result{Interrupted: ctx.Err() != nil}
+		}
+		defer unlock()
+		opts.locked = true
+	}
+
+	opts.DirLevel = false
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Warningf("reading staticcheck cache dir: %v", err)
+		}
+		return Result{}
+	}
+
+	// don't prune stale version dirs entry by entry, they will be
+	// deleted entirely
+	var stale []string
+	for _, entry := range entries {
+		if !entry.IsDir() || cache.IsBuildShard(entry.Name()) {
+			continue
+		}
+		versionDir := filepath.Join(dir, entry.Name())
+		if staleVersionDir(dir, versionDir, usedFiles, opts) {
+			stale = append(stale, versionDir)
+		}
+	}
+	opts.skipDirs = stale
+
+	res := Cache(ctx, dir, false, usedFiles, opts)
+	now := clock.Or(opts.Clock).Now()
+	var (
+		rm       = newRemover(dir, opts)
+		workers  = opts.deleteWorkers()
+		throttle = opts.deleteThrottle()
+		wg       sync.WaitGroup
+		// guards res while dirs are being deleted
+		mu sync.Mutex
+	)
+	for _, versionDir := range stale {
+		if ctx.Err() != nil {
+			res.Interrupted = true
+			break
+		}
+
+		fi, err := os.Stat(versionDir)
+		if err != nil {
+			log.Warningf("getting info of %q: %v", versionDir, err)
+			continue
+		}
+		size := dirSize(versionDir)
+		info := newEntryInfo(fi, size, now)
+		if err := throttle.wait(ctx, size); err != nil {
+			res.Interrupted = true
+			break
+		}
+
+		versionDir := versionDir
+		workers.run(&wg, func() {
+			if opts.DryRun {
+				log.Infof("would delete unused staticcheck cache dir %q, %s", versionDir, bytesize.Format(size))
+			} else {
+				if err := rm.remove(versionDir); err != nil {
+					log.Warningf("deleting unused staticcheck cache dir: %v", err)
+					return
+				}
+				debuglog.Debugf("staticcheck deletion", versionDir, "%s unused staticcheck cache dir %q", rm.verb(), versionDir)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.addDeleted(versionDir, info)
+		})
+	}
+	wg.Wait()
+
+	return res
+}
+
+// staleVersionDir returns true if no entry of the staticcheck cache
+// version subdir versionDir would be kept.
+func staleVersionDir(dir, versionDir string, usedFiles cache.UsedFiles, opts Options) bool {
+	err := filepath.WalkDir(versionDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// keep anything that can't be fully inspected
+			return errKept
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return errKept
+		}
+		used := usedFiles.Has(path)
+		if keep(ruleEntry{name: filterName(dir, path, false), info: fi, used: used}, opts) {
+			return errKept
+		}
+		return nil
+	})
+	return err == nil
+}
@@ -0,0 +1,50 @@
+package prune
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+func TestStaticcheck(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		filepath.Join(dir, "00", "unused"),
+		filepath.Join(dir, "00", "used"),
+		filepath.Join(dir, "go1.21", "00", "a"),
+		filepath.Join(dir, "go1.21", "01", "b"),
+		filepath.Join(dir, "go1.22", "00", "unused"),
+		filepath.Join(dir, "go1.22", "00", "used"),
+	}
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatalf("creating dir: %v", err)
+		}
+		if err := os.WriteFile(file, []byte("entry"), 0o644); err != nil {
+			t.Fatalf("writing entry: %v", err)
+		}
+	}
+	usedFiles := cache.NewUsedFiles(files[1], files[5])
+
+	res := Staticcheck(context.Background(), dir, usedFiles, Options{})
+	// the unused version dir is deleted as one entry
+	if res.Deleted != 3 {
+		t.Fatalf("expected 3 entries to be deleted, got %d: %q", res.Deleted, res.DeletedPaths)
+	}
+	if res.BytesFreed != 4*int64(len("entry")) {
+		t.Errorf("expected %d bytes to be freed, got %d", 4*len("entry"), res.BytesFreed)
+	}
+	for _, path := range []string{files[1], files[5]} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("used entry %q was deleted", path)
+		}
+	}
+	for _, path := range []string{files[0], filepath.Join(dir, "go1.21"), files[4]} {
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("unused entry %q wasn't deleted", path)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package prune
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+)
+
+// EntryInfo is the age and size of a cache entry.
+type EntryInfo struct {
+	Age  time.Duration
+	Size int64
+}
+
+// EntryInfos are the ages and sizes of cache entries.
+type EntryInfos []EntryInfo
+
+func newEntryInfo(info fs.FileInfo, size int64, now time.Time) EntryInfo {
+	return EntryInfo{
+		Age:  now.Sub(info.ModTime()),
+		Size: size,
+	}
+}
+
+// Summary returns the 50th, 90th and 99th percentiles of the ages and
+// sizes of entries.
+func (e EntryInfos) Summary() string {
+	if len(e) == 0 {
+		return "no entries"
+	}
+
+	ages := make([]time.Duration, len(e))
+	sizes := make([]int64, len(e))
+	for i := range e {
+		ages[i] = e[i].Age
+		sizes[i] = e[i].Size
+	}
+	slices.Sort(ages)
+	slices.Sort(sizes)
+
+	return fmt.Sprintf("%d entries: age p50=%s p90=%s p99=%s size p50=%s p90=%s p99=%s",
+		len(e),
+		percentile(ages, 50).Round(time.Second),
+		percentile(ages, 90).Round(time.Second),
+		percentile(ages, 99).Round(time.Second),
+		bytesize.Format(percentile(sizes, 50)),
+		bytesize.Format(percentile(sizes, 90)),
+		bytesize.Format(percentile(sizes, 99)),
+	)
+}
+
+// TotalSize returns the total size of entries.
+func (e EntryInfos) TotalSize() int64 {
+	var size int64
+	for i := range e {
+		size += e[i].Size
+	}
+	return size
+}
+
+// percentile returns the pth percentile of sorted using the nearest
+// rank method. sorted must not be empty.
+func percentile[T any](sorted []T, p int) T {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// dirSize returns the total size of all files in dir.
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
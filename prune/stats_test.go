@@ -0,0 +1,27 @@
+package prune
+
+import "testing"
+
+func TestPercentile(t *testing.T) {
+	sorted := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	tests := []struct {
+		p    int
+		want int
+	}{
+		{p: 0, want: 1},
+		{p: 50, want: 5},
+		{p: 90, want: 9},
+		{p: 99, want: 10},
+		{p: 100, want: 10},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%d) = %d, want %d", tt.p, got, tt.want)
+		}
+	}
+
+	if got := percentile([]int{42}, 99); got != 42 {
+		t.Errorf("percentile of single element = %d, want 42", got)
+	}
+}
@@ -0,0 +1,87 @@
+package prune
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/capnspacehook/go-cache-prune/clock"
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+	"github.com/capnspacehook/go-cache-prune/internal/debuglog"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+// telemetryDirs are the dirs of the Go telemetry dir that counter files
+// and reports accumulate in. Other files such as the telemetry mode
+// are never deleted.
+var telemetryDirs = []string{"local", "upload"}
+
+// Telemetry deletes counter files and reports in the Go telemetry dir
+// dir that weren't accessed or modified after opts.KeepAccessedAfter.
+// Nothing is deleted if it is the zero time. Counter files are written
+// to while a Go program is running, so files in use are always kept
+// as long as opts.KeepAccessedAfter isn't in the future.
+func Telemetry(ctx context.Context, dir string, opts Options) Result {
+	log := logging.FromContext(ctx)
+
+	var res Result
+	if opts.KeepAccessedAfter.IsZero() {
+		return res
+	}
+
+	now := clock.Or(opts.Clock).Now()
+	for _, subdir := range telemetryDirs {
+		err := filepath.WalkDir(filepath.Join(dir, subdir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					log.Warningf("walking %q: %v", path, err)
+				}
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if d.IsDir() || !isTelemetryFile(d.Name()) {
+				return nil
+			}
+
+			fi, err := d.Info()
+			if err != nil {
+				log.Warningf("getting info of %q: %v", path, err)
+				return nil
+			}
+			info := newEntryInfo(fi, fi.Size(), now)
+			if recentlyUsed(fi, opts) {
+				res.KeptEntries = append(res.KeptEntries, info)
+				return nil
+			}
+
+			if opts.DryRun {
+				log.Infof("would delete telemetry file %q, %s", path, bytesize.Format(info.Size))
+			} else {
+				if err := os.Remove(path); err != nil {
+					log.Warningf("deleting telemetry file: %v", err)
+					return nil
+				}
+				debuglog.Debugf("telemetry deletion", path, "deleted telemetry file %q", path)
+			}
+			res.addDeleted(path, info)
+			return nil
+		})
+		if err != nil && ctx.Err() != nil {
+			res.Interrupted = true
+			break
+		}
+	}
+
+	return res
+}
+
+// isTelemetryFile returns true if name is the name of a telemetry
+// counter file or report.
+func isTelemetryFile(name string) bool {
+	return strings.HasSuffix(name, ".count") || strings.HasSuffix(name, ".json")
+}
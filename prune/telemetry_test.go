@@ -0,0 +1,59 @@
+package prune
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTelemetry(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	old := now.Add(-60 * 24 * time.Hour)
+
+	files := []struct {
+		path string
+		old  bool
+		kept bool
+	}{
+		{path: "mode", old: true, kept: true},
+		{path: "local/weekends", old: true, kept: true},
+		{path: "local/go@go1.23.0-go1.23.0-linux-amd64-2024-08-12.v1.count", old: true},
+		{path: "local/go@go1.23.0-go1.23.0-linux-amd64-2024-10-07.v1.count", kept: true},
+		{path: "local/2024-08-19.json", old: true},
+		{path: "upload/2024-08-19.json", old: true},
+		{path: "debug/go-2024-08-12.log", old: true, kept: true},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("creating dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+			t.Fatalf("writing file: %v", err)
+		}
+		if f.old {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatalf("setting times of file: %v", err)
+			}
+		}
+	}
+
+	if res := Telemetry(context.Background(), dir, Options{}); res.Deleted != 0 {
+		t.Fatalf("expected nothing to be deleted without a max age, got %d", res.Deleted)
+	}
+	res := Telemetry(context.Background(), dir, Options{KeepAccessedAfter: now.Add(-30 * 24 * time.Hour)})
+	if res.Deleted != 3 {
+		t.Errorf("expected 3 files to be deleted, got %d", res.Deleted)
+	}
+	for _, f := range files {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f.path)))
+		if exists := !errors.Is(err, fs.ErrNotExist); exists != f.kept {
+			t.Errorf("file %q exists = %t, want %t", f.path, exists, f.kept)
+		}
+	}
+}
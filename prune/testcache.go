@@ -0,0 +1,152 @@
+package prune
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/clock"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+// testlogMagic starts the test logs the go command caches to check
+// whether the inputs of a test changed, see cmd/go/internal/test.
+const testlogMagic = "# test log\n"
+
+// testResultPrefix starts the last line of cached output of passing
+// tests.
+const testResultPrefix = "ok  \t"
+
+// TestResults deletes cached test results and test logs from the build
+// cache dir, so tests are run again instead of their results being
+// reused. Compiled packages and other entries are kept. Both the
+// action entry of a result and its output are deleted.
+func TestResults(ctx context.Context, dir string, opts Options) Result {
+	log := logging.FromContext(ctx)
+	if !opts.DryRun {
+		unlock, ok := lockCache(ctx, dir, opts)
+		if !ok {
+			return Result{Interrupted: ctx.Err() != nil}
+		}
+		defer unlock()
+	}
+
+	var (
+		res Result
+		now = clock.Or(opts.Clock).Now()
+		// outputs that were deleted, multiple actions can have the
+		// same output
+		deletedOutputs = make(map[string]bool)
+		rm             = newRemover(dir, opts)
+
+		workers  = opts.deleteWorkers()
+		throttle = opts.deleteThrottle()
+		wg       sync.WaitGroup
+		// guards res while entries are being deleted
+		mu sync.Mutex
+	)
+	deleteFile := func(path string) {
+		fi, err := os.Lstat(path)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				log.Warningf("getting info of %q: %v", path, err)
+			}
+			return
+		}
+		info := newEntryInfo(fi, fi.Size(), now)
+		if err := throttle.wait(ctx, info.Size); err != nil {
+			return
+		}
+		workers.run(&wg, func() {
+			if deleteBuildFile(log, rm, path, info, opts.DryRun) {
+				mu.Lock()
+				res.addDeleted(path, info)
+				mu.Unlock()
+			}
+		})
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Warningf("walking %q: %v", path, err)
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || !cache.IsBuildEntry(d.Name()) || !strings.HasSuffix(d.Name(), "-a") {
+			return nil
+		}
+
+		outputID, ok := readActionOutput(path)
+		if !ok {
+			return nil
+		}
+		outputPath := filepath.Join(dir, outputID[:2], outputID+"-d")
+		if !deletedOutputs[outputID] {
+			if !isTestResult(outputPath) {
+				return nil
+			}
+			deleteFile(outputPath)
+			deletedOutputs[outputID] = true
+		}
+		deleteFile(path)
+		return nil
+	})
+	wg.Wait()
+	res.Interrupted = err != nil && ctx.Err() != nil
+
+	return res
+}
+
+// readActionOutput returns the output ID of the build cache action
+// entry at path.
+func readActionOutput(path string) (string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) != actionEntrySize {
+		return "", false
+	}
+	// entries are "v1 <action ID> <output ID> <size> <time>\n"
+	fields := strings.Fields(string(data))
+	if len(fields) != 5 || fields[0] != "v1" || len(fields[2]) != 64 {
+		return "", false
+	}
+	return fields[2], true
+}
+
+// isTestResult returns true if the build cache output at path is a test
+// log or the output of a passing test.
+func isTestResult(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	head := make([]byte, len(testlogMagic))
+	if _, err := io.ReadFull(f, head); err == nil && string(head) == testlogMagic {
+		return true
+	}
+
+	// only the last line matters, and test output can be large
+	fi, err := f.Stat()
+	if err != nil || fi.Size() == 0 {
+		return false
+	}
+	const tailSize = 4096
+	offset := max(fi.Size()-tailSize, 0)
+	tail := make([]byte, fi.Size()-offset)
+	if _, err := f.ReadAt(tail, offset); err != nil && !errors.Is(err, io.EOF) {
+		return false
+	}
+	tail = bytes.TrimSuffix(tail, []byte("\n"))
+	lastLine := tail[bytes.LastIndexByte(tail, '\n')+1:]
+	return bytes.HasPrefix(lastLine, []byte(testResultPrefix))
+}
@@ -0,0 +1,58 @@
+package prune
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTestResults(t *testing.T) {
+	dir := t.TempDir()
+
+	// writeEntry writes an action entry and its output to the cache and
+	// returns their paths
+	writeEntry := func(actionID, outputID byte, output string) (string, string) {
+		action := strings.Repeat(fmt.Sprintf("%02x", actionID), 32)
+		out := strings.Repeat(fmt.Sprintf("%02x", outputID), 32)
+		actionPath := filepath.Join(dir, action[:2], action+"-a")
+		outputPath := filepath.Join(dir, out[:2], out+"-d")
+		for path, data := range map[string]string{
+			actionPath: fmt.Sprintf("v1 %s %s %20d %20d\n", action, out, len(output), 0),
+			outputPath: output,
+		} {
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("creating shard: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatalf("writing entry: %v", err)
+			}
+		}
+		return actionPath, outputPath
+	}
+
+	pkgAction, pkgOutput := writeEntry(0x01, 0x02, "!<arch>\npackage archive")
+	logAction, logOutput := writeEntry(0x03, 0x04, testlogMagic+"getenv HOME\n")
+	resultAction, resultOutput := writeEntry(0x05, 0x06, "PASS\nok  \texample.com/pkg\t0.012s\n")
+	// another test with the same output
+	sameAction, _ := writeEntry(0x07, 0x06, "PASS\nok  \texample.com/pkg\t0.012s\n")
+
+	res := TestResults(context.Background(), dir, Options{})
+	if res.Deleted != 5 {
+		t.Errorf("expected 5 files to be deleted, got %d", res.Deleted)
+	}
+	for _, path := range []string{logAction, logOutput, resultAction, resultOutput, sameAction} {
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %q to be deleted, got %v", path, err)
+		}
+	}
+	for _, path := range []string{pkgAction, pkgOutput} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %q to be kept, got %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package prune
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/clock"
+)
+
+// throttle limits how quickly entries are deleted. One throttle is
+// shared by every cache pruned by Caches like workerPool.
+type throttle struct {
+	clk clock.Clock
+	// entryInterval is the minimum time between starting to delete
+	// entries
+	entryInterval time.Duration
+	// bytesPerSec limits how many bytes of entries are deleted per
+	// second if it is greater than 0
+	bytesPerSec int64
+	// pause is how long to wait after every batch entries
+	pause time.Duration
+	batch int
+
+	mu sync.Mutex
+	// next is when the next entry may be deleted
+	next    time.Time
+	started int
+}
+
+// deleteThrottle returns the throttle shared by the caches pruned by
+// Caches, a new throttle if opts isn't from Caches, or nil if deletions
+// aren't throttled.
+func (o Options) deleteThrottle() *throttle {
+	if o.throttle != nil {
+		return o.throttle
+	}
+	if o.DryRun || o.DeleteRate <= 0 && o.DeleteBytesRate <= 0 && o.DeletePause <= 0 {
+		return nil
+	}
+
+	t := &throttle{
+		clk:         clock.Or(o.Clock),
+		bytesPerSec: o.DeleteBytesRate,
+		pause:       o.DeletePause,
+		batch:       o.Concurrency,
+	}
+	if o.DeleteRate > 0 {
+		t.entryInterval = time.Duration(float64(time.Second) / o.DeleteRate)
+	}
+	if t.batch <= 0 {
+		t.batch = DefaultConcurrency
+	}
+	return t
+}
+
+// wait blocks until an entry of size bytes may be deleted, or returns
+// an error if ctx is canceled first. It returns immediately if t is
+// nil.
+func (t *throttle) wait(ctx context.Context, size int64) error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	now := t.clk.Now()
+	start := t.next
+	if start.Before(now) {
+		start = now
+	}
+	cost := t.entryInterval
+	if t.bytesPerSec > 0 {
+		cost = max(cost, time.Duration(float64(size)/float64(t.bytesPerSec)*float64(time.Second)))
+	}
+	t.next = start.Add(cost)
+	t.started++
+	if t.pause > 0 && t.started%t.batch == 0 {
+		t.next = t.next.Add(t.pause)
+	}
+	t.mu.Unlock()
+
+	d := start.Sub(now)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := t.clk.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,63 @@
+package prune
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/clock"
+)
+
+func TestThrottle(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	clk := clock.NewFake(start)
+	ctx := context.Background()
+
+	if th := (Options{Clock: clk}).deleteThrottle(); th != nil {
+		t.Fatalf("expected no throttle without limits, got %+v", th)
+	}
+	if th := (Options{Clock: clk, DeleteRate: 10, DryRun: true}).deleteThrottle(); th != nil {
+		t.Fatalf("expected no throttle on dry runs, got %+v", th)
+	}
+
+	th := Options{
+		Clock:           clk,
+		DeleteRate:      10,
+		DeleteBytesRate: 1000,
+		DeletePause:     time.Second,
+		Concurrency:     2,
+	}.deleteThrottle()
+
+	// small entries are limited by the entry rate
+	if err := th.wait(ctx, 10); err != nil {
+		t.Fatalf("waiting: %v", err)
+	}
+	if want := start.Add(100 * time.Millisecond); !th.next.Equal(want) {
+		t.Fatalf("expected next deletion at %v, got %v", want, th.next)
+	}
+
+	// large entries are limited by the byte rate, and the batch is
+	// followed by a pause
+	done := make(chan error)
+	go func() { done <- th.wait(ctx, 500) }()
+	for clk.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clk.Advance(100 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatalf("waiting: %v", err)
+	}
+	if want := start.Add(100*time.Millisecond + 500*time.Millisecond + time.Second); !th.next.Equal(want) {
+		t.Fatalf("expected next deletion at %v, got %v", want, th.next)
+	}
+
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := th.wait(canceledCtx, 10); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected waiting to be canceled, got %v", err)
+	}
+	if err := (*throttle)(nil).wait(ctx, 10); err != nil {
+		t.Fatalf("expected nil throttle to not wait, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package prune
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StateFilePrefix is the prefix of the names of files go-cache-prune
+// stores state in in the root of caches. They are never pruned.
+const StateFilePrefix = ".go-cache-prune-"
+
+// TombstonesName is the name of the file in the root of a cache that
+// records when unused entries were marked for deletion when
+// Options.SoftDelete is set.
+const TombstonesName = StateFilePrefix + "tombstones.json"
+
+// tombstones records when unused entries of a cache were marked for
+// deletion. Entries are keyed by their slash separated path relative to
+// the cache.
+type tombstones struct {
+	path string
+	// marks read from the file
+	prev map[string]time.Time
+	// marks of entries that are still unused and weren't deleted yet
+	cur map[string]time.Time
+}
+
+// loadTombstones reads the tombstones of the cache dir. A missing or
+// corrupted file is treated as if no entries were marked.
+func loadTombstones(dir string) (*tombstones, error) {
+	t := &tombstones{
+		path: filepath.Join(dir, TombstonesName),
+		prev: make(map[string]time.Time),
+		cur:  make(map[string]time.Time),
+	}
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return t, nil
+		}
+		return t, fmt.Errorf("reading tombstones: %w", err)
+	}
+	if err := json.Unmarshal(data, &t.prev); err != nil {
+		return t, fmt.Errorf("parsing tombstones: %w", err)
+	}
+
+	return t, nil
+}
+
+// mark marks the unused entry relPath for deletion at now if it wasn't
+// already, and returns true if it was marked at least grace ago and
+// should be deleted.
+func (t *tombstones) mark(relPath string, now time.Time, grace time.Duration) bool {
+	marked, ok := t.prev[relPath]
+	if ok && now.Sub(marked) >= grace {
+		return true
+	}
+	if !ok {
+		marked = now
+	}
+	t.cur[relPath] = marked
+	return false
+}
+
+// save replaces the file with the entries that are still marked.
+// Entries that were used again or deleted are forgotten.
+func (t *tombstones) save() error {
+	if len(t.cur) == 0 {
+		if err := os.Remove(t.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("removing tombstones: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(t.cur)
+	if err != nil {
+		return fmt.Errorf("encoding tombstones: %w", err)
+	}
+	tmpPath := t.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("writing tombstones: %w", err)
+	}
+	if err := os.Rename(tmpPath, t.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("writing tombstones: %w", err)
+	}
+
+	return nil
+}
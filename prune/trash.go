@@ -0,0 +1,166 @@
+package prune
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/capnspacehook/go-cache-prune/internal/debuglog"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+// remover removes entries of the cache dir. If trashDir is set they
+// are moved to the same relative path in it instead of being deleted.
+type remover struct {
+	dir      string
+	trashDir string
+}
+
+// newRemover returns a remover of entries of the cache dir that moves
+// them to Options.TrashDir if set.
+func newRemover(dir string, opts Options) remover {
+	return remover{dir: dir, trashDir: opts.TrashDir}
+}
+
+// remove deletes or moves the entry at path to the trash dir.
+func (r remover) remove(path string) error {
+	if r.trashDir == "" {
+		return os.RemoveAll(path)
+	}
+	if err := moveEntry(r.dir, r.trashDir, path); err != nil {
+		return fmt.Errorf("moving %q to trash: %w", path, err)
+	}
+	return nil
+}
+
+// verb is how removing entries is described in logs.
+func (r remover) verb() string {
+	if r.trashDir == "" {
+		return "deleted"
+	}
+	return "moved to trash"
+}
+
+// moveEntry moves the file or dir path, which is in cacheDir, to the
+// same relative path in destDir, replacing anything already there.
+func moveEntry(cacheDir, destDir, path string) error {
+	relPath, err := filepath.Rel(cacheDir, path)
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(destDir, relPath)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("creating dir: %w", err)
+	}
+	// dirs can't be renamed over non-empty dirs
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+
+	err = os.Rename(path, dest)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	// destDir is on a different filesystem, copy instead
+	if err := copyEntry(path, dest); err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+// copyEntry copies the file or dir src to dest.
+func copyEntry(src, dest string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, relPath)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		return copyFile(path, target)
+	})
+}
+
+// trashSubdir returns the subdir name of trashDir, or an empty string
+// if trashDir is empty.
+func trashSubdir(trashDir, name string) string {
+	if trashDir == "" {
+		return ""
+	}
+	return filepath.Join(trashDir, name)
+}
+
+// Restore moves the entries of the cache dir that were moved to
+// trashDir back into dir and deletes trashDir. Entries that were
+// added to the cache again since they were pruned are kept and their
+// trashed copies discarded. If isModCache is true restored module dirs
+// are made read-only like the go command does. The number of files
+// and bytes restored are returned.
+func Restore(ctx context.Context, dir, trashDir string, isModCache bool) (files int, size int64, err error) {
+	log := logging.FromContext(ctx)
+	// nothing was pruned from the cache
+	if _, err := os.Stat(trashDir); errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, nil
+	}
+
+	var depDirs []string
+	err = filepath.WalkDir(trashDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		relPath, err := filepath.Rel(trashDir, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(dir, relPath)
+		if d.IsDir() {
+			// dirs of module versions have an '@' in their name,
+			// downloads don't
+			if isModCache && relPath != "." && strings.Contains(d.Name(), "@") && !strings.HasPrefix(filepath.ToSlash(relPath), "cache/") {
+				depDirs = append(depDirs, dest)
+			}
+			return nil
+		}
+
+		if _, err := os.Lstat(dest); err == nil {
+			debuglog.Debugf("restore", dest, "not restoring %q, it is in the cache already", dest)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := moveEntry(trashDir, dir, path); err != nil {
+			return fmt.Errorf("restoring %q: %w", dest, err)
+		}
+		debuglog.Debugf("restore", dest, "restored %q", dest)
+		files++
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return files, size, err
+	}
+
+	for _, depDir := range depDirs {
+		makeReadOnly(log, depDir)
+	}
+	if err := os.RemoveAll(trashDir); err != nil {
+		return files, size, fmt.Errorf("deleting trash dir: %w", err)
+	}
+	return files, size, nil
+}
@@ -0,0 +1,80 @@
+package prune
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+func TestRestore(t *testing.T) {
+	modCache := t.TempDir()
+	// restored dirs are read-only, allow them to be cleaned up
+	t.Cleanup(func() { chmodDir(logging.Discard, modCache) })
+	trashDir := filepath.Join(t.TempDir(), "mod")
+	files := map[string]string{
+		filepath.Join("example.com", "a@v1.0.0", "go.mod"):                         "module example.com/a\n",
+		filepath.Join("cache", "download", "example.com", "a", "@v", "v1.0.0.zip"): "zip",
+		filepath.Join("example.com", "b@v1.0.0", "go.mod"):                         "trashed",
+	}
+	for path, data := range files {
+		path = filepath.Join(trashDir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("creating dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("writing file: %v", err)
+		}
+	}
+	// b was downloaded again after it was pruned
+	bMod := filepath.Join(modCache, "example.com", "b@v1.0.0", "go.mod")
+	if err := os.MkdirAll(filepath.Dir(bMod), 0o755); err != nil {
+		t.Fatalf("creating dir: %v", err)
+	}
+	if err := os.WriteFile(bMod, []byte("module example.com/b\n"), 0o644); err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+
+	restored, size, err := Restore(context.Background(), modCache, trashDir, true)
+	if err != nil {
+		t.Fatalf("restoring: %v", err)
+	}
+	if restored != 2 {
+		t.Errorf("expected 2 files to be restored, got %d", restored)
+	}
+	if want := int64(len("module example.com/a\n") + len("zip")); size != want {
+		t.Errorf("expected %d bytes to be restored, got %d", want, size)
+	}
+
+	aMod := filepath.Join(modCache, "example.com", "a@v1.0.0", "go.mod")
+	info, err := os.Stat(aMod)
+	if err != nil {
+		t.Fatalf("expected module file to be restored, got %v", err)
+	}
+	if info.Mode().Perm()&0o222 != 0 {
+		t.Errorf("expected restored module file to be read-only, got %v", info.Mode())
+	}
+	if _, err := os.Stat(filepath.Join(modCache, "cache", "download", "example.com", "a", "@v", "v1.0.0.zip")); err != nil {
+		t.Errorf("expected download file to be restored, got %v", err)
+	}
+	data, err := os.ReadFile(bMod)
+	if err != nil {
+		t.Fatalf("reading module file: %v", err)
+	}
+	if string(data) != "module example.com/b\n" {
+		t.Errorf("expected module file in the cache to be kept, got %q", data)
+	}
+	if _, err := os.Stat(trashDir); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected trash dir to be deleted, got %v", err)
+	}
+
+	// restoring again does nothing
+	restored, _, err = Restore(context.Background(), modCache, trashDir, true)
+	if err != nil || restored != 0 {
+		t.Errorf("expected nothing to be restored without a trash dir, got %d files and %v", restored, err)
+	}
+}
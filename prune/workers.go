@@ -0,0 +1,44 @@
+package prune
+
+import "sync"
+
+// DefaultConcurrency is how many entries are deleted at the same time
+// if Options.Concurrency isn't set.
+const DefaultConcurrency = 4
+
+// workerPool bounds how many entries are deleted at the same time. One
+// pool is shared by every cache pruned by Caches, so pruning both
+// caches together doesn't do more I/O at once than pruning one.
+type workerPool struct {
+	sem chan struct{}
+}
+
+func newWorkerPool(size int) *workerPool {
+	if size <= 0 {
+		size = DefaultConcurrency
+	}
+	return &workerPool{sem: make(chan struct{}, size)}
+}
+
+// deleteWorkers returns the worker pool shared by the caches pruned by
+// Caches, or a new pool if opts isn't from Caches.
+func (o Options) deleteWorkers() *workerPool {
+	if o.workers != nil {
+		return o.workers
+	}
+	return newWorkerPool(o.Concurrency)
+}
+
+// run calls f in a new goroutine once a worker is free, blocking until
+// then. wg is done once f returns.
+func (p *workerPool) run(wg *sync.WaitGroup, f func()) {
+	p.sem <- struct{}{}
+	wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.sem
+			wg.Done()
+		}()
+		f()
+	}()
+}
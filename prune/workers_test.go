@@ -0,0 +1,39 @@
+package prune
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	const size = 3
+	pool := newWorkerPool(size)
+
+	var (
+		wg               sync.WaitGroup
+		running, maxSeen atomic.Int32
+	)
+	for i := 0; i < 20; i++ {
+		pool.run(&wg, func() {
+			n := running.Add(1)
+			for {
+				seen := maxSeen.Load()
+				if n <= seen || maxSeen.CompareAndSwap(seen, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+		})
+	}
+	wg.Wait()
+
+	if got := maxSeen.Load(); got > size {
+		t.Errorf("expected at most %d workers to run at the same time, got %d", size, got)
+	}
+	if got := cap(newWorkerPool(0).sem); got != DefaultConcurrency {
+		t.Errorf("expected %d workers by default, got %d", DefaultConcurrency, got)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const registryDirname = "go-cache-prune.d"
+
+// instance is a running go-cache-prune process that is watching
+// caches.
+type instance struct {
+	PID int `json:"pid"`
+	// Name is the -instance name, or empty for the unnamed instance
+	Name string `json:"name,omitempty"`
+	// ControlSocket is where the instance listens for control commands
+	ControlSocket string    `json:"controlSocket,omitempty"`
+	Caches        []string  `json:"caches"`
+	StartTime     time.Time `json:"startTime"`
+}
+
+// instanceNameRe matches valid -instance names, which are used in file
+// names.
+var instanceNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// runtimePaths returns the paths of the PID file and control socket of
+// the instance named name in the runtime dir runDir, or of the unnamed
+// instance if name is empty.
+func runtimePaths(runDir, name string) (pidFile, controlSocket string) {
+	if name == "" {
+		return filepath.Join(runDir, pidFilename), filepath.Join(runDir, controlSocketName)
+	}
+	pidFile = strings.TrimSuffix(pidFilename, ".pid") + "-" + name + ".pid"
+	controlSocket = strings.TrimSuffix(controlSocketName, ".sock") + "-" + name + ".sock"
+	return filepath.Join(runDir, pidFile), filepath.Join(runDir, controlSocket)
+}
+
+// registryDir returns the dir instances are registered in under the
+// runtime dir runDir.
+func registryDir(runDir string) string {
+	return filepath.Join(runDir, registryDirname)
+}
+
+// registerInstance records that this process, the instance named name
+// listening on controlSocket, is watching caches so other
+// go-cache-prune processes won't prune them. The returned function
+// removes the registration.
+func registerInstance(runDir, name, controlSocket string, caches []string) (func(), error) {
+	if err := os.MkdirAll(registryDir(runDir), 0o755); err != nil {
+		return nil, fmt.Errorf("creating instance registry: %w", err)
+	}
+
+	inst := instance{
+		PID:           os.Getpid(),
+		Name:          name,
+		ControlSocket: controlSocket,
+		Caches:        caches,
+		StartTime:     time.Now(),
+	}
+	instBytes, err := json.Marshal(inst)
+	if err != nil {
+		return nil, fmt.Errorf("encoding instance: %w", err)
+	}
+	instFile := filepath.Join(registryDir(runDir), strconv.Itoa(inst.PID)+".json")
+	if err := os.WriteFile(instFile, instBytes, 0o644); err != nil {
+		return nil, fmt.Errorf("writing instance file: %w", err)
+	}
+
+	return func() {
+		if err := os.Remove(instFile); err != nil {
+			log.Warningf("removing instance file: %v", err)
+		}
+	}, nil
+}
+
+// liveInstances returns all registered instances that are still
+// running, excluding this process.
+func liveInstances(runDir string) ([]instance, error) {
+	entries, err := os.ReadDir(registryDir(runDir))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading instance registry: %w", err)
+	}
+
+	var instances []instance
+	for _, entry := range entries {
+		instBytes, err := os.ReadFile(filepath.Join(registryDir(runDir), entry.Name()))
+		if err != nil {
+			continue
+		}
+		var inst instance
+		if err := json.Unmarshal(instBytes, &inst); err != nil {
+			log.Warningf("decoding instance file %q: %v", entry.Name(), err)
+			continue
+		}
+		if inst.PID == os.Getpid() || !processExists(inst.PID) {
+			continue
+		}
+
+		instances = append(instances, inst)
+	}
+
+	return instances, nil
+}
+
+// checkCachesNotWatched returns an error if another go-cache-prune
+// process is watching any of caches.
+func checkCachesNotWatched(runDir string, caches []string) error {
+	instances, err := liveInstances(runDir)
+	if err != nil {
+		return err
+	}
+
+	for _, inst := range instances {
+		for _, cache := range caches {
+			if slices.Contains(inst.Caches, cache) {
+				return fmt.Errorf("cache %q is being watched by go-cache-prune process %d", cache, inst.PID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// instanceStats is how watching is going for a running go-cache-prune
+// process, as returned by the stats control command.
+type instanceStats struct {
+	PID     int    `json:"pid"`
+	Events  uint64 `json:"events"`
+	Used    uint64 `json:"used"`
+	Watches int64  `json:"watches"`
+}
+
+// printInstances writes a table of the running go-cache-prune
+// processes registered in the runtime dir runDir to w. Event counts are
+// queried from the control socket of each process, or controlSocket if
+// a process didn't register its own.
+func printInstances(w io.Writer, runDir, controlSocket string) error {
+	instances, err := liveInstances(runDir)
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(instances, func(a, b instance) int {
+		return a.StartTime.Compare(b.StartTime)
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "PID\tNAME\tSTARTED\tEVENTS\tUSED\tCACHES")
+	for _, inst := range instances {
+		sock := inst.ControlSocket
+		if sock == "" {
+			sock = controlSocket
+		}
+		var stats instanceStats
+		if out, err := sendControlCommand(sock, "stats"); err == nil {
+			_ = json.Unmarshal([]byte(out), &stats)
+		}
+
+		name, events, used := "-", "-", "-"
+		if inst.Name != "" {
+			name = inst.Name
+		}
+		if stats.PID == inst.PID {
+			events, used = strconv.FormatUint(stats.Events, 10), strconv.FormatUint(stats.Used, 10)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			inst.PID, name, inst.StartTime.Format(time.RFC3339), events, used, strings.Join(inst.Caches, ","))
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckCachesNotWatched(t *testing.T) {
+	runDir := t.TempDir()
+
+	unregister, err := registerInstance(runDir, "", filepath.Join(runDir, controlSocketName), []string{"/cache/mod"})
+	if err != nil {
+		t.Fatalf("registering instance: %v", err)
+	}
+	t.Cleanup(unregister)
+
+	// this process's registration should be ignored
+	if err := checkCachesNotWatched(runDir, []string{"/cache/mod"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// register another running process
+	other := instance{
+		PID:    os.Getppid(),
+		Caches: []string{"/cache/build"},
+	}
+	otherBytes, err := json.Marshal(other)
+	if err != nil {
+		t.Fatalf("encoding instance: %v", err)
+	}
+	otherFile := filepath.Join(registryDir(runDir), strconv.Itoa(other.PID)+".json")
+	if err := os.WriteFile(otherFile, otherBytes, 0o644); err != nil {
+		t.Fatalf("writing instance file: %v", err)
+	}
+
+	if err := checkCachesNotWatched(runDir, []string{"/cache/mod"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := checkCachesNotWatched(runDir, []string{"/cache/mod", "/cache/build"}); err == nil {
+		t.Fatal("expected error when cache is being watched")
+	}
+}
+
+func TestPrintInstances(t *testing.T) {
+	runDir := t.TempDir()
+	if err := os.MkdirAll(registryDir(runDir), 0o755); err != nil {
+		t.Fatalf("creating instance registry: %v", err)
+	}
+	sockPath := filepath.Join(runDir, "go-cache-prune-job1.sock")
+	other := instance{
+		PID:           os.Getppid(),
+		Name:          "job1",
+		ControlSocket: sockPath,
+		Caches:        []string{"/cache/mod", "/cache/build"},
+		StartTime:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	otherBytes, err := json.Marshal(other)
+	if err != nil {
+		t.Fatalf("encoding instance: %v", err)
+	}
+	otherFile := filepath.Join(registryDir(runDir), strconv.Itoa(other.PID)+".json")
+	if err := os.WriteFile(otherFile, otherBytes, 0o644); err != nil {
+		t.Fatalf("writing instance file: %v", err)
+	}
+
+	stop, err := serveControl(sockPath, map[string]controlHandler{
+		"stats": func([]string) (string, error) {
+			return fmt.Sprintf(`{"pid":%d,"events":42,"used":7}`+"\n", other.PID), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("serving control socket: %v", err)
+	}
+	t.Cleanup(stop)
+
+	var sb strings.Builder
+	// the registered control socket of the instance is used
+	if err := printInstances(&sb, runDir, filepath.Join(runDir, controlSocketName)); err != nil {
+		t.Fatalf("printing instances: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(sb.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a header and 1 instance, got %q", sb.String())
+	}
+	fields := strings.Fields(lines[1])
+	want := []string{strconv.Itoa(other.PID), "job1", "2024-01-02T03:04:05Z", "42", "7", "/cache/mod,/cache/build"}
+	if !slices.Equal(fields, want) {
+		t.Errorf("expected instance line %q, got %q", want, fields)
+	}
+}
+
+func TestRuntimePaths(t *testing.T) {
+	pidFile, controlSocket := runtimePaths("/run", "")
+	if pidFile != filepath.Join("/run", pidFilename) || controlSocket != filepath.Join("/run", controlSocketName) {
+		t.Errorf("unexpected paths of unnamed instance %q and %q", pidFile, controlSocket)
+	}
+	pidFile, controlSocket = runtimePaths("/run", "job1")
+	if pidFile != filepath.Join("/run", "go-cache-prune-job1.pid") || controlSocket != filepath.Join("/run", "go-cache-prune-job1.sock") {
+		t.Errorf("unexpected paths of named instance %q and %q", pidFile, controlSocket)
+	}
+}
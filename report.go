@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	actions "github.com/sethvargo/go-githubactions"
+
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+	"github.com/capnspacehook/go-cache-prune/prune"
+)
+
+// pruneReport is the JSON report of what was pruned written by -report.
+type pruneReport struct {
+	DryRun           bool           `json:"dryRun"`
+	ModuleCache      *cacheReport   `json:"moduleCache,omitempty"`
+	BuildCache       *cacheReport   `json:"buildCache,omitempty"`
+	LintCache        *cacheReport   `json:"lintCache,omitempty"`
+	StaticcheckCache *cacheReport   `json:"staticcheckCache,omitempty"`
+	ExtraCaches      []*cacheReport `json:"extraCaches,omitempty"`
+	Telemetry        *cacheReport   `json:"telemetry,omitempty"`
+	Suggestions      []string       `json:"suggestions,omitempty"`
+
+	// mu guards adding caches, which can be pruned concurrently when
+	// they stop being watched at different times
+	mu sync.Mutex
+}
+
+// cacheReport is what was pruned from a cache.
+type cacheReport struct {
+	Dir         string   `json:"dir"`
+	Deleted     []string `json:"deleted"`
+	BytesFreed  int64    `json:"bytesFreed"`
+	Kept        int      `json:"kept"`
+	Quarantined uint     `json:"quarantined,omitempty"`
+	Interrupted bool     `json:"interrupted,omitempty"`
+
+	keptEntries    prune.EntryInfos
+	deletedEntries prune.EntryInfos
+}
+
+func newCacheReport(dir string, res prune.Result) *cacheReport {
+	deleted := res.DeletedPaths
+	if deleted == nil {
+		deleted = []string{}
+	}
+	return &cacheReport{
+		Dir:         dir,
+		Deleted:     deleted,
+		BytesFreed:  res.BytesFreed,
+		Kept:        len(res.KeptEntries),
+		Quarantined: res.Quarantined,
+		Interrupted: res.Interrupted,
+
+		keptEntries:    res.KeptEntries,
+		deletedEntries: res.DeletedEntries,
+	}
+}
+
+// addCaches adds the results of pruning the caches to the report. A
+// cache is skipped if its dir is empty. Caches pruned more than once,
+// like while watching, report what every prune deleted.
+func (r *pruneReport) addCaches(modCache, buildCache string, modRes, buildRes prune.Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if modCache != "" {
+		r.ModuleCache = r.ModuleCache.add(modCache, modRes)
+	}
+	if buildCache != "" {
+		r.BuildCache = r.BuildCache.add(buildCache, buildRes)
+	}
+}
+
+// add returns a report of c with the results of pruning its cache
+// again added, or a new report if c is nil. Kept entries are only
+// reported for the last prune, as earlier prunes kept them too.
+func (c *cacheReport) add(dir string, res prune.Result) *cacheReport {
+	next := newCacheReport(dir, res)
+	if c == nil {
+		return next
+	}
+
+	next.Deleted = append(c.Deleted, next.Deleted...)
+	next.BytesFreed += c.BytesFreed
+	next.Quarantined += c.Quarantined
+	next.Interrupted = next.Interrupted || c.Interrupted
+	next.deletedEntries = append(c.deletedEntries, next.deletedEntries...)
+	return next
+}
+
+// publish adds suggestions of how to tune pruning to the report,
+// writes it to the -report file if set and adds a summary of it to the
+// GitHub Actions step summary and sets step outputs if running in
+// GitHub Actions. The summary is also added to the Buildkite build as
+// an annotation if -buildkite-annotate is set, and the cache key of the
+// kept modules is written if -cache-key-file is set.
+func (r *pruneReport) publish(cfg *config) error {
+	r.suggest(cfg)
+	if os.Getenv("GITHUB_STEP_SUMMARY") != "" {
+		actions.AddStepSummary(r.markdown())
+	}
+	if os.Getenv("GITHUB_OUTPUT") != "" {
+		for _, output := range r.outputs() {
+			actions.SetOutput(output.name, output.value)
+		}
+	}
+	if cfg.cacheKeyFile != "" && r.ModuleCache != nil {
+		// entries are still in the cache after a dry run
+		var deleted []string
+		if r.DryRun {
+			deleted = r.ModuleCache.Deleted
+		}
+		if err := writeCacheKey(cfg.cacheKeyFile, r.ModuleCache.Dir, deleted); err != nil {
+			return err
+		}
+	}
+	if cfg.buildkiteAnnotate {
+		// failing to annotate the build shouldn't fail the job
+		if err := annotateBuildkite(r.markdown()); err != nil {
+			log.Warningf("annotating Buildkite build: %v", err)
+		}
+	}
+	return r.write(cfg.reportFile)
+}
+
+type stepOutput struct {
+	name  string
+	value string
+}
+
+// outputs returns the GitHub Actions step outputs of the report.
+// Deleted telemetry files are counted as deleted files.
+func (r *pruneReport) outputs() []stepOutput {
+	var filesDeleted, modulesDeleted int
+	var bytesFreed int64
+	if r.ModuleCache != nil {
+		modulesDeleted = len(r.ModuleCache.Deleted)
+		bytesFreed += r.ModuleCache.BytesFreed
+	}
+	for _, c := range []*cacheReport{r.BuildCache, r.LintCache, r.StaticcheckCache, r.Telemetry} {
+		if c != nil {
+			filesDeleted += len(c.Deleted)
+			bytesFreed += c.BytesFreed
+		}
+	}
+
+	return []stepOutput{
+		{name: "files-deleted", value: strconv.Itoa(filesDeleted)},
+		{name: "modules-deleted", value: strconv.Itoa(modulesDeleted)},
+		{name: "bytes-freed", value: strconv.FormatInt(bytesFreed, 10)},
+	}
+}
+
+// markdown returns a markdown table summarizing the report.
+func (r *pruneReport) markdown() string {
+	var sb strings.Builder
+	sb.WriteString("### go-cache-prune\n\n")
+	deletedHeader := "Deleted"
+	if r.DryRun {
+		deletedHeader = "Would delete"
+	}
+	fmt.Fprintf(&sb, "| | Kept | %s | Space reclaimed |\n", deletedHeader)
+	sb.WriteString("| --- | ---: | ---: | ---: |\n")
+
+	type row struct {
+		name   string
+		unit   string
+		report *cacheReport
+	}
+	rows := []row{
+		{name: "Module cache", unit: "modules", report: r.ModuleCache},
+		{name: "Build cache", unit: "files", report: r.BuildCache},
+		{name: "golangci-lint cache", unit: "files", report: r.LintCache},
+		{name: "staticcheck cache", unit: "entries", report: r.StaticcheckCache},
+	}
+	for _, extra := range r.ExtraCaches {
+		rows = append(rows, row{name: "`" + extra.Dir + "`", unit: "entries", report: extra})
+	}
+	rows = append(rows, row{name: "Telemetry", unit: "files", report: r.Telemetry})
+	for _, c := range rows {
+		if c.report == nil {
+			continue
+		}
+		fmt.Fprintf(&sb, "| %s | %d %s | %d %s | %s |\n",
+			c.name, c.report.Kept, c.unit, len(c.report.Deleted), c.unit, bytesize.Format(c.report.BytesFreed))
+	}
+
+	if len(r.Suggestions) != 0 {
+		sb.WriteString("\n#### Suggestions\n\n")
+		for _, suggestion := range r.Suggestions {
+			fmt.Fprintf(&sb, "- %s\n", suggestion)
+		}
+	}
+
+	return sb.String()
+}
+
+// write writes the report to path if it isn't empty.
+func (r *pruneReport) write(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding report: %w", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/capnspacehook/go-cache-prune/prune"
+)
+
+func TestPruneReport(t *testing.T) {
+	report := pruneReport{DryRun: true}
+	report.addCaches("/cache/mod", "", prune.Result{
+		Deleted:        1,
+		DeletedPaths:   []string{"/cache/mod/example.com/a@v1.0.0"},
+		DeletedEntries: prune.EntryInfos{{Size: 100}},
+		BytesFreed:     100,
+		KeptEntries:    prune.EntryInfos{{Size: 10}, {Size: 20}},
+	}, prune.Result{})
+	report.addCaches("", "/cache/build", prune.Result{}, prune.Result{})
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := report.write(path); err != nil {
+		t.Fatalf("writing report: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading report: %v", err)
+	}
+	var got pruneReport
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decoding report: %v", err)
+	}
+
+	if !got.DryRun {
+		t.Error("expected report to be of a dry run")
+	}
+	mod := got.ModuleCache
+	if mod == nil || mod.Dir != "/cache/mod" || mod.BytesFreed != 100 || mod.Kept != 2 ||
+		!slices.Equal(mod.Deleted, []string{"/cache/mod/example.com/a@v1.0.0"}) {
+		t.Errorf("unexpected module cache report %+v", mod)
+	}
+	build := got.BuildCache
+	if build == nil || build.Deleted == nil || len(build.Deleted) != 0 {
+		t.Errorf("expected empty list of deleted build cache files, got %+v", build)
+	}
+	if got.Telemetry != nil {
+		t.Errorf("expected no telemetry report, got %+v", got.Telemetry)
+	}
+}
+
+func TestPruneReportMarkdown(t *testing.T) {
+	report := pruneReport{
+		ModuleCache: &cacheReport{Deleted: []string{"a", "b"}, BytesFreed: 2048, Kept: 3},
+	}
+	want := `### go-cache-prune
+
+| | Kept | Deleted | Space reclaimed |
+| --- | ---: | ---: | ---: |
+| Module cache | 3 modules | 2 modules | 2.0KiB |
+`
+	if got := report.markdown(); got != want {
+		t.Errorf("markdown() = %q, want %q", got, want)
+	}
+}
+
+func TestPruneReportOutputs(t *testing.T) {
+	report := pruneReport{
+		ModuleCache: &cacheReport{Deleted: []string{"a", "b"}, BytesFreed: 2048},
+		BuildCache:  &cacheReport{Deleted: []string{"c"}, BytesFreed: 100},
+		Telemetry:   &cacheReport{Deleted: []string{"d"}, BytesFreed: 10},
+	}
+	want := []stepOutput{
+		{name: "files-deleted", value: "2"},
+		{name: "modules-deleted", value: "2"},
+		{name: "bytes-freed", value: "2158"},
+	}
+	if got := report.outputs(); !slices.Equal(got, want) {
+		t.Errorf("outputs() = %v, want %v", got, want)
+	}
+}
+
+func TestPruneReportAddCaches(t *testing.T) {
+	var report pruneReport
+	report.addCaches("/cache/mod", "", prune.Result{
+		DeletedPaths:   []string{"a"},
+		DeletedEntries: prune.EntryInfos{{Size: 100}},
+		BytesFreed:     100,
+		KeptEntries:    prune.EntryInfos{{Size: 10}, {Size: 20}},
+	}, prune.Result{})
+	// pruning the cache again adds to what was deleted
+	report.addCaches("/cache/mod", "", prune.Result{
+		DeletedPaths:   []string{"b"},
+		DeletedEntries: prune.EntryInfos{{Size: 50}},
+		BytesFreed:     50,
+		KeptEntries:    prune.EntryInfos{{Size: 10}},
+	}, prune.Result{})
+
+	mod := report.ModuleCache
+	if !slices.Equal(mod.Deleted, []string{"a", "b"}) || mod.BytesFreed != 150 || mod.Kept != 1 || len(mod.deletedEntries) != 2 {
+		t.Errorf("unexpected module cache report %+v", mod)
+	}
+	if report.BuildCache != nil {
+		t.Errorf("expected no build cache report, got %+v", report.BuildCache)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// sbom contains the fields of SPDX and CycloneDX JSON documents that
+// are needed to find Go modules.
+type sbom struct {
+	// CycloneDX
+	BOMFormat  string `json:"bomFormat"`
+	Components []struct {
+		PURL string `json:"purl"`
+	} `json:"components"`
+
+	// SPDX
+	SPDXVersion string `json:"spdxVersion"`
+	Packages    []struct {
+		ExternalRefs []struct {
+			ReferenceType    string `json:"referenceType"`
+			ReferenceLocator string `json:"referenceLocator"`
+		} `json:"externalRefs"`
+	} `json:"packages"`
+}
+
+// parseSBOM returns the Go modules listed in a SPDX or CycloneDX JSON
+// SBOM.
+func parseSBOM(path string) ([]module.Version, error) {
+	sbomBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading SBOM: %w", err)
+	}
+	var doc sbom
+	if err := json.Unmarshal(sbomBytes, &doc); err != nil {
+		return nil, fmt.Errorf("decoding SBOM: %w", err)
+	}
+
+	var purls []string
+	switch {
+	case doc.BOMFormat == "CycloneDX":
+		for _, c := range doc.Components {
+			purls = append(purls, c.PURL)
+		}
+	case doc.SPDXVersion != "":
+		for _, p := range doc.Packages {
+			for _, ref := range p.ExternalRefs {
+				if ref.ReferenceType == "purl" {
+					purls = append(purls, ref.ReferenceLocator)
+				}
+			}
+		}
+	default:
+		return nil, errors.New("SBOM is not a SPDX or CycloneDX JSON document")
+	}
+
+	var mods []module.Version
+	for _, purl := range purls {
+		if mod, ok := parseGoPURL(purl); ok {
+			mods = append(mods, mod)
+		}
+	}
+
+	return mods, nil
+}
+
+// parseGoPURL parses a package URL of a Go module, ex.
+// pkg:golang/github.com/foo/bar@v1.2.3.
+func parseGoPURL(purl string) (module.Version, bool) {
+	rest, ok := strings.CutPrefix(purl, "pkg:golang/")
+	if !ok {
+		return module.Version{}, false
+	}
+	// remove subpath and qualifiers
+	rest, _, _ = strings.Cut(rest, "#")
+	rest, _, _ = strings.Cut(rest, "?")
+
+	escPath, escVer, ok := strings.Cut(rest, "@")
+	if !ok {
+		return module.Version{}, false
+	}
+	modPath, err := url.PathUnescape(escPath)
+	if err != nil {
+		return module.Version{}, false
+	}
+	modVer, err := url.PathUnescape(escVer)
+	if err != nil {
+		return module.Version{}, false
+	}
+
+	return module.Version{Path: modPath, Version: modVer}, true
+}
+
+// compareSBOM returns the used modules that aren't in the SBOM and the
+// modules in the SBOM that weren't used.
+func compareSBOM(used, sbomMods []module.Version) (missing, unused []module.Version) {
+	inSBOM := make(map[module.Version]bool, len(sbomMods))
+	for _, mod := range sbomMods {
+		inSBOM[mod] = false
+	}
+	for _, mod := range used {
+		if _, ok := inSBOM[mod]; !ok {
+			missing = append(missing, mod)
+			continue
+		}
+		inSBOM[mod] = true
+	}
+	for _, mod := range sbomMods {
+		if wasUsed := inSBOM[mod]; !wasUsed {
+			unused = append(unused, mod)
+			// don't report duplicate SBOM entries twice
+			inSBOM[mod] = true
+		}
+	}
+
+	return missing, unused
+}
+
+// checkSBOM reports differences between the used modules and the
+// modules in the SBOM at path.
+func checkSBOM(path string, used []module.Version) error {
+	sbomMods, err := parseSBOM(path)
+	if err != nil {
+		return err
+	}
+
+	missing, unused := compareSBOM(used, sbomMods)
+	for _, mod := range missing {
+		log.Warningf("module %s was used but is not in the SBOM", mod)
+	}
+	for _, mod := range unused {
+		log.Debugf("module %s is in the SBOM but was not used", mod)
+	}
+	log.Infof("SBOM check: %d used modules missing from the SBOM, %d modules in the SBOM not used", len(missing), len(unused))
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+func TestParseSBOM(t *testing.T) {
+	want := []module.Version{
+		{Path: "github.com/foo/bar", Version: "v1.2.3"},
+		{Path: "golang.org/x/mod", Version: "v0.12.0"},
+	}
+
+	tests := map[string]string{
+		"CycloneDX": `{
+	"bomFormat": "CycloneDX",
+	"components": [
+		{"purl": "pkg:golang/github.com/foo/bar@v1.2.3?type=module"},
+		{"purl": "pkg:npm/left-pad@1.3.0"},
+		{"purl": "pkg:golang/golang.org/x/mod@v0.12.0#module"}
+	]
+}`,
+		"SPDX": `{
+	"spdxVersion": "SPDX-2.3",
+	"packages": [
+		{"externalRefs": [{"referenceType": "purl", "referenceLocator": "pkg:golang/github.com/foo/bar@v1.2.3"}]},
+		{"externalRefs": [{"referenceType": "cpe23Type", "referenceLocator": "cpe:2.3:a:foo:bar"}]},
+		{"externalRefs": [{"referenceType": "purl", "referenceLocator": "pkg:golang/golang.org%2Fx%2Fmod@v0.12.0"}]}
+	]
+}`,
+	}
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sbom.json")
+			if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+				t.Fatalf("writing SBOM: %v", err)
+			}
+
+			mods, err := parseSBOM(path)
+			if err != nil {
+				t.Fatalf("parsing SBOM: %v", err)
+			}
+			if !slices.Equal(mods, want) {
+				t.Fatalf("expected modules %v, got %v", want, mods)
+			}
+		})
+	}
+}
+
+func TestCompareSBOM(t *testing.T) {
+	var (
+		a = module.Version{Path: "a", Version: "v1.0.0"}
+		b = module.Version{Path: "b", Version: "v1.0.0"}
+		c = module.Version{Path: "c", Version: "v1.0.0"}
+	)
+
+	missing, unused := compareSBOM([]module.Version{a, b}, []module.Version{b, c, c})
+	if !slices.Equal(missing, []module.Version{a}) {
+		t.Errorf("expected missing modules to be [a], got %v", missing)
+	}
+	if !slices.Equal(unused, []module.Version{c}) {
+		t.Errorf("expected unused modules to be [c], got %v", unused)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/mod/module"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+)
+
+// seedManifestName is the name of the manifest written to the sync dir.
+const seedManifestName = "manifest.json"
+
+// seedManifest describes what is in a sync dir so image maintainers can
+// review what is baked into images.
+type seedManifest struct {
+	Created      time.Time      `json:"created"`
+	Modules      []seedModule   `json:"modules"`
+	BuildEntries []seedEntry    `json:"buildEntries"`
+	Sizes        seedCacheSizes `json:"sizes"`
+}
+
+type seedModule struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Size    int64  `json:"size"`
+}
+
+type seedEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+type seedCacheSizes struct {
+	ModuleCache int64 `json:"moduleCache"`
+	BuildCache  int64 `json:"buildCache"`
+	Total       int64 `json:"total"`
+}
+
+// newSeedManifest returns the manifest of the module cache modCache and
+// build cache buildCache of a sync dir, either can be empty.
+func newSeedManifest(modCache, buildCache string, created time.Time) (*seedManifest, error) {
+	m := &seedManifest{
+		Created:      created,
+		Modules:      []seedModule{},
+		BuildEntries: []seedEntry{},
+	}
+
+	if modCache != "" {
+		err := filepath.WalkDir(modCache, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			depDir, ok := cache.DependencyDir(path, d)
+			if !ok || depDir != path {
+				return nil
+			}
+			mod, ok := dirModule(modCache, depDir)
+			if !ok {
+				return nil
+			}
+
+			size := dirSize(depDir)
+			m.Modules = append(m.Modules, seedModule{Path: mod.Path, Version: mod.Version, Size: size})
+			m.Sizes.ModuleCache += size
+			return fs.SkipDir
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walking %q: %w", modCache, err)
+		}
+	}
+
+	if buildCache != "" {
+		err := filepath.WalkDir(buildCache, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !cache.IsBuildEntry(d.Name()) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			relPath, err := filepath.Rel(buildCache, path)
+			if err != nil {
+				return err
+			}
+			m.BuildEntries = append(m.BuildEntries, seedEntry{Name: filepath.ToSlash(relPath), Size: info.Size()})
+			m.Sizes.BuildCache += info.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walking %q: %w", buildCache, err)
+		}
+	}
+	m.Sizes.Total = m.Sizes.ModuleCache + m.Sizes.BuildCache
+
+	return m, nil
+}
+
+// readSeedManifest reads the manifest at path, nil is returned if it
+// doesn't exist.
+func readSeedManifest(path string) (*seedManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading seed manifest: %w", err)
+	}
+	var m seedManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing seed manifest: %w", err)
+	}
+
+	return &m, nil
+}
+
+// writeSeedManifest writes the manifest of the sync dir syncDir and logs
+// how it changed since the previous manifest.
+func writeSeedManifest(syncDir, modCache, buildCache string, created time.Time) error {
+	path := filepath.Join(syncDir, seedManifestName)
+	prev, err := readSeedManifest(path)
+	if err != nil {
+		// a corrupted manifest is replaced
+		log.Warningf("%v", err)
+	}
+	m, err := newSeedManifest(modCache, buildCache, created)
+	if err != nil {
+		return fmt.Errorf("creating seed manifest: %w", err)
+	}
+
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding seed manifest: %w", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("writing seed manifest: %w", err)
+	}
+
+	log.Infof("wrote seed manifest %q: %d modules, %d build entries, %s total",
+		path, len(m.Modules), len(m.BuildEntries), bytesize.Format(m.Sizes.Total))
+	if prev != nil {
+		added, removed := diffSeedModules(prev.Modules, m.Modules)
+		log.Infof("since the seed from %s: %s change in size, %d modules added, %d modules removed",
+			prev.Created.Format(time.RFC3339), formatSizeChange(m.Sizes.Total-prev.Sizes.Total), len(added), len(removed))
+	}
+
+	return nil
+}
+
+// diffSeedModules returns the modules of cur that aren't in prev and
+// the modules of prev that aren't in cur.
+func diffSeedModules(prev, cur []seedModule) (added, removed []module.Version) {
+	toSet := func(mods []seedModule) map[module.Version]bool {
+		set := make(map[module.Version]bool, len(mods))
+		for _, mod := range mods {
+			set[module.Version{Path: mod.Path, Version: mod.Version}] = true
+		}
+		return set
+	}
+	prevSet, curSet := toSet(prev), toSet(cur)
+
+	for mod := range curSet {
+		if !prevSet[mod] {
+			added = append(added, mod)
+		}
+	}
+	for mod := range prevSet {
+		if !curSet[mod] {
+			removed = append(removed, mod)
+		}
+	}
+	module.Sort(added)
+	module.Sort(removed)
+
+	return added, removed
+}
+
+// formatSizeChange formats a change in size with its sign.
+func formatSizeChange(change int64) string {
+	if change < 0 {
+		return "-" + bytesize.Format(-change)
+	}
+	return "+" + bytesize.Format(change)
+}
+
+// dirSize returns the total size of all files in dir.
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+func TestSeedManifest(t *testing.T) {
+	actionID := strings.Repeat("0", 62) + "11"
+	outputID := strings.Repeat("0", 62) + "22"
+	syncDir := t.TempDir()
+	modCache := filepath.Join(syncDir, "mod")
+	buildCache := filepath.Join(syncDir, "build")
+	for path, contents := range map[string]string{
+		filepath.Join(modCache, "example.com", "a@v1.0.0", "go.mod"):                         "module example.com/a\n",
+		filepath.Join(modCache, "example.com", "a@v1.0.0", "a.go"):                           "package a\n",
+		filepath.Join(modCache, "cache", "download", "example.com", "a", "@v", "v1.0.0.zip"): "zip",
+		filepath.Join(buildCache, "00", actionID+"-a"):                                       "action",
+		filepath.Join(buildCache, "00", outputID+"-d"):                                       "output",
+		filepath.Join(buildCache, "README"):                                                  "readme",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("creating dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatalf("writing file: %v", err)
+		}
+	}
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := writeSeedManifest(syncDir, modCache, buildCache, created); err != nil {
+		t.Fatalf("writing seed manifest: %v", err)
+	}
+	m, err := readSeedManifest(filepath.Join(syncDir, seedManifestName))
+	if err != nil || m == nil {
+		t.Fatalf("reading seed manifest: %v, %v", m, err)
+	}
+
+	if !m.Created.Equal(created) {
+		t.Errorf("created = %v, want %v", m.Created, created)
+	}
+	if len(m.Modules) != 1 || m.Modules[0].Path != "example.com/a" || m.Modules[0].Version != "v1.0.0" || m.Modules[0].Size != 31 {
+		t.Errorf("unexpected modules: %+v", m.Modules)
+	}
+	if len(m.BuildEntries) != 2 || m.BuildEntries[0].Name != "00/"+actionID+"-a" {
+		t.Errorf("unexpected build entries: %+v", m.BuildEntries)
+	}
+	if m.Sizes.ModuleCache != 31 || m.Sizes.BuildCache != 12 || m.Sizes.Total != 43 {
+		t.Errorf("unexpected sizes: %+v", m.Sizes)
+	}
+}
+
+func TestDiffSeedModules(t *testing.T) {
+	prev := []seedModule{
+		{Path: "example.com/a", Version: "v1.0.0"},
+		{Path: "example.com/b", Version: "v1.0.0"},
+	}
+	cur := []seedModule{
+		{Path: "example.com/a", Version: "v1.0.0"},
+		{Path: "example.com/b", Version: "v1.1.0"},
+		{Path: "example.com/c", Version: "v0.1.0"},
+	}
+
+	added, removed := diffSeedModules(prev, cur)
+	wantAdded := []module.Version{{Path: "example.com/b", Version: "v1.1.0"}, {Path: "example.com/c", Version: "v0.1.0"}}
+	wantRemoved := []module.Version{{Path: "example.com/b", Version: "v1.0.0"}}
+	if len(added) != len(wantAdded) || added[0] != wantAdded[0] || added[1] != wantAdded[1] {
+		t.Errorf("added = %v, want %v", added, wantAdded)
+	}
+	if len(removed) != len(wantRemoved) || removed[0] != wantRemoved[0] {
+		t.Errorf("removed = %v, want %v", removed, wantRemoved)
+	}
+}
@@ -0,0 +1,64 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// terminateSignals stop go-cache-prune without pruning caches
+	terminateSignals = []os.Signal{os.Interrupt, unix.SIGTERM}
+	// pruneSignals stop watching caches and start pruning them
+	pruneSignals = []os.Signal{unix.SIGHUP}
+	// restoreDoneSignals end the cache restore window
+	restoreDoneSignals = []os.Signal{unix.SIGUSR2}
+	// statusSignals log how watching caches is going
+	statusSignals = []os.Signal{unix.SIGUSR1}
+)
+
+// requestPrune tells a running go-cache-prune to stop watching and
+// prune caches and waits for it to exit.
+func requestPrune(pidFile, _ string) error {
+	p, err := signalProcess(pidFile, unix.SIGHUP)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.Wait(); err != nil {
+		return fmt.Errorf("waiting for signaling go-cache-prune process to complete: %w", err)
+	}
+
+	return nil
+}
+
+// requestRestoreDone tells a running go-cache-prune that caches have
+// been restored.
+func requestRestoreDone(pidFile, _ string) error {
+	_, err := signalProcess(pidFile, unix.SIGUSR2)
+	return err
+}
+
+// requestAbort tells a running go-cache-prune to shut down without
+// pruning caches. SIGINT is used as SIGTERM prunes caches when running
+// as a systemd service.
+func requestAbort(pidFile, _ string) error {
+	_, err := signalProcess(pidFile, unix.SIGINT)
+	return err
+}
+
+// requestStatus tells a running go-cache-prune to log how watching
+// caches is going.
+func requestStatus(pidFile, _ string) error {
+	_, err := signalProcess(pidFile, unix.SIGUSR1)
+	return err
+}
+
+func processExists(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || errors.Is(err, unix.EPERM)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// exit code of processes that haven't exited yet
+const stillActive = 259
+
+var (
+	// terminateSignals stop go-cache-prune without pruning caches
+	terminateSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	// signals can't be sent to other processes on Windows, the
+	// control socket is used instead
+	pruneSignals       []os.Signal
+	restoreDoneSignals []os.Signal
+	statusSignals      []os.Signal
+)
+
+// requestPrune tells a running go-cache-prune to stop watching and
+// prune caches.
+func requestPrune(_, controlSocket string) error {
+	_, err := sendControlCommand(controlSocket, "prune")
+	return err
+}
+
+// requestRestoreDone tells a running go-cache-prune that caches have
+// been restored.
+func requestRestoreDone(_, controlSocket string) error {
+	_, err := sendControlCommand(controlSocket, "restore-done")
+	return err
+}
+
+// requestAbort tells a running go-cache-prune to shut down without
+// pruning caches.
+func requestAbort(_, controlSocket string) error {
+	_, err := sendControlCommand(controlSocket, abortCommand)
+	return err
+}
+
+// requestStatus tells a running go-cache-prune to log how watching
+// caches is going.
+func requestStatus(_, controlSocket string) error {
+	_, err := sendControlCommand(controlSocket, logStatusCommand)
+	return err
+}
+
+func processExists(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(h)
+
+	var exitCode uint32
+	if err := windows.GetExitCodeProcess(h, &exitCode); err != nil {
+		return false
+	}
+	return exitCode == stillActive
+}
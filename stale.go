@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cleanStaleState removes the PID file pidFile and instance
+// registrations in the runtime dir runDir left behind by go-cache-prune processes that
+// didn't exit cleanly. If requirePIDFile is true and the PID file
+// belongs to a running process an error is returned.
+func cleanStaleState(runDir, pidFile string, requirePIDFile bool) error {
+	pid, err := readPIDFile(pidFile)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err == nil && pid != os.Getpid() && processExists(pid):
+		if requirePIDFile {
+			return fmt.Errorf("go-cache-prune is already running with PID %d", pid)
+		}
+	default:
+		if err := os.Remove(pidFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("removing stale PID file: %w", err)
+		}
+		if err != nil {
+			log.Infof("removed invalid PID file %q: %v", pidFile, err)
+		} else {
+			log.Infof("removed stale PID file %q of exited process %d", pidFile, pid)
+		}
+	}
+
+	removed, err := cleanStaleInstances(runDir)
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		log.Infof("removed %d stale instance registrations", removed)
+	}
+
+	return nil
+}
+
+// readPIDFile returns the PID stored in pidFile.
+func readPIDFile(pidFile string) (int, error) {
+	pidBytes, err := os.ReadFile(pidFile)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+	if err != nil {
+		return 0, fmt.Errorf("parsing PID: %w", err)
+	}
+
+	return pid, nil
+}
+
+// cleanStaleInstances removes registrations of instances that aren't
+// running anymore or can't be decoded, and returns how many were
+// removed.
+func cleanStaleInstances(runDir string) (int, error) {
+	entries, err := os.ReadDir(registryDir(runDir))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("reading instance registry: %w", err)
+	}
+
+	var removed int
+	for _, entry := range entries {
+		instFile := filepath.Join(registryDir(runDir), entry.Name())
+		instBytes, err := os.ReadFile(instFile)
+		if err != nil {
+			continue
+		}
+		var inst instance
+		if err := json.Unmarshal(instBytes, &inst); err == nil && processExists(inst.PID) {
+			continue
+		}
+
+		if err := os.Remove(instFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warningf("removing stale instance file: %v", err)
+			continue
+		}
+		removed++
+	}
+
+	return removed, nil
+}
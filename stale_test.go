@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// exitedPID returns the PID of a process that has exited.
+func exitedPID(t *testing.T) int {
+	t.Helper()
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("getting executable: %v", err)
+	}
+	cmd := exec.Command(exe, "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("running process: %v", err)
+	}
+	return cmd.ProcessState.Pid()
+}
+
+func TestCleanStaleState(t *testing.T) {
+	runDir := t.TempDir()
+	pidFile := filepath.Join(runDir, pidFilename)
+	deadPID := exitedPID(t)
+
+	// a PID file of a running process should be kept
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getppid())), 0o644); err != nil {
+		t.Fatalf("writing PID file: %v", err)
+	}
+	if err := cleanStaleState(runDir, pidFile, true); err == nil {
+		t.Fatal("expected error when PID file is of a running process")
+	}
+	if err := cleanStaleState(runDir, pidFile, false); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := os.Stat(pidFile); err != nil {
+		t.Fatalf("expected PID file to be kept, got %v", err)
+	}
+
+	// stale PID files and registrations should be removed
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(deadPID)), 0o644); err != nil {
+		t.Fatalf("writing PID file: %v", err)
+	}
+	unregister, err := registerInstance(runDir, "", filepath.Join(runDir, controlSocketName), []string{"/cache/mod"})
+	if err != nil {
+		t.Fatalf("registering instance: %v", err)
+	}
+	t.Cleanup(unregister)
+	staleFiles := map[string]string{
+		strconv.Itoa(deadPID) + ".json": `{"pid": ` + strconv.Itoa(deadPID) + `}`,
+		"corrupt.json":                  "{",
+	}
+	for name, contents := range staleFiles {
+		if err := os.WriteFile(filepath.Join(registryDir(runDir), name), []byte(contents), 0o644); err != nil {
+			t.Fatalf("writing instance file: %v", err)
+		}
+	}
+
+	if err := cleanStaleState(runDir, pidFile, true); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := os.Stat(pidFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected stale PID file to be removed, got %v", err)
+	}
+	entries, err := os.ReadDir(registryDir(runDir))
+	if err != nil {
+		t.Fatalf("reading instance registry: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != strconv.Itoa(os.Getpid())+".json" {
+		t.Errorf("expected only this process to be registered, got %v", entries)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/internal/otlp"
+	"github.com/capnspacehook/go-cache-prune/watch"
+)
+
+// runStatus tracks how watching and pruning caches is going. It is
+// safe to use concurrently.
+type runStatus struct {
+	// metricsFile is where metrics are written after pruning if set
+	metricsFile string
+	// tracing exports spans and metrics after pruning if set
+	tracing *tracing
+
+	mu         sync.Mutex
+	stats      *watch.Stats
+	since      time.Time
+	readyAt    time.Time
+	lastPrune  time.Time
+	lastReport *pruneReport
+	totals     metricsTotals
+}
+
+// statusJSON is the JSON encoding of runStatus returned by the stats
+// and status control commands and the HTTP status endpoint.
+type statusJSON struct {
+	instanceStats
+	WatchingSince time.Time    `json:"watchingSince"`
+	LastPrune     *time.Time   `json:"lastPrune,omitempty"`
+	LastReport    *pruneReport `json:"lastReport,omitempty"`
+}
+
+// watching records that caches started being watched at since.
+func (s *runStatus) watching(stats *watch.Stats, since time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats != nil {
+		s.totals.pastEvents += s.stats.Events()
+	}
+	s.stats, s.since, s.readyAt = stats, since, time.Time{}
+}
+
+// ready records that all caches were being watched at t.
+func (s *runStatus) ready(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readyAt = t
+}
+
+// pruned records that pruning caches started at start and finished at
+// end, writes metrics to the -metrics-textfile file and exports spans
+// and metrics to an OpenTelemetry collector if configured.
+func (s *runStatus) pruned(report *pruneReport, start, end time.Time) {
+	s.mu.Lock()
+	s.lastPrune, s.lastReport = end, report
+	s.totals.lastPruneDuration = end.Sub(start)
+	if !report.DryRun {
+		s.totals.addReport(report)
+	}
+	var (
+		spans   []otlp.Span
+		metrics []otlp.Metric
+	)
+	if s.tracing != nil {
+		var watchEvents uint64
+		if s.stats != nil {
+			watchEvents = s.stats.Events()
+		}
+		spans, metrics = s.tracing.pruneSpans(s, watchEvents, start), s.tracing.metrics(s, watchEvents)
+	}
+	s.mu.Unlock()
+
+	if s.tracing != nil {
+		s.tracing.export(spans, metrics)
+	}
+
+	if s.metricsFile != "" {
+		if err := s.writeMetricsFile(); err != nil {
+			log.Warningf("%v", err)
+		}
+	}
+}
+
+// json returns the status encoded as JSON.
+func (s *runStatus) json() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := statusJSON{
+		instanceStats: instanceStats{
+			PID:     os.Getpid(),
+			Events:  s.stats.Events(),
+			Used:    s.stats.Used(),
+			Watches: s.stats.Watches(),
+		},
+		WatchingSince: s.since,
+		LastReport:    s.lastReport,
+	}
+	if !s.lastPrune.IsZero() {
+		lastPrune := s.lastPrune
+		status.LastPrune = &lastPrune
+	}
+	return json.Marshal(status)
+}
+
+// controlHandler returns a control handler that outputs the status.
+func (s *runStatus) controlHandler() controlHandler {
+	return func([]string) (string, error) {
+		statusBytes, err := s.json()
+		if err != nil {
+			return "", err
+		}
+		return string(statusBytes) + "\n", nil
+	}
+}
+
+// logStatus logs how watching caches is going.
+func (s *runStatus) logStatus() {
+	s.mu.Lock()
+	stats, since := s.stats, s.since
+	s.mu.Unlock()
+
+	if stats == nil {
+		log.Infof("status: not watching caches")
+		return
+	}
+	log.Infof("status: watching for %s, %s", time.Since(since).Round(time.Second), stats.Status())
+}
+
+// logStatusOnSignal logs the status whenever one of statusSignals is
+// received until the returned function is called.
+func (s *runStatus) logStatusOnSignal() func() {
+	if len(statusSignals) == 0 {
+		return func() {}
+	}
+
+	statusSig := make(chan os.Signal, 1)
+	signal.Notify(statusSig, statusSignals...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-statusSig:
+				s.logStatus()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(statusSig)
+		close(done)
+	}
+}
+
+// logStatusHandler returns a control handler that logs the status.
+func (s *runStatus) logStatusHandler() controlHandler {
+	return func([]string) (string, error) {
+		s.logStatus()
+		return "", nil
+	}
+}
+
+// ServeHTTP serves the status as JSON.
+func (s *runStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	statusBytes, err := s.json()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(statusBytes, '\n'))
+}
+
+// serveStatus serves status over HTTP on addr at /status, and as
+// Prometheus metrics at /metrics. The returned function stops serving.
+func serveStatus(addr string, status *runStatus) (func(), error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening for HTTP status requests: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/status", status)
+	mux.HandleFunc("/metrics", status.serveMetrics)
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Infof("serving status at http://%s/status", l.Addr())
+
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Warningf("serving HTTP status: %v", err)
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Warningf("stopping HTTP status server: %v", err)
+		}
+	}, nil
+}
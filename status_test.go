@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/watch"
+)
+
+func TestRunStatus(t *testing.T) {
+	status := new(runStatus)
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	status.watching(new(watch.Stats), since)
+
+	get := func() statusJSON {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		status.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		var got statusJSON
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("decoding status: %v", err)
+		}
+		return got
+	}
+
+	got := get()
+	if !got.WatchingSince.Equal(since) || got.LastPrune != nil || got.LastReport != nil {
+		t.Errorf("unexpected status before pruning: %+v", got)
+	}
+
+	pruneTime := since.Add(time.Hour)
+	status.pruned(&pruneReport{DryRun: true}, pruneTime.Add(-time.Second), pruneTime)
+	got = get()
+	if got.LastPrune == nil || !got.LastPrune.Equal(pruneTime) {
+		t.Errorf("expected last prune at %s, got %v", pruneTime, got.LastPrune)
+	}
+	if got.LastReport == nil || !got.LastReport.DryRun {
+		t.Errorf("expected last report to be included, got %+v", got.LastReport)
+	}
+
+	rec := httptest.NewRecorder()
+	status.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/status", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d for POST, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
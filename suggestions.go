@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+	"github.com/capnspacehook/go-cache-prune/prune"
+)
+
+const (
+	// recentDeletionAge is how recently deleted entries must have been
+	// added to suggest -min-residency
+	recentDeletionAge = 48 * time.Hour
+	// minSuggestedSavings is how much space a suggestion must make a
+	// difference for to be made
+	minSuggestedSavings = 64 << 20
+	// minUsedRatio is the share of the module cache that must be used
+	// by a run to not suggest keeping modules used by most runs
+	minUsedRatio = 0.5
+	// largeCacheSize is the size of the pruned caches above which
+	// -max-cache-size is suggested
+	largeCacheSize = 5 << 30
+)
+
+// suggest adds suggestions of how the pruning policy could be tuned
+// based on what was kept and deleted in this run to the report.
+func (r *pruneReport) suggest(cfg *config) {
+	r.Suggestions = nil
+
+	var kept, deleted prune.EntryInfos
+	for _, c := range []*cacheReport{r.ModuleCache, r.BuildCache} {
+		if c != nil {
+			kept = append(kept, c.keptEntries...)
+			deleted = append(deleted, c.deletedEntries...)
+		}
+	}
+
+	// entries added recently were likely downloaded or built by another
+	// job sharing the caches and will be needed again soon
+	if cfg.minResidency == 0 {
+		var recent prune.EntryInfos
+		for _, entry := range deleted {
+			if entry.Age < recentDeletionAge {
+				recent = append(recent, entry)
+			}
+		}
+		if size := recent.TotalSize(); size >= minSuggestedSavings {
+			r.Suggestions = append(r.Suggestions, fmt.Sprintf(
+				"%d deleted entries were added in the last %s; consider -min-residency=%s: it would have kept an extra %s",
+				len(recent), formatHours(recentDeletionAge), formatHours(recentDeletionAge), bytesize.Format(size)))
+		}
+	}
+
+	// keep lists only apply to the module cache, and entries are never
+	// recorded as used with -max-age
+	if mod := r.ModuleCache; mod != nil && cfg.maxAge == 0 && cfg.usageHistory == "" && cfg.keepListFile == "" {
+		keptSize, deletedSize := mod.keptEntries.TotalSize(), mod.deletedEntries.TotalSize()
+		total := keptSize + deletedSize
+		if deletedSize >= minSuggestedSavings && float64(keptSize)/float64(total) < minUsedRatio {
+			r.Suggestions = append(r.Suggestions, fmt.Sprintf(
+				"only %.0f%% of the module cache (%s of %s) was used; if the cache is shared by jobs with different dependencies, consider -usage-history and -keep-list to keep modules used by most runs",
+				100*float64(keptSize)/float64(total), bytesize.Format(keptSize), bytesize.Format(total)))
+		}
+	}
+
+	if size := kept.TotalSize(); cfg.maxCacheBytes == 0 && size > largeCacheSize {
+		r.Suggestions = append(r.Suggestions, fmt.Sprintf(
+			"the caches are still %s after pruning; consider -max-cache-size to cap their size",
+			bytesize.Format(size)))
+	}
+}
+
+// formatHours formats d in hours, the way durations are usually
+// written in flags.
+func formatHours(d time.Duration) string {
+	return fmt.Sprintf("%dh", int(d.Hours()))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/prune"
+)
+
+func TestSuggest(t *testing.T) {
+	const mib = 1 << 20
+
+	tests := []struct {
+		name     string
+		cfg      config
+		modRes   prune.Result
+		buildRes prune.Result
+		want     []string
+	}{
+		{
+			name: "nothing to suggest",
+			modRes: prune.Result{
+				KeptEntries:    prune.EntryInfos{{Age: time.Hour, Size: 100 * mib}},
+				DeletedEntries: prune.EntryInfos{{Age: 72 * time.Hour, Size: 10 * mib}},
+			},
+		},
+		{
+			name: "recently added entries deleted",
+			buildRes: prune.Result{
+				DeletedEntries: prune.EntryInfos{
+					{Age: time.Hour, Size: 50 * mib},
+					{Age: 24 * time.Hour, Size: 50 * mib},
+					{Age: 72 * time.Hour, Size: 500 * mib},
+				},
+			},
+			want: []string{"-min-residency=48h: it would have kept an extra 100.0MiB"},
+		},
+		{
+			name: "min residency already set",
+			cfg:  config{minResidency: time.Hour},
+			buildRes: prune.Result{
+				DeletedEntries: prune.EntryInfos{{Age: time.Hour, Size: 100 * mib}},
+			},
+		},
+		{
+			name: "most of module cache unused",
+			modRes: prune.Result{
+				KeptEntries:    prune.EntryInfos{{Age: 72 * time.Hour, Size: 100 * mib}},
+				DeletedEntries: prune.EntryInfos{{Age: 72 * time.Hour, Size: 300 * mib}},
+			},
+			want: []string{"only 25% of the module cache (100.0MiB of 400.0MiB) was used"},
+		},
+		{
+			name: "usage history already recorded",
+			cfg:  config{usageHistory: "history.json"},
+			modRes: prune.Result{
+				KeptEntries:    prune.EntryInfos{{Age: 72 * time.Hour, Size: 100 * mib}},
+				DeletedEntries: prune.EntryInfos{{Age: 72 * time.Hour, Size: 300 * mib}},
+			},
+		},
+		{
+			name: "large caches",
+			buildRes: prune.Result{
+				KeptEntries: prune.EntryInfos{{Age: time.Hour, Size: 6 << 30}},
+			},
+			want: []string{"the caches are still 6.0GiB after pruning"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var report pruneReport
+			report.addCaches("/cache/mod", "/cache/build", tt.modRes, tt.buildRes)
+			report.suggest(&tt.cfg)
+
+			if len(report.Suggestions) != len(tt.want) {
+				t.Fatalf("suggest() = %q, want suggestions containing %q", report.Suggestions, tt.want)
+			}
+			for i := range tt.want {
+				if !strings.Contains(report.Suggestions[i], tt.want[i]) {
+					t.Errorf("suggestion %q doesn't contain %q", report.Suggestions[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
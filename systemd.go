@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/internal/sdnotify"
+)
+
+// serviceSignals returns the signals that stop go-cache-prune without
+// pruning caches and the ones that start pruning them. When running
+// as a systemd service SIGTERM, which systemd stops services with,
+// prunes caches before exiting.
+func serviceSignals(cfg *config) (terminate, prune []os.Signal) {
+	if !cfg.systemd {
+		return terminateSignals, pruneSignals
+	}
+
+	terminate = slices.DeleteFunc(slices.Clone(terminateSignals), func(sig os.Signal) bool {
+		return sig == syscall.SIGTERM
+	})
+	prune = append(slices.Clone(pruneSignals), syscall.SIGTERM)
+	return terminate, prune
+}
+
+// notifySystemd tells systemd about state changes if -systemd is set.
+func notifySystemd(cfg *config, state string) {
+	if !cfg.systemd {
+		return
+	}
+	if _, err := sdnotify.Notify(state); err != nil {
+		log.Warningf("notifying systemd: %v", err)
+	}
+}
+
+// runWatchdog tells systemd that go-cache-prune is alive at half the
+// watchdog interval until ctx is canceled, if -systemd is set and the
+// watchdog is enabled.
+func runWatchdog(ctx context.Context, cfg *config) {
+	if !cfg.systemd {
+		return
+	}
+	interval, err := sdnotify.WatchdogInterval()
+	if err != nil {
+		log.Warningf("getting systemd watchdog interval: %v", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			notifySystemd(cfg, "WATCHDOG=1")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+	"github.com/capnspacehook/go-cache-prune/prune"
+	"github.com/capnspacehook/go-cache-prune/watch"
+)
+
+// defaultToolCache is the value of -lint-cache and -staticcheck-cache
+// that causes the cache dir to be found the same way the tool does.
+const defaultToolCache = "default"
+
+// toolCache is a cache of a Go tool other than the go command that is
+// watched and pruned along with the Go caches.
+type toolCache struct {
+	// name is what the cache is called in logs
+	name string
+	dir  string
+	// trashName is the subdir of the trash dir unused entries are
+	// moved to
+	trashName string
+	// dirLevel is true if usage is recorded per directory instead of
+	// per file
+	dirLevel bool
+	// prune deletes entries of the cache that aren't in usedFiles
+	prune func(ctx context.Context, dir string, usedFiles cache.UsedFiles, opts prune.Options) prune.Result
+	// setReport adds the result of pruning the cache to a report
+	setReport func(r *pruneReport, c *cacheReport)
+}
+
+// toolCaches returns the tool caches that will be pruned.
+func toolCaches(cfg *config) []toolCache {
+	var caches []toolCache
+	if cfg.lintCache != "" {
+		caches = append(caches, toolCache{
+			name:      "golangci-lint cache",
+			dir:       cfg.lintCache,
+			trashName: "golangci-lint",
+			// it is laid out like the build cache
+			prune: func(ctx context.Context, dir string, usedFiles cache.UsedFiles, opts prune.Options) prune.Result {
+				opts.DirLevel = false
+				return prune.Cache(ctx, dir, false, usedFiles, opts)
+			},
+			setReport: func(r *pruneReport, c *cacheReport) { r.LintCache = c },
+		})
+	}
+	if cfg.staticcheckCache != "" {
+		caches = append(caches, toolCache{
+			name:      "staticcheck cache",
+			dir:       cfg.staticcheckCache,
+			trashName: "staticcheck",
+			prune:     prune.Staticcheck,
+			setReport: func(r *pruneReport, c *cacheReport) { r.StaticcheckCache = c },
+		})
+	}
+	for _, extra := range cfg.extraCaches {
+		extra := extra
+		caches = append(caches, toolCache{
+			name:      fmt.Sprintf("extra cache %q", extra.dir),
+			dir:       extra.dir,
+			trashName: filepath.Join("extra", strings.TrimPrefix(extra.dir, filepath.VolumeName(extra.dir))),
+			dirLevel:  extra.dirLevel,
+			prune: func(ctx context.Context, dir string, usedFiles cache.UsedFiles, opts prune.Options) prune.Result {
+				opts.DirLevel = extra.dirLevel
+				// nothing is laid out like the build cache
+				opts.QuarantineDir = ""
+				opts.KeepFuzzCache = false
+				opts.MaxFuzzSize = 0
+				return prune.Cache(ctx, dir, false, usedFiles, opts)
+			},
+			setReport: func(r *pruneReport, c *cacheReport) { r.ExtraCaches = append(r.ExtraCaches, c) },
+		})
+	}
+	return caches
+}
+
+// extraCache is a cache passed with -extra-cache.
+type extraCache struct {
+	dir string
+	// dirLevel is true if usage is recorded per directory instead of
+	// per file
+	dirLevel bool
+}
+
+// parseExtraCache parses a -extra-cache value of the form path[:type],
+// where type is the granularity usage is recorded at.
+func parseExtraCache(s string) (extraCache, error) {
+	path, granularity := s, fileGranularity
+	if i := strings.LastIndexByte(s, ':'); i != -1 && !strings.ContainsAny(s[i+1:], `/\`) {
+		path, granularity = s[:i], s[i+1:]
+	}
+	if granularity != fileGranularity && granularity != dirGranularity {
+		return extraCache{}, fmt.Errorf("type of -extra-cache %q must be %s or %s", s, fileGranularity, dirGranularity)
+	}
+	if path == "" {
+		return extraCache{}, fmt.Errorf("-extra-cache %q has no path", s)
+	}
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return extraCache{}, fmt.Errorf("getting absolute path of -extra-cache %q: %w", s, err)
+	}
+	return extraCache{dir: dir, dirLevel: granularity == dirGranularity}, nil
+}
+
+// toolCacheDir returns the cache dir of a tool. If flagVal is
+// defaultToolCache the dir is found like the tool does, from envVar or
+// the name subdir of the user cache dir.
+func toolCacheDir(flagVal, envVar, name string) (string, error) {
+	if flagVal != defaultToolCache {
+		return filepath.Abs(flagVal)
+	}
+	if dir := os.Getenv(envVar); dir != "" {
+		return filepath.Abs(dir)
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("getting %s cache dir: %w", name, err)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// watchToolCaches starts recording which entries of caches are used
+// until ctx is canceled. opts.Ready is changed to only be called once
+// all caches are being watched. The returned function waits until
+// recording is done and returns the used entries of each cache.
+func watchToolCaches(ctx context.Context, caches []toolCache, opts *watch.Options) func() ([]cache.UsedFiles, error) {
+	toolOpts := *opts
+	toolOpts.CacheDone = nil
+	toolOpts.OnUsed = nil
+	var readyWG sync.WaitGroup
+	if ready := opts.Ready; ready != nil {
+		readyWG.Add(len(caches) + 1)
+		opts.Ready = readyWG.Done
+		go func() {
+			readyWG.Wait()
+			ready()
+		}()
+	}
+
+	var (
+		usedFiles = make([]cache.UsedFiles, len(caches))
+		errs      = make([]error, len(caches))
+		wg        sync.WaitGroup
+	)
+	for i, c := range caches {
+		i, c := i, c
+		cacheOpts := toolOpts
+		cacheOpts.DirLevel = c.dirLevel
+		if cacheOpts.Ready != nil {
+			// don't block the command from running if watching fails
+			// before the cache is being watched
+			cacheOpts.Ready = sync.OnceFunc(readyWG.Done)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			usedFiles[i], errs[i] = watch.Record(ctx, false, c.dir, cacheOpts)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("watching %s: %w", c.name, errs[i])
+			}
+			if cacheOpts.Ready != nil {
+				cacheOpts.Ready()
+			}
+		}()
+	}
+
+	return func() ([]cache.UsedFiles, error) {
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+		return usedFiles, nil
+	}
+}
+
+// pruneToolCache deletes entries of the tool cache c that aren't in
+// usedFiles.
+func pruneToolCache(ctx context.Context, c toolCache, usedFiles cache.UsedFiles, opts prune.Options, report *pruneReport) {
+	if opts.TrashDir != "" {
+		opts.TrashDir = filepath.Join(opts.TrashDir, c.trashName)
+	}
+	res := c.prune(ctx, c.dir, usedFiles, opts)
+	c.setReport(report, newCacheReport(c.dir, res))
+	if opts.DryRun {
+		log.Infof("would delete %d entries from %s, freeing %s", res.Deleted, c.name, bytesize.Format(res.BytesFreed))
+	} else {
+		log.Infof("deleted %d entries from %s, freed %s", res.Deleted, c.name, bytesize.Format(res.BytesFreed))
+	}
+}
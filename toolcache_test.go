@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestToolCacheDir(t *testing.T) {
+	userCache, err := os.UserCacheDir()
+	if err != nil {
+		t.Skipf("no user cache dir: %v", err)
+	}
+	tmp := t.TempDir()
+
+	tests := []struct {
+		name    string
+		flagVal string
+		env     string
+		want    string
+	}{
+		{name: "path", flagVal: tmp, want: tmp},
+		{name: "default", flagVal: defaultToolCache, want: filepath.Join(userCache, "golangci-lint")},
+		{name: "default from env", flagVal: defaultToolCache, env: tmp, want: tmp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GOLANGCI_LINT_CACHE", tt.env)
+			got, err := toolCacheDir(tt.flagVal, "GOLANGCI_LINT_CACHE", "golangci-lint")
+			if err != nil {
+				t.Fatalf("getting tool cache dir: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseExtraCache(t *testing.T) {
+	tmp := t.TempDir()
+
+	tests := []struct {
+		name    string
+		val     string
+		want    extraCache
+		wantErr bool
+	}{
+		{name: "path", val: tmp, want: extraCache{dir: tmp}},
+		{name: "file type", val: tmp + ":file", want: extraCache{dir: tmp}},
+		{name: "dir type", val: tmp + ":dir", want: extraCache{dir: tmp, dirLevel: true}},
+		{name: "colon in path", val: filepath.Join(tmp+":x", "cache"), want: extraCache{dir: filepath.Join(tmp+":x", "cache")}},
+		{name: "unknown type", val: tmp + ":blob", wantErr: true},
+		{name: "no path", val: ":dir", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExtraCache(tt.val)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsing: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+	"github.com/capnspacehook/go-cache-prune/prune"
+)
+
+// emptyTrash deletes the entries moved to the trash dir by the last
+// prune, so only the entries of the prune about to happen can be
+// restored. It does nothing if -trash-dir is unset or on dry runs.
+func emptyTrash(cfg *config) error {
+	if cfg.trashDir == "" || cfg.dryRun {
+		return nil
+	}
+	entries, err := os.ReadDir(cfg.trashDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("reading trash dir: %w", err)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(cfg.trashDir, entry.Name())); err != nil {
+			return fmt.Errorf("emptying trash dir: %w", err)
+		}
+	}
+	if len(entries) != 0 {
+		log.Infof("emptied trash dir %q", cfg.trashDir)
+	}
+	return nil
+}
+
+// restoreCommand is the command that restores what the last prune
+// moved to the trash dir.
+const restoreCommand = "restore"
+
+// restoreCaches moves the entries the last prune moved to the trash dir
+// back into the caches they were pruned from.
+func restoreCaches(ctx context.Context, cfg *config) error {
+	type trashedCache struct {
+		name       string
+		dir        string
+		trashName  string
+		isModCache bool
+	}
+	var caches []trashedCache
+	if cfg.pruneModCache {
+		caches = append(caches, trashedCache{name: "module cache", dir: cfg.moduleCache, trashName: "mod", isModCache: true})
+	}
+	if cfg.pruneBuildCache {
+		caches = append(caches, trashedCache{name: "build cache", dir: cfg.buildCache, trashName: "build"})
+	}
+	for _, c := range toolCaches(cfg) {
+		caches = append(caches, trashedCache{name: c.name, dir: c.dir, trashName: c.trashName})
+	}
+
+	for _, c := range caches {
+		files, size, err := prune.Restore(ctx, c.dir, filepath.Join(cfg.trashDir, c.trashName), c.isModCache)
+		if err != nil {
+			return fmt.Errorf("restoring %s: %w", c.name, err)
+		}
+		log.Infof("restored %d files totaling %s to %s", files, bytesize.Format(size), c.name)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// verifyArgs returns the arguments to pass to the go command to verify
+// the caches with. verify is either a go command or a list of packages
+// to build, -v is added so cache misses are printed.
+func verifyArgs(verify string) ([]string, error) {
+	args := strings.Fields(verify)
+	if len(args) == 0 {
+		return nil, errors.New("no command or packages")
+	}
+	if args[0] != "go" {
+		args = append([]string{"build"}, args...)
+	} else {
+		args = args[1:]
+		if len(args) == 0 {
+			return nil, errors.New("no go subcommand")
+		}
+	}
+	// only these print which packages are built with -v
+	if args[0] != "build" && args[0] != "install" {
+		return nil, fmt.Errorf("'go %s' can't be used to verify caches, only 'go build' or 'go install' can", args[0])
+	}
+
+	var flags []string
+	if !slices.Contains(args, "-v") {
+		flags = append(flags, "-v")
+	}
+	// only check that the build hits the caches, don't write binaries
+	if args[0] == "build" && !slices.ContainsFunc(args, isOutputFlag) {
+		flags = append(flags, "-o", os.DevNull)
+	}
+	return slices.Insert(args, 1, flags...), nil
+}
+
+func isOutputFlag(arg string) bool {
+	return arg == "-o" || strings.HasPrefix(arg, "-o=")
+}
+
+// cacheMisses are what a build had to download or compile because it
+// wasn't cached.
+type cacheMisses struct {
+	// Downloaded are the modules that were downloaded as path@version
+	Downloaded []string
+	// Compiled are the import paths of packages that were compiled
+	Compiled []string
+}
+
+func (m cacheMisses) len() int {
+	return len(m.Downloaded) + len(m.Compiled)
+}
+
+// parseCacheMisses parses the output of the go command run with -v.
+// Like when testing, output is assumed to only be printed if modules
+// weren't in the module cache or packages weren't in the build cache.
+func parseCacheMisses(output string) cacheMisses {
+	var misses cacheMisses
+	s := bufio.NewScanner(strings.NewReader(output))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if mod, ok := strings.CutPrefix(line, "go: downloading "); ok {
+			if path, version, ok := strings.Cut(mod, " "); ok {
+				misses.Downloaded = append(misses.Downloaded, path+"@"+version)
+			}
+			continue
+		}
+		// anything else that isn't an import path is a message from
+		// the go command or a tool, not a package being built
+		if line == "" || strings.ContainsAny(line, " \t:") {
+			continue
+		}
+		misses.Compiled = append(misses.Compiled, line)
+	}
+
+	return misses
+}
+
+// verifyCaches runs the -verify command with the pruned caches and
+// warns if more than -verify-max-misses modules had to be downloaded or
+// packages had to be compiled, which means something that was still
+// needed was pruned.
+func verifyCaches(ctx context.Context, cfg *config) {
+	if cfg.verify == "" || ctx.Err() != nil {
+		return
+	}
+	log.Group("Verifying caches")
+	defer log.EndGroup()
+
+	// the arguments were already checked when parsing flags
+	args, _ := verifyArgs(cfg.verify)
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Env = os.Environ()
+	if cfg.moduleCache != "" {
+		cmd.Env = append(cmd.Env, "GOMODCACHE="+cfg.moduleCache)
+	}
+	if cfg.buildCache != "" {
+		cmd.Env = append(cmd.Env, "GOCACHE="+cfg.buildCache)
+	}
+	log.Infof("running %s", cmd)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Warningf("verifying caches: running %s: %v\n%s", cmd, err, strings.TrimSpace(string(out)))
+		return
+	}
+
+	misses := parseCacheMisses(string(out))
+	if misses.len() <= cfg.verifyMaxMisses {
+		log.Infof("verification hit the caches, %d modules were downloaded and %d packages were compiled",
+			len(misses.Downloaded), len(misses.Compiled))
+		return
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "verification missed the caches after pruning, %d modules were downloaded and %d packages were compiled",
+		len(misses.Downloaded), len(misses.Compiled))
+	for _, list := range []struct {
+		name  string
+		items []string
+	}{
+		{name: "downloaded modules", items: misses.Downloaded},
+		{name: "compiled packages", items: misses.Compiled},
+	} {
+		if len(list.items) != 0 {
+			fmt.Fprintf(&msg, "\n%s:\n  %s", list.name, strings.Join(list.items, "\n  "))
+		}
+	}
+	log.Warningf("%s", msg.String())
+}
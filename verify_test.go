@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestVerifyArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		verify  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "go build", verify: "go build ./...", want: []string{"build", "-v", "-o", os.DevNull, "./..."}},
+		{name: "packages", verify: "./cmd/a ./cmd/b", want: []string{"build", "-v", "-o", os.DevNull, "./cmd/a", "./cmd/b"}},
+		{name: "output set", verify: "go build -v -o bin/ ./...", want: []string{"build", "-v", "-o", "bin/", "./..."}},
+		{name: "go install", verify: "go install ./cmd/a", want: []string{"install", "-v", "./cmd/a"}},
+		{name: "go test", verify: "go test ./...", wantErr: true},
+		{name: "no subcommand", verify: "go", wantErr: true},
+		{name: "empty", verify: " ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyArgs(tt.verify)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getting args: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseCacheMisses(t *testing.T) {
+	output := `go: downloading golang.org/x/sys v0.20.0
+go: downloading github.com/google/uuid v1.6.0
+golang.org/x/sys/unix
+github.com/google/uuid
+# example.com/app
+./main.go:3:2: warning
+example.com/app
+`
+	misses := parseCacheMisses(output)
+	wantDownloaded := []string{"golang.org/x/sys@v0.20.0", "github.com/google/uuid@v1.6.0"}
+	if !slices.Equal(misses.Downloaded, wantDownloaded) {
+		t.Errorf("expected downloaded %q, got %q", wantDownloaded, misses.Downloaded)
+	}
+	wantCompiled := []string{"golang.org/x/sys/unix", "github.com/google/uuid", "example.com/app"}
+	if !slices.Equal(misses.Compiled, wantCompiled) {
+		t.Errorf("expected compiled %q, got %q", wantCompiled, misses.Compiled)
+	}
+
+	if misses := parseCacheMisses(""); misses.len() != 0 {
+		t.Errorf("expected no misses, got %+v", misses)
+	}
+}
@@ -0,0 +1,128 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/clock"
+)
+
+// ParseProcNames parses a comma separated list of process names.
+func ParseProcNames(names string) map[string]struct{} {
+	if names == "" {
+		return nil
+	}
+
+	procs := make(map[string]struct{})
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		// the kernel truncates process names to 15 bytes
+		if len(name) > 15 {
+			name = name[:15]
+		}
+		procs[name] = struct{}{}
+	}
+
+	return procs
+}
+
+// procRefreshInterval is how often the processes whose accesses are
+// ignored are looked up again.
+const procRefreshInterval = time.Second
+
+// procMatcher finds which processes with names in procs have files
+// open. inotify doesn't report which process caused an event, so this
+// is best effort: files that are quickly opened and closed won't be
+// found. Reading all of /proc for every event is too slow, so the PIDs
+// of matching processes are cached and only looked up again every
+// procRefreshInterval, processes started since then aren't found.
+type procMatcher struct {
+	procs     map[string]struct{}
+	clk       clock.Clock
+	refreshed time.Time
+	// pids maps the PIDs of matching processes to their names
+	pids map[string]string
+}
+
+func newProcMatcher(procs map[string]struct{}, clk clock.Clock) *procMatcher {
+	return &procMatcher{
+		procs: procs,
+		clk:   clock.Or(clk),
+	}
+}
+
+// accessedBy returns the name of a matching process that has path or a
+// file in path open, or an empty string if none do.
+func (m *procMatcher) accessedBy(path string) string {
+	if now := m.clk.Now(); m.pids == nil || now.Sub(m.refreshed) >= procRefreshInterval {
+		m.pids = matchingProcs(m.procs)
+		m.refreshed = now
+	}
+
+	for pid, name := range m.pids {
+		if hasOpen(pid, path) {
+			return name
+		}
+	}
+	return ""
+}
+
+// matchingProcs returns the PIDs and names of running processes with
+// names in procs.
+func matchingProcs(procs map[string]struct{}) map[string]string {
+	pids := make(map[string]string)
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return pids
+	}
+
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		name := procName(entry.Name())
+		if _, ok := procs[name]; ok {
+			pids[entry.Name()] = name
+		}
+	}
+
+	return pids
+}
+
+// hasOpen returns true if the process with the passed PID has path or
+// a file in path open.
+func hasOpen(pid, path string) bool {
+	fdDir := filepath.Join("/proc", pid, "fd")
+	fds, err := os.ReadDir(fdDir)
+	if err != nil {
+		return false
+	}
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err != nil {
+			continue
+		}
+		if target == path || cache.IsWithinDir(path, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// procName returns the name of the process with the passed PID, or an
+// empty string if it can't be found.
+func procName(pid string) string {
+	comm, err := os.ReadFile(filepath.Join("/proc", pid, "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(comm), "\n")
+}
@@ -0,0 +1,51 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/clock"
+)
+
+func TestProcMatcher(t *testing.T) {
+	comm, err := os.ReadFile("/proc/self/comm")
+	if err != nil {
+		t.Skipf("reading process name: %v", err)
+	}
+	procName := strings.TrimSuffix(string(comm), "\n")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating file: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	clk := clock.NewFake(time.Now())
+	m := newProcMatcher(ParseProcNames("gopls, "+procName), clk)
+	if name := m.accessedBy(path); name != procName {
+		t.Fatalf("expected file to be accessed by %q, got %q", procName, name)
+	}
+	if name := m.accessedBy(dir); name != procName {
+		t.Fatalf("expected dir to be accessed by %q, got %q", procName, name)
+	}
+	if name := newProcMatcher(ParseProcNames("gopls"), clk).accessedBy(path); name != "" {
+		t.Fatalf("expected file to not be accessed by gopls, got %q", name)
+	}
+
+	// processes are only looked up again once the refresh interval
+	// passed
+	m.pids = map[string]string{}
+	clk.Advance(procRefreshInterval / 2)
+	if name := m.accessedBy(path); name != "" {
+		t.Fatalf("expected cached processes to be used, got %q", name)
+	}
+	clk.Advance(procRefreshInterval / 2)
+	if name := m.accessedBy(path); name != procName {
+		t.Fatalf("expected processes to be looked up again, got %q", name)
+	}
+}
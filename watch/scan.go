@@ -0,0 +1,165 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/clock"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+// scanCache records which entries of a cache are used by waiting until
+// ctx is canceled and then finding entries that were accessed or
+// changed since watching started. It is used when file access events
+// aren't available, and relies on the filesystem updating access times.
+// Only the default phase is recorded, and ignoring processes and
+// reporting unexpected writes aren't supported.
+func scanCache(ctx context.Context, isModCache bool, dir string, opts Options) (cache.UsedFiles, error) {
+	log := logging.FromContext(ctx)
+
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return cache.UsedFiles{}, fmt.Errorf("creating cache dir: %w", err)
+	}
+	if len(opts.IgnoredProcs) != 0 || opts.UnexpectedWrites != nil {
+		log.Warningf("ignoring processes and reporting unexpected writes aren't supported when scanning caches")
+	}
+
+	// changes made while the cache is being restored aren't usage
+	if opts.RestoreDone != nil {
+		select {
+		case <-opts.RestoreDone:
+		case <-ctx.Done():
+		}
+	}
+	start := clock.Or(opts.Clock).Now()
+	log.Infof("watching cache dir %q", dir)
+	if opts.Ready != nil {
+		opts.Ready()
+	}
+
+	<-ctx.Done()
+
+	log.Infof("scanning cache dir %q for used entries", dir)
+	return scanUsedSince(log, isModCache, opts.DirLevel, dir, start, cache.DefaultPhaseMask), nil
+}
+
+// pollInterval is how often caches are scanned when polling
+const pollInterval = 30 * time.Second
+
+// pollCache records which entries of a cache are used by periodically
+// scanning it for entries that were accessed or changed since the last
+// scan. It is used when the filesystem the cache is on doesn't generate
+// file events, and relies on it updating access or modification times.
+// Usage is recorded as part of the phase that is current when it's
+// found, and ignoring processes and reporting unexpected writes aren't
+// supported.
+func pollCache(ctx context.Context, isModCache bool, dir string, opts Options) (cache.UsedFiles, error) {
+	log := logging.FromContext(ctx)
+
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return cache.UsedFiles{}, fmt.Errorf("creating cache dir: %w", err)
+	}
+	if len(opts.IgnoredProcs) != 0 || opts.UnexpectedWrites != nil {
+		log.Warningf("ignoring processes and reporting unexpected writes aren't supported when polling caches")
+	}
+
+	// changes made while the cache is being restored aren't usage
+	if opts.RestoreDone != nil {
+		select {
+		case <-opts.RestoreDone:
+		case <-ctx.Done():
+		}
+	}
+	clk := clock.Or(opts.Clock)
+	since := clk.Now()
+	log.Infof("polling cache dir %q every %s", dir, pollInterval)
+	if opts.Ready != nil {
+		opts.Ready()
+	}
+
+	usedFiles := cache.NewUsedFiles()
+	poll := func() {
+		scanStart := clk.Now()
+		scanUsedSince(log, isModCache, opts.DirLevel, dir, since, opts.Phases.CurrentMask()).Range(func(path string, mask cache.PhaseMask) bool {
+			opts.markUsed(isModCache, usedFiles, path, mask)
+			return true
+		})
+		since = scanStart
+	}
+
+	ticker := clk.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			poll()
+		case <-ctx.Done():
+			log.Infof("scanning cache dir %q for used entries", dir)
+			poll()
+			return usedFiles, nil
+		}
+	}
+}
+
+// scanUsedSince returns the entries of a cache that were accessed or
+// changed after since, recording them as used in the phases of mask.
+func scanUsedSince(log logging.Logger, isModCache, dirLevel bool, dir string, since time.Time, mask cache.PhaseMask) cache.UsedFiles {
+	usedFiles := cache.NewUsedFiles()
+
+	var depDir string
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Warningf("walking %q: %v", path, err)
+			return nil
+		}
+		if path == dir {
+			return nil
+		}
+
+		if isModCache {
+			if dd, ok := cache.DependencyDir(path, d); ok && dd == path {
+				depDir = dd
+			}
+			if path != depDir && !cache.IsWithinDir(depDir, path) {
+				return nil
+			}
+			// a dependency dir is used if anything in it was used
+			if usedFiles.Has(depDir) {
+				return filepath.SkipDir
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			if cache.LastUsed(info).After(since) {
+				usedFiles.Add(depDir, mask)
+				return filepath.SkipDir
+			}
+
+			return nil
+		} else if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if cache.LastUsed(info).After(since) {
+			usedPath := path
+			if dirLevel {
+				usedPath = filepath.Dir(path)
+			}
+			usedFiles.Add(usedPath, mask)
+		}
+
+		return nil
+	})
+
+	return usedFiles
+}
@@ -0,0 +1,49 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+func TestScanUsedSince(t *testing.T) {
+	cacheDir := t.TempDir()
+	for _, name := range []string{"ab/used", "ab/unused", "cd/unused"} {
+		path := filepath.Join(cacheDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("creating dir: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatalf("creating file: %v", err)
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(cacheDir, "ab", "used"), now, now); err != nil {
+		t.Fatalf("changing file times: %v", err)
+	}
+
+	used := scanUsedSince(logging.Actions, false, false, cacheDir, since, cache.DefaultPhaseMask)
+	if used.Len() != 1 {
+		t.Fatalf("expected 1 used file, got %d", used.Len())
+	}
+	if !used.Has(filepath.Join(cacheDir, "ab", "used")) {
+		t.Fatal("expected ab/used to be used")
+	}
+
+	used = scanUsedSince(logging.Actions, false, true, cacheDir, since, cache.DefaultPhaseMask)
+	if used.Len() != 1 {
+		t.Fatalf("expected 1 used dir, got %d", used.Len())
+	}
+	if !used.Has(filepath.Join(cacheDir, "ab")) {
+		t.Fatal("expected ab to be used")
+	}
+}
@@ -0,0 +1,98 @@
+package watch
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+// maxUnexpectedWriteWarnings is how many unexpected writes will be
+// individually warned about.
+const maxUnexpectedWriteWarnings = 20
+
+// UnexpectedWrites records files created in the caches that don't
+// match the naming conventions the go command uses.
+type UnexpectedWrites struct {
+	mtx   sync.Mutex
+	paths []string
+}
+
+// check records path if it was created in cacheDir and is not a file
+// or directory the go command would create.
+func (u *UnexpectedWrites) check(log logging.Logger, isModCache bool, cacheDir, path string, isDir bool) {
+	relPath, err := filepath.Rel(cacheDir, path)
+	if err != nil {
+		return
+	}
+	relPath = filepath.ToSlash(relPath)
+
+	var expected bool
+	if isModCache {
+		expected = expectedModCacheWrite(relPath, isDir)
+	} else {
+		expected = expectedBuildCacheWrite(relPath, isDir)
+	}
+	if expected {
+		return
+	}
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	if len(u.paths) < maxUnexpectedWriteWarnings {
+		log.Warningf("unexpected write to cache: %q", path)
+	} else {
+		log.Debugf("unexpected write to cache: %q", path)
+	}
+	u.paths = append(u.paths, path)
+}
+
+func expectedBuildCacheWrite(relPath string, isDir bool) bool {
+	elems := strings.Split(relPath, "/")
+	switch {
+	case elems[0] == "fuzz":
+		return true
+	case len(elems) == 1 && isDir:
+		return cache.IsBuildShard(elems[0])
+	case len(elems) == 1:
+		return elems[0] == "README" || elems[0] == "trim.txt" || elems[0] == "testexpire.txt"
+	case len(elems) == 2 && !isDir:
+		return cache.IsBuildShard(elems[0]) && cache.IsBuildEntry(elems[1])
+	}
+
+	return false
+}
+
+func expectedModCacheWrite(relPath string, isDir bool) bool {
+	elems := strings.Split(relPath, "/")
+	if elems[0] == "cache" {
+		return true
+	}
+
+	for i, elem := range elems {
+		// modules are extracted to temporary dirs
+		if cache.IsModTempDir(elem) {
+			return true
+		}
+		// extracted modules are read-only, nothing should be created
+		// in them
+		if cache.IsVersionedDir(elem) {
+			return i == len(elems)-1 && isDir
+		}
+	}
+
+	// only directories of module paths should be created outside of
+	// module dirs
+	return isDir
+}
+
+// List returns all recorded unexpected writes.
+func (u *UnexpectedWrites) List() []string {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	return u.paths
+}
@@ -0,0 +1,27 @@
+package watch
+
+import "testing"
+
+func TestExpectedBuildCacheWrite(t *testing.T) {
+	tests := []struct {
+		path   string
+		isDir  bool
+		expect bool
+	}{
+		{path: "README", expect: true},
+		{path: "trim.txt", expect: true},
+		{path: "0a", isDir: true, expect: true},
+		{path: "0a/0a5c1f6c8d33b2cc6e0fa2d1fb4e4f44d6e9b8a3c6b7f3e1f7c5a2b9d2e3f4a1-d", expect: true},
+		{path: "0a/0a5c1f6c8d33b2cc6e0fa2d1fb4e4f44d6e9b8a3c6b7f3e1f7c5a2b9d2e3f4a1-a", expect: true},
+		{path: "fuzz/example.com/pkg/FuzzFoo/abc", expect: true},
+		{path: "evil.sh", expect: false},
+		{path: "zz", isDir: true, expect: false},
+		{path: "0a/payload", expect: false},
+		{path: "0a/nested", isDir: true, expect: false},
+	}
+	for _, tt := range tests {
+		if got := expectedBuildCacheWrite(tt.path, tt.isDir); got != tt.expect {
+			t.Errorf("expectedBuildCacheWrite(%q, %t) = %t, want %t", tt.path, tt.isDir, got, tt.expect)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// Package watch records which entries of Go's module and build caches
+// are used.
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/clock"
+	"github.com/capnspacehook/go-cache-prune/internal/bytesize"
+	"github.com/capnspacehook/go-cache-prune/internal/debuglog"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+// ErrEventsLost is returned when the kernel dropped events while
+// watching, so not every used entry was recorded.
+var ErrEventsLost = errors.New("events were lost")
+
+// Caches records which entries of the module cache modCache and the
+// build cache buildCache are used until modCtx and buildCtx are canceled
+// respectively. Either cache can be empty to not watch it.
+func Caches(modCtx, buildCtx context.Context, modCache, buildCache string, dirLevel bool, opts Options) (cache.UsedFiles, cache.UsedFiles, error) {
+	log := logging.FromContext(modCtx)
+	log.Group("Recording used cache files")
+	defer log.EndGroup()
+
+	// nothing to watch, wait until watching would have stopped
+	if modCache == "" && buildCache == "" {
+		if opts.Ready != nil {
+			opts.Ready()
+		}
+		<-modCtx.Done()
+		return cache.UsedFiles{}, cache.UsedFiles{}, nil
+	}
+
+	var (
+		modFiles      cache.UsedFiles
+		buildFiles    cache.UsedFiles
+		watchModErr   error
+		watchBuildErr error
+		wg            sync.WaitGroup
+		readyWG       sync.WaitGroup
+	)
+
+	cacheOpts := opts
+	cacheOpts.Ready = readyWG.Done
+
+	if modCache != "" {
+		wg.Add(1)
+		readyWG.Add(1)
+		go func() {
+			defer wg.Done()
+			modFiles, watchModErr = Record(modCtx, true, modCache, cacheOpts)
+			if watchModErr != nil {
+				watchModErr = fmt.Errorf("watching module cache: %w", watchModErr)
+				if opts.CacheFailed != nil {
+					opts.CacheFailed(true)
+				}
+			} else if opts.CacheDone != nil {
+				opts.CacheDone(true, modFiles)
+			}
+		}()
+	}
+	if buildCache != "" {
+		wg.Add(1)
+		readyWG.Add(1)
+		go func() {
+			defer wg.Done()
+			buildOpts := cacheOpts
+			buildOpts.DirLevel = dirLevel
+			buildFiles, watchBuildErr = Record(buildCtx, false, buildCache, buildOpts)
+			if watchBuildErr != nil {
+				watchBuildErr = fmt.Errorf("watching build cache: %w", watchBuildErr)
+				if opts.CacheFailed != nil {
+					opts.CacheFailed(false)
+				}
+			} else if opts.CacheDone != nil {
+				opts.CacheDone(false, buildFiles)
+			}
+		}()
+	}
+	// call the ready callback once all caches are being watched
+	if opts.Ready != nil {
+		go func() {
+			readyWG.Wait()
+			opts.Ready()
+		}()
+	}
+	wg.Wait()
+	debuglog.Flush()
+
+	err := errors.Join(watchModErr, watchBuildErr)
+	if err != nil {
+		return cache.UsedFiles{}, cache.UsedFiles{}, err
+	}
+
+	return modFiles, buildFiles, nil
+}
+
+// Record records which entries of a cache are used until ctx is
+// canceled, by scanning it if opts.Scan is set or watching it
+// otherwise.
+func Record(ctx context.Context, isModCache bool, dir string, opts Options) (cache.UsedFiles, error) {
+	if opts.Scan {
+		return scanCache(ctx, isModCache, dir, opts)
+	}
+	return Cache(ctx, isModCache, dir, opts)
+}
+
+// NativeBackend is the default way caches are watched on each platform.
+const NativeBackend = "native"
+
+// Options configures how caches are watched. The zero value watches
+// with the native backend.
+type Options struct {
+	// Backend is how caches are watched, one of Backends
+	Backend string
+	// Scan causes caches to be scanned for entries used while watching
+	// once watching stops instead of being watched
+	Scan bool
+	// DirLevel records usage of the build cache per directory instead
+	// of per file, and a directory will stop being watched once it has
+	// been used, so only the phase it was first used in is recorded
+	DirLevel bool
+	// RestoreDone is closed once the cache has finished being restored,
+	// events before then populate the cache and aren't usage
+	RestoreDone <-chan struct{}
+	// IgnoredProcs are names of processes whose accesses aren't usage
+	IgnoredProcs map[string]struct{}
+	// Phases is the phases of the watch session, usage is recorded as
+	// part of the current phase
+	Phases *cache.Phases
+	// UnexpectedWrites records created files that the go command
+	// wouldn't create if non-nil
+	UnexpectedWrites *UnexpectedWrites
+	// Ready is called once the cache is being watched if non-nil
+	Ready func()
+	// Stats counts events and usage while watching if non-nil
+	Stats *Stats
+	// Clock is used to get the time caches started being scanned and to
+	// poll them, the system clock is used if it is nil
+	Clock clock.Clock
+	// CacheDone is called with the used entries of a cache once it
+	// stops being watched if non-nil
+	CacheDone func(isModCache bool, usedFiles cache.UsedFiles)
+	// CacheFailed is called if watching a cache failed if non-nil,
+	// what was recorded as used may be incomplete
+	CacheFailed func(isModCache bool)
+	// RaiseWatchLimit causes the inotify watch limit to be raised if a
+	// cache needs more watches than it allows, which requires root.
+	// Caches that still can't be watched are polled instead.
+	RaiseWatchLimit bool
+	// OnUsed is called with every entry recorded as used while watching
+	// if non-nil, it may be called concurrently
+	OnUsed func(isModCache bool, path string)
+}
+
+// markUsed records path as used in the phases of mask and passes it on
+// to OnUsed.
+func (o *Options) markUsed(isModCache bool, usedFiles cache.UsedFiles, path string, mask cache.PhaseMask) {
+	o.Stats.markUsed(isModCache, usedFiles, path, mask)
+	if o.OnUsed != nil {
+		o.OnUsed(isModCache, path)
+	}
+}
+
+// Stats counts events and usage while caches are being watched. It
+// is safe to use concurrently, and all methods do nothing if it is nil.
+type Stats struct {
+	events      atomic.Uint64
+	used        atomic.Uint64
+	usedModules atomic.Uint64
+	watches     atomic.Int64
+	// usedMemory is how many bytes recording used entries takes up,
+	// which only grows while watching
+	usedMemory atomic.Int64
+}
+
+func (s *Stats) event() {
+	if s != nil {
+		s.events.Add(1)
+	}
+}
+
+func (s *Stats) addWatches(n int64) {
+	if s != nil {
+		s.watches.Add(n)
+	}
+}
+
+// Events returns how many events were received.
+func (s *Stats) Events() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.events.Load()
+}
+
+// Used returns how many entries were recorded as used.
+func (s *Stats) Used() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.used.Load()
+}
+
+// UsedModules returns how many module cache entries were recorded as
+// used.
+func (s *Stats) UsedModules() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.usedModules.Load()
+}
+
+// Watches returns how many watches are registered.
+func (s *Stats) Watches() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.watches.Load()
+}
+
+// UsedMemory returns roughly the peak memory used to record which
+// entries were used.
+func (s *Stats) UsedMemory() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.usedMemory.Load()
+}
+
+// markUsed records path of the module cache if isModCache is true, or
+// another cache otherwise, as being used in the phases of mask.
+func (s *Stats) markUsed(isModCache bool, usedFiles cache.UsedFiles, path string, mask cache.PhaseMask) {
+	if s == nil {
+		usedFiles.Add(path, mask)
+		return
+	}
+
+	if !usedFiles.Has(path) {
+		s.used.Add(1)
+		if isModCache {
+			s.usedModules.Add(1)
+		}
+	}
+	size := usedFiles.Size()
+	usedFiles.Add(path, mask)
+	s.usedMemory.Add(usedFiles.Size() - size)
+}
+
+// Status returns a line summarizing what was recorded so far and how
+// much memory is in use.
+func (s *Stats) Status() string {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	used, usedModules := s.Used(), s.UsedModules()
+	return fmt.Sprintf("%d events processed, %d module dirs and %d other entries used taking up %s, %d watches, %s memory in use",
+		s.Events(), usedModules, used-usedModules, bytesize.Format(s.UsedMemory()), s.Watches(), bytesize.Format(int64(mem.Sys)))
+}
+
+// Log logs a line summarizing how watching is going every interval of
+// clk until ctx is canceled. The system clock is used if clk is nil.
+func (s *Stats) Log(ctx context.Context, clk clock.Clock, interval time.Duration) {
+	log := logging.FromContext(ctx)
+	clk = clock.Or(clk)
+
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastEvents uint64
+	lastTick := clk.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			events := s.events.Load()
+			rate := float64(events-lastEvents) / now.Sub(lastTick).Seconds()
+			lastEvents, lastTick = events, now
+
+			log.Infof("status: %.1f events/s, %s", rate, s.Status())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package watch
+
+import (
+	"context"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+// Backends are the ways caches can be watched on this platform.
+var Backends = []string{NativeBackend}
+
+// Cache records which entries of a cache are used until ctx is
+// canceled. macOS doesn't provide file access events, so the cache is
+// scanned for entries used while watching instead.
+func Cache(ctx context.Context, isModCache bool, dir string, opts Options) (cache.UsedFiles, error) {
+	return scanCache(ctx, isModCache, dir, opts)
+}
@@ -0,0 +1,603 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/internal/debuglog"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+const (
+	// max length of paths recorded by the eBPF program, longer paths
+	// are truncated
+	ebpfPathMax = 1024
+	// size of the ring buffer the eBPF program sends paths over, must
+	// be a power of 2 multiple of the page size
+	ebpfRingBufSize = 8 << 20
+	// how often to check if watching should stop in milliseconds
+	ebpfPollMillis = 500
+
+	// eBPF helper function IDs
+	bpfFuncMapLookupElem     = 1
+	bpfFuncGetCurrentPIDTGID = 14
+	bpfFuncProbeReadUserStr  = 114
+	bpfFuncRingbufReserve    = 131
+	bpfFuncRingbufSubmit     = 132
+	bpfFuncRingbufDiscard    = 133
+
+	// bits set in the length of ring buffer record headers
+	ringbufBusyBit    = 1 << 31
+	ringbufDiscardBit = 1 << 30
+	ringbufHeaderSize = 8
+)
+
+// syscall tracepoints that are traced, the go command opens and stats
+// cache files with them
+var ebpfTracepoints = []string{"sys_enter_openat", "sys_enter_newfstatat"}
+
+// watchCacheEBPF records which entries of a cache are used with an eBPF
+// program attached to syscall tracepoints, which records paths opened
+// or stated by any process. Filtering by the cache dir is done in the
+// kernel, so only absolute paths to cache files are recorded, which is
+// how the go command accesses caches. If eBPF programs can't be loaded,
+// usually because CAP_BPF is missing, the cache is watched with inotify
+// instead.
+func watchCacheEBPF(ctx context.Context, isModCache bool, dir string, opts Options) (cache.UsedFiles, error) {
+	log := logging.FromContext(ctx)
+
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return cache.UsedFiles{}, fmt.Errorf("creating cache dir: %w", err)
+	}
+
+	log.Infof("loading eBPF program for cache dir %q", dir)
+
+	tracer, err := newPathTracer(dir)
+	if err != nil {
+		log.Warningf("loading eBPF program failed, watching cache dir %q with inotify instead: %v", dir, err)
+		return watchCacheInotify(ctx, isModCache, dir, opts)
+	}
+	defer tracer.close()
+
+	if opts.UnexpectedWrites != nil {
+		log.Warningf("reporting unexpected writes isn't supported with the eBPF backend")
+	}
+	log.Infof("watching cache dir %q", dir)
+	if opts.Ready != nil {
+		opts.Ready()
+	}
+
+	var (
+		usedFiles   = cache.NewUsedFiles()
+		restoreDone = opts.RestoreDone
+		restoring   = restoreDone != nil
+		populated   uint
+	)
+	handlePath := func(pid uint32, path string) {
+		path = filepath.Clean(path)
+		debuglog.Debugf("event", path, "got event: path=%q pid=%d", path, pid)
+		opts.Stats.event()
+
+		if restoring {
+			populated++
+			return
+		}
+		if len(opts.IgnoredProcs) != 0 {
+			name := procName(strconv.FormatUint(uint64(pid), 10))
+			if _, ok := opts.IgnoredProcs[name]; ok {
+				debuglog.Debugf("ignored event", path, "ignoring event for %q caused by %s", path, name)
+				return
+			}
+		}
+
+		if isModCache {
+			if depDir, ok := cache.ContainingDependencyDir(dir, path); ok {
+				opts.markUsed(isModCache, usedFiles, depDir, opts.Phases.CurrentMask())
+			}
+			return
+		}
+		usedPath := path
+		if opts.DirLevel {
+			usedPath = filepath.Dir(path)
+		}
+		opts.markUsed(isModCache, usedFiles, usedPath, opts.Phases.CurrentMask())
+	}
+
+	for {
+		if restoring {
+			select {
+			case <-restoreDone:
+				log.Infof("cache dir %q finished being restored, %d events were from populating the cache", dir, populated)
+				restoring = false
+			default:
+			}
+		}
+		if ctx.Err() != nil {
+			// read paths recorded after the last wait
+			tracer.read(handlePath)
+			if err := tracer.checkDropped(dir); err != nil {
+				return cache.UsedFiles{}, err
+			}
+			return usedFiles, nil
+		}
+
+		if err := tracer.wait(ebpfPollMillis); err != nil {
+			return cache.UsedFiles{}, fmt.Errorf("waiting for eBPF events: %w", err)
+		}
+		tracer.read(handlePath)
+		if err := tracer.checkDropped(dir); err != nil {
+			return cache.UsedFiles{}, err
+		}
+	}
+}
+
+// pathTracer is a loaded eBPF program that sends paths accessed within
+// a directory over a ring buffer.
+type pathTracer struct {
+	mapFD int
+	// dropsFD is a per-CPU array map counting paths that were dropped
+	// because the ring buffer was full
+	dropsFD  int
+	progFD   int
+	eventFDs []int
+	epollFD  int
+	consumer []byte
+	producer []byte
+	data     []byte
+}
+
+func newPathTracer(dir string) (_ *pathTracer, err error) {
+	t := &pathTracer{mapFD: -1, dropsFD: -1, progFD: -1, epollFD: -1}
+	defer func() {
+		if err != nil {
+			t.close()
+		}
+	}()
+
+	t.mapFD, err = bpfMapCreate(unix.BPF_MAP_TYPE_RINGBUF, 0, 0, ebpfRingBufSize)
+	if err != nil {
+		return nil, fmt.Errorf("creating ring buffer: %w", err)
+	}
+	t.dropsFD, err = bpfMapCreate(unix.BPF_MAP_TYPE_PERCPU_ARRAY, 4, 8, 1)
+	if err != nil {
+		return nil, fmt.Errorf("creating dropped paths counter: %w", err)
+	}
+	t.progFD, err = bpfProgLoad(pathTracerProg(t.mapFD, t.dropsFD, dir+string(filepath.Separator), os.Getpid()))
+	if err != nil {
+		return nil, fmt.Errorf("loading program: %w", err)
+	}
+	for _, tp := range ebpfTracepoints {
+		fd, err := attachTracepoint(t.progFD, "syscalls", tp)
+		if err != nil {
+			return nil, fmt.Errorf("attaching program to %s: %w", tp, err)
+		}
+		t.eventFDs = append(t.eventFDs, fd)
+	}
+
+	pageSize := os.Getpagesize()
+	t.consumer, err = unix.Mmap(t.mapFD, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mapping ring buffer consumer page: %w", err)
+	}
+	// the data pages are mapped twice in a row so records that wrap
+	// around the end of the buffer can be read contiguously
+	t.producer, err = unix.Mmap(t.mapFD, int64(pageSize), pageSize+2*ebpfRingBufSize, unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mapping ring buffer producer pages: %w", err)
+	}
+	t.data = t.producer[pageSize:]
+
+	t.epollFD, err = unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("creating epoll instance: %w", err)
+	}
+	event := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(t.mapFD)}
+	if err := unix.EpollCtl(t.epollFD, unix.EPOLL_CTL_ADD, t.mapFD, &event); err != nil {
+		return nil, fmt.Errorf("adding ring buffer to epoll instance: %w", err)
+	}
+
+	return t, nil
+}
+
+// wait waits until paths are available to be read or timeout
+// milliseconds have passed.
+func (t *pathTracer) wait(timeout int) error {
+	events := make([]unix.EpollEvent, 1)
+	_, err := unix.EpollWait(t.epollFD, events, timeout)
+	if err != nil && !errors.Is(err, unix.EINTR) {
+		return err
+	}
+	return nil
+}
+
+// read calls handle for every path in the ring buffer.
+func (t *pathTracer) read(handle func(pid uint32, path string)) {
+	consumerPos := (*uint64)(unsafe.Pointer(&t.consumer[0]))
+	producerPos := (*uint64)(unsafe.Pointer(&t.producer[0]))
+
+	cons := atomic.LoadUint64(consumerPos)
+	prod := atomic.LoadUint64(producerPos)
+	for cons < prod {
+		offset := cons & (ebpfRingBufSize - 1)
+		header := atomic.LoadUint32((*uint32)(unsafe.Pointer(&t.data[offset])))
+		if header&ringbufBusyBit != 0 {
+			// the record is still being written
+			break
+		}
+
+		length := uint64(header &^ (ringbufBusyBit | ringbufDiscardBit))
+		if header&ringbufDiscardBit == 0 {
+			record := t.data[offset+ringbufHeaderSize : offset+ringbufHeaderSize+length]
+			pid := *(*uint32)(unsafe.Pointer(&record[0]))
+			path, _, _ := bytes.Cut(record[8:], []byte{0})
+			handle(pid, string(path))
+		}
+
+		// records are 8 byte aligned
+		cons += (ringbufHeaderSize + length + 7) &^ 7
+		atomic.StoreUint64(consumerPos, cons)
+	}
+}
+
+// dropped returns how many paths weren't sent because the ring buffer
+// was full.
+func (t *pathTracer) dropped() (uint64, error) {
+	cpus, err := possibleCPUs()
+	if err != nil {
+		return 0, fmt.Errorf("getting number of possible CPUs: %w", err)
+	}
+
+	// the counter of every CPU is returned
+	var key uint32
+	values := make([]uint64, cpus)
+	attr := struct {
+		mapFD uint32
+		_     uint32
+		key   uint64
+		value uint64
+		flags uint64
+	}{
+		mapFD: uint32(t.dropsFD),
+		key:   uint64(uintptr(unsafe.Pointer(&key))),
+		value: uint64(uintptr(unsafe.Pointer(&values[0]))),
+	}
+	if _, err := bpfSyscall(unix.BPF_MAP_LOOKUP_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+		return 0, fmt.Errorf("looking up dropped paths counter: %w", err)
+	}
+
+	var dropped uint64
+	for _, v := range values {
+		dropped += v
+	}
+	return dropped, nil
+}
+
+// checkDropped returns an error if any paths accessed in the cache dir
+// dir were dropped, as used entries may not have been recorded.
+func (t *pathTracer) checkDropped(dir string) error {
+	dropped, err := t.dropped()
+	if err != nil {
+		return err
+	}
+	if dropped != 0 {
+		return fmt.Errorf("%w: %d paths didn't fit in the eBPF ring buffer, not every used entry of %q was recorded", ErrEventsLost, dropped, dir)
+	}
+	return nil
+}
+
+// possibleCPUs returns how many CPUs the kernel may bring online, which
+// is how many values per-CPU maps have.
+func possibleCPUs() (int, error) {
+	b, err := os.ReadFile("/sys/devices/system/cpu/possible")
+	if err != nil {
+		return 0, err
+	}
+	return parseCPUList(strings.TrimSpace(string(b)))
+}
+
+// parseCPUList returns how many CPUs a kernel CPU list like "0-3,8"
+// contains.
+func parseCPUList(list string) (int, error) {
+	var cpus int
+	for _, cpuRange := range strings.Split(list, ",") {
+		first, last, isRange := strings.Cut(cpuRange, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return 0, fmt.Errorf("invalid CPU list %q", list)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(last)
+			if err != nil || end < start {
+				return 0, fmt.Errorf("invalid CPU list %q", list)
+			}
+		}
+		cpus += end - start + 1
+	}
+	return cpus, nil
+}
+
+func (t *pathTracer) close() {
+	if t.epollFD != -1 {
+		unix.Close(t.epollFD)
+	}
+	if t.producer != nil {
+		_ = unix.Munmap(t.producer)
+	}
+	if t.consumer != nil {
+		_ = unix.Munmap(t.consumer)
+	}
+	for _, fd := range t.eventFDs {
+		unix.Close(fd)
+	}
+	if t.progFD != -1 {
+		unix.Close(t.progFD)
+	}
+	if t.dropsFD != -1 {
+		unix.Close(t.dropsFD)
+	}
+	if t.mapFD != -1 {
+		unix.Close(t.mapFD)
+	}
+}
+
+// bpfInsn is an eBPF instruction.
+type bpfInsn struct {
+	op   uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+func bpfInstr(op uint8, dst, src uint8, off int16, imm int32) bpfInsn {
+	return bpfInsn{op: op, regs: src<<4 | dst, off: off, imm: imm}
+}
+
+// pathTracerProg returns an eBPF program for syscall tracepoints whose
+// second argument is a path. It sends the PID of the calling process and
+// the path over the ring buffer mapFD if the path starts with prefix,
+// ignoring calls made by the process selfPID. If the ring buffer is full
+// the counter in the per-CPU array dropsFD is incremented instead.
+func pathTracerProg(mapFD, dropsFD int, prefix string, selfPID int) []bpfInsn {
+	const (
+		r0, r1, r2, r3, r6, r7, r8, r10 = 0, 1, 2, 3, 6, 7, 8, 10
+		// offset of the path argument in the tracepoint context
+		pathArgOffset = 24
+		// the path is stored after the PID and padding in records
+		recordPathOffset = 8
+		recordSize       = recordPathOffset + ebpfPathMax
+	)
+	var (
+		movReg  = uint8(unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_X)
+		movImm  = uint8(unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_K)
+		addImm  = uint8(unix.BPF_ALU64 | unix.BPF_ADD | unix.BPF_K)
+		rshImm  = uint8(unix.BPF_ALU64 | unix.BPF_RSH | unix.BPF_K)
+		jeqImm  = uint8(unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K)
+		jneImm  = uint8(unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K)
+		jsltImm = uint8(unix.BPF_JMP | unix.BPF_JSLT | unix.BPF_K)
+		ja      = uint8(unix.BPF_JMP | unix.BPF_JA)
+		call    = uint8(unix.BPF_JMP | unix.BPF_CALL)
+		exit    = uint8(unix.BPF_JMP | unix.BPF_EXIT)
+		ldImm64 = uint8(unix.BPF_LD | unix.BPF_IMM | unix.BPF_DW)
+		ldxDW   = uint8(unix.BPF_LDX | unix.BPF_MEM | unix.BPF_DW)
+		ldxB    = uint8(unix.BPF_LDX | unix.BPF_MEM | unix.BPF_B)
+		stxW    = uint8(unix.BPF_STX | unix.BPF_MEM | unix.BPF_W)
+		stxDW   = uint8(unix.BPF_STX | unix.BPF_MEM | unix.BPF_DW)
+		stW     = uint8(unix.BPF_ST | unix.BPF_MEM | unix.BPF_W)
+	)
+
+	// jumps to the discard, drop and exit labels are recorded and fixed
+	// up once the labels' positions are known
+	var (
+		prog         []bpfInsn
+		discardJumps []int
+		dropJumps    []int
+		exitJumps    []int
+	)
+	emit := func(insns ...bpfInsn) {
+		prog = append(prog, insns...)
+	}
+	jumpTo := func(jumps *[]int, insn bpfInsn) {
+		*jumps = append(*jumps, len(prog))
+		emit(insn)
+	}
+
+	emit(
+		bpfInstr(movReg, r6, r1, 0, 0),
+		bpfInstr(call, 0, 0, 0, bpfFuncGetCurrentPIDTGID),
+		bpfInstr(movReg, r8, r0, 0, 0),
+		bpfInstr(rshImm, r8, 0, 0, 32),
+	)
+	jumpTo(&exitJumps, bpfInstr(jeqImm, r8, 0, 0, int32(selfPID)))
+
+	// reserve a record
+	emit(
+		bpfInstr(ldImm64, r1, unix.BPF_PSEUDO_MAP_FD, 0, int32(mapFD)),
+		bpfInstr(0, 0, 0, 0, 0),
+		bpfInstr(movImm, r2, 0, 0, recordSize),
+		bpfInstr(movImm, r3, 0, 0, 0),
+		bpfInstr(call, 0, 0, 0, bpfFuncRingbufReserve),
+	)
+	jumpTo(&dropJumps, bpfInstr(jeqImm, r0, 0, 0, 0))
+	emit(
+		bpfInstr(movReg, r7, r0, 0, 0),
+		bpfInstr(stxW, r7, r8, 0, 0),
+		// copy the path into the record
+		bpfInstr(movReg, r1, r7, 0, 0),
+		bpfInstr(addImm, r1, 0, 0, recordPathOffset),
+		bpfInstr(movImm, r2, 0, 0, ebpfPathMax),
+		bpfInstr(ldxDW, r3, r6, pathArgOffset, 0),
+		bpfInstr(call, 0, 0, 0, bpfFuncProbeReadUserStr),
+	)
+	// the returned length includes the terminating NUL
+	jumpTo(&discardJumps, bpfInstr(jsltImm, r0, 0, 0, int32(len(prefix)+1)))
+	for i := 0; i < len(prefix); i++ {
+		emit(bpfInstr(ldxB, r1, r7, int16(recordPathOffset+i), 0))
+		jumpTo(&discardJumps, bpfInstr(jneImm, r1, 0, 0, int32(prefix[i])))
+	}
+
+	emit(
+		bpfInstr(movReg, r1, r7, 0, 0),
+		bpfInstr(movImm, r2, 0, 0, 0),
+		bpfInstr(call, 0, 0, 0, bpfFuncRingbufSubmit),
+	)
+	jumpTo(&exitJumps, bpfInstr(ja, 0, 0, 0, 0))
+
+	discard := len(prog)
+	emit(
+		bpfInstr(movReg, r1, r7, 0, 0),
+		bpfInstr(movImm, r2, 0, 0, 0),
+		bpfInstr(call, 0, 0, 0, bpfFuncRingbufDiscard),
+	)
+	jumpTo(&exitJumps, bpfInstr(ja, 0, 0, 0, 0))
+
+	// count the path as dropped, the counter is per-CPU so it doesn't
+	// need to be incremented atomically
+	drop := len(prog)
+	emit(
+		bpfInstr(stW, r10, 0, -4, 0),
+		bpfInstr(ldImm64, r1, unix.BPF_PSEUDO_MAP_FD, 0, int32(dropsFD)),
+		bpfInstr(0, 0, 0, 0, 0),
+		bpfInstr(movReg, r2, r10, 0, 0),
+		bpfInstr(addImm, r2, 0, 0, -4),
+		bpfInstr(call, 0, 0, 0, bpfFuncMapLookupElem),
+	)
+	jumpTo(&exitJumps, bpfInstr(jeqImm, r0, 0, 0, 0))
+	emit(
+		bpfInstr(ldxDW, r1, r0, 0, 0),
+		bpfInstr(addImm, r1, 0, 0, 1),
+		bpfInstr(stxDW, r0, r1, 0, 0),
+	)
+
+	exitPos := len(prog)
+	emit(
+		bpfInstr(movImm, r0, 0, 0, 0),
+		bpfInstr(exit, 0, 0, 0, 0),
+	)
+
+	for _, i := range discardJumps {
+		prog[i].off = int16(discard - i - 1)
+	}
+	for _, i := range dropJumps {
+		prog[i].off = int16(drop - i - 1)
+	}
+	for _, i := range exitJumps {
+		prog[i].off = int16(exitPos - i - 1)
+	}
+
+	return prog
+}
+
+func bpfMapCreate(mapType, keySize, valueSize, maxEntries uint32) (int, error) {
+	attr := struct {
+		mapType    uint32
+		keySize    uint32
+		valueSize  uint32
+		maxEntries uint32
+	}{
+		mapType:    mapType,
+		keySize:    keySize,
+		valueSize:  valueSize,
+		maxEntries: maxEntries,
+	}
+	return bpfSyscall(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+func bpfProgLoad(prog []bpfInsn) (int, error) {
+	license := []byte("GPL\x00")
+	logBuf := make([]byte, 64*1024)
+	attr := struct {
+		progType    uint32
+		insnCnt     uint32
+		insns       uint64
+		license     uint64
+		logLevel    uint32
+		logSize     uint32
+		logBuf      uint64
+		kernVersion uint32
+		progFlags   uint32
+	}{
+		progType: unix.BPF_PROG_TYPE_TRACEPOINT,
+		insnCnt:  uint32(len(prog)),
+		insns:    uint64(uintptr(unsafe.Pointer(&prog[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(logBuf)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+
+	fd, err := bpfSyscall(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		if verifierLog, _, _ := bytes.Cut(logBuf, []byte{0}); len(verifierLog) != 0 {
+			return -1, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(verifierLog)))
+		}
+		return -1, err
+	}
+	return fd, nil
+}
+
+func bpfSyscall(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// attachTracepoint attaches the eBPF program progFD to a tracepoint and
+// returns the perf event file descriptor that keeps it attached.
+func attachTracepoint(progFD int, category, name string) (int, error) {
+	var (
+		id  []byte
+		err error
+	)
+	for _, tracefs := range []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"} {
+		id, err = os.ReadFile(filepath.Join(tracefs, "events", category, name, "id"))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return -1, fmt.Errorf("reading tracepoint ID: %w", err)
+	}
+	tpID, err := strconv.ParseUint(strings.TrimSpace(string(id)), 10, 64)
+	if err != nil {
+		return -1, fmt.Errorf("parsing tracepoint ID: %w", err)
+	}
+
+	attr := unix.PerfEventAttr{
+		Type:        unix.PERF_TYPE_TRACEPOINT,
+		Size:        uint32(unsafe.Sizeof(unix.PerfEventAttr{})),
+		Config:      tpID,
+		Sample:      1,
+		Sample_type: unix.PERF_SAMPLE_RAW,
+		Wakeup:      1,
+	}
+	// eBPF programs attached to tracepoints run on every CPU no matter
+	// which CPU the perf event is for
+	fd, err := unix.PerfEventOpen(&attr, -1, 0, -1, unix.PERF_FLAG_FD_CLOEXEC)
+	if err != nil {
+		return -1, fmt.Errorf("opening perf event: %w", err)
+	}
+	if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_SET_BPF, progFD); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("attaching program: %w", err)
+	}
+	if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("enabling perf event: %w", err)
+	}
+
+	return fd, nil
+}
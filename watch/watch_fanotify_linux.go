@@ -0,0 +1,158 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/internal/debuglog"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+// watchCacheFanotify records which entries of a cache are used with a
+// single fanotify mark covering the whole filesystem or mount the cache
+// is on, which avoids needing a watch per directory. CAP_SYS_ADMIN is
+// required. Files being created aren't reported, but files are opened
+// after being created so they will still be recorded as used.
+func watchCacheFanotify(ctx context.Context, isModCache bool, dir string, opts Options) (cache.UsedFiles, error) {
+	log := logging.FromContext(ctx)
+
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return cache.UsedFiles{}, fmt.Errorf("creating cache dir: %w", err)
+	}
+	if opts.UnexpectedWrites != nil {
+		log.Warningf("reporting unexpected writes isn't supported with the fanotify backend")
+	}
+	// paths of opened files are read back with symlinks resolved, so
+	// they have to be compared against the resolved cache dir
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return cache.UsedFiles{}, fmt.Errorf("resolving cache dir: %w", err)
+	}
+
+	log.Infof("creating fanotify mark for cache dir %q", dir)
+
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK, unix.O_RDONLY|unix.O_LARGEFILE|unix.O_CLOEXEC)
+	if err != nil {
+		return cache.UsedFiles{}, fmt.Errorf("initializing fanotify: %w", err)
+	}
+	// the file is registered with the runtime poller as fd is
+	// non-blocking, so closing it will unblock reads
+	f := os.NewFile(uintptr(fd), "fanotify")
+	defer f.Close()
+
+	mask := uint64(unix.FAN_ACCESS | unix.FAN_OPEN | unix.FAN_ONDIR | unix.FAN_EVENT_ON_CHILD)
+	err = unix.FanotifyMark(fd, unix.FAN_MARK_ADD|unix.FAN_MARK_FILESYSTEM, mask, unix.AT_FDCWD, dir)
+	if err != nil {
+		// filesystem marks require Linux 4.20, fallback to mount marks
+		log.Debugf("adding fanotify filesystem mark failed, adding mount mark: %v", err)
+		err = unix.FanotifyMark(fd, unix.FAN_MARK_ADD|unix.FAN_MARK_MOUNT, mask, unix.AT_FDCWD, dir)
+		if err != nil {
+			return cache.UsedFiles{}, fmt.Errorf("adding fanotify mark: %w", err)
+		}
+	}
+
+	opts.Stats.addWatches(1)
+	log.Infof("watching cache dir %q", dir)
+	if opts.Ready != nil {
+		opts.Ready()
+	}
+
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	var (
+		buf         = make([]byte, 64*1024)
+		usedFiles   = cache.NewUsedFiles()
+		restoreDone = opts.RestoreDone
+		restoring   = restoreDone != nil
+		populated   uint
+		selfPID     = int32(os.Getpid())
+	)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return usedFiles, nil
+			}
+			if errors.Is(err, os.ErrClosed) {
+				return cache.UsedFiles{}, errors.New("fanotify file closed")
+			}
+			return cache.UsedFiles{}, fmt.Errorf("reading fanotify events: %w", err)
+		}
+
+		if restoring {
+			select {
+			case <-restoreDone:
+				log.Infof("cache dir %q finished being restored, %d events were from populating the cache", dir, populated)
+				restoring = false
+			default:
+			}
+		}
+
+		for offset := 0; offset+int(unsafe.Sizeof(unix.FanotifyEventMetadata{})) <= n; {
+			event := (*unix.FanotifyEventMetadata)(unsafe.Pointer(&buf[offset]))
+			if event.Vers != unix.FANOTIFY_METADATA_VERSION {
+				return cache.UsedFiles{}, fmt.Errorf("unsupported fanotify metadata version %d", event.Vers)
+			}
+			offset += int(event.Event_len)
+			if event.Mask&unix.FAN_Q_OVERFLOW != 0 {
+				return cache.UsedFiles{}, fmt.Errorf("%w: fanotify event queue overflowed, not every used entry of %q was recorded", ErrEventsLost, dir)
+			}
+			if event.Fd < 0 {
+				continue
+			}
+
+			path, err := os.Readlink(filepath.Join("/proc/self/fd", strconv.Itoa(int(event.Fd))))
+			unix.Close(int(event.Fd))
+			// the mark covers the whole filesystem, ignore events
+			// outside of the cache and caused by this process
+			if err != nil || event.Pid == selfPID || !cache.IsWithinDir(resolvedDir, path) {
+				continue
+			}
+			if resolvedDir != dir {
+				rel, err := filepath.Rel(resolvedDir, path)
+				if err != nil {
+					continue
+				}
+				path = filepath.Join(dir, rel)
+			}
+			debuglog.Debugf("event", path, "got event: path=%q mask=%#x pid=%d", path, event.Mask, event.Pid)
+			opts.Stats.event()
+
+			if restoring {
+				populated++
+				continue
+			}
+			if len(opts.IgnoredProcs) != 0 {
+				name := procName(strconv.Itoa(int(event.Pid)))
+				if _, ok := opts.IgnoredProcs[name]; ok {
+					debuglog.Debugf("ignored event", path, "ignoring event for %q caused by %s", path, name)
+					continue
+				}
+			}
+
+			isDirEvent := event.Mask&unix.FAN_ONDIR != 0
+			if isModCache {
+				if depDir, ok := cache.ContainingDependencyDir(dir, path); ok {
+					opts.markUsed(isModCache, usedFiles, depDir, opts.Phases.CurrentMask())
+				}
+				continue
+			}
+			usedPath := path
+			if opts.DirLevel && !isDirEvent {
+				usedPath = filepath.Dir(path)
+			}
+			opts.markUsed(isModCache, usedFiles, usedPath, opts.Phases.CurrentMask())
+		}
+	}
+}
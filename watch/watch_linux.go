@@ -0,0 +1,526 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sys/unix"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/internal/debuglog"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+const (
+	// FanotifyBackend watches caches with a fanotify mark on the
+	// filesystem they are on.
+	FanotifyBackend = "fanotify"
+	// EBPFBackend traces paths accessed in caches with an eBPF program.
+	EBPFBackend = "ebpf"
+	// PollBackend periodically scans caches for used entries.
+	PollBackend = "poll"
+)
+
+// Backends are the ways caches can be watched on this platform.
+var Backends = []string{NativeBackend, FanotifyBackend, EBPFBackend, PollBackend}
+
+// Cache records which entries of a cache are used until ctx is
+// canceled.
+func Cache(ctx context.Context, isModCache bool, dir string, opts Options) (cache.UsedFiles, error) {
+	log := logging.FromContext(ctx)
+
+	switch opts.Backend {
+	case FanotifyBackend:
+		return watchCacheFanotify(ctx, isModCache, dir, opts)
+	case EBPFBackend:
+		return watchCacheEBPF(ctx, isModCache, dir, opts)
+	case PollBackend:
+		return pollCache(ctx, isModCache, dir, opts)
+	}
+
+	if fsName := networkFilesystem(dir); fsName != "" {
+		log.Infof("cache dir %q is on %s which doesn't support inotify, polling it instead", dir, fsName)
+		return pollCache(ctx, isModCache, dir, opts)
+	}
+	return watchCacheInotify(ctx, isModCache, dir, opts)
+}
+
+// networkFilesystem returns the name of the network filesystem path is
+// on, or an empty string if it isn't on one. Changes made to network
+// filesystems by other hosts don't generate inotify events. If path
+// doesn't exist yet the filesystem of its nearest existing parent is
+// used.
+func networkFilesystem(path string) string {
+	var stat unix.Statfs_t
+	for {
+		err := unix.Statfs(path, &stat)
+		if err == nil {
+			break
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, unix.ENOENT) || parent == path {
+			return ""
+		}
+		path = parent
+	}
+
+	switch uint32(stat.Type) {
+	case unix.NFS_SUPER_MAGIC:
+		return "NFS"
+	case unix.SMB_SUPER_MAGIC, unix.SMB2_SUPER_MAGIC, unix.CIFS_SUPER_MAGIC:
+		return "SMB"
+	case unix.V9FS_MAGIC:
+		return "9P"
+	}
+	return ""
+}
+
+const (
+	// watchFlags are the inotify events cache dirs are watched for
+	watchFlags = unix.IN_ACCESS | unix.IN_CREATE
+	// directories of the module cache that aren't dependency dirs are
+	// only watched so newly downloaded dependency dirs are noticed
+	newDirFlags = unix.IN_CREATE | unix.IN_MOVED_TO
+	// running a toolchain doesn't access its dependency dir, so the
+	// binaries it contains are watched for being opened
+	toolchainFlags = unix.IN_OPEN
+)
+
+// watchCacheInotify records which entries of a cache are used by
+// watching every directory of the cache with inotify.
+func watchCacheInotify(ctx context.Context, isModCache bool, dir string, opts Options) (cache.UsedFiles, error) {
+	log := logging.FromContext(ctx)
+
+	// the cache may not exist yet if it hasn't been restored or
+	// populated, create it so it can be watched from the start
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return cache.UsedFiles{}, fmt.Errorf("creating cache dir: %w", err)
+	}
+
+	// check that there are enough watches left before adding any so
+	// falling back doesn't require adding and removing many watches
+	limit, err := watchLimit()
+	if err != nil {
+		log.Warningf("getting inotify watch limit: %v", err)
+	}
+	var needed int
+	if limit > 0 {
+		needed, err = watchesNeeded(isModCache, dir)
+		if err != nil {
+			return cache.UsedFiles{}, fmt.Errorf("walking %q: %w", dir, err)
+		}
+		inUse := watchesInUse()
+		log.Debugf("cache dir %q needs %d inotify watches, %d of the limit of %d are in use", dir, needed, inUse, limit)
+		if needed > limit-inUse {
+			return watchLimitExceeded(ctx, isModCache, dir, needed, limit, opts)
+		}
+	}
+
+	log.Infof("creating watches for cache dir %q", dir)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return cache.UsedFiles{}, fmt.Errorf("creating file watcher: %w", err)
+	}
+	closeWatcher := sync.OnceFunc(func() {
+		if err := watcher.Close(); err != nil {
+			log.Warningf("closing file watchers: %v", err)
+		}
+	})
+	defer closeWatcher()
+
+	var (
+		usedFiles = cache.NewUsedFiles()
+		// guards usedFiles while adding watches concurrently
+		usedMu sync.Mutex
+		added  atomic.Int64
+		// finds processes whose accesses are ignored
+		ignoredProcs = newProcMatcher(opts.IgnoredProcs, opts.Clock)
+	)
+
+	// addWatches walks root adding watches, if markUsed is true all
+	// dependency dirs found will be recorded as used
+	addWatches := func(root string, markUsed bool) error {
+		return walkWatchDirs(isModCache, root, func(path string, flags uint32, isDepDir bool) error {
+			err := watcher.AddWith(path, fsnotify.WithInotifyFlags(flags))
+			if err != nil {
+				return fmt.Errorf("adding watch for %q: %w", path, err)
+			}
+			debuglog.Debugf("watch", path, "added watch for %q", path)
+			opts.Stats.addWatches(1)
+			added.Add(1)
+
+			if markUsed && isDepDir {
+				usedMu.Lock()
+				opts.markUsed(isModCache, usedFiles, path, opts.Phases.CurrentMask())
+				usedMu.Unlock()
+			}
+			return nil
+		})
+	}
+
+	// recordNewBuildDir records files in a new build cache dir as used,
+	// they may have been created before it was watched
+	recordNewBuildDir := func(path string) {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			if opts.DirLevel {
+				// like when a file in it is used, stop watching it
+				opts.markUsed(isModCache, usedFiles, path, opts.Phases.CurrentMask())
+				if err := watcher.Remove(path); err != nil {
+					log.Warningf("removing watch for %q: %v", path, err)
+				} else {
+					opts.Stats.addWatches(-1)
+				}
+				return
+			}
+			opts.markUsed(isModCache, usedFiles, filepath.Join(path, entry.Name()), opts.Phases.CurrentMask())
+		}
+	}
+
+	start := time.Now()
+	if err := addWatches(dir, false); err != nil {
+		if !errors.Is(err, unix.ENOSPC) {
+			return cache.UsedFiles{}, fmt.Errorf("walking %q: %w", dir, err)
+		}
+
+		// other processes may have added watches after the limit was
+		// checked. The watches that were added are removed when the
+		// watcher is closed, so they count towards the limit again
+		opts.Stats.addWatches(-added.Load())
+		closeWatcher()
+		if needed == 0 {
+			if needed, err = watchesNeeded(isModCache, dir); err != nil {
+				log.Warningf("counting directories of cache dir %q: %v", dir, err)
+			}
+		}
+		return watchLimitExceeded(ctx, isModCache, dir, needed, limit, opts)
+	}
+	log.Infof("watching cache dir %q, adding %d watches took %s", dir, added.Load(), time.Since(start).Round(time.Millisecond))
+	if opts.Ready != nil {
+		opts.Ready()
+	}
+
+	var (
+		restoreDone = opts.RestoreDone
+		restoring   = restoreDone != nil
+		populated   uint
+	)
+	for {
+		select {
+		case <-restoreDone:
+			log.Infof("cache dir %q finished being restored, %d events were from populating the cache", dir, populated)
+			restoring = false
+			restoreDone = nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return cache.UsedFiles{}, errors.New("file watcher event channel closed")
+			}
+
+			debuglog.Debugf("event", event.Name, "got event: path=%q op=%s", event.Name, event.Op)
+			opts.Stats.event()
+
+			isDirEvent := event.Mask&unix.IN_ISDIR == unix.IN_ISDIR
+			isCreateEvent := event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0
+			isNewDirEvent := isDirEvent && isCreateEvent
+			if isCreateEvent && opts.UnexpectedWrites != nil {
+				opts.UnexpectedWrites.check(log, isModCache, dir, event.Name, isDirEvent)
+			}
+			if isModCache && isNewDirEvent && !cache.IsModTempDir(filepath.Base(event.Name)) && !cache.IsVersionedDir(filepath.Base(filepath.Dir(event.Name))) {
+				// a module was downloaded, it and any other new
+				// dependency dirs under it were used
+				if err := addWatches(event.Name, !restoring); err != nil {
+					log.Errorf("adding watches for %q: %v", event.Name, err)
+				}
+				continue
+			}
+			if !isModCache && isNewDirEvent {
+				err := watcher.AddWith(event.Name, fsnotify.WithInotifyFlags(watchFlags))
+				if err != nil {
+					log.Errorf("adding watch for %q: %v", event.Name, err)
+				} else {
+					opts.Stats.addWatches(1)
+					if !restoring {
+						recordNewBuildDir(event.Name)
+					}
+				}
+			}
+			if restoring {
+				populated++
+				continue
+			}
+			if len(opts.IgnoredProcs) != 0 {
+				if name := ignoredProcs.accessedBy(event.Name); name != "" {
+					debuglog.Debugf("ignored event", event.Name, "ignoring event for %q caused by %s", event.Name, name)
+					continue
+				}
+			}
+
+			if isModCache && event.Mask&unix.IN_OPEN != 0 {
+				// a toolchain binary was run
+				if depDir := filepath.Dir(filepath.Dir(event.Name)); cache.IsToolchainDir(depDir) {
+					opts.markUsed(isModCache, usedFiles, depDir, opts.Phases.CurrentMask())
+				}
+			} else if opts.DirLevel && !isDirEvent {
+				// only the first use of a directory matters, stop
+				// watching it so no more events are generated
+				usedDir := filepath.Dir(event.Name)
+				if !usedFiles.Has(usedDir) {
+					opts.markUsed(isModCache, usedFiles, usedDir, opts.Phases.CurrentMask())
+					if usedDir != dir {
+						if err := watcher.Remove(usedDir); err != nil {
+							log.Warningf("removing watch for %q: %v", usedDir, err)
+						} else {
+							opts.Stats.addWatches(-1)
+						}
+					}
+				}
+			} else if isModCache && isDirEvent || !isModCache && !isDirEvent {
+				opts.markUsed(isModCache, usedFiles, event.Name, opts.Phases.CurrentMask())
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return cache.UsedFiles{}, errors.New("file watcher error channel closed")
+			}
+			log.Errorf("file watcher: %v", err)
+		case <-ctx.Done():
+			return usedFiles, nil
+		}
+	}
+}
+
+// walkWorkers is how many directories are read and watched at the
+// same time when walking a cache. Adding watches is mostly waiting on
+// the kernel, so walking warm caches with tens of thousands of
+// directories is much faster concurrently.
+const walkWorkers = 16
+
+// walkWatchDirs walks root calling add with every directory of a cache
+// that needs to be watched and the inotify flags to watch it with.
+// isDepDir is true for dependency dirs of the module cache. walkWorkers
+// goroutines take directories to walk from a shared queue so add may be
+// called concurrently, and the first error add returns stops the walk
+// and is returned.
+func walkWatchDirs(isModCache bool, root string, add func(path string, flags uint32, isDepDir bool) error) error {
+	var (
+		mu    sync.Mutex
+		cond  = sync.NewCond(&mu)
+		queue = []string{root}
+		// pending is how many directories are queued or being walked,
+		// the walk is done when it's 0
+		pending = 1
+		walkErr error
+	)
+
+	walk := func() {
+		for {
+			mu.Lock()
+			for len(queue) == 0 && pending != 0 && walkErr == nil {
+				cond.Wait()
+			}
+			if pending == 0 || walkErr != nil {
+				mu.Unlock()
+				return
+			}
+			path := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			mu.Unlock()
+
+			subdirs, err := watchDir(isModCache, path, path == root, add)
+
+			mu.Lock()
+			if err != nil && walkErr == nil {
+				walkErr = err
+			}
+			queue = append(queue, subdirs...)
+			pending += len(subdirs) - 1
+			mu.Unlock()
+			cond.Broadcast()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < walkWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			walk()
+		}()
+	}
+	wg.Wait()
+
+	return walkErr
+}
+
+// watchDir calls add with the directory path if it needs to be watched
+// and returns its subdirectories that need to be walked. Only the root
+// of a walk may not exist.
+func watchDir(isModCache bool, path string, isRoot bool, add func(path string, flags uint32, isDepDir bool) error) ([]string, error) {
+	if isModCache && cache.IsVersionedDir(filepath.Base(path)) {
+		return nil, addDepDir(path, add)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		if !isRoot && errors.Is(err, fs.ErrNotExist) {
+			// removed while walking
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var subdirs []string
+	for _, entry := range entries {
+		if isModCache && !entry.IsDir() && entry.Name() == "go.mod" {
+			// If the dir contains 'go.mod', this is a dep dir
+			return nil, addDepDir(path, add)
+		}
+		if entry.IsDir() && !(isModCache && cache.IsModTempDir(entry.Name())) {
+			subdirs = append(subdirs, filepath.Join(path, entry.Name()))
+		}
+	}
+
+	flags := uint32(watchFlags)
+	if isModCache {
+		flags = newDirFlags
+	}
+	if err := add(path, flags, false); err != nil {
+		return nil, err
+	}
+	return subdirs, nil
+}
+
+// addDepDir calls add with the dependency dir depDir, and the binaries
+// dir of it if it's a toolchain.
+func addDepDir(depDir string, add func(path string, flags uint32, isDepDir bool) error) error {
+	if err := add(depDir, watchFlags, true); err != nil {
+		return err
+	}
+	if cache.IsToolchainDir(depDir) {
+		binDir := filepath.Join(depDir, "bin")
+		if err := add(binDir, toolchainFlags, false); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchLimitPath is the sysctl that limits how many inotify watches a
+// user can create.
+const watchLimitPath = "/proc/sys/fs/inotify/max_user_watches"
+
+// watchLimitExceeded handles a cache needing more inotify watches than
+// the limit allows. The limit is raised and the cache watched again if
+// opts.RaiseWatchLimit is set, otherwise the cache is polled.
+func watchLimitExceeded(ctx context.Context, isModCache bool, dir string, needed, limit int, opts Options) (cache.UsedFiles, error) {
+	log := logging.FromContext(ctx)
+	log.Warningf("cache dir %q needs %d inotify watches, more than are available with the limit of %d set by fs.inotify.max_user_watches", dir, needed, limit)
+
+	if opts.RaiseWatchLimit && limit > 0 {
+		// other caches and processes of the same user use watches too
+		newLimit := max(2*limit, limit+2*needed)
+		if err := os.WriteFile(watchLimitPath, []byte(strconv.Itoa(newLimit)+"\n"), 0o644); err != nil {
+			log.Warningf("raising inotify watch limit: %v", err)
+		} else {
+			log.Infof("raised inotify watch limit from %d to %d", limit, newLimit)
+			opts.RaiseWatchLimit = false
+			return watchCacheInotify(ctx, isModCache, dir, opts)
+		}
+	}
+
+	log.Warningf("polling cache dir %q instead of watching it", dir)
+	return pollCache(ctx, isModCache, dir, opts)
+}
+
+// watchesNeeded returns how many inotify watches are needed to watch
+// dir.
+func watchesNeeded(isModCache bool, dir string) (int, error) {
+	// the callback is called concurrently
+	var needed atomic.Int64
+	err := walkWatchDirs(isModCache, dir, func(string, uint32, bool) error {
+		needed.Add(1)
+		return nil
+	})
+	return int(needed.Load()), err
+}
+
+// watchesInUse returns how many inotify watches processes of the
+// current user have created, which count towards the limit. Processes
+// whose file descriptors can't be read are skipped.
+func watchesInUse() int {
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0
+	}
+
+	uid := os.Getuid()
+	var inUse int
+	for _, proc := range procs {
+		if _, err := strconv.Atoi(proc.Name()); err != nil {
+			continue
+		}
+		info, err := proc.Info()
+		if err != nil {
+			continue
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != uid {
+			continue
+		}
+
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err != nil || target != "anon_inode:inotify" {
+				continue
+			}
+			fdinfo, err := os.ReadFile(filepath.Join("/proc", proc.Name(), "fdinfo", fd.Name()))
+			if err != nil {
+				continue
+			}
+			inUse += countInotifyWatches(string(fdinfo))
+		}
+	}
+	return inUse
+}
+
+// countInotifyWatches returns how many watches the fdinfo of an inotify
+// file descriptor lists, there is a line for each one.
+func countInotifyWatches(fdinfo string) int {
+	var watches int
+	for _, line := range strings.Split(fdinfo, "\n") {
+		if strings.HasPrefix(line, "inotify wd:") {
+			watches++
+		}
+	}
+	return watches
+}
+
+// watchLimit returns the maximum number of inotify watches a user can
+// create.
+func watchLimit() (int, error) {
+	b, err := os.ReadFile(watchLimitPath)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
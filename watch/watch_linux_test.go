@@ -0,0 +1,180 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+)
+
+func TestWalkWatchDirs(t *testing.T) {
+	modCache := t.TempDir()
+	for _, dir := range []string{
+		filepath.Join("example.com", "a@v1.0.0", "pkg"),
+		filepath.Join("cache", "download", "example.com", "a", "@v"),
+		filepath.Join("golang.org", "toolchain@v0.0.1-go1.21.0.linux-amd64", "bin"),
+	} {
+		if err := os.MkdirAll(filepath.Join(modCache, dir), 0o755); err != nil {
+			t.Fatalf("creating dir: %v", err)
+		}
+	}
+
+	var (
+		mu            sync.Mutex
+		dirs, depDirs []string
+	)
+	err := walkWatchDirs(true, modCache, func(path string, _ uint32, isDepDir bool) error {
+		rel, err := filepath.Rel(modCache, path)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		dirs = append(dirs, filepath.ToSlash(rel))
+		if isDepDir {
+			depDirs = append(depDirs, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking module cache: %v", err)
+	}
+	slices.Sort(dirs)
+	slices.Sort(depDirs)
+
+	// dirs within dependency dirs aren't watched, except the binaries
+	// of toolchains
+	wantDirs := []string{
+		".",
+		"cache",
+		"cache/download",
+		"cache/download/example.com",
+		"cache/download/example.com/a",
+		"cache/download/example.com/a/@v",
+		"example.com",
+		"example.com/a@v1.0.0",
+		"golang.org",
+		"golang.org/toolchain@v0.0.1-go1.21.0.linux-amd64",
+		"golang.org/toolchain@v0.0.1-go1.21.0.linux-amd64/bin",
+	}
+	if !slices.Equal(dirs, wantDirs) {
+		t.Errorf("expected watched dirs %q, got %q", wantDirs, dirs)
+	}
+	wantDepDirs := []string{"example.com/a@v1.0.0", "golang.org/toolchain@v0.0.1-go1.21.0.linux-amd64"}
+	if !slices.Equal(depDirs, wantDepDirs) {
+		t.Errorf("expected dependency dirs %q, got %q", wantDepDirs, depDirs)
+	}
+}
+
+func TestWalkWatchDirsError(t *testing.T) {
+	buildCache := t.TempDir()
+	for i := 0; i < 100; i++ {
+		if err := os.Mkdir(filepath.Join(buildCache, fmt.Sprintf("%02x", i)), 0o755); err != nil {
+			t.Fatalf("creating dir: %v", err)
+		}
+	}
+
+	errFull := errors.New("no space left")
+	var added atomic.Int64
+	err := walkWatchDirs(false, buildCache, func(string, uint32, bool) error {
+		if added.Add(1) > 10 {
+			return errFull
+		}
+		return nil
+	})
+	if !errors.Is(err, errFull) {
+		t.Fatalf("expected walking to fail with %v, got %v", errFull, err)
+	}
+
+	err = walkWatchDirs(false, filepath.Join(buildCache, "missing"), func(string, uint32, bool) error { return nil })
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected walking a missing root to fail, got %v", err)
+	}
+}
+
+func TestCountInotifyWatches(t *testing.T) {
+	fdinfo := `pos:	0
+flags:	02004000
+mnt_id:	15
+ino:	1057
+inotify wd:2 ino:1a2b sdev:800001 mask:fc6 ignored_mask:0 fhandle-bytes:8 fhandle-type:1 f_handle:2b1a000000000000
+inotify wd:1 ino:2 sdev:800001 mask:fc6 ignored_mask:0 fhandle-bytes:8 fhandle-type:1 f_handle:0200000000000000
+`
+	if watches := countInotifyWatches(fdinfo); watches != 2 {
+		t.Fatalf("expected 2 watches, got %d", watches)
+	}
+}
+
+func TestWatchesInUse(t *testing.T) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("creating file watcher: %v", err)
+	}
+	defer watcher.Close()
+	for i := 0; i < 3; i++ {
+		if err := watcher.Add(t.TempDir()); err != nil {
+			t.Fatalf("adding watch: %v", err)
+		}
+	}
+
+	// other processes of the same user may have watches too
+	if inUse := watchesInUse(); inUse < 3 {
+		t.Fatalf("expected at least 3 watches in use, got %d", inUse)
+	}
+}
+
+func TestCachesCacheFailed(t *testing.T) {
+	// the cache dir can't be created under a file
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+
+	var failed, done []bool
+	opts := Options{
+		CacheFailed: func(isModCache bool) { failed = append(failed, isModCache) },
+		CacheDone:   func(isModCache bool, _ cache.UsedFiles) { done = append(done, isModCache) },
+	}
+	ctx := context.Background()
+	_, _, err := Caches(ctx, ctx, filepath.Join(file, "mod"), "", false, opts)
+	if err == nil {
+		t.Fatal("expected watching a cache that can't be created to fail")
+	}
+	if !slices.Equal(failed, []bool{true}) || len(done) != 0 {
+		t.Fatalf("expected only the module cache to fail, got failed=%v done=%v", failed, done)
+	}
+}
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		list    string
+		cpus    int
+		wantErr bool
+	}{
+		{list: "0", cpus: 1},
+		{list: "0-7", cpus: 8},
+		{list: "0-3,8,10-11", cpus: 7},
+		{list: "", wantErr: true},
+		{list: "3-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		cpus, err := parseCPUList(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCPUList(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		if cpus != tt.cpus {
+			t.Errorf("parseCPUList(%q) = %d, want %d", tt.list, cpus, tt.cpus)
+		}
+	}
+}
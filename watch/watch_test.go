@@ -0,0 +1,94 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/clock"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+func TestStats(t *testing.T) {
+	var s Stats
+	modFiles, buildFiles := cache.NewUsedFiles(), cache.NewUsedFiles()
+	s.markUsed(true, modFiles, "/mod/example.com/a@v1.0.0", cache.DefaultPhaseMask)
+	s.markUsed(true, modFiles, "/mod/example.com/a@v1.0.0", cache.DefaultPhaseMask)
+	s.markUsed(false, buildFiles, "/build/ab/ab-a", cache.DefaultPhaseMask)
+	s.markUsed(false, buildFiles, "/build/cd/cd-a", cache.DefaultPhaseMask)
+	s.event()
+	s.addWatches(3)
+
+	if got := s.Used(); got != 3 {
+		t.Errorf("expected 3 used entries, got %d", got)
+	}
+	if got := s.UsedModules(); got != 1 {
+		t.Errorf("expected 1 used module, got %d", got)
+	}
+	if got, want := s.UsedMemory(), modFiles.Size()+buildFiles.Size(); got != want {
+		t.Errorf("expected used entries to take up %d bytes, got %d", want, got)
+	}
+	if status := s.Status(); !strings.HasPrefix(status, "1 events processed, 1 module dirs and 2 other entries used taking up ") || !strings.Contains(status, ", 3 watches") {
+		t.Errorf("unexpected status %q", status)
+	}
+
+	var nilStats *Stats
+	nilStats.markUsed(true, modFiles, "/mod/example.com/b@v1.0.0", cache.DefaultPhaseMask)
+	if nilStats.Used() != 0 || nilStats.UsedModules() != 0 {
+		t.Error("expected nil stats to count nothing")
+	}
+}
+
+func TestStatsLog(t *testing.T) {
+	var buf bytes.Buffer
+	var bufMu sync.Mutex
+	l := logging.Slog(slog.New(slog.NewTextHandler(lockedWriter{w: &buf, mu: &bufMu}, nil)))
+	ctx, cancel := context.WithCancel(logging.NewContext(context.Background(), l))
+
+	var s Stats
+	c := clock.NewFake(time.Now())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Log(ctx, c, time.Minute)
+	}()
+	for c.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 60; i++ {
+		s.event()
+	}
+	c.Advance(time.Minute)
+	// wait for the line to be logged
+	for {
+		bufMu.Lock()
+		out := buf.String()
+		bufMu.Unlock()
+		if out != "" {
+			if !strings.Contains(out, "status: 1.0 events/s") {
+				t.Errorf("expected an event rate of 1/s, got %q", out)
+			}
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+type lockedWriter struct {
+	w  *bytes.Buffer
+	mu *sync.Mutex
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
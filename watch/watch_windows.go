@@ -0,0 +1,208 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+
+	"github.com/capnspacehook/go-cache-prune/cache"
+	"github.com/capnspacehook/go-cache-prune/internal/debuglog"
+	"github.com/capnspacehook/go-cache-prune/logging"
+)
+
+const (
+	// size of the buffer ReadDirectoryChangesW writes events to
+	changesBufSize = 64 * 1024
+	// how often to check if watching should stop in milliseconds
+	changesPollMillis = 500
+)
+
+// Backends are the ways caches can be watched on this platform.
+var Backends = []string{NativeBackend}
+
+// Cache records which entries of a cache are used until ctx is
+// canceled. A single recursive ReadDirectoryChangesW watch is used,
+// which relies on NTFS last access time updates being enabled to
+// notice files being read, so watching fails if they are disabled.
+// Ignoring processes isn't supported.
+func Cache(ctx context.Context, isModCache bool, dir string, opts Options) (cache.UsedFiles, error) {
+	log := logging.FromContext(ctx)
+
+	// the cache may not exist yet if it hasn't been restored or
+	// populated, create it so it can be watched from the start
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return cache.UsedFiles{}, fmt.Errorf("creating cache dir: %w", err)
+	}
+	if len(opts.IgnoredProcs) != 0 {
+		log.Warningf("ignoring processes isn't supported on Windows")
+	}
+	// without last access time updates files being read isn't noticed,
+	// so every entry that was only read would be pruned
+	disabled, err := lastAccessUpdatesDisabled()
+	if err != nil {
+		log.Warningf("checking if NTFS last access time updates are enabled: %v", err)
+	} else if disabled {
+		return cache.UsedFiles{}, errors.New("NTFS last access time updates are disabled so reading cache files can't be noticed, enable them with 'fsutil behavior set disablelastaccess 0'")
+	}
+
+	log.Infof("creating watch for cache dir %q", dir)
+
+	dirPtr, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return cache.UsedFiles{}, err
+	}
+	h, err := windows.CreateFile(
+		dirPtr,
+		windows.FILE_LIST_DIRECTORY,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil,
+		windows.OPEN_EXISTING,
+		windows.FILE_FLAG_BACKUP_SEMANTICS|windows.FILE_FLAG_OVERLAPPED,
+		0,
+	)
+	if err != nil {
+		return cache.UsedFiles{}, fmt.Errorf("opening cache dir: %w", err)
+	}
+	defer windows.CloseHandle(h)
+
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return cache.UsedFiles{}, fmt.Errorf("creating event: %w", err)
+	}
+	defer windows.CloseHandle(event)
+
+	const mask = windows.FILE_NOTIFY_CHANGE_FILE_NAME |
+		windows.FILE_NOTIFY_CHANGE_DIR_NAME |
+		windows.FILE_NOTIFY_CHANGE_LAST_ACCESS |
+		windows.FILE_NOTIFY_CHANGE_LAST_WRITE |
+		windows.FILE_NOTIFY_CHANGE_CREATION
+
+	var (
+		buf         = make([]byte, changesBufSize)
+		usedFiles   = cache.NewUsedFiles()
+		restoreDone = opts.RestoreDone
+		restoring   = restoreDone != nil
+		populated   uint
+	)
+	log.Infof("watching cache dir %q", dir)
+	if opts.Ready != nil {
+		opts.Ready()
+	}
+
+	for {
+		ov := windows.Overlapped{HEvent: event}
+		if err := windows.ResetEvent(event); err != nil {
+			return cache.UsedFiles{}, fmt.Errorf("resetting event: %w", err)
+		}
+		err := windows.ReadDirectoryChanges(h, &buf[0], uint32(len(buf)), true, mask, nil, &ov, 0)
+		if err != nil && !errors.Is(err, windows.ERROR_IO_PENDING) {
+			return cache.UsedFiles{}, fmt.Errorf("reading directory changes: %w", err)
+		}
+
+		// wait for changes while checking if watching should stop
+		for {
+			if restoring {
+				select {
+				case <-restoreDone:
+					log.Infof("cache dir %q finished being restored, %d events were from populating the cache", dir, populated)
+					restoring = false
+				default:
+				}
+			}
+			if ctx.Err() != nil {
+				_ = windows.CancelIoEx(h, &ov)
+				return usedFiles, nil
+			}
+
+			ret, err := windows.WaitForSingleObject(event, changesPollMillis)
+			if err != nil {
+				return cache.UsedFiles{}, fmt.Errorf("waiting for directory changes: %w", err)
+			}
+			if ret != uint32(windows.WAIT_TIMEOUT) {
+				break
+			}
+		}
+
+		var n uint32
+		if err := windows.GetOverlappedResult(h, &ov, &n, false); err != nil {
+			if errors.Is(err, windows.ERROR_NOTIFY_ENUM_DIR) {
+				log.Warningf("too many changes in cache dir %q, some events were lost", dir)
+				continue
+			}
+			return cache.UsedFiles{}, fmt.Errorf("getting directory changes: %w", err)
+		}
+		if n == 0 {
+			log.Warningf("too many changes in cache dir %q, some events were lost", dir)
+			continue
+		}
+
+		for offset := uint32(0); ; {
+			info := (*windows.FileNotifyInformation)(unsafe.Pointer(&buf[offset]))
+			name := windows.UTF16ToString(unsafe.Slice(&info.FileName, info.FileNameLength/2))
+			path := filepath.Join(dir, name)
+			debuglog.Debugf("event", path, "got event: path=%q action=%d", path, info.Action)
+			opts.Stats.event()
+
+			if restoring {
+				populated++
+			} else if info.Action != windows.FILE_ACTION_REMOVED && info.Action != windows.FILE_ACTION_RENAMED_OLD_NAME {
+				recordWindowsEvent(log, isModCache, dir, path, info.Action, usedFiles, opts)
+			}
+
+			if info.NextEntryOffset == 0 {
+				break
+			}
+			offset += info.NextEntryOffset
+		}
+	}
+}
+
+// lastAccessUpdatesDisabled returns true if NTFS doesn't update the
+// last access times of files when they are read.
+func lastAccessUpdatesDisabled() (bool, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\FileSystem`, registry.QUERY_VALUE)
+	if err != nil {
+		return false, err
+	}
+	defer k.Close()
+
+	v, _, err := k.GetIntegerValue("NtfsDisableLastAccessUpdate")
+	if errors.Is(err, registry.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// the high bit is set if the value is managed by the system since
+	// Windows 10 1803, the low bit is whether updates are disabled
+	return v&1 != 0, nil
+}
+
+func recordWindowsEvent(log logging.Logger, isModCache bool, dir, path string, action uint32, usedFiles cache.UsedFiles, opts Options) {
+	isCreate := action == windows.FILE_ACTION_ADDED || action == windows.FILE_ACTION_RENAMED_NEW_NAME
+	if isCreate && opts.UnexpectedWrites != nil {
+		fi, err := os.Lstat(path)
+		if err == nil {
+			opts.UnexpectedWrites.check(log, isModCache, dir, path, fi.IsDir())
+		}
+	}
+
+	if isModCache {
+		if depDir, ok := cache.ContainingDependencyDir(dir, path); ok {
+			opts.markUsed(isModCache, usedFiles, depDir, opts.Phases.CurrentMask())
+		}
+		return
+	}
+
+	usedPath := path
+	if opts.DirLevel {
+		usedPath = filepath.Dir(path)
+	}
+	opts.markUsed(isModCache, usedFiles, usedPath, opts.Phases.CurrentMask())
+}